@@ -0,0 +1,120 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+
+	"github.com/437d5/merch-store/pkg/token"
+)
+
+const keysUsage = `usage: merch-store keys <command> [-file path] [args]
+
+commands:
+  secret         print a random secret for JWT_SECRET
+  list           list keys in the key file
+  add            add a new key, -activate also signs new tokens with it,
+                 -alg picks HS256 (default), RS256, ES256 or EdDSA
+  activate KID   sign new tokens with KID
+  remove KID     stop accepting tokens signed with KID
+
+Rotation without logging users out: "add", roll the file out to every
+replica, "activate" the new key, wait for the token lifetime, "remove"
+the old key.
+
+The key file defaults to $JWT_KEYS_FILE.
+`
+
+// runKeys manages the JWT key file and returns the exit code.
+func runKeys(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, keysUsage)
+		return 2
+	}
+
+	cmd := args[0]
+	fset := flag.NewFlagSet("keys "+cmd, flag.ContinueOnError)
+	path := fset.String("file", os.Getenv("JWT_KEYS_FILE"), "key file")
+	activate := fset.Bool("activate", false, "activate the added key")
+	alg := fset.String("alg", token.AlgHS256, "signing algorithm of the added key")
+	if err := fset.Parse(args[1:]); err != nil {
+		return 2
+	}
+
+	if cmd == "secret" {
+		secret := make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		fmt.Println(base64.RawURLEncoding.EncodeToString(secret))
+		return 0
+	}
+
+	if *path == "" {
+		fmt.Fprintln(os.Stderr, "key file is not set, use -file or JWT_KEYS_FILE")
+		return 2
+	}
+
+	if err := keysCommand(cmd, *path, *alg, *activate, fset.Args()); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	return 0
+}
+
+func keysCommand(cmd, path, alg string, activate bool, args []string) error {
+	ks, err := token.LoadKeySet(path)
+	if errors.Is(err, fs.ErrNotExist) && cmd == "add" {
+		ks, err = &token.KeySet{}, nil
+		activate = true
+	}
+	if err != nil {
+		return err
+	}
+
+	switch cmd {
+	case "list":
+		for _, k := range ks.Keys {
+			marker := " "
+			if k.Kid == ks.Active {
+				marker = "*"
+			}
+			fmt.Printf(
+				"%s %s\t%s\t%s\n", marker, k.Kid, k.Alg,
+				k.CreatedAt.Format("2006-01-02 15:04:05"),
+			)
+		}
+		return nil
+	case "add":
+		kid, err := ks.Generate(alg)
+		if err != nil {
+			return err
+		}
+		if activate {
+			ks.Active = kid
+		}
+		fmt.Println(kid)
+	case "activate", "remove":
+		if len(args) != 1 {
+			return fmt.Errorf("usage: merch-store keys %s KID", cmd)
+		}
+		if cmd == "activate" {
+			err = ks.Activate(args[0])
+		} else {
+			err = ks.Remove(args[0])
+		}
+		if err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown command %q\n\n%s", cmd, keysUsage)
+	}
+
+	return ks.Save(path)
+}
@@ -12,44 +12,160 @@ import (
 
 	"github.com/437d5/merch-store/internal/config"
 	"github.com/437d5/merch-store/internal/handler"
+	"github.com/437d5/merch-store/internal/migrate"
 	"github.com/437d5/merch-store/internal/repository"
 	"github.com/437d5/merch-store/internal/service"
+	"github.com/437d5/merch-store/internal/sso"
+	"github.com/437d5/merch-store/migrations"
+	sqlitemigrations "github.com/437d5/merch-store/migrations/sqlite"
 	"github.com/437d5/merch-store/pkg/logger"
 	"github.com/gin-gonic/gin"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "keys" {
+		os.Exit(runKeys(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		os.Exit(runMigrate(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "mockidp" {
+		os.Exit(runMockIdP(os.Args[2:]))
+	}
+
 	cfg := config.MustLoad()
 
 	logger := logger.NewLogger(cfg.Log.LogMode, slog.LevelError)
 
-	config, err := pgxpool.ParseConfig(fmt.Sprintf(
-		"postgres://%s:%s@%s:%d/%s",
-		cfg.Db.DbUser, cfg.Db.DbPass, cfg.Db.DbHost, cfg.Db.DbPort, cfg.Db.DbName,
-	))
-	if err != nil {
-		logger.Error("failed to connect to database", "error", err)
-		os.Exit(1)
+	var repos repository.Repos
+	switch cfg.Db.Driver {
+	case config.DriverMemory:
+		logger.Warn("using in-memory storage, data is lost on exit")
+		repos = repository.NewMemoryRepos(logger)
+	case config.DriverSQLite:
+		db, err := repository.OpenSQLite(cfg.Db.DbPath)
+		if err != nil {
+			logger.Error("failed to open database", "error", err)
+			os.Exit(1)
+		}
+		defer db.Close()
+
+		if cfg.Db.MigrateOnStart {
+			migrator, err := migrate.NewSQLiteMigrator(db, sqlitemigrations.Files, logger)
+			if err != nil {
+				logger.Error("failed to load migrations", "error", err)
+				os.Exit(1)
+			}
+			if _, err = migrator.Up(context.Background()); err != nil {
+				logger.Error("failed to migrate database", "error", err)
+				os.Exit(1)
+			}
+		}
+
+		repos = repository.NewSQLiteRepos(db, logger)
+	default:
+		dbpool, err := connectDB(context.Background(), cfg)
+		if err != nil {
+			logger.Error("failed to connect to database", "error", err)
+			os.Exit(1)
+		}
+		defer dbpool.Close()
+
+		if cfg.Db.MigrateOnStart {
+			migrator, err := migrate.NewMigrator(dbpool, migrations.Files, logger)
+			if err != nil {
+				logger.Error("failed to load migrations", "error", err)
+				os.Exit(1)
+			}
+			if _, err = migrator.Up(context.Background()); err != nil {
+				logger.Error("failed to migrate database", "error", err)
+				os.Exit(1)
+			}
+		}
+
+		repos = repository.NewPostgresRepos(dbpool, logger)
 	}
-	config.MaxConns = 100
-	dbpool, err := pgxpool.NewWithConfig(context.Background(), config)
-	if err != nil {
-		logger.Error("failed to connect to database", "error", err)
+
+	sessionService := service.NewSessionService(
+		repos.RefreshTokens, repos.Revocations, repos.Keys, repos.Users, repos.TxManager,
+		cfg.JWT.Keys, cfg.JWT.AccessTTL, cfg.JWT.RefreshTTL, cfg.JWT.RevocationCacheTTL, logger,
+	)
+
+	userService := service.NewUserService(
+		repos.Users, repos.Ledger, repos.Identities, repos.TxManager, cfg.Pwd.Hasher,
+		sessionService, cfg.Adm.Usernames, cfg.Ath.AutoRegister, logger,
+	)
+	marketService := service.NewMarketService(
+		repos.Users, logger, repos.Items, repos.Purchases, repos.Inventory, repos.Ledger,
+		repos.TxManager,
+	)
+	transactionService := service.NewTransactionService(
+		repos.Transactions, repos.Users, repos.Ledger, repos.TxManager, logger,
+	)
+	ledgerService := service.NewLedgerService(repos.Ledger, logger)
+	catalogService := service.NewCatalogService(repos.Items, repos.TxManager, logger)
+
+	if err := userService.EnsureAdmins(context.Background()); err != nil {
+		logger.Error("failed to promote admins", "error", err)
 		os.Exit(1)
 	}
-	defer dbpool.Close()
 
-	userRepo := repository.NewUserRepo(dbpool, logger)
-	itemRepo := repository.NewItemRepo(dbpool, logger)
-	transactionRepo := repository.NewTransRepo(dbpool, logger)
+	if cfg.Db.ReconcileOnStart {
+		// the sums scan every posting, the server does not wait for them
+		go func() {
+			report, err := ledgerService.Reconcile(context.Background())
+			if err != nil {
+				logger.Error("failed to reconcile ledger", "error", err)
+				return
+			}
+			if !report.Balanced() {
+				logger.Error("ledger is out of balance, see discrepancies above")
+			}
+		}()
+	}
+
+	idempotencyService := service.NewIdempotencyService(
+		repos.Idempotency, repos.TxManager, cfg.Idm.Retention, logger,
+	)
+
+	lockoutService := service.NewLockoutService(
+		repos.Lockouts, repos.TxManager, service.LockoutPolicy{
+			UserLimit:  cfg.Ath.UserFailureLimit,
+			IPLimit:    cfg.Ath.IPFailureLimit,
+			BaseDelay:  cfg.Ath.BackoffBase,
+			MaxLockout: cfg.Ath.MaxLockout,
+		}, logger,
+	)
+
+	passwordService := service.NewPasswordService(
+		repos.Users, repos.PasswordResets, repos.TxManager, cfg.Pwd.Hasher,
+		sessionService, cfg.Ath.ResetTTL, logger,
+	)
 
-	userService := service.NewUserService(userRepo, logger)
-	marketService := service.NewMarketService(userRepo, logger, itemRepo)
-	transactionService := service.NewTransactionService(transactionRepo, userRepo, logger)
+	apiKeyService := service.NewAPIKeyService(repos.Keys, logger)
+
+	var oidcService *service.OIDCService
+	if cfg.Sso.Enabled() {
+		provider, err := sso.NewProvider(context.Background(), sso.Config{
+			Issuer:        cfg.Sso.Issuer,
+			ClientID:      cfg.Sso.ClientID,
+			ClientSecret:  cfg.Sso.ClientSecret,
+			RedirectURL:   cfg.Sso.RedirectURL,
+			Scopes:        cfg.Sso.Scopes,
+			UsernameClaim: cfg.Sso.UsernameClaim,
+		})
+		if err != nil {
+			logger.Error("failed to set up oidc", "error", err)
+			os.Exit(1)
+		}
+		oidcService = service.NewOIDCService(provider, repos.LoginStates, cfg.Sso.StateTTL, logger)
+	}
 
 	h := handler.NewHandler(
-		userService, marketService, transactionService, logger,
+		userService, marketService, transactionService,
+		idempotencyService, catalogService, ledgerService, sessionService,
+		lockoutService, passwordService, apiKeyService, oidcService, *cfg, logger,
 	)
 
 	router := gin.Default()
@@ -68,6 +184,42 @@ func main() {
 	}()
 	logger.Info("server started", "port", cfg.Srv.SrvPort)
 
+	cleanupCtx, stopCleanup := context.WithCancel(context.Background())
+	defer stopCleanup()
+	go func() {
+		ticker := time.NewTicker(time.Hour)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-cleanupCtx.Done():
+				return
+			case <-ticker.C:
+				n, err := idempotencyService.Cleanup(cleanupCtx)
+				if err == nil {
+					logger.Info("idempotency keys cleaned up", "count", n)
+				}
+				n, err = sessionService.Cleanup(cleanupCtx)
+				if err == nil {
+					logger.Info("sessions cleaned up", "count", n)
+				}
+				n, err = lockoutService.Cleanup(cleanupCtx)
+				if err == nil {
+					logger.Info("lockouts cleaned up", "count", n)
+				}
+				n, err = passwordService.Cleanup(cleanupCtx)
+				if err == nil {
+					logger.Info("password resets cleaned up", "count", n)
+				}
+				if oidcService != nil {
+					n, err = oidcService.Cleanup(cleanupCtx)
+					if err == nil {
+						logger.Info("oidc login states cleaned up", "count", n)
+					}
+				}
+			}
+		}
+	}()
+
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, os.Interrupt, syscall.SIGTERM)
 	<-quit
@@ -80,3 +232,16 @@ func main() {
 
 	logger.Info("server exited")
 }
+
+func connectDB(ctx context.Context, cfg *config.Config) (*pgxpool.Pool, error) {
+	config, err := pgxpool.ParseConfig(fmt.Sprintf(
+		"postgres://%s:%s@%s:%d/%s",
+		cfg.Db.DbUser, cfg.Db.DbPass, cfg.Db.DbHost, cfg.Db.DbPort, cfg.Db.DbName,
+	))
+	if err != nil {
+		return nil, err
+	}
+	config.MaxConns = 100
+
+	return pgxpool.NewWithConfig(ctx, config)
+}
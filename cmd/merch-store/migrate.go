@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/437d5/merch-store/internal/config"
+	"github.com/437d5/merch-store/internal/migrate"
+	"github.com/437d5/merch-store/internal/repository"
+	"github.com/437d5/merch-store/migrations"
+	sqlitemigrations "github.com/437d5/merch-store/migrations/sqlite"
+	"github.com/437d5/merch-store/pkg/logger"
+)
+
+const migrateUsage = `usage: merch-store migrate <command> [-steps n]
+
+commands:
+  up       apply every pending migration
+  down     roll back the last -steps migrations (default 1)
+  status   list migrations and when they were applied
+
+The database is configured with the same STORAGE_DRIVER, DATABASE_*
+and SQLITE_PATH variables as the server. Set MIGRATE_ON_START=true to run "up" when the server starts.
+`
+
+// runMigrate applies or rolls back the embedded migrations and returns
+// the exit code.
+func runMigrate(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, migrateUsage)
+		return 2
+	}
+
+	cmd := args[0]
+	fset := flag.NewFlagSet("migrate "+cmd, flag.ContinueOnError)
+	steps := fset.Int("steps", 1, "number of migrations to roll back")
+	if err := fset.Parse(args[1:]); err != nil {
+		return 2
+	}
+
+	if cmd != "up" && cmd != "down" && cmd != "status" {
+		fmt.Fprint(os.Stderr, migrateUsage)
+		return 2
+	}
+	if *steps < 1 {
+		fmt.Fprintln(os.Stderr, "steps must be positive")
+		return 2
+	}
+
+	cfg := config.MustLoad()
+	logger := logger.NewLogger(cfg.Log.LogMode, slog.LevelError)
+
+	ctx := context.Background()
+	var migrator *migrate.Migrator
+	switch cfg.Db.Driver {
+	case config.DriverSQLite:
+		db, err := repository.OpenSQLite(cfg.Db.DbPath)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		defer db.Close()
+
+		migrator, err = migrate.NewSQLiteMigrator(db, sqlitemigrations.Files, logger)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	case config.DriverPostgres:
+		db, err := connectDB(ctx, cfg)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		defer db.Close()
+
+		migrator, err = migrate.NewMigrator(db, migrations.Files, logger)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	default:
+		fmt.Fprintf(os.Stderr, "the %s storage driver has no migrations\n", cfg.Db.Driver)
+		return 1
+	}
+
+	switch cmd {
+	case "up":
+		done, err := migrator.Up(ctx)
+		if !printMigrations("applied", done, err) {
+			return 1
+		}
+	case "down":
+		done, err := migrator.Down(ctx, *steps)
+		if !printMigrations("rolled back", done, err) {
+			return 1
+		}
+	case "status":
+		statuses, err := migrator.Status(ctx)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		for _, s := range statuses {
+			applied := "pending"
+			if s.AppliedAt != nil {
+				applied = s.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Printf("%04d  %-30s %s\n", s.Version, s.Name, applied)
+		}
+	}
+
+	return 0
+}
+
+// printMigrations reports what was done before err stopped the run and
+// returns whether the run succeeded.
+func printMigrations(action string, done []migrate.Migration, err error) bool {
+	for _, m := range done {
+		fmt.Printf("%s %04d_%s\n", action, m.Version, m.Name)
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return false
+	}
+	if len(done) == 0 {
+		fmt.Println("nothing to do")
+	}
+
+	return true
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/437d5/merch-store/internal/sso/mockidp"
+)
+
+const mockIdPUsage = `usage: merch-store mockidp [-addr :9000] [-issuer url] [-client id] [-secret secret]
+
+Runs an OpenID Connect provider for local development. Every login is
+approved without a prompt as the user given by the login_hint parameter
+of /api/oidc/login, or as "%s" without one. The signing key is
+generated on start, so tokens do not survive a restart.
+`
+
+// runMockIdP serves the mock identity provider and returns the exit code.
+func runMockIdP(args []string) int {
+	fset := flag.NewFlagSet("mockidp", flag.ContinueOnError)
+	fset.Usage = func() { fmt.Fprintf(os.Stderr, mockIdPUsage, mockidp.DefaultUser) }
+	addr := fset.String("addr", ":9000", "listen address")
+	issuer := fset.String("issuer", "http://localhost:9000", "issuer url, as seen by merch-store")
+	client := fset.String("client", "merch-store", "client id")
+	secret := fset.String("secret", "secret", "client secret")
+	if err := fset.Parse(args); err != nil {
+		return 2
+	}
+
+	idp, err := mockidp.New(*issuer, *client, *secret)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	fmt.Fprintf(os.Stderr, "mock idp %s listening on %s\n", *issuer, *addr)
+	if err = http.ListenAndServe(*addr, idp); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	return 0
+}
@@ -0,0 +1,86 @@
+package apikey
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"time"
+	"unicode/utf8"
+)
+
+var (
+	ErrKeyNotFound    = errors.New("api key not found")
+	ErrInvalidScope   = errors.New("invalid api key scope")
+	ErrInvalidKeyName = errors.New("invalid api key name")
+)
+
+// MaxNameLen matches the VARCHAR(64) api_keys.name column
+const MaxNameLen = 64
+
+// Scope grants an API key access to a group of endpoints.
+type Scope string
+
+const (
+	ScopeReadInfo  Scope = "read:info"
+	ScopeSendCoins Scope = "send:coins"
+	ScopeBuyItems  Scope = "buy:items"
+)
+
+// ParseScopes validates scopes and drops duplicates.
+func ParseScopes(scopes []string) ([]Scope, error) {
+	var res []Scope
+
+	for _, s := range scopes {
+		switch sc := Scope(s); sc {
+		case ScopeReadInfo, ScopeSendCoins, ScopeBuyItems:
+			if !slices.Contains(res, sc) {
+				res = append(res, sc)
+			}
+		default:
+			return nil, ErrInvalidScope
+		}
+	}
+
+	if len(res) == 0 {
+		return nil, ErrInvalidScope
+	}
+
+	return res, nil
+}
+
+func ValidateName(name string) error {
+	if n := utf8.RuneCountInString(name); n == 0 || n > MaxNameLen {
+		return ErrInvalidKeyName
+	}
+
+	return nil
+}
+
+// Key is a long-lived credential a user creates for a bot or an
+// integration. Only the SHA-256 hash of the key is stored, Prefix is
+// kept to tell the keys apart.
+type Key struct {
+	Id         int
+	UserId     int
+	Name       string
+	Prefix     string
+	Hash       string
+	Scopes     []Scope
+	CreatedAt  time.Time
+	LastUsedAt *time.Time
+}
+
+func (k Key) HasScope(scope Scope) bool {
+	return slices.Contains(k.Scopes, scope)
+}
+
+type KeyRepo interface {
+	CreateKey(ctx context.Context, key Key) (Key, error)
+	// ListKeys returns the keys of the user that are not revoked.
+	ListKeys(ctx context.Context, userId int) ([]Key, error)
+	GetKeyByHash(ctx context.Context, hash string) (Key, error)
+	RevokeKey(ctx context.Context, userId, id int) error
+	// RevokeUserKeys revokes every key of the user.
+	RevokeUserKeys(ctx context.Context, userId int) error
+	TouchKey(ctx context.Context, id int, at time.Time) error
+}
@@ -6,7 +6,13 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"slices"
 	"strconv"
+	"strings"
+	"time"
+
+	"github.com/437d5/merch-store/pkg/password"
+	"github.com/437d5/merch-store/pkg/token"
 )
 
 const (
@@ -16,13 +22,55 @@ const (
 	dbPassEnv = "DATABASE_PASSWORD"
 	dbNameEnv = "DATABASE_NAME"
 	dbHostEnv = "DATABASE_HOST"
+	dbPathEnv = "SQLITE_PATH"
+
+	dbMigrateEnv   = "MIGRATE_ON_START"
+	dbReconcileEnv = "RECONCILE_ON_START"
+	dbDriverEnv    = "STORAGE_DRIVER"
 
 	// env names for srv config
 	srvPortEnv = "SERVER_PORT"
 
+	// env names for jwt config
+	jwtSecretEnv          = "JWT_SECRET"
+	jwtKeysFileEnv        = "JWT_KEYS_FILE"
+	accessTTLEnv          = "ACCESS_TOKEN_TTL"
+	refreshTTLEnv         = "REFRESH_TOKEN_TTL"
+	revocationCacheTTLEnv = "REVOCATION_CACHE_TTL"
+
 	secretKeyLen = 16
 
 	logModeEnv = "LOG_MODE"
+
+	// env names for idempotency config
+	idempotencyRetentionEnv = "IDEMPOTENCY_RETENTION"
+
+	// comma separated usernames that are granted the admin role
+	adminUsersEnv = "ADMIN_USERS"
+
+	// env names for auth config
+	autoRegisterEnv     = "AUTO_REGISTER"
+	loginUserLimitEnv   = "LOGIN_USER_FAILURE_LIMIT"
+	loginIPLimitEnv     = "LOGIN_IP_FAILURE_LIMIT"
+	loginBackoffBaseEnv = "LOGIN_BACKOFF_BASE"
+	loginMaxLockoutEnv  = "LOGIN_MAX_LOCKOUT"
+	passwordResetTTLEnv = "PASSWORD_RESET_TTL"
+
+	// env names for password hashing config
+	passwordHashEnv     = "PASSWORD_HASH"
+	bcryptCostEnv       = "BCRYPT_COST"
+	argonMemoryEnv      = "ARGON2_MEMORY_KIB"
+	argonIterationsEnv  = "ARGON2_ITERATIONS"
+	argonParallelismEnv = "ARGON2_PARALLELISM"
+
+	// env names for oidc config, single sign-on is off without an issuer
+	oidcIssuerEnv        = "OIDC_ISSUER"
+	oidcClientIDEnv      = "OIDC_CLIENT_ID"
+	oidcClientSecretEnv  = "OIDC_CLIENT_SECRET"
+	oidcRedirectURLEnv   = "OIDC_REDIRECT_URL"
+	oidcScopesEnv        = "OIDC_SCOPES"
+	oidcUsernameClaimEnv = "OIDC_USERNAME_CLAIM"
+	oidcStateTTLEnv      = "OIDC_STATE_TTL"
 )
 
 type Config struct {
@@ -30,28 +78,98 @@ type Config struct {
 	Srv ConfigSrv
 	JWT ConfigJWT
 	Log ConfigLog
+	Idm ConfigIdempotency
+	Adm ConfigAdmin
+	Ath ConfigAuth
+	Pwd ConfigPassword
+	Sso ConfigOIDC
 }
 
 type ConfigSrv struct {
 	SrvPort int
 }
 
+// storage drivers accepted in STORAGE_DRIVER
+const (
+	DriverPostgres = "postgres"
+	DriverSQLite   = "sqlite"
+	DriverMemory   = "memory"
+)
+
 type ConfigDB struct {
+	// Driver is the storage backend, DriverPostgres, DriverSQLite or
+	// DriverMemory
+	Driver string
 	DbPort int
 	DbUser string
 	DbPass string
 	DbName string
 	DbHost string
+	// DbPath is the database file of DriverSQLite
+	DbPath string
+	// MigrateOnStart applies pending migrations before serving
+	MigrateOnStart bool
+	// ReconcileOnStart checks the ledger in the background once the
+	// server starts
+	ReconcileOnStart bool
 }
 
 type ConfigJWT struct {
-	Secret string
+	Keys       *token.KeySet
+	AccessTTL  time.Duration
+	RefreshTTL time.Duration
+	// RevocationCacheTTL is how long a token found not revoked is
+	// trusted without asking the database again
+	RevocationCacheTTL time.Duration
 }
 
 type ConfigLog struct {
 	LogMode string
 }
 
+type ConfigIdempotency struct {
+	Retention time.Duration
+}
+
+type ConfigAdmin struct {
+	Usernames []string
+}
+
+type ConfigAuth struct {
+	// AutoRegister registers unknown users on POST /api/auth
+	AutoRegister bool
+	// failed logins allowed per username and per client address
+	// before the backoff starts
+	UserFailureLimit int
+	IPFailureLimit   int
+	BackoffBase      time.Duration
+	MaxLockout       time.Duration
+	// ResetTTL is how long an admin issued password reset token is valid
+	ResetTTL time.Duration
+}
+
+type ConfigPassword struct {
+	// Hasher hashes new passwords with the configured algorithm,
+	// outdated hashes are replaced on login
+	Hasher *password.Hasher
+}
+
+type ConfigOIDC struct {
+	// Issuer is the provider URL, empty when single sign-on is disabled
+	Issuer        string
+	ClientID      string
+	ClientSecret  string
+	RedirectURL   string
+	Scopes        []string
+	UsernameClaim string
+	// StateTTL is how long a user may take to sign in at the provider
+	StateTTL time.Duration
+}
+
+func (c ConfigOIDC) Enabled() bool {
+	return c.Issuer != ""
+}
+
 func MustLoad() *Config {
 	dbPortStr := getStringOrDefault(dbPortEnv, "5432")
 	dbPort, err := strconv.Atoi(dbPortStr)
@@ -63,6 +181,23 @@ func MustLoad() *Config {
 	dbPass := getStringOrDefault(dbPassEnv, "password")
 	dbName := getStringOrDefault(dbNameEnv, "shop")
 	dbHost := getStringOrDefault(dbHostEnv, "db")
+	dbPath := getStringOrDefault(dbPathEnv, "merch-store.db")
+
+	migrateOnStart, err := strconv.ParseBool(getStringOrDefault(dbMigrateEnv, "false"))
+	if err != nil {
+		log.Fatalf("invalid migrate on start flag: %s", err)
+	}
+
+	reconcileOnStart, err := strconv.ParseBool(getStringOrDefault(dbReconcileEnv, "false"))
+	if err != nil {
+		log.Fatalf("invalid reconcile on start flag: %s", err)
+	}
+
+	driver := getStringOrDefault(dbDriverEnv, DriverPostgres)
+	if driver != DriverPostgres && driver != DriverSQLite && driver != DriverMemory {
+		log.Fatalf("unknown storage driver %q, use %s, %s or %s",
+			driver, DriverPostgres, DriverSQLite, DriverMemory)
+	}
 
 	srvPortStr := getStringOrDefault(srvPortEnv, "8080")
 	srvPort, err := strconv.Atoi(srvPortStr)
@@ -70,33 +205,166 @@ func MustLoad() *Config {
 		log.Fatalf("invalid server port: %s", err)
 	}
 
-	secret, err := generateSecretKey(secretKeyLen)
+	keys, err := loadJWTKeys()
 	if err != nil {
 		log.Fatal(err)
 	}
 
+	accessTTL, err := time.ParseDuration(getStringOrDefault(accessTTLEnv, "1h"))
+	if err != nil || accessTTL <= 0 {
+		log.Fatalf("invalid access token ttl: %v", err)
+	}
+
+	refreshTTL, err := time.ParseDuration(getStringOrDefault(refreshTTLEnv, "720h"))
+	if err != nil || refreshTTL <= 0 {
+		log.Fatalf("invalid refresh token ttl: %v", err)
+	}
+
+	revocationCacheTTL, err := time.ParseDuration(getStringOrDefault(revocationCacheTTLEnv, "30s"))
+	if err != nil || revocationCacheTTL < 0 {
+		log.Fatalf("invalid revocation cache ttl: %v", err)
+	}
+
+	retention, err := time.ParseDuration(getStringOrDefault(idempotencyRetentionEnv, "24h"))
+	if err != nil {
+		log.Fatalf("invalid idempotency retention: %s", err)
+	}
+
+	var admins []string
+	for _, name := range strings.Split(getStringOrDefault(adminUsersEnv, ""), ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			admins = append(admins, name)
+		}
+	}
+
+	autoRegister, err := strconv.ParseBool(getStringOrDefault(autoRegisterEnv, "true"))
+	if err != nil {
+		log.Fatalf("invalid auto register flag: %s", err)
+	}
+
+	userFailureLimit, err := strconv.Atoi(getStringOrDefault(loginUserLimitEnv, "5"))
+	if err != nil || userFailureLimit < 0 {
+		log.Fatalf("invalid login failure limit: %v", err)
+	}
+
+	ipFailureLimit, err := strconv.Atoi(getStringOrDefault(loginIPLimitEnv, "20"))
+	if err != nil || ipFailureLimit < 0 {
+		log.Fatalf("invalid login failure limit: %v", err)
+	}
+
+	backoffBase, err := time.ParseDuration(getStringOrDefault(loginBackoffBaseEnv, "1s"))
+	if err != nil || backoffBase <= 0 {
+		log.Fatalf("invalid login backoff: %v", err)
+	}
+
+	maxLockout, err := time.ParseDuration(getStringOrDefault(loginMaxLockoutEnv, "15m"))
+	if err != nil || maxLockout < backoffBase {
+		log.Fatalf("invalid login max lockout: %v", err)
+	}
+
+	resetTTL, err := time.ParseDuration(getStringOrDefault(passwordResetTTLEnv, "24h"))
+	if err != nil || resetTTL <= 0 {
+		log.Fatalf("invalid password reset ttl: %v", err)
+	}
+
+	hasher, err := loadHasher()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	oidc := ConfigOIDC{
+		Issuer:        os.Getenv(oidcIssuerEnv),
+		ClientID:      os.Getenv(oidcClientIDEnv),
+		ClientSecret:  os.Getenv(oidcClientSecretEnv),
+		RedirectURL:   os.Getenv(oidcRedirectURLEnv),
+		Scopes:        strings.Fields(strings.ReplaceAll(os.Getenv(oidcScopesEnv), ",", " ")),
+		UsernameClaim: getStringOrDefault(oidcUsernameClaimEnv, "preferred_username"),
+	}
+	oidc.StateTTL, err = time.ParseDuration(getStringOrDefault(oidcStateTTLEnv, "10m"))
+	if err != nil || oidc.StateTTL <= 0 {
+		log.Fatalf("invalid oidc state ttl: %v", err)
+	}
+	if oidc.Enabled() && (oidc.ClientID == "" || oidc.RedirectURL == "") {
+		log.Fatalf("%s and %s are required with %s", oidcClientIDEnv, oidcRedirectURLEnv, oidcIssuerEnv)
+	}
+
 	log := getStringOrDefault(logModeEnv, "JSON")
 
 	return &Config{
 		Db: ConfigDB{
+			Driver: driver,
 			DbPort: dbPort,
 			DbUser: dbUser,
 			DbPass: dbPass,
 			DbName: dbName,
 			DbHost: dbHost,
+			DbPath: dbPath,
+
+			MigrateOnStart:   migrateOnStart,
+			ReconcileOnStart: reconcileOnStart,
 		},
 		Srv: ConfigSrv{
 			SrvPort: srvPort,
 		},
 		JWT: ConfigJWT{
-			Secret: secret,
+			Keys:               keys,
+			AccessTTL:          accessTTL,
+			RefreshTTL:         refreshTTL,
+			RevocationCacheTTL: revocationCacheTTL,
 		},
 		Log: ConfigLog{
 			LogMode: log,
 		},
+		Idm: ConfigIdempotency{
+			Retention: retention,
+		},
+		Adm: ConfigAdmin{
+			Usernames: admins,
+		},
+		Pwd: ConfigPassword{
+			Hasher: hasher,
+		},
+		Ath: ConfigAuth{
+			AutoRegister:     autoRegister,
+			UserFailureLimit: userFailureLimit,
+			IPFailureLimit:   ipFailureLimit,
+			BackoffBase:      backoffBase,
+			MaxLockout:       maxLockout,
+			ResetTTL:         resetTTL,
+		},
+		Sso: oidc,
 	}
 }
 
+func loadHasher() (*password.Hasher, error) {
+	bcryptCost, err := strconv.Atoi(getStringOrDefault(bcryptCostEnv, "10"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid bcrypt cost: %w", err)
+	}
+
+	var argon password.Argon2id
+
+	memory, err := strconv.ParseUint(getStringOrDefault(argonMemoryEnv, "19456"), 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid argon2 memory: %w", err)
+	}
+	argon.Memory = uint32(memory)
+
+	iterations, err := strconv.ParseUint(getStringOrDefault(argonIterationsEnv, "2"), 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid argon2 iterations: %w", err)
+	}
+	argon.Time = uint32(iterations)
+
+	parallelism, err := strconv.ParseUint(getStringOrDefault(argonParallelismEnv, "1"), 10, 8)
+	if err != nil {
+		return nil, fmt.Errorf("invalid argon2 parallelism: %w", err)
+	}
+	argon.Threads = uint8(parallelism)
+
+	return password.New(getStringOrDefault(passwordHashEnv, password.AlgBcrypt), bcryptCost, argon)
+}
+
 func getStringOrDefault(name, defaultVal string) string {
 	res := os.Getenv(name)
 	if res == "" {
@@ -106,6 +374,41 @@ func getStringOrDefault(name, defaultVal string) string {
 	return res
 }
 
+// placeholderSecrets are secrets from examples and old compose files,
+// anyone could sign tokens with them.
+var placeholderSecrets = []string{"change-me", "changeme", "secret", "jwt-secret"}
+
+// loadJWTKeys reads the signing keys from JWT_KEYS_FILE or uses JWT_SECRET
+// as the only key. Without either a random key is generated, so tokens
+// do not survive a restart.
+func loadJWTKeys() (*token.KeySet, error) {
+	if path := os.Getenv(jwtKeysFileEnv); path != "" {
+		keys, err := token.LoadKeySet(path)
+		if err != nil {
+			return nil, fmt.Errorf("cannot load jwt keys: %s", err)
+		}
+		return keys, nil
+	}
+
+	if secret := os.Getenv(jwtSecretEnv); secret != "" {
+		if slices.Contains(placeholderSecrets, strings.ToLower(secret)) {
+			return nil, fmt.Errorf(
+				"%s is set to the placeholder %q, generate one with \"merch-store keys secret\"",
+				jwtSecretEnv, secret,
+			)
+		}
+		return token.NewKeySet("default", []byte(secret)), nil
+	}
+
+	log.Printf("%s and %s are not set, using a random jwt secret", jwtKeysFileEnv, jwtSecretEnv)
+	secret, err := generateSecretKey(secretKeyLen)
+	if err != nil {
+		return nil, err
+	}
+
+	return token.NewKeySet("default", []byte(secret)), nil
+}
+
 func generateSecretKey(keyLen int) (string, error) {
 	bytes := make([]byte, keyLen)
 	_, err := rand.Read(bytes)
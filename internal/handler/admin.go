@@ -0,0 +1,250 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/437d5/merch-store/internal/items"
+	"github.com/437d5/merch-store/internal/lockout"
+	"github.com/437d5/merch-store/internal/user"
+	"github.com/gin-gonic/gin"
+)
+
+func (h *Handler) CreateItem(c *gin.Context) {
+	const op = "/internal/handler/admin/CreateItem"
+
+	var req struct {
+		Name string `json:"name" binding:"required"`
+		Cost int    `json:"cost" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		h.logger.Warn("invalid request", "op", op, "error", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+		return
+	}
+
+	item, err := h.catalogService.CreateItem(
+		c.Request.Context(), c.GetInt("user_id"), req.Name, req.Cost,
+	)
+	if err != nil {
+		h.catalogError(c, op, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, formatItem(item))
+}
+
+func (h *Handler) UpdateItemPrice(c *gin.Context) {
+	const op = "/internal/handler/admin/UpdateItemPrice"
+
+	var req struct {
+		Cost int `json:"cost" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		h.logger.Warn("invalid request", "op", op, "error", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+		return
+	}
+
+	item, err := h.catalogService.UpdatePrice(
+		c.Request.Context(), c.GetInt("user_id"), c.Param("name"), req.Cost,
+	)
+	if err != nil {
+		h.catalogError(c, op, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, formatItem(item))
+}
+
+func (h *Handler) RenameItem(c *gin.Context) {
+	const op = "/internal/handler/admin/RenameItem"
+
+	var req struct {
+		Name string `json:"name" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		h.logger.Warn("invalid request", "op", op, "error", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+		return
+	}
+
+	item, err := h.catalogService.RenameItem(
+		c.Request.Context(), c.GetInt("user_id"), c.Param("name"), req.Name,
+	)
+	if err != nil {
+		h.catalogError(c, op, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, formatItem(item))
+}
+
+func (h *Handler) RetireItem(c *gin.Context) {
+	const op = "/internal/handler/admin/RetireItem"
+
+	item, err := h.catalogService.RetireItem(
+		c.Request.Context(), c.GetInt("user_id"), c.Param("name"),
+	)
+	if err != nil {
+		h.catalogError(c, op, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, formatItem(item))
+}
+
+func (h *Handler) RestoreItem(c *gin.Context) {
+	const op = "/internal/handler/admin/RestoreItem"
+
+	item, err := h.catalogService.RestoreItem(
+		c.Request.Context(), c.GetInt("user_id"), c.Param("name"),
+	)
+	if err != nil {
+		h.catalogError(c, op, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, formatItem(item))
+}
+
+func (h *Handler) GetCatalogAudit(c *gin.Context) {
+	const op = "/internal/handler/admin/GetCatalogAudit"
+
+	cList, err := h.catalogService.ListChanges(c.Request.Context())
+	if err != nil {
+		h.logger.Error("failed list catalog changes", "op", op, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed list catalog changes"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"changes": formatCatalogChanges(cList)})
+}
+
+func (h *Handler) SetUserRole(c *gin.Context) {
+	const op = "/internal/handler/admin/SetUserRole"
+
+	var req struct {
+		Role string `json:"role" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		h.logger.Warn("invalid request", "op", op, "error", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+		return
+	}
+
+	role, err := user.ParseRole(req.Role)
+	if err != nil {
+		h.logger.Warn("invalid role", "op", op, "role", req.Role)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid role"})
+		return
+	}
+
+	err = h.userService.SetRole(c.Request.Context(), c.Param("name"), role)
+	if err != nil {
+		if errors.Is(err, user.ErrUserNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+			return
+		}
+		h.logger.Error("failed set role", "op", op, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed set role"})
+		return
+	}
+
+	h.logger.Info("role changed", "op", op, "name", c.Param("name"), "role", role, "by", c.GetInt("user_id"))
+	c.Status(http.StatusOK)
+}
+
+func (h *Handler) RevokeUserSessions(c *gin.Context) {
+	const op = "/internal/handler/admin/RevokeUserSessions"
+
+	err := h.sessionService.RevokeUser(c.Request.Context(), c.Param("name"))
+	if err != nil {
+		if errors.Is(err, user.ErrUserNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+			return
+		}
+		h.logger.Error("failed revoke sessions", "op", op, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed revoke sessions"})
+		return
+	}
+
+	h.logger.Info("sessions revoked", "op", op, "name", c.Param("name"), "by", c.GetInt("user_id"))
+	c.Status(http.StatusOK)
+}
+
+func (h *Handler) ListLockouts(c *gin.Context) {
+	const op = "/internal/handler/admin/ListLockouts"
+
+	lList, err := h.lockoutService.ListLockouts(c.Request.Context())
+	if err != nil {
+		h.logger.Error("failed list lockouts", "op", op, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed list lockouts"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"lockouts": formatLockouts(lList)})
+}
+
+func (h *Handler) Unlock(c *gin.Context) {
+	const op = "/internal/handler/admin/Unlock"
+
+	scope, err := lockout.ParseScope(c.Param("scope"))
+	if err != nil {
+		h.logger.Warn("invalid scope", "op", op, "scope", c.Param("scope"))
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid scope"})
+		return
+	}
+
+	err = h.lockoutService.Unlock(c.Request.Context(), scope, c.Param("key"))
+	if err != nil {
+		if errors.Is(err, lockout.ErrLockoutNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "lockout not found"})
+			return
+		}
+		h.logger.Error("failed unlock", "op", op, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed unlock"})
+		return
+	}
+
+	h.logger.Info("login unlocked", "op", op, "scope", scope, "key", c.Param("key"), "by", c.GetInt("user_id"))
+	c.Status(http.StatusOK)
+}
+
+func (h *Handler) ReconcileLedger(c *gin.Context) {
+	const op = "/internal/handler/admin/ReconcileLedger"
+
+	report, err := h.ledgerService.Reconcile(c.Request.Context())
+	if err != nil {
+		h.logger.Error("failed reconcile ledger", "op", op, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed reconcile ledger"})
+		return
+	}
+
+	c.JSON(http.StatusOK, formatReconcileReport(report))
+}
+
+// catalogError maps catalog service errors to responses
+func (h *Handler) catalogError(c *gin.Context, op string, err error) {
+	switch {
+	case errors.Is(err, items.ErrInvalidItemName):
+		h.logger.Warn("invalid item name", "op", op)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid item name"})
+	case errors.Is(err, items.ErrInvalidItemCost):
+		h.logger.Warn("invalid item cost", "op", op)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid item cost"})
+	case errors.Is(err, items.ErrItemNotFound):
+		h.logger.Warn("item not found", "op", op)
+		c.JSON(http.StatusNotFound, gin.H{"error": "item not found"})
+	case errors.Is(err, items.ErrItemExists):
+		h.logger.Warn("item already exists", "op", op)
+		c.JSON(http.StatusConflict, gin.H{"error": "item already exists"})
+	default:
+		h.logger.Error("catalog update failed", "op", op, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "catalog update failed"})
+	}
+}
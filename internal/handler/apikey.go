@@ -0,0 +1,81 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/437d5/merch-store/internal/apikey"
+	"github.com/gin-gonic/gin"
+)
+
+func (h *Handler) CreateAPIKey(c *gin.Context) {
+	const op = "/internal/handler/apikey/CreateAPIKey"
+
+	var req struct {
+		Name   string   `json:"name" binding:"required"`
+		Scopes []string `json:"scopes" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		h.logger.Warn("invalid request", "op", op, "error", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+		return
+	}
+
+	key, raw, err := h.apiKeyService.Create(
+		c.Request.Context(), c.GetInt("user_id"), req.Name, req.Scopes,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, apikey.ErrInvalidKeyName):
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid key name"})
+		case errors.Is(err, apikey.ErrInvalidScope):
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid scopes"})
+		default:
+			h.logger.Error("failed create api key", "op", op, "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed create api key"})
+		}
+		return
+	}
+
+	res := formatAPIKey(key)
+	res["key"] = raw
+	c.JSON(http.StatusCreated, res)
+}
+
+func (h *Handler) ListAPIKeys(c *gin.Context) {
+	const op = "/internal/handler/apikey/ListAPIKeys"
+
+	kList, err := h.apiKeyService.List(c.Request.Context(), c.GetInt("user_id"))
+	if err != nil {
+		h.logger.Error("failed list api keys", "op", op, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed list api keys"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"keys": formatAPIKeys(kList)})
+}
+
+func (h *Handler) RevokeAPIKey(c *gin.Context) {
+	const op = "/internal/handler/apikey/RevokeAPIKey"
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid key id"})
+		return
+	}
+
+	err = h.apiKeyService.Revoke(c.Request.Context(), c.GetInt("user_id"), id)
+	if err != nil {
+		if errors.Is(err, apikey.ErrKeyNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "api key not found"})
+			return
+		}
+		h.logger.Error("failed revoke api key", "op", op, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed revoke api key"})
+		return
+	}
+
+	c.Status(http.StatusOK)
+}
@@ -1,7 +1,13 @@
 package handler
 
 import (
+	"github.com/437d5/merch-store/internal/apikey"
 	"github.com/437d5/merch-store/internal/inventory"
+	"github.com/437d5/merch-store/internal/items"
+	"github.com/437d5/merch-store/internal/ledger"
+	"github.com/437d5/merch-store/internal/lockout"
+	"github.com/437d5/merch-store/internal/purchases"
+	"github.com/437d5/merch-store/internal/service"
 	"github.com/437d5/merch-store/internal/transactions"
 	"github.com/gin-gonic/gin"
 )
@@ -12,13 +18,17 @@ func formatTranscations(transactions []transactions.Transaction, userId int) gin
 	for _, t := range transactions {
 		if t.FromUser == userId {
 			sent = append(sent, gin.H{
-				"toUser": t.ToUser,
-				"amount": t.Amount,
+				"id":        t.Id,
+				"toUser":    t.ToUsername,
+				"amount":    t.Amount,
+				"timestamp": t.Timestamp,
 			})
 		} else {
 			received = append(received, gin.H{
-				"fromUser": t.FromUser,
-				"amount":   t.Amount,
+				"id":        t.Id,
+				"fromUser":  t.FromUsername,
+				"amount":    t.Amount,
+				"timestamp": t.Timestamp,
 			})
 		}
 	}
@@ -29,6 +39,22 @@ func formatTranscations(transactions []transactions.Transaction, userId int) gin
 	}
 }
 
+func formatTransactionPage(transactions []transactions.Transaction) []gin.H {
+	page := []gin.H{}
+
+	for _, t := range transactions {
+		page = append(page, gin.H{
+			"id":        t.Id,
+			"fromUser":  t.FromUsername,
+			"toUser":    t.ToUsername,
+			"amount":    t.Amount,
+			"timestamp": t.Timestamp,
+		})
+	}
+
+	return page
+}
+
 func formatInventory(inventory inventory.Inventory) []gin.H {
 	var items []gin.H
 
@@ -41,3 +67,135 @@ func formatInventory(inventory inventory.Inventory) []gin.H {
 
 	return items
 }
+
+func formatPurchases(purchases []purchases.Purchase) []gin.H {
+	var history []gin.H
+
+	for _, p := range purchases {
+		history = append(history, gin.H{
+			"item":        p.ItemName,
+			"cost":        p.Cost,
+			"purchasedAt": p.CreatedAt,
+		})
+	}
+
+	return history
+}
+
+func formatItem(item items.ItemType) gin.H {
+	return gin.H{
+		"name":      item.Name,
+		"cost":      item.Cost,
+		"available": item.Available,
+	}
+}
+
+func formatItems(items []items.ItemType) []gin.H {
+	catalog := []gin.H{}
+
+	for _, i := range items {
+		catalog = append(catalog, formatItem(i))
+	}
+
+	return catalog
+}
+
+func formatCatalogChanges(changes []items.Change) []gin.H {
+	log := []gin.H{}
+
+	for _, c := range changes {
+		entry := gin.H{
+			"id":        c.Id,
+			"actorId":   c.ActorId,
+			"action":    c.Action,
+			"item":      c.ItemName,
+			"createdAt": c.CreatedAt,
+		}
+		if c.Before != nil {
+			entry["before"] = formatItem(*c.Before)
+		}
+		if c.After != nil {
+			entry["after"] = formatItem(*c.After)
+		}
+
+		log = append(log, entry)
+	}
+
+	return log
+}
+
+func formatAPIKey(k apikey.Key) gin.H {
+	res := gin.H{
+		"id":        k.Id,
+		"name":      k.Name,
+		"prefix":    k.Prefix,
+		"scopes":    k.Scopes,
+		"createdAt": k.CreatedAt,
+	}
+	if k.LastUsedAt != nil {
+		res["lastUsedAt"] = *k.LastUsedAt
+	}
+
+	return res
+}
+
+func formatAPIKeys(keys []apikey.Key) []gin.H {
+	res := []gin.H{}
+
+	for _, k := range keys {
+		res = append(res, formatAPIKey(k))
+	}
+
+	return res
+}
+
+func formatLockouts(lockouts []lockout.Lockout) []gin.H {
+	res := []gin.H{}
+
+	for _, l := range lockouts {
+		entry := gin.H{
+			"scope":       l.Scope,
+			"key":         l.Key,
+			"failures":    l.Failures,
+			"lastFailure": l.LastFailure,
+		}
+		if l.LockedUntil != nil {
+			entry["lockedUntil"] = *l.LockedUntil
+		}
+
+		res = append(res, entry)
+	}
+
+	return res
+}
+
+func formatReconcileReport(report ledger.Report) gin.H {
+	mismatches := []gin.H{}
+
+	for _, m := range report.Mismatches {
+		mismatches = append(mismatches, gin.H{
+			"accountId": m.AccountId,
+			"cached":    m.Cached,
+			"posted":    m.Posted,
+		})
+	}
+
+	unbalanced := report.UnbalancedEntries
+	if unbalanced == nil {
+		unbalanced = []int{}
+	}
+
+	return gin.H{
+		"balanced":          report.Balanced(),
+		"total":             report.Total,
+		"unbalancedEntries": unbalanced,
+		"mismatches":        mismatches,
+	}
+}
+
+func formatTokens(tokens service.Tokens) gin.H {
+	return gin.H{
+		"token":        tokens.Access,
+		"refreshToken": tokens.Refresh,
+	}
+}
@@ -1,12 +1,20 @@
 package handler
 
 import (
+	"encoding/json"
 	"errors"
 	"log/slog"
+	"math"
 	"net/http"
+	"strconv"
+	"time"
 
+	"github.com/437d5/merch-store/internal/apikey"
 	"github.com/437d5/merch-store/internal/config"
+	"github.com/437d5/merch-store/internal/items"
 	"github.com/437d5/merch-store/internal/service"
+	"github.com/437d5/merch-store/internal/transactions"
+	"github.com/437d5/merch-store/internal/user"
 	"github.com/437d5/merch-store/pkg/token"
 	"github.com/gin-gonic/gin"
 )
@@ -15,31 +23,96 @@ type Handler struct {
 	userService        *service.UserService
 	marketService      *service.MarketService
 	transactionService *service.TransactionService
-	logger             *slog.Logger
-	cfg                config.Config
+	idempotencyService *service.IdempotencyService
+	catalogService     *service.CatalogService
+	ledgerService      *service.LedgerService
+	sessionService     *service.SessionService
+	lockoutService     *service.LockoutService
+	passwordService    *service.PasswordService
+	apiKeyService      *service.APIKeyService
+	// oidcService is nil when single sign-on is not configured
+	oidcService *service.OIDCService
+	logger      *slog.Logger
+	cfg         config.Config
 }
 
 func NewHandler(
 	userService *service.UserService,
 	marketService *service.MarketService,
 	transactionService *service.TransactionService,
+	idempotencyService *service.IdempotencyService,
+	catalogService *service.CatalogService,
+	ledgerService *service.LedgerService,
+	sessionService *service.SessionService,
+	lockoutService *service.LockoutService,
+	passwordService *service.PasswordService,
+	apiKeyService *service.APIKeyService,
+	oidcService *service.OIDCService,
+	cfg config.Config,
 	logger *slog.Logger,
 ) *Handler {
 	return &Handler{
 		userService:        userService,
 		marketService:      marketService,
 		transactionService: transactionService,
+		idempotencyService: idempotencyService,
+		catalogService:     catalogService,
+		ledgerService:      ledgerService,
+		sessionService:     sessionService,
+		lockoutService:     lockoutService,
+		passwordService:    passwordService,
+		apiKeyService:      apiKeyService,
+		oidcService:        oidcService,
 		logger:             logger,
+		cfg:                cfg,
 	}
 }
 
 func (h *Handler) SetupRoutes(router *gin.Engine) {
+	router.GET("/.well-known/jwks.json", h.JWKS)
+
 	api := router.Group("/api")
 
-	api.GET("/info", h.AuthMiddleware, h.GetUserInfo)
-	api.POST("/sendCoin", h.AuthMiddleware, h.SendCoin)
-	api.GET("/buy/:item", h.AuthMiddleware, h.BuyItem)
+	readInfo := h.AllowAPIKey(apikey.ScopeReadInfo)
+	sendCoins := h.AllowAPIKey(apikey.ScopeSendCoins)
+	buyItems := h.AllowAPIKey(apikey.ScopeBuyItems)
+
+	api.GET("/info", readInfo, h.AuthMiddleware, h.GetUserInfo)
+	api.GET("/transactions", readInfo, h.AuthMiddleware, h.GetTransactions)
+	api.POST("/sendCoin", sendCoins, h.AuthMiddleware, h.IdempotencyMiddleware, h.SendCoin)
+	api.GET("/buy/:item", buyItems, h.AuthMiddleware, h.IdempotencyMiddleware, h.BuyItem)
+	api.GET("/items", h.ListItems)
+	api.POST("/register", h.Register)
 	api.POST("/auth", h.Auth)
+	api.POST("/auth/refresh", h.Refresh)
+	api.POST("/auth/logout", h.AuthMiddleware, h.Logout)
+	api.POST("/password", h.AuthMiddleware, h.ChangePassword)
+	api.POST("/password/reset", h.ResetPassword)
+	api.GET("/keys", h.AuthMiddleware, h.ListAPIKeys)
+	api.POST("/keys", h.AuthMiddleware, h.CreateAPIKey)
+	api.DELETE("/keys/:id", h.AuthMiddleware, h.RevokeAPIKey)
+
+	if h.oidcService != nil {
+		api.GET("/oidc/login", h.OIDCLogin)
+		api.GET("/oidc/callback", h.OIDCCallback)
+	}
+
+	adminOnly := h.RequireRole(user.RoleAdmin)
+	adminOrAuditor := h.RequireRole(user.RoleAdmin, user.RoleAuditor)
+
+	admin := api.Group("/admin", h.AuthMiddleware)
+	admin.POST("/items", adminOnly, h.CreateItem)
+	admin.PUT("/items/:name/price", adminOnly, h.UpdateItemPrice)
+	admin.PUT("/items/:name/name", adminOnly, h.RenameItem)
+	admin.POST("/items/:name/retire", adminOnly, h.RetireItem)
+	admin.POST("/items/:name/restore", adminOnly, h.RestoreItem)
+	admin.PUT("/users/:name/role", adminOnly, h.SetUserRole)
+	admin.DELETE("/users/:name/sessions", adminOnly, h.RevokeUserSessions)
+	admin.POST("/users/:name/password-reset", adminOnly, h.IssuePasswordReset)
+	admin.GET("/lockouts", adminOnly, h.ListLockouts)
+	admin.DELETE("/lockouts/:scope/:key", adminOnly, h.Unlock)
+	admin.GET("/audit/catalog", adminOrAuditor, h.GetCatalogAudit)
+	admin.GET("/ledger/reconcile", adminOrAuditor, h.ReconcileLedger)
 }
 
 func (h *Handler) GetUserInfo(c *gin.Context) {
@@ -53,22 +126,98 @@ func (h *Handler) GetUserInfo(c *gin.Context) {
 		return
 	}
 
-	tList, err := h.transactionService.GetTransactionsByUser(c.Request.Context(), userId)
+	tList, err := h.transactionService.RecentTransactions(c.Request.Context(), userId)
 	if err != nil {
 		h.logger.Error("failed get transaction list", "op", op, "error", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err})
 		return
 	}
 
+	pList, err := h.marketService.RecentPurchases(c.Request.Context(), userId)
+	if err != nil {
+		h.logger.Error("failed get purchase list", "op", op, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err})
+		return
+	}
+
+	inv, err := h.marketService.GetInventory(c.Request.Context(), userId)
+	if err != nil {
+		h.logger.Error("failed get inventory", "op", op, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err})
+		return
+	}
+
 	response := gin.H{
-		"coins":       u.Coins,
-		"inventory":   formatInventory(u.Inventory),
-		"coinHistory": formatTranscations(tList, userId),
+		"coins":           u.Coins,
+		"inventory":       formatInventory(inv),
+		"coinHistory":     formatTranscations(tList, userId),
+		"purchaseHistory": formatPurchases(pList),
 	}
 
 	c.JSON(http.StatusOK, response)
 }
 
+func (h *Handler) GetTransactions(c *gin.Context) {
+	const op = "/internal/handler/handlers/GetTransactions"
+
+	userId := c.GetInt("user_id")
+
+	var req struct {
+		Direction    string    `form:"direction"`
+		Counterparty string    `form:"counterparty"`
+		MinAmount    int       `form:"minAmount" binding:"min=0"`
+		MaxAmount    int       `form:"maxAmount" binding:"min=0"`
+		From         time.Time `form:"from" time_format:"2006-01-02T15:04:05Z07:00"`
+		To           time.Time `form:"to" time_format:"2006-01-02T15:04:05Z07:00"`
+		Cursor       string    `form:"cursor"`
+		Limit        int       `form:"limit" binding:"min=0"`
+	}
+
+	if err := c.ShouldBindQuery(&req); err != nil {
+		h.logger.Warn("invalid request", "op", op, "error", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+		return
+	}
+
+	filter := transactions.Filter{
+		UserId:       userId,
+		Direction:    transactions.Direction(req.Direction),
+		Counterparty: req.Counterparty,
+		MinAmount:    req.MinAmount,
+		MaxAmount:    req.MaxAmount,
+		From:         req.From,
+		To:           req.To,
+		Limit:        req.Limit,
+	}
+
+	if req.Cursor != "" {
+		cursor, err := transactions.DecodeCursor(req.Cursor)
+		if err != nil {
+			h.logger.Warn("invalid cursor", "op", op, "error", err)
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid cursor"})
+			return
+		}
+		filter.After = &cursor
+	}
+
+	page, err := h.transactionService.ListTransactions(c.Request.Context(), filter)
+	if err != nil {
+		if errors.Is(err, service.ErrInvalidFilter) {
+			h.logger.Warn("invalid filter", "op", op, "error", err)
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid filter"})
+			return
+		}
+		h.logger.Error("failed list transactions", "op", op, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed list transactions"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"transactions": formatTransactionPage(page.Transactions),
+		"nextCursor":   page.NextCursor,
+	})
+}
+
 func (h *Handler) SendCoin(c *gin.Context) {
 	const op = "/internal/handler/handlers/SendCoin"
 
@@ -99,9 +248,17 @@ func (h *Handler) SendCoin(c *gin.Context) {
 			h.logger.Warn("invalid amount", "op", op, "amount", req.Amount)
 			c.JSON(http.StatusBadRequest, gin.H{"errors": "invalid amount"})
 			return
+		} else if errors.Is(err, service.ErrSelfTransfer) {
+			h.logger.Warn("self transfer", "op", op, "id", userId)
+			c.JSON(http.StatusBadRequest, gin.H{"errors": "cannot send coins to yourself"})
+			return
+		} else if errors.Is(err, user.ErrUserNotFound) {
+			h.logger.Warn("recipient not found", "op", op, "to", req.ToUsername)
+			c.JSON(http.StatusBadRequest, gin.H{"errors": "recipient not found"})
+			return
 		} else {
 			h.logger.Error("failed transfer coins", "op", op, "error", err)
-			c.JSON(http.StatusInternalServerError, gin.H{"errors": err})
+			c.JSON(http.StatusInternalServerError, gin.H{"errors": "failed transfer coins"})
 			return
 		}
 	}
@@ -120,6 +277,14 @@ func (h *Handler) BuyItem(c *gin.Context) {
 			h.logger.Warn("not enough coins to buy merch", "op", op, "id", userId)
 			c.JSON(http.StatusBadRequest, gin.H{"errors": "not enough money"})
 			return
+		} else if errors.Is(err, service.ErrItemUnavailable) {
+			h.logger.Warn("item is not available", "op", op, "item", c.Param("item"))
+			c.JSON(http.StatusBadRequest, gin.H{"errors": "item is not available"})
+			return
+		} else if errors.Is(err, items.ErrItemNotFound) {
+			h.logger.Warn("item not found", "op", op, "item", c.Param("item"))
+			c.JSON(http.StatusBadRequest, gin.H{"errors": "item not found"})
+			return
 		} else {
 			h.logger.Error("failed buy item", "op", op, "error", err)
 			c.JSON(http.StatusInternalServerError, gin.H{"errors": "failed buy merch"})
@@ -130,6 +295,47 @@ func (h *Handler) BuyItem(c *gin.Context) {
 	c.Status(http.StatusOK)
 }
 
+func (h *Handler) ListItems(c *gin.Context) {
+	const op = "/internal/handler/handlers/ListItems"
+
+	iList, err := h.marketService.ListItems(c.Request.Context(), c.Query("sort"))
+	if err != nil {
+		if errors.Is(err, service.ErrInvalidSort) {
+			h.logger.Warn("invalid sort", "op", op, "sort", c.Query("sort"))
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid sort"})
+			return
+		}
+		h.logger.Error("failed list items", "op", op, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed list items"})
+		return
+	}
+
+	body, err := json.Marshal(gin.H{"items": formatItems(iList)})
+	if err != nil {
+		h.logger.Error("failed encode items", "op", op, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed list items"})
+		return
+	}
+
+	etag := computeETag(body)
+	c.Header("ETag", etag)
+	c.Header("Cache-Control", "no-cache")
+
+	if etagMatches(c.GetHeader("If-None-Match"), etag) {
+		c.Status(http.StatusNotModified)
+		return
+	}
+
+	c.Data(http.StatusOK, gin.MIMEJSON+"; charset=utf-8", body)
+}
+
+// JWKS publishes the public signing keys so that other services can
+// verify merch-store tokens without sharing a secret.
+func (h *Handler) JWKS(c *gin.Context) {
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, h.cfg.JWT.Keys.JWKS())
+}
+
 func (h *Handler) Auth(c *gin.Context) {
 	const op = "/internal/handler/handlers/Auth"
 
@@ -146,22 +352,147 @@ func (h *Handler) Auth(c *gin.Context) {
 		return
 	}
 
+	ip := c.ClientIP()
+
+	// the attempt counts as failed until the password proves right
+	wait, err := h.lockoutService.Attempt(c.Request.Context(), req.Username, ip)
+	if err != nil {
+		h.logger.Error("failed to check lockout", "op", op, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "authentification failed"})
+		return
+	}
+
+	if wait > 0 {
+		h.logger.Warn("login locked", "op", op, "username", req.Username, "ip", ip)
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "too many failed attempts"})
+		return
+	}
+
 	u, err := h.userService.AuthUser(c.Request.Context(), req.Username, req.Password)
 	if err != nil {
-		h.logger.Warn("authentification failed", "op", op, "error", err)
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "authentification failed"})
+		if errors.Is(err, user.ErrUserNotFound) || errors.Is(err, service.ErrInvalidPassword) {
+			h.logger.Warn("authentification failed", "op", op, "error", err)
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "authentification failed"})
+			return
+		}
+		// an unknown user is registered when AUTO_REGISTER is on
+		if errors.Is(err, user.ErrInvalidUsername) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": invalidUsernameMessage()})
+			return
+		}
+		if errors.Is(err, user.ErrWeakPassword) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": weakPasswordMessage(err)})
+			return
+		}
+		h.logger.Error("authentification failed", "op", op, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "authentification failed"})
 		return
 	}
 
-	t, err := token.CreateToken(u.Id, u.Name, h.cfg.JWT.Secret, token.JWTExpAt)
+	if err = h.lockoutService.Succeed(c.Request.Context(), u.Name, ip); err != nil {
+		h.logger.Error("failed to reset failed logins", "op", op, "error", err)
+	}
+
+	tokens, err := h.sessionService.Login(c.Request.Context(), u)
 	if err != nil {
-		h.logger.Error("failed to create JWT", "op", op, "error", err)
+		h.logger.Error("failed to create tokens", "op", op, "error", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create token"})
 		return
 	}
 
 	h.logger.Info("user authentificated", "op", op, "username", req.Username)
 
-	c.Header("Authorization", bearerPrefix+t)
-	c.JSON(http.StatusOK, gin.H{"token": t})
+	c.Header("Authorization", bearerPrefix+tokens.Access)
+	c.JSON(http.StatusOK, formatTokens(tokens))
+}
+
+func (h *Handler) Register(c *gin.Context) {
+	const op = "/internal/handler/handlers/Register"
+
+	var req struct {
+		Username string `json:"username" binding:"required"`
+		Password string `json:"password" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		h.logger.Warn("invalid request", "op", op, "error", err)
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "invalid request",
+		})
+		return
+	}
+
+	u, err := h.userService.Register(c.Request.Context(), req.Username, req.Password)
+	if err != nil {
+		switch {
+		case errors.Is(err, user.ErrInvalidUsername):
+			c.JSON(http.StatusBadRequest, gin.H{"error": invalidUsernameMessage()})
+		case errors.Is(err, user.ErrWeakPassword):
+			c.JSON(http.StatusBadRequest, gin.H{"error": weakPasswordMessage(err)})
+		case errors.Is(err, user.ErrUserExists):
+			c.JSON(http.StatusConflict, gin.H{"error": "user already exists"})
+		default:
+			h.logger.Error("registration failed", "op", op, "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "registration failed"})
+		}
+		return
+	}
+
+	tokens, err := h.sessionService.Login(c.Request.Context(), u)
+	if err != nil {
+		h.logger.Error("failed to create tokens", "op", op, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create token"})
+		return
+	}
+
+	h.logger.Info("user registered", "op", op, "username", req.Username)
+
+	c.Header("Authorization", bearerPrefix+tokens.Access)
+	c.JSON(http.StatusCreated, formatTokens(tokens))
+}
+
+func (h *Handler) Refresh(c *gin.Context) {
+	const op = "/internal/handler/handlers/Refresh"
+
+	var req struct {
+		RefreshToken string `json:"refreshToken" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		h.logger.Warn("invalid request", "op", op, "error", err)
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "invalid request",
+		})
+		return
+	}
+
+	tokens, err := h.sessionService.Refresh(c.Request.Context(), req.RefreshToken)
+	if err != nil {
+		if errors.Is(err, service.ErrInvalidRefreshToken) || errors.Is(err, service.ErrRefreshTokenReused) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid refresh token"})
+			return
+		}
+		h.logger.Error("failed to refresh tokens", "op", op, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to refresh token"})
+		return
+	}
+
+	c.Header("Authorization", bearerPrefix+tokens.Access)
+	c.JSON(http.StatusOK, formatTokens(tokens))
+}
+
+func (h *Handler) Logout(c *gin.Context) {
+	const op = "/internal/handler/handlers/Logout"
+
+	claims := c.MustGet("claims").(*token.TokenClaims)
+
+	if err := h.sessionService.Logout(c.Request.Context(), claims); err != nil {
+		h.logger.Error("failed to logout", "op", op, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to logout"})
+		return
+	}
+
+	h.logger.Info("user logged out", "op", op, "id", claims.Id)
+	c.Status(http.StatusOK)
 }
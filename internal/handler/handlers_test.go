@@ -0,0 +1,217 @@
+package handler_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/437d5/merch-store/internal/config"
+	"github.com/437d5/merch-store/internal/handler"
+	"github.com/437d5/merch-store/internal/repository"
+	"github.com/437d5/merch-store/internal/service"
+	"github.com/437d5/merch-store/pkg/password"
+	"github.com/437d5/merch-store/pkg/token"
+	"github.com/gin-gonic/gin"
+)
+
+// newRouter serves the API over an in-memory store.
+func newRouter(t *testing.T) *gin.Engine {
+	t.Helper()
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	repos := repository.NewMemoryRepos(logger)
+	// the lowest bcrypt cost keeps the tests fast
+	hasher := password.NewHasher(password.Bcrypt{Cost: 4})
+	cfg := config.Config{
+		JWT: config.ConfigJWT{
+			Keys:               token.NewKeySet("test", []byte("test-secret")),
+			AccessTTL:          time.Minute,
+			RefreshTTL:         time.Hour,
+			RevocationCacheTTL: time.Minute,
+		},
+		Pwd: config.ConfigPassword{Hasher: hasher},
+	}
+
+	sessions := service.NewSessionService(
+		repos.RefreshTokens, repos.Revocations, repos.Keys, repos.Users, repos.TxManager,
+		cfg.JWT.Keys, cfg.JWT.AccessTTL, cfg.JWT.RefreshTTL, cfg.JWT.RevocationCacheTTL, logger,
+	)
+	h := handler.NewHandler(
+		service.NewUserService(
+			repos.Users, repos.Ledger, repos.Identities, repos.TxManager, hasher,
+			sessions, nil, true, logger,
+		),
+		service.NewMarketService(
+			repos.Users, logger, repos.Items, repos.Purchases, repos.Inventory,
+			repos.Ledger, repos.TxManager,
+		),
+		service.NewTransactionService(
+			repos.Transactions, repos.Users, repos.Ledger, repos.TxManager, logger,
+		),
+		service.NewIdempotencyService(repos.Idempotency, repos.TxManager, time.Hour, logger),
+		service.NewCatalogService(repos.Items, repos.TxManager, logger),
+		service.NewLedgerService(repos.Ledger, logger),
+		sessions,
+		service.NewLockoutService(repos.Lockouts, repos.TxManager, service.LockoutPolicy{
+			UserLimit: 5, IPLimit: 20, BaseDelay: time.Second, MaxLockout: time.Minute,
+		}, logger),
+		service.NewPasswordService(
+			repos.Users, repos.PasswordResets, repos.TxManager, hasher, sessions,
+			time.Hour, logger,
+		),
+		service.NewAPIKeyService(repos.Keys, logger),
+		nil,
+		cfg,
+		logger,
+	)
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	h.SetupRoutes(router)
+
+	return router
+}
+
+// do sends body as JSON and returns the recorded response.
+func do(router *gin.Engine, method, path, accessToken string, body any) *httptest.ResponseRecorder {
+	raw, _ := json.Marshal(body)
+	req := httptest.NewRequest(method, path, bytes.NewReader(raw))
+	req.Header.Set("Content-Type", "application/json")
+	if accessToken != "" {
+		req.Header.Set("Authorization", "Bearer "+accessToken)
+	}
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	return w
+}
+
+// register signs up a user and returns the access token or fails the test.
+func register(t *testing.T, router *gin.Engine, name string) string {
+	t.Helper()
+
+	w := do(router, http.MethodPost, "/api/register", "", gin.H{"username": name, "password": "password"})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("register %s: status %d, body %s", name, w.Code, w.Body)
+	}
+
+	var resp struct {
+		Token string `json:"token"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || resp.Token == "" {
+		t.Fatalf("register %s: body %s", name, w.Body)
+	}
+
+	return resp.Token
+}
+
+func TestSendCoin(t *testing.T) {
+	router := newRouter(t)
+	alice := register(t, router, "alice")
+	register(t, router, "bob")
+
+	w := do(router, http.MethodPost, "/api/sendCoin", alice, gin.H{"toUser": "bob", "amount": 10})
+	if w.Code != http.StatusOK {
+		t.Errorf("send to bob: status %d, body %s", w.Code, w.Body)
+	}
+
+	w = do(router, http.MethodPost, "/api/sendCoin", alice, gin.H{"toUser": "nobody", "amount": 10})
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("send to unknown user: status %d, want %d", w.Code, http.StatusBadRequest)
+	}
+
+	var resp struct {
+		Errors string `json:"errors"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || resp.Errors != "recipient not found" {
+		t.Errorf("send to unknown user: body %s", w.Body)
+	}
+}
+
+// coins returns the balance of the user or fails the test.
+func coins(t *testing.T, router *gin.Engine, accessToken string) int {
+	t.Helper()
+
+	w := do(router, http.MethodGet, "/api/info", accessToken, nil)
+	var resp struct {
+		Coins int `json:"coins"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("info: status %d, body %s", w.Code, w.Body)
+	}
+
+	return resp.Coins
+}
+
+func TestSendCoinIdempotent(t *testing.T) {
+	router := newRouter(t)
+	alice := register(t, router, "alice")
+	register(t, router, "bob")
+	before := coins(t, router, alice)
+
+	send := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/sendCoin", bytes.NewReader([]byte(`{"toUser":"bob","amount":10}`)))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+alice)
+		req.Header.Set("Idempotency-Key", "transfer-1")
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := send(); w.Code != http.StatusOK || w.Header().Get("Idempotent-Replayed") != "" {
+		t.Fatalf("send: status %d, headers %v", w.Code, w.Header())
+	}
+	if w := send(); w.Code != http.StatusOK || w.Header().Get("Idempotent-Replayed") != "true" {
+		t.Errorf("retry: status %d, headers %v, want a replay", w.Code, w.Header())
+	}
+
+	if got := coins(t, router, alice); got != before-10 {
+		t.Errorf("coins = %d, want %d", got, before-10)
+	}
+}
+
+func TestBuyItem(t *testing.T) {
+	router := newRouter(t)
+	alice := register(t, router, "alice")
+
+	if w := do(router, http.MethodGet, "/api/buy/cup", alice, nil); w.Code != http.StatusOK {
+		t.Errorf("buy cup: status %d, body %s", w.Code, w.Body)
+	}
+
+	w := do(router, http.MethodGet, "/api/buy/cupp", alice, nil)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("buy unknown item: status %d, want %d", w.Code, http.StatusBadRequest)
+	}
+
+	var resp struct {
+		Errors string `json:"errors"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || resp.Errors != "item not found" {
+		t.Errorf("buy unknown item: body %s", w.Body)
+	}
+}
+
+func TestAuthRegistersValidUsers(t *testing.T) {
+	router := newRouter(t)
+
+	for name, body := range map[string]gin.H{
+		"weak password":    {"username": "alice", "password": "short"},
+		"invalid username": {"username": "a l i c e", "password": "password"},
+	} {
+		if w := do(router, http.MethodPost, "/api/auth", "", body); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: status %d, want %d, body %s", name, w.Code, http.StatusBadRequest, w.Body)
+		}
+	}
+
+	if w := do(router, http.MethodPost, "/api/auth", "", gin.H{"username": "alice", "password": "password"}); w.Code != http.StatusOK {
+		t.Errorf("first login: status %d, body %s", w.Code, w.Body)
+	}
+}
@@ -0,0 +1,138 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/437d5/merch-store/internal/service"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	idempotencyHeader    = "Idempotency-Key"
+	idempotencyReplayed  = "Idempotent-Replayed"
+	maxIdempotencyKeyLen = 255
+)
+
+// responseBuffer holds back the response of a request until it has been
+// committed together with its idempotency record
+type responseBuffer struct {
+	gin.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (w *responseBuffer) WriteHeader(code int) {
+	w.status = code
+}
+
+func (w *responseBuffer) WriteHeaderNow() {}
+
+func (w *responseBuffer) Write(b []byte) (int, error) {
+	return w.body.Write(b)
+}
+
+func (w *responseBuffer) WriteString(s string) (int, error) {
+	return w.body.WriteString(s)
+}
+
+func (w *responseBuffer) Status() int {
+	return w.status
+}
+
+func (w *responseBuffer) Size() int {
+	return w.body.Len()
+}
+
+func (w *responseBuffer) Written() bool {
+	return w.body.Len() > 0
+}
+
+// IdempotencyMiddleware replays the stored response when a request is
+// retried with the same Idempotency-Key. The handlers after it run in one
+// transaction with the write of their response, so they must use the
+// ctx of c.Request. It must run after AuthMiddleware.
+func (h *Handler) IdempotencyMiddleware(c *gin.Context) {
+	const op = "/internal/handler/idempotency/IdempotencyMiddleware"
+
+	key := c.GetHeader(idempotencyHeader)
+	if key == "" {
+		c.Next()
+		return
+	}
+
+	if len(key) > maxIdempotencyKeyLen {
+		h.logger.Warn("idempotency key too long", "op", op)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid idempotency key"})
+		c.Abort()
+		return
+	}
+
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		h.logger.Warn("cannot read body", "op", op, "error", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+		c.Abort()
+		return
+	}
+	c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+	userId := c.GetInt("user_id")
+	// the stored response must outlive a client that hangs up
+	ctx := context.WithoutCancel(c.Request.Context())
+
+	buffer := &responseBuffer{ResponseWriter: c.Writer, status: http.StatusOK}
+	c.Writer = buffer
+	// restored before the response is sent or a panic is recovered
+	defer func() { c.Writer = buffer.ResponseWriter }()
+
+	rec, replay, err := h.idempotencyService.Do(
+		ctx, userId, key, requestFingerprint(c, body),
+		func(ctx context.Context) (int, []byte) {
+			c.Request = c.Request.WithContext(ctx)
+			c.Next()
+			return buffer.Status(), buffer.body.Bytes()
+		},
+	)
+	c.Writer = buffer.ResponseWriter
+	c.Abort()
+
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrIdempotencyConflict):
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "idempotency key reused with different request"})
+		case errors.Is(err, service.ErrIdempotencyInProgress):
+			c.JSON(http.StatusConflict, gin.H{"error": "request is already in progress"})
+		default:
+			// the request was rolled back, its response is dropped
+			h.logger.Error("idempotent request failed", "op", op, "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "request failed"})
+		}
+		return
+	}
+
+	if replay {
+		h.logger.Info("replaying response", "op", op, "userId", userId)
+		c.Header(idempotencyReplayed, "true")
+	}
+	if len(rec.Response) == 0 {
+		c.Status(rec.StatusCode)
+	} else {
+		c.Data(rec.StatusCode, gin.MIMEJSON+"; charset=utf-8", rec.Response)
+	}
+}
+
+func requestFingerprint(c *gin.Context, body []byte) string {
+	sum := sha256.New()
+	sum.Write([]byte(c.Request.Method))
+	sum.Write([]byte{0})
+	sum.Write([]byte(c.Request.URL.Path))
+	sum.Write([]byte{0})
+	sum.Write(body)
+	return hex.EncodeToString(sum.Sum(nil))
+}
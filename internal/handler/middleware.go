@@ -1,9 +1,14 @@
 package handler
 
 import (
+	"errors"
 	"net/http"
+	"slices"
 	"strings"
 
+	"github.com/437d5/merch-store/internal/apikey"
+	"github.com/437d5/merch-store/internal/service"
+	"github.com/437d5/merch-store/internal/user"
 	"github.com/437d5/merch-store/pkg/token"
 	"github.com/gin-gonic/gin"
 )
@@ -30,10 +35,16 @@ func (h *Handler) AuthMiddleware(c *gin.Context) {
 
 	t := strings.TrimPrefix(authHeader, bearerPrefix)
 
-	userId, ok, err := token.ValidateToken(t, h.cfg.JWT.Secret)
+	if service.IsAPIKey(t) {
+		h.authenticateAPIKey(c, t)
+		return
+	}
+
+	claims, ok, err := token.ValidateToken(t, h.cfg.JWT.Keys)
 	if err != nil {
-		h.logger.Error("failed to check token", "op", op, "error", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed parse token"})
+		// expired tokens end up here, clients renew them on 401
+		h.logger.Warn("failed to check token", "op", op, "error", err)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid token"})
 		c.Abort()
 		return
 	}
@@ -45,6 +56,91 @@ func (h *Handler) AuthMiddleware(c *gin.Context) {
 		return
 	}
 
-	c.Set("user_id", userId)
+	revoked, err := h.sessionService.IsRevoked(c.Request.Context(), claims)
+	if err != nil {
+		h.logger.Error("failed to check revocation", "op", op, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to check token"})
+		c.Abort()
+		return
+	}
+
+	if revoked {
+		h.logger.Warn("revoked token", "op", op, "id", claims.Id)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "token revoked"})
+		c.Abort()
+		return
+	}
+
+	c.Set("user_id", claims.Id)
+	c.Set("username", claims.Username)
+	c.Set("role", claims.Role)
+	c.Set("claims", claims)
+	c.Next()
+}
+
+// AllowAPIKey lets AuthMiddleware accept API keys granted scope in
+// place of a session token. It must run before AuthMiddleware, routes
+// without it accept session tokens only.
+func (h *Handler) AllowAPIKey(scope apikey.Scope) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set("api_key_scope", string(scope))
+		c.Next()
+	}
+}
+
+func (h *Handler) authenticateAPIKey(c *gin.Context, raw string) {
+	const op = "/internal/handler/middleware/authenticateAPIKey"
+
+	scope := apikey.Scope(c.GetString("api_key_scope"))
+	if scope == "" {
+		h.logger.Warn("api key not allowed", "op", op, "path", c.FullPath())
+		c.JSON(http.StatusForbidden, gin.H{"error": "api keys are not allowed here"})
+		c.Abort()
+		return
+	}
+
+	key, err := h.apiKeyService.Authenticate(c.Request.Context(), raw)
+	if err != nil {
+		if errors.Is(err, service.ErrInvalidAPIKey) {
+			h.logger.Warn("invalid api key", "op", op)
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid api key"})
+			c.Abort()
+			return
+		}
+		h.logger.Error("failed to check api key", "op", op, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to check api key"})
+		c.Abort()
+		return
+	}
+
+	if !key.HasScope(scope) {
+		h.logger.Warn("api key scope missing", "op", op, "keyId", key.Id, "scope", scope)
+		c.JSON(http.StatusForbidden, gin.H{"error": "api key lacks scope " + string(scope)})
+		c.Abort()
+		return
+	}
+
+	// no role or username is set, so role and password endpoints
+	// stay out of reach of API keys
+	c.Set("user_id", key.UserId)
+	c.Set("api_key_id", key.Id)
 	c.Next()
 }
+
+// RequireRole lets through only users whose token carries one of roles.
+// It must run after AuthMiddleware.
+func (h *Handler) RequireRole(roles ...user.Role) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		const op = "/internal/handler/middleware/RequireRole"
+
+		role := user.Role(c.GetString("role"))
+		if !slices.Contains(roles, role) {
+			h.logger.Warn("forbidden", "op", op, "id", c.GetInt("user_id"), "role", role)
+			c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
@@ -0,0 +1,101 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/437d5/merch-store/internal/service"
+	"github.com/gin-gonic/gin"
+)
+
+// oidcBindingCookie ties a login to the browser that started it
+const oidcBindingCookie = "oidc_login"
+
+// setBindingCookie sets the binding cookie of a login, a negative
+// maxAge deletes it. Only the callback gets the cookie, and SameSite=Lax
+// still sends it on the top-level redirect back from the provider.
+func (h *Handler) setBindingCookie(c *gin.Context, value string, maxAge int) {
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     oidcBindingCookie,
+		Value:    value,
+		Path:     "/api/oidc/callback",
+		MaxAge:   maxAge,
+		Secure:   strings.HasPrefix(h.cfg.Sso.RedirectURL, "https://"),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// OIDCLogin redirects the user to the identity provider. The optional
+// login_hint query parameter is passed on to the provider.
+func (h *Handler) OIDCLogin(c *gin.Context) {
+	const op = "/internal/handler/oidc/OIDCLogin"
+
+	url, binding, err := h.oidcService.Begin(c.Request.Context(), c.Query("login_hint"))
+	if err != nil {
+		h.logger.Error("failed begin oidc login", "op", op, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed begin login"})
+		return
+	}
+
+	h.setBindingCookie(c, binding, int(h.cfg.Sso.StateTTL.Seconds()))
+	c.Redirect(http.StatusFound, url)
+}
+
+// OIDCCallback completes the login the provider redirected back from
+// and answers with merch-store tokens, provisioning the user on the
+// first login.
+func (h *Handler) OIDCCallback(c *gin.Context) {
+	const op = "/internal/handler/oidc/OIDCCallback"
+
+	if idpErr := c.Query("error"); idpErr != "" {
+		h.logger.Warn("oidc login refused", "op", op, "error", idpErr)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "login refused: " + idpErr})
+		return
+	}
+
+	state, code := c.Query("state"), c.Query("code")
+	if state == "" || code == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "state and code are required"})
+		return
+	}
+
+	// a login started in another browser has no cookie here
+	binding, err := c.Cookie(oidcBindingCookie)
+	if err != nil {
+		h.logger.Warn("missing login binding", "op", op)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid or expired login state"})
+		return
+	}
+	h.setBindingCookie(c, "", -1)
+
+	claims, err := h.oidcService.Complete(c.Request.Context(), state, binding, code)
+	if err != nil {
+		if errors.Is(err, service.ErrInvalidLoginState) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid or expired login state"})
+			return
+		}
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "login failed"})
+		return
+	}
+
+	u, err := h.userService.AuthExternal(
+		c.Request.Context(), claims.Issuer, claims.Subject, claims.Username,
+	)
+	if err != nil {
+		h.logger.Error("failed to authenticate", "op", op, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to authenticate"})
+		return
+	}
+
+	tokens, err := h.sessionService.Login(c.Request.Context(), u)
+	if err != nil {
+		h.logger.Error("failed to create tokens", "op", op, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create token"})
+		return
+	}
+
+	c.Header("Authorization", bearerPrefix+tokens.Access)
+	c.JSON(http.StatusOK, formatTokens(tokens))
+}
@@ -0,0 +1,166 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/437d5/merch-store/internal/service"
+	"github.com/437d5/merch-store/internal/user"
+	"github.com/437d5/merch-store/pkg/password"
+	"github.com/gin-gonic/gin"
+)
+
+func (h *Handler) ChangePassword(c *gin.Context) {
+	const op = "/internal/handler/password/ChangePassword"
+
+	var req struct {
+		CurrentPassword string `json:"currentPassword" binding:"required"`
+		NewPassword     string `json:"newPassword" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		h.logger.Warn("invalid request", "op", op, "error", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+		return
+	}
+
+	// the current password can be guessed here as well as on /api/auth
+	username := c.GetString("username")
+	ip := c.ClientIP()
+
+	wait, err := h.lockoutService.Attempt(c.Request.Context(), username, ip)
+	if err != nil {
+		h.logger.Error("failed to check lockout", "op", op, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed change password"})
+		return
+	}
+
+	if wait > 0 {
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "too many failed attempts"})
+		return
+	}
+
+	u, err := h.passwordService.ChangePassword(
+		c.Request.Context(), c.GetInt("user_id"), req.CurrentPassword, req.NewPassword,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrInvalidPassword):
+			c.JSON(http.StatusForbidden, gin.H{"error": "invalid current password"})
+		case errors.Is(err, user.ErrWeakPassword):
+			// the current password was right
+			if lErr := h.lockoutService.Succeed(c.Request.Context(), username, ip); lErr != nil {
+				h.logger.Error("failed to reset failed logins", "op", op, "error", lErr)
+			}
+			c.JSON(http.StatusBadRequest, gin.H{"error": weakPasswordMessage(err)})
+		default:
+			h.logger.Error("failed change password", "op", op, "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed change password"})
+		}
+		return
+	}
+
+	if err = h.lockoutService.Succeed(c.Request.Context(), u.Name, ip); err != nil {
+		h.logger.Error("failed to reset failed logins", "op", op, "error", err)
+	}
+
+	h.restartSessions(c, op, u)
+}
+
+// ResetPassword sets a new password using a token from IssuePasswordReset.
+func (h *Handler) ResetPassword(c *gin.Context) {
+	const op = "/internal/handler/password/ResetPassword"
+
+	var req struct {
+		ResetToken  string `json:"resetToken" binding:"required"`
+		NewPassword string `json:"newPassword" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		h.logger.Warn("invalid request", "op", op, "error", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+		return
+	}
+
+	u, err := h.passwordService.ResetPassword(c.Request.Context(), req.ResetToken, req.NewPassword)
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrInvalidResetToken):
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid reset token"})
+		case errors.Is(err, user.ErrWeakPassword):
+			c.JSON(http.StatusBadRequest, gin.H{"error": weakPasswordMessage(err)})
+		default:
+			h.logger.Error("failed reset password", "op", op, "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed reset password"})
+		}
+		return
+	}
+
+	// the admin reset is the way out of a lockout
+	if err = h.lockoutService.ResetUser(c.Request.Context(), u.Name); err != nil {
+		h.logger.Error("failed to reset failed logins", "op", op, "error", err)
+	}
+
+	h.restartSessions(c, op, u)
+}
+
+func (h *Handler) IssuePasswordReset(c *gin.Context) {
+	const op = "/internal/handler/password/IssuePasswordReset"
+
+	raw, expiresAt, err := h.passwordService.IssueReset(
+		c.Request.Context(), c.Param("name"), c.GetInt("user_id"),
+	)
+	if err != nil {
+		if errors.Is(err, user.ErrUserNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+			return
+		}
+		h.logger.Error("failed issue password reset", "op", op, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed issue password reset"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"resetToken": raw,
+		"expiresAt":  expiresAt,
+	})
+}
+
+// restartSessions answers a password change with tokens for a new
+// session. The password service ended the other sessions together with
+// the change, so only the caller stays logged in.
+func (h *Handler) restartSessions(c *gin.Context, op string, u user.User) {
+	tokens, err := h.sessionService.Login(c.Request.Context(), u)
+	if err != nil {
+		h.logger.Error("failed to create tokens", "op", op, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create token"})
+		return
+	}
+
+	c.Header("Authorization", bearerPrefix+tokens.Access)
+	c.JSON(http.StatusOK, formatTokens(tokens))
+}
+
+// invalidUsernameMessage describes the username requirements.
+func invalidUsernameMessage() string {
+	return fmt.Sprintf(
+		"username must be %d to %d letters, digits, '.', '_' or '-'",
+		user.MinNameLen, user.MaxNameLen,
+	)
+}
+
+// weakPasswordMessage describes the password requirement err failed.
+func weakPasswordMessage(err error) string {
+	if errors.Is(err, password.ErrPasswordTooLong) {
+		return fmt.Sprintf("password must be at most %d bytes long", password.BcryptMaxLen)
+	}
+
+	return fmt.Sprintf(
+		"password must be at least %d characters and at most %d bytes long",
+		user.MinPasswordLen, user.MaxPasswordLen,
+	)
+}
@@ -0,0 +1,33 @@
+package idempotency
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+var (
+	ErrKeyExists      = errors.New("idempotency key already exists")
+	ErrRecordNotFound = errors.New("idempotency record not found")
+)
+
+// Record stores the outcome of a request sent with an Idempotency-Key.
+// It is created and completed in the transaction of the request, so a
+// committed record with a zero StatusCode is only left by older versions
+// that stored the response separately.
+type Record struct {
+	UserId      int
+	Key         string
+	Fingerprint string
+	StatusCode  int
+	Response    []byte
+	CreatedAt   time.Time
+}
+
+type IdempotencyRepo interface {
+	CreateRecord(ctx context.Context, record Record) error
+	GetRecord(ctx context.Context, userId int, key string) (Record, error)
+	CompleteRecord(ctx context.Context, userId int, key string, statusCode int, response []byte) error
+	DeleteRecord(ctx context.Context, userId int, key string) error
+	DeleteExpired(ctx context.Context, before time.Time) (int64, error)
+}
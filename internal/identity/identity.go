@@ -0,0 +1,50 @@
+// Package identity links users to accounts at external OpenID Connect
+// providers.
+package identity
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+var (
+	ErrIdentityNotFound   = errors.New("identity not found")
+	ErrIdentityExists     = errors.New("identity already exists")
+	ErrLoginStateNotFound = errors.New("login state not found")
+)
+
+// Identity maps the subject an issuer assigned to a user. Subjects are
+// only unique per issuer.
+type Identity struct {
+	Issuer    string
+	Subject   string
+	UserId    int
+	CreatedAt time.Time
+}
+
+type IdentityRepo interface {
+	GetIdentity(ctx context.Context, issuer, subject string) (Identity, error)
+	CreateIdentity(ctx context.Context, id Identity) error
+}
+
+// LoginState is kept between redirecting the user to the provider and
+// the callback. The state parameter is the key, the PKCE verifier and
+// the nonce never leave the server. BindingHash is the hash of a value
+// kept in a cookie of the browser that started the login, only that
+// browser can complete it.
+type LoginState struct {
+	State       string
+	Verifier    string
+	Nonce       string
+	BindingHash string
+	ExpiresAt   time.Time
+}
+
+type LoginStateRepo interface {
+	CreateLoginState(ctx context.Context, s LoginState) error
+	// TakeLoginState deletes and returns the state, so every state
+	// completes at most one login
+	TakeLoginState(ctx context.Context, state string) (LoginState, error)
+	DeleteExpiredLoginStates(ctx context.Context, before time.Time) (int64, error)
+}
@@ -1,5 +1,12 @@
 package inventory
 
+import (
+	"context"
+	"errors"
+)
+
+var ErrNotEnoughItems = errors.New("not enough items")
+
 type Item struct {
 	ItemType string
 	Quantity int
@@ -19,3 +26,17 @@ func (i *Inventory) AddItem(item Item) {
 
 	i.Items = append(i.Items, item)
 }
+
+// InventoryRepo keeps a quantity per user and catalog item. Items are
+// passed by their current name, holdings follow renames of the item.
+// Quantities are changed in place, so concurrent changes of one
+// inventory do not overwrite each other.
+type InventoryRepo interface {
+	GetInventory(ctx context.Context, userId int) (Inventory, error)
+	// IncrementItem adds quantity items and returns the new quantity,
+	// items.ErrItemNotFound when the catalog has no such item
+	IncrementItem(ctx context.Context, userId int, itemType string, quantity int) (int, error)
+	// DecrementItem takes quantity items away and returns the new
+	// quantity, ErrNotEnoughItems when the user owns fewer
+	DecrementItem(ctx context.Context, userId int, itemType string, quantity int) (int, error)
+}
@@ -1,12 +1,81 @@
 package items
 
-import "context"
+import (
+	"context"
+	"errors"
+	"regexp"
+	"time"
+	"unicode/utf8"
+)
 
+var (
+	ErrItemNotFound    = errors.New("item not found")
+	ErrItemExists      = errors.New("item already exists")
+	ErrInvalidItemName = errors.New("invalid item name")
+	ErrInvalidItemCost = errors.New("invalid item cost")
+)
+
+// MaxNameLen matches the VARCHAR(10) items.name column
+const MaxNameLen = 10
+
+// item names end up in /api/buy/:item, so keep them URL friendly
+var nameRe = regexp.MustCompile(`^[a-z0-9][a-z0-9-]*$`)
+
+// ItemType is a catalog entry. Unavailable items are listed but
+// cannot be bought.
 type ItemType struct {
-	Name string
-	Cost int
+	Name      string
+	Cost      int
+	Available bool
+}
+
+func ValidateName(name string) error {
+	if utf8.RuneCountInString(name) > MaxNameLen || !nameRe.MatchString(name) {
+		return ErrInvalidItemName
+	}
+
+	return nil
+}
+
+func ValidateCost(cost int) error {
+	if cost <= 0 {
+		return ErrInvalidItemCost
+	}
+
+	return nil
+}
+
+type Action string
+
+const (
+	ActionCreate  Action = "create"
+	ActionReprice Action = "reprice"
+	ActionRename  Action = "rename"
+	ActionRetire  Action = "retire"
+	ActionRestore Action = "restore"
+)
+
+// Change is an audit record of a catalog modification. Before is nil
+// for created items.
+type Change struct {
+	Id        int
+	ActorId   int
+	Action    Action
+	ItemName  string
+	Before    *ItemType
+	After     *ItemType
+	CreatedAt time.Time
 }
 
 type ItemRepo interface {
 	GetItemByName(ctx context.Context, name string) (ItemType, error)
+	GetItemByNameForUpdate(ctx context.Context, name string) (ItemType, error)
+	// GetItemByNameForShare keeps the item from changing until the
+	// surrounding transaction ends, other readers are not blocked
+	GetItemByNameForShare(ctx context.Context, name string) (ItemType, error)
+	ListItems(ctx context.Context) ([]ItemType, error)
+	CreateItem(ctx context.Context, item ItemType) error
+	UpdateItem(ctx context.Context, name string, item ItemType) error
+	RecordChange(ctx context.Context, change Change) error
+	ListChanges(ctx context.Context, limit int) ([]Change, error)
 }
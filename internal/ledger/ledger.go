@@ -0,0 +1,112 @@
+package ledger
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+var (
+	ErrUnbalancedEntry   = errors.New("entry postings do not balance")
+	ErrInsufficientFunds = errors.New("insufficient funds")
+	ErrAccountNotFound   = errors.New("account not found")
+)
+
+type AccountKind string
+
+const (
+	// AccountWallet holds the coins of a single user and never goes negative
+	AccountWallet AccountKind = "wallet"
+	// AccountRevenue collects coins spent in the store
+	AccountRevenue AccountKind = "revenue"
+	// AccountMint is the source of every coin in circulation, so its
+	// balance is minus the amount ever issued
+	AccountMint AccountKind = "mint"
+)
+
+type EntryKind string
+
+const (
+	EntryGrant    EntryKind = "grant"
+	EntryTransfer EntryKind = "transfer"
+	EntryPurchase EntryKind = "purchase"
+)
+
+type Account struct {
+	Id      int
+	Kind    AccountKind
+	UserId  int
+	Balance int
+}
+
+// Posting changes the balance of a single account by Amount.
+type Posting struct {
+	AccountId int
+	Amount    int
+}
+
+// Entry is one money movement. The amounts of its postings sum to zero.
+type Entry struct {
+	Id        int
+	Kind      EntryKind
+	Postings  []Posting
+	CreatedAt time.Time
+}
+
+// NewMovement builds an entry moving amount coins from one account to another.
+func NewMovement(kind EntryKind, fromAccountId, toAccountId, amount int) Entry {
+	return Entry{
+		Kind: kind,
+		Postings: []Posting{
+			{AccountId: fromAccountId, Amount: -amount},
+			{AccountId: toAccountId, Amount: amount},
+		},
+	}
+}
+
+func (e Entry) Validate() error {
+	if len(e.Postings) < 2 {
+		return ErrUnbalancedEntry
+	}
+
+	sum := 0
+	for _, p := range e.Postings {
+		if p.Amount == 0 {
+			return ErrUnbalancedEntry
+		}
+		sum += p.Amount
+	}
+
+	if sum != 0 {
+		return ErrUnbalancedEntry
+	}
+
+	return nil
+}
+
+type AccountMismatch struct {
+	AccountId int
+	Cached    int
+	Posted    int
+}
+
+// Report is the result of a reconciliation run. The books balance when
+// every entry sums to zero, every cached balance equals the sum of its
+// postings and all balances together sum to zero.
+type Report struct {
+	UnbalancedEntries []int
+	Mismatches        []AccountMismatch
+	Total             int
+}
+
+func (r Report) Balanced() bool {
+	return len(r.UnbalancedEntries) == 0 && len(r.Mismatches) == 0 && r.Total == 0
+}
+
+type LedgerRepo interface {
+	CreateWallet(ctx context.Context, userId int) (Account, error)
+	GetWallet(ctx context.Context, userId int) (Account, error)
+	GetSystemAccount(ctx context.Context, kind AccountKind) (Account, error)
+	PostEntry(ctx context.Context, entry Entry) (int, error)
+	Reconcile(ctx context.Context) (Report, error)
+}
@@ -0,0 +1,53 @@
+package lockout
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+var (
+	ErrLockoutNotFound = errors.New("lockout not found")
+	ErrInvalidScope    = errors.New("invalid lockout scope")
+)
+
+// Scope tells what failed login attempts are counted for.
+type Scope string
+
+const (
+	ScopeUser Scope = "user"
+	ScopeIP   Scope = "ip"
+)
+
+func ParseScope(s string) (Scope, error) {
+	switch sc := Scope(s); sc {
+	case ScopeUser, ScopeIP:
+		return sc, nil
+	default:
+		return "", ErrInvalidScope
+	}
+}
+
+// Lockout counts the recent failed logins for a username or a client
+// address. Logins are refused while LockedUntil is in the future.
+type Lockout struct {
+	Scope       Scope
+	Key         string
+	Failures    int
+	LastFailure time.Time
+	LockedUntil *time.Time
+}
+
+type LockoutRepo interface {
+	GetLockout(ctx context.Context, scope Scope, key string) (Lockout, error)
+	// RecordFailure counts a failed login at and returns the number of
+	// failures. Failures made before resetBefore are forgotten.
+	RecordFailure(ctx context.Context, scope Scope, key string, at, resetBefore time.Time) (int, error)
+	// ForgetFailure takes back one failure counted by RecordFailure, a
+	// lock is kept
+	ForgetFailure(ctx context.Context, scope Scope, key string) error
+	LockUntil(ctx context.Context, scope Scope, key string, until time.Time) error
+	DeleteLockout(ctx context.Context, scope Scope, key string) error
+	ListLockouts(ctx context.Context, limit int) ([]Lockout, error)
+	DeleteStaleLockouts(ctx context.Context, before time.Time) (int64, error)
+}
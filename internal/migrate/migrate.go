@@ -0,0 +1,257 @@
+// Package migrate applies numbered SQL migrations to Postgres or SQLite.
+// Every migration is a pair of files NNNN_name.up.sql and
+// NNNN_name.down.sql, applied versions are recorded in the
+// schema_migrations table.
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"regexp"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+var (
+	ErrNoDownMigration  = errors.New("migration has no down script")
+	ErrUnknownMigration = errors.New("applied migration is unknown to this binary")
+)
+
+var fileRe = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	// Down is empty for migrations that cannot be rolled back
+	Down string
+}
+
+type Status struct {
+	Migration
+	// AppliedAt is nil for pending migrations
+	AppliedAt *time.Time
+}
+
+// Load reads the migrations in the root of fsys sorted by version.
+// Other files are ignored.
+func Load(fsys fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, fmt.Errorf("cannot read migrations: %w", err)
+	}
+
+	byVersion := make(map[int]*Migration)
+	for _, e := range entries {
+		match := fileRe.FindStringSubmatch(e.Name())
+		if e.IsDir() || match == nil {
+			continue
+		}
+
+		version, err := strconv.Atoi(match[1])
+		if err != nil || version == 0 {
+			return nil, fmt.Errorf("invalid migration version: %s", e.Name())
+		}
+
+		script, err := fs.ReadFile(fsys, e.Name())
+		if err != nil {
+			return nil, fmt.Errorf("cannot read migration: %w", err)
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = m
+		}
+		if m.Name != match[2] {
+			return nil, fmt.Errorf("migration %d has two names: %s and %s", version, m.Name, match[2])
+		}
+
+		if match[3] == "up" {
+			m.Up = string(script)
+		} else {
+			m.Down = string(script)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, fmt.Errorf("migration %d_%s has no up script", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+
+	slices.SortFunc(migrations, func(a, b Migration) int { return a.Version - b.Version })
+
+	return migrations, nil
+}
+
+// database is the part of a Migrator that depends on the database.
+type database interface {
+	// withLock runs fn on a connection holding the migration lock,
+	// schema_migrations exists by the time fn is called
+	withLock(ctx context.Context, fn func(conn migrationConn) error) error
+}
+
+// migrationConn is a connection holding the migration lock.
+type migrationConn interface {
+	// applied returns the recorded versions and when they were applied
+	applied(ctx context.Context) (map[int]time.Time, error)
+	// up runs the up script of mg and records it in one transaction
+	up(ctx context.Context, mg Migration) error
+	// down runs the down script of mg and removes its record in one
+	// transaction
+	down(ctx context.Context, mg Migration) error
+}
+
+type Migrator struct {
+	db         database
+	migrations []Migration
+	logger     *slog.Logger
+}
+
+// NewMigrator returns a Migrator applying the migrations in fsys to
+// Postgres.
+func NewMigrator(db *pgxpool.Pool, fsys fs.FS, logger *slog.Logger) (*Migrator, error) {
+	return newMigrator(&postgresDB{db: db, logger: logger}, fsys, logger)
+}
+
+// NewSQLiteMigrator returns a Migrator applying the migrations in fsys
+// to SQLite.
+func NewSQLiteMigrator(db *sql.DB, fsys fs.FS, logger *slog.Logger) (*Migrator, error) {
+	return newMigrator(&sqliteDB{db: db, logger: logger}, fsys, logger)
+}
+
+func newMigrator(db database, fsys fs.FS, logger *slog.Logger) (*Migrator, error) {
+	migrations, err := Load(fsys)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Migrator{db: db, migrations: migrations, logger: logger}, nil
+}
+
+// Up applies every pending migration in order and returns them.
+func (m *Migrator) Up(ctx context.Context) ([]Migration, error) {
+	const op = "/internal/migrate/migrate/Up"
+
+	var done []Migration
+	err := m.db.withLock(ctx, func(conn migrationConn) error {
+		applied, err := conn.applied(ctx)
+		if err != nil {
+			return err
+		}
+
+		for version := range applied {
+			if !slices.ContainsFunc(m.migrations, func(mg Migration) bool { return mg.Version == version }) {
+				m.logger.Warn("database has an unknown migration", "op", op, "version", version)
+			}
+		}
+
+		for _, mg := range m.migrations {
+			if _, ok := applied[mg.Version]; ok {
+				continue
+			}
+
+			if err = m.run(ctx, mg, conn.up); err != nil {
+				return err
+			}
+
+			m.logger.Info("migration applied", "op", op, "version", mg.Version, "name", mg.Name)
+			done = append(done, mg)
+		}
+
+		return nil
+	})
+
+	return done, err
+}
+
+// Down rolls back the last steps applied migrations, newest first,
+// and returns them.
+func (m *Migrator) Down(ctx context.Context, steps int) ([]Migration, error) {
+	const op = "/internal/migrate/migrate/Down"
+
+	var done []Migration
+	err := m.db.withLock(ctx, func(conn migrationConn) error {
+		applied, err := conn.applied(ctx)
+		if err != nil {
+			return err
+		}
+
+		versions := make([]int, 0, len(applied))
+		for version := range applied {
+			versions = append(versions, version)
+		}
+		slices.Sort(versions)
+		slices.Reverse(versions)
+
+		for _, version := range versions[:min(steps, len(versions))] {
+			idx := slices.IndexFunc(m.migrations, func(mg Migration) bool { return mg.Version == version })
+			if idx < 0 {
+				return fmt.Errorf("cannot roll back %d: %w", version, ErrUnknownMigration)
+			}
+
+			mg := m.migrations[idx]
+			if mg.Down == "" {
+				return fmt.Errorf("cannot roll back %d_%s: %w", mg.Version, mg.Name, ErrNoDownMigration)
+			}
+
+			if err = m.run(ctx, mg, conn.down); err != nil {
+				return err
+			}
+
+			m.logger.Info("migration rolled back", "op", op, "version", mg.Version, "name", mg.Name)
+			done = append(done, mg)
+		}
+
+		return nil
+	})
+
+	return done, err
+}
+
+// Status lists the known migrations and when they were applied.
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	var statuses []Status
+	err := m.db.withLock(ctx, func(conn migrationConn) error {
+		applied, err := conn.applied(ctx)
+		if err != nil {
+			return err
+		}
+
+		for _, mg := range m.migrations {
+			s := Status{Migration: mg}
+			if at, ok := applied[mg.Version]; ok {
+				s.AppliedAt = &at
+			}
+			statuses = append(statuses, s)
+		}
+
+		return nil
+	})
+
+	return statuses, err
+}
+
+// run applies mg with step, the up or down of a migrationConn.
+func (m *Migrator) run(
+	ctx context.Context, mg Migration, step func(ctx context.Context, mg Migration) error,
+) error {
+	const op = "/internal/migrate/migrate/run"
+
+	if err := step(ctx, mg); err != nil {
+		m.logger.Error("migration failed", "op", op, "version", mg.Version, "error", err)
+		return fmt.Errorf("migration %d_%s failed: %w", mg.Version, mg.Name, err)
+	}
+
+	return nil
+}
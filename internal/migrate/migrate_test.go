@@ -0,0 +1,314 @@
+package migrate_test
+
+import (
+	"context"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+
+	"github.com/437d5/merch-store/internal/migrate"
+	"github.com/437d5/merch-store/internal/repository"
+	"github.com/437d5/merch-store/migrations"
+	sqlitemigrations "github.com/437d5/merch-store/migrations/sqlite"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+func TestLoad(t *testing.T) {
+	fsys := fstest.MapFS{
+		"0002_items.up.sql":   {Data: []byte("CREATE TABLE items ();")},
+		"0002_items.down.sql": {Data: []byte("DROP TABLE items;")},
+		"0001_init.up.sql":    {Data: []byte("CREATE TABLE users ();")},
+		"0010_seed.up.sql":    {Data: []byte("INSERT INTO items DEFAULT VALUES;")},
+		"README.md":           {Data: []byte("not a migration")},
+	}
+
+	got, err := migrate.Load(fsys)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+
+	want := []migrate.Migration{
+		{Version: 1, Name: "init", Up: "CREATE TABLE users ();"},
+		{Version: 2, Name: "items", Up: "CREATE TABLE items ();", Down: "DROP TABLE items;"},
+		{Version: 10, Name: "seed", Up: "INSERT INTO items DEFAULT VALUES;"},
+	}
+
+	if len(got) != len(want) {
+		t.Fatalf("got %d migrations, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("migration %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestLoadRejects(t *testing.T) {
+	tests := []struct {
+		name string
+		fsys fstest.MapFS
+	}{
+		{
+			name: "down without up",
+			fsys: fstest.MapFS{"0001_init.down.sql": {Data: []byte("DROP TABLE users;")}},
+		},
+		{
+			name: "two names for a version",
+			fsys: fstest.MapFS{
+				"0001_init.up.sql":  {Data: []byte("CREATE TABLE users ();")},
+				"0001_other.up.sql": {Data: []byte("CREATE TABLE items ();")},
+			},
+		},
+		{
+			name: "version zero",
+			fsys: fstest.MapFS{"0000_init.up.sql": {Data: []byte("CREATE TABLE users ();")}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := migrate.Load(tt.fsys); err == nil {
+				t.Fatal("load succeeded, want error")
+			}
+		})
+	}
+}
+
+func TestEmbeddedMigrations(t *testing.T) {
+	sets := map[string]fs.FS{
+		"postgres": migrations.Files,
+		"sqlite":   sqlitemigrations.Files,
+	}
+
+	for name, fsys := range sets {
+		t.Run(name, func(t *testing.T) {
+			got, err := migrate.Load(fsys)
+			if err != nil {
+				t.Fatalf("load: %v", err)
+			}
+
+			for i, m := range got {
+				if m.Version != i+1 {
+					t.Errorf("migration %d_%s breaks the numbering, want %d", m.Version, m.Name, i+1)
+				}
+				if m.Down == "" {
+					t.Errorf("migration %d_%s has no down script", m.Version, m.Name)
+				}
+			}
+		})
+	}
+
+	// the sqlite schema follows the postgres one version by version
+	pg, _ := migrate.Load(migrations.Files)
+	lite, _ := migrate.Load(sqlitemigrations.Files)
+	if len(pg) != len(lite) {
+		t.Errorf("%d postgres migrations, %d sqlite migrations", len(pg), len(lite))
+	}
+}
+
+func TestSQLiteUpDown(t *testing.T) {
+	ctx := context.Background()
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	db, err := repository.OpenSQLite(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer db.Close()
+
+	m, err := migrate.NewSQLiteMigrator(db, sqlitemigrations.Files, logger)
+	if err != nil {
+		t.Fatalf("new migrator: %v", err)
+	}
+
+	applied, err := m.Up(ctx)
+	if err != nil {
+		t.Fatalf("first up: %v", err)
+	}
+
+	done, err := m.Up(ctx)
+	if err != nil {
+		t.Fatalf("second up: %v", err)
+	}
+	if len(done) != 0 {
+		t.Errorf("second up applied %d migrations, want none", len(done))
+	}
+
+	done, err = m.Down(ctx, len(applied))
+	if err != nil {
+		t.Fatalf("down: %v", err)
+	}
+	if len(done) != len(applied) {
+		t.Errorf("down rolled back %d migrations, want %d", len(done), len(applied))
+	}
+
+	var tables int
+	err = db.QueryRowContext(ctx, `
+		SELECT COUNT(*) FROM sqlite_master
+		WHERE type = 'table' AND name NOT IN ('schema_migrations', 'sqlite_sequence');
+	`).Scan(&tables)
+	if err != nil {
+		t.Fatalf("count tables: %v", err)
+	}
+	if tables != 0 {
+		t.Errorf("%d tables left after down", tables)
+	}
+
+	if _, err = m.Up(ctx); err != nil {
+		t.Fatalf("up after down: %v", err)
+	}
+}
+
+// testDatabaseEnv points at a Postgres database, migrations are applied
+// to it
+const testDatabaseEnv = "TEST_DATABASE_URL"
+
+func TestUpIsIdempotent(t *testing.T) {
+	dsn := os.Getenv(testDatabaseEnv)
+	if dsn == "" {
+		t.Skipf("%s is not set", testDatabaseEnv)
+	}
+
+	ctx := context.Background()
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	db, err := pgxpool.New(ctx, dsn)
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	defer db.Close()
+
+	m, err := migrate.NewMigrator(db, migrations.Files, logger)
+	if err != nil {
+		t.Fatalf("new migrator: %v", err)
+	}
+
+	if _, err = m.Up(ctx); err != nil {
+		t.Fatalf("first up: %v", err)
+	}
+
+	done, err := m.Up(ctx)
+	if err != nil {
+		t.Fatalf("second up: %v", err)
+	}
+	if len(done) != 0 {
+		t.Errorf("second up applied %d migrations, want none", len(done))
+	}
+
+	statuses, err := m.Status(ctx)
+	if err != nil {
+		t.Fatalf("status: %v", err)
+	}
+	for _, s := range statuses {
+		if s.AppliedAt == nil {
+			t.Errorf("migration %d_%s is pending after up", s.Version, s.Name)
+		}
+	}
+}
+
+// TestSQLiteUserItemsById checks that 0002 keeps the holdings, also of
+// items renamed before it.
+func TestSQLiteUserItemsById(t *testing.T) {
+	ctx := context.Background()
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	db, err := repository.OpenSQLite(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer db.Close()
+
+	m, err := migrate.NewSQLiteMigrator(db, sqlitemigrations.Files, logger)
+	if err != nil {
+		t.Fatalf("new migrator: %v", err)
+	}
+	applied, err := m.Up(ctx)
+	if err != nil {
+		t.Fatalf("up: %v", err)
+	}
+	if _, err = m.Down(ctx, len(applied)-1); err != nil {
+		t.Fatalf("down to 0001: %v", err)
+	}
+
+	// "old-cup" was renamed, only the purchase remembers its price, the
+	// price of "old-pen" is lost
+	_, err = db.ExecContext(ctx, `
+		INSERT INTO users (id, name, password) VALUES (1, 'alice', 'hash');
+		INSERT INTO user_items (user_id, item_type, quantity)
+		VALUES (1, 'cup', 2), (1, 'old-cup', 3), (1, 'old-pen', 1);
+		INSERT INTO purchases (user_id, item_name, cost, created_at)
+		VALUES (1, 'old-cup', 15, '2025-01-01 00:00:00');
+	`)
+	if err != nil {
+		t.Fatalf("seed: %v", err)
+	}
+
+	if _, err = m.Up(ctx); err != nil {
+		t.Fatalf("up again: %v", err)
+	}
+
+	holdings := func() map[string]int {
+		t.Helper()
+
+		rows, err := db.QueryContext(ctx, `
+			SELECT i.name, ui.quantity
+			FROM user_items ui
+			JOIN items i ON i.id = ui.item_id;
+		`)
+		if err != nil {
+			t.Fatalf("query holdings: %v", err)
+		}
+		defer rows.Close()
+
+		got := map[string]int{}
+		for rows.Next() {
+			var name string
+			var quantity int
+			if err = rows.Scan(&name, &quantity); err != nil {
+				t.Fatalf("scan: %v", err)
+			}
+			got[name] = quantity
+		}
+
+		return got
+	}
+
+	if got := holdings(); len(got) != 3 || got["cup"] != 2 || got["old-cup"] != 3 || got["old-pen"] != 1 {
+		t.Errorf("holdings = %v, want 2 cup, 3 old-cup and 1 old-pen", got)
+	}
+
+	// items without a known price get -1, which cannot be restored
+	for name, want := range map[string]int{"old-cup": 15, "old-pen": -1} {
+		var cost int
+		var available bool
+		err = db.QueryRowContext(ctx, `SELECT cost, available FROM items WHERE name = ?;`, name).
+			Scan(&cost, &available)
+		if err != nil {
+			t.Fatalf("get %s: %v", name, err)
+		}
+		if cost != want || available {
+			t.Errorf("%s cost %d, available %v, want a retired item for %d", name, cost, available, want)
+		}
+	}
+
+	// renames no longer orphan holdings
+	if _, err = db.ExecContext(ctx, `UPDATE items SET name = 'mug' WHERE name = 'cup';`); err != nil {
+		t.Fatalf("rename: %v", err)
+	}
+	if got := holdings(); got["mug"] != 2 {
+		t.Errorf("holdings after rename = %v, want 2 mug", got)
+	}
+
+	if _, err = m.Down(ctx, len(applied)-1); err != nil {
+		t.Fatalf("down: %v", err)
+	}
+	var quantity int
+	err = db.QueryRowContext(ctx, `SELECT quantity FROM user_items WHERE item_type = 'mug';`).Scan(&quantity)
+	if err != nil || quantity != 2 {
+		t.Errorf("mug after down = %d, %v, want 2", quantity, err)
+	}
+}
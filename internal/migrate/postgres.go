@@ -0,0 +1,117 @@
+package migrate
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// lockKey is the pg_advisory_lock key that keeps concurrent runners,
+// e.g. replicas migrating on startup, from applying the same migration
+const lockKey int64 = 0x6d65726368 // "merch"
+
+type postgresDB struct {
+	db     *pgxpool.Pool
+	logger *slog.Logger
+}
+
+type postgresConn struct {
+	conn   *pgxpool.Conn
+	logger *slog.Logger
+}
+
+// withLock runs fn on a single connection holding the migration lock.
+func (p *postgresDB) withLock(ctx context.Context, fn func(conn migrationConn) error) error {
+	const op = "/internal/migrate/postgres/withLock"
+
+	conn, err := p.db.Acquire(ctx)
+	if err != nil {
+		p.logger.Error("cannot acquire connection", "op", op, "error", err)
+		return fmt.Errorf("cannot acquire connection: %w", err)
+	}
+	defer conn.Release()
+
+	if _, err = conn.Exec(ctx, `SELECT pg_advisory_lock($1);`, lockKey); err != nil {
+		p.logger.Error("cannot take migration lock", "op", op, "error", err)
+		return fmt.Errorf("cannot take migration lock: %w", err)
+	}
+	defer func() {
+		// the session lock must be released before the connection goes
+		// back to the pool, ctx may be canceled by now
+		_, err := conn.Exec(context.Background(), `SELECT pg_advisory_unlock($1);`, lockKey)
+		if err != nil {
+			p.logger.Error("cannot release migration lock", "op", op, "error", err)
+			conn.Conn().Close(context.Background())
+		}
+	}()
+
+	query := `
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version INTEGER PRIMARY KEY,
+			name TEXT NOT NULL,
+			applied_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+		);
+	`
+
+	if _, err = conn.Exec(ctx, query); err != nil {
+		p.logger.Error("cannot create schema_migrations", "op", op, "error", err)
+		return fmt.Errorf("cannot create schema_migrations: %w", err)
+	}
+
+	return fn(&postgresConn{conn: conn, logger: p.logger})
+}
+
+func (c *postgresConn) applied(ctx context.Context) (map[int]time.Time, error) {
+	const op = "/internal/migrate/postgres/applied"
+
+	rows, err := c.conn.Query(ctx, `SELECT version, applied_at FROM schema_migrations;`)
+	if err != nil {
+		c.logger.Error("cannot read schema_migrations", "op", op, "error", err)
+		return nil, fmt.Errorf("cannot read schema_migrations: %w", err)
+	}
+
+	applied := make(map[int]time.Time)
+	for rows.Next() {
+		var version int
+		var at time.Time
+		if err = rows.Scan(&version, &at); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("cannot scan schema_migrations: %w", err)
+		}
+		applied[version] = at
+	}
+	rows.Close()
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("cannot read schema_migrations: %w", err)
+	}
+
+	return applied, nil
+}
+
+func (c *postgresConn) up(ctx context.Context, mg Migration) error {
+	record := `INSERT INTO schema_migrations (version, name) VALUES ($1, $2);`
+	return c.run(ctx, mg.Up, record, mg.Version, mg.Name)
+}
+
+func (c *postgresConn) down(ctx context.Context, mg Migration) error {
+	record := `DELETE FROM schema_migrations WHERE version = $1;`
+	return c.run(ctx, mg.Down, record, mg.Version)
+}
+
+// run executes script and records the change in one transaction, so a
+// failed migration leaves nothing behind.
+func (c *postgresConn) run(ctx context.Context, script, record string, args ...any) error {
+	return pgx.BeginFunc(ctx, c.conn, func(tx pgx.Tx) error {
+		if _, err := tx.Exec(ctx, script); err != nil {
+			return err
+		}
+
+		_, err := tx.Exec(ctx, record, args...)
+		return err
+	})
+}
@@ -0,0 +1,105 @@
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// sqliteDB has no lock of its own. A runner applying a migration that
+// another one applied meanwhile fails on the schema_migrations primary
+// key, which rolls the migration back.
+type sqliteDB struct {
+	db     *sql.DB
+	logger *slog.Logger
+}
+
+type sqliteConn struct {
+	conn   *sql.Conn
+	logger *slog.Logger
+}
+
+func (s *sqliteDB) withLock(ctx context.Context, fn func(conn migrationConn) error) error {
+	const op = "/internal/migrate/sqlite/withLock"
+
+	conn, err := s.db.Conn(ctx)
+	if err != nil {
+		s.logger.Error("cannot acquire connection", "op", op, "error", err)
+		return fmt.Errorf("cannot acquire connection: %w", err)
+	}
+	defer conn.Close()
+
+	query := `
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version INTEGER PRIMARY KEY,
+			name TEXT NOT NULL,
+			applied_at TIMESTAMP NOT NULL
+		);
+	`
+
+	if _, err = conn.ExecContext(ctx, query); err != nil {
+		s.logger.Error("cannot create schema_migrations", "op", op, "error", err)
+		return fmt.Errorf("cannot create schema_migrations: %w", err)
+	}
+
+	return fn(&sqliteConn{conn: conn, logger: s.logger})
+}
+
+func (c *sqliteConn) applied(ctx context.Context) (map[int]time.Time, error) {
+	const op = "/internal/migrate/sqlite/applied"
+
+	rows, err := c.conn.QueryContext(ctx, `SELECT version, applied_at FROM schema_migrations;`)
+	if err != nil {
+		c.logger.Error("cannot read schema_migrations", "op", op, "error", err)
+		return nil, fmt.Errorf("cannot read schema_migrations: %w", err)
+	}
+	defer rows.Close()
+
+	applied := make(map[int]time.Time)
+	for rows.Next() {
+		var version int
+		var at time.Time
+		if err = rows.Scan(&version, &at); err != nil {
+			return nil, fmt.Errorf("cannot scan schema_migrations: %w", err)
+		}
+		applied[version] = at
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("cannot read schema_migrations: %w", err)
+	}
+
+	return applied, nil
+}
+
+func (c *sqliteConn) up(ctx context.Context, mg Migration) error {
+	record := `INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?);`
+	return c.run(ctx, mg.Up, record, mg.Version, mg.Name, time.Now().UTC())
+}
+
+func (c *sqliteConn) down(ctx context.Context, mg Migration) error {
+	record := `DELETE FROM schema_migrations WHERE version = ?;`
+	return c.run(ctx, mg.Down, record, mg.Version)
+}
+
+// run executes script and records the change in one transaction, so a
+// failed migration leaves nothing behind. SQLite rolls back schema
+// changes too.
+func (c *sqliteConn) run(ctx context.Context, script, record string, args ...any) error {
+	tx, err := c.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err = tx.ExecContext(ctx, script); err != nil {
+		return err
+	}
+	if _, err = tx.ExecContext(ctx, record, args...); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,23 @@
+package purchases
+
+import (
+	"context"
+	"time"
+)
+
+// Purchase is a receipt, ItemName is the name the item had when it was
+// bought and is not changed by later renames.
+type Purchase struct {
+	Id        int
+	UserId    int
+	ItemName  string
+	Cost      int
+	CreatedAt time.Time
+}
+
+type PurchaseRepo interface {
+	CreatePurchase(ctx context.Context, purchase Purchase) error
+	// GetPurchasesByUser returns at most limit purchases of the user,
+	// the latest first
+	GetPurchasesByUser(ctx context.Context, userId, limit int) ([]Purchase, error)
+}
@@ -0,0 +1,90 @@
+package repository_test
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/437d5/merch-store/internal/migrate"
+	"github.com/437d5/merch-store/internal/repository"
+	"github.com/437d5/merch-store/internal/repository/repotest"
+	"github.com/437d5/merch-store/migrations"
+	sqlitemigrations "github.com/437d5/merch-store/migrations/sqlite"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// testDatabaseEnv points at a Postgres database the Postgres repos are
+// tested against, migrations are applied to it
+const testDatabaseEnv = "TEST_DATABASE_URL"
+
+func testLogger() *slog.Logger {
+	return slog.New(slog.NewTextHandler(io.Discard, nil))
+}
+
+func openMemory(t *testing.T) repository.Repos {
+	return repository.NewMemoryRepos(testLogger())
+}
+
+func openSQLite(t *testing.T) repository.Repos {
+	t.Helper()
+
+	db, err := repository.OpenSQLite(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	m, err := migrate.NewSQLiteMigrator(db, sqlitemigrations.Files, testLogger())
+	if err != nil {
+		t.Fatalf("new migrator: %v", err)
+	}
+	if _, err = m.Up(context.Background()); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+
+	return repository.NewSQLiteRepos(db, testLogger())
+}
+
+// openPostgres connects to the database at dsn and brings it up to date.
+func openPostgres(t *testing.T, dsn string) repository.Repos {
+	t.Helper()
+
+	db, err := pgxpool.New(context.Background(), dsn)
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	t.Cleanup(db.Close)
+
+	m, err := migrate.NewMigrator(db, migrations.Files, testLogger())
+	if err != nil {
+		t.Fatalf("new migrator: %v", err)
+	}
+	if _, err = m.Up(context.Background()); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+
+	return repository.NewPostgresRepos(db, testLogger())
+}
+
+func TestMemoryRepos(t *testing.T) {
+	repotest.Run(t, openMemory)
+}
+
+func TestSQLiteRepos(t *testing.T) {
+	repotest.Run(t, openSQLite)
+}
+
+// TestPostgresRepos shares one database between the tests, repotest
+// only relies on the rows it creates.
+func TestPostgresRepos(t *testing.T) {
+	dsn := os.Getenv(testDatabaseEnv)
+	if dsn == "" {
+		t.Skipf("%s is not set", testDatabaseEnv)
+	}
+
+	repos := openPostgres(t, dsn)
+	repotest.Run(t, func(*testing.T) repository.Repos { return repos })
+}
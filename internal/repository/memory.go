@@ -0,0 +1,1261 @@
+package repository
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"time"
+
+	"github.com/437d5/merch-store/internal/apikey"
+	"github.com/437d5/merch-store/internal/idempotency"
+	"github.com/437d5/merch-store/internal/identity"
+	"github.com/437d5/merch-store/internal/inventory"
+	"github.com/437d5/merch-store/internal/items"
+	"github.com/437d5/merch-store/internal/ledger"
+	"github.com/437d5/merch-store/internal/lockout"
+	"github.com/437d5/merch-store/internal/purchases"
+	"github.com/437d5/merch-store/internal/session"
+	"github.com/437d5/merch-store/internal/transactions"
+	"github.com/437d5/merch-store/internal/user"
+)
+
+// UserRepo implementation
+type MemoryUserRepo struct {
+	store  *MemoryStore
+	logger *slog.Logger
+}
+
+func NewMemoryUserRepo(store *MemoryStore, logger *slog.Logger) *MemoryUserRepo {
+	return &MemoryUserRepo{store: store, logger: logger}
+}
+
+func (r *MemoryUserRepo) GetUserByID(ctx context.Context, id int) (user.User, error) {
+	const op = "/internal/repository/memory/GetUserByID"
+
+	var u user.User
+	err := r.store.do(ctx, func(d *memoryData) error {
+		var ok bool
+		if u, ok = d.users[id]; !ok {
+			r.logger.Warn("user not found", "op", op, "userId", id)
+			return fmt.Errorf("%w: %d", user.ErrUserNotFound, id)
+		}
+
+		wallet, _ := d.wallet(id)
+		u.Coins = wallet.Balance
+		return nil
+	})
+
+	return u, err
+}
+
+// GetUserByIDForUpdate needs no lock of its own, the transaction in ctx
+// holds the whole store.
+func (r *MemoryUserRepo) GetUserByIDForUpdate(ctx context.Context, id int) (user.User, error) {
+	return r.GetUserByID(ctx, id)
+}
+
+func (r *MemoryUserRepo) GetUserByName(ctx context.Context, name string) (user.User, error) {
+	const op = "/internal/repository/memory/GetUserByName"
+
+	var u user.User
+	err := r.store.do(ctx, func(d *memoryData) error {
+		for _, saved := range d.users {
+			if saved.Name == name {
+				u = saved
+				wallet, _ := d.wallet(u.Id)
+				u.Coins = wallet.Balance
+				return nil
+			}
+		}
+
+		r.logger.Warn("user not found", "op", op, "name", name)
+		return fmt.Errorf("%w: %s", user.ErrUserNotFound, name)
+	})
+
+	return u, err
+}
+
+func (r *MemoryUserRepo) CreateUser(ctx context.Context, u user.User) (int, error) {
+	const op = "/internal/repository/memory/CreateUser"
+
+	err := r.store.do(ctx, func(d *memoryData) error {
+		for _, saved := range d.users {
+			if saved.Name == u.Name {
+				r.logger.Warn("user already exists", "op", op, "name", u.Name)
+				return fmt.Errorf("%w: %s", user.ErrUserExists, u.Name)
+			}
+		}
+
+		if u.Role == "" {
+			u.Role = user.RoleEmployee
+		}
+		// balances live in the ledger
+		u.Coins = 0
+		u.Id = d.nextId("users")
+		d.users[u.Id] = u
+
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return u.Id, nil
+}
+
+func (r *MemoryUserRepo) UpdateRole(ctx context.Context, name string, role user.Role) error {
+	const op = "/internal/repository/memory/UpdateRole"
+
+	return r.store.do(ctx, func(d *memoryData) error {
+		for id, u := range d.users {
+			if u.Name == name {
+				u.Role = role
+				d.users[id] = u
+				return nil
+			}
+		}
+
+		r.logger.Warn("user not found", "op", op, "name", name)
+		return fmt.Errorf("%w: %s", user.ErrUserNotFound, name)
+	})
+}
+
+func (r *MemoryUserRepo) UpdatePassword(ctx context.Context, id int, password string) error {
+	const op = "/internal/repository/memory/UpdatePassword"
+
+	return r.store.do(ctx, func(d *memoryData) error {
+		u, ok := d.users[id]
+		if !ok {
+			r.logger.Warn("user not found", "op", op, "userId", id)
+			return fmt.Errorf("%w: %d", user.ErrUserNotFound, id)
+		}
+
+		u.Password = password
+		d.users[id] = u
+		return nil
+	})
+}
+
+// InventoryRepo implementation
+type MemoryInventoryRepo struct {
+	store  *MemoryStore
+	logger *slog.Logger
+}
+
+func NewMemoryInventoryRepo(store *MemoryStore, logger *slog.Logger) *MemoryInventoryRepo {
+	return &MemoryInventoryRepo{store: store, logger: logger}
+}
+
+func (r *MemoryInventoryRepo) GetInventory(ctx context.Context, userId int) (inventory.Inventory, error) {
+	var inv inventory.Inventory
+	err := r.store.do(ctx, func(d *memoryData) error {
+		for k, quantity := range d.userItems {
+			if k.userId == userId {
+				inv.Items = append(inv.Items, inventory.Item{ItemType: k.itemType, Quantity: quantity})
+			}
+		}
+		return nil
+	})
+
+	slices.SortFunc(inv.Items, func(a, b inventory.Item) int {
+		return cmp.Compare(a.ItemType, b.ItemType)
+	})
+
+	return inv, err
+}
+
+func (r *MemoryInventoryRepo) IncrementItem(
+	ctx context.Context, userId int, itemType string, quantity int,
+) (int, error) {
+	var total int
+	err := r.store.do(ctx, func(d *memoryData) error {
+		if _, ok := d.users[userId]; !ok {
+			return fmt.Errorf("cannot increment item: %w", user.ErrUserNotFound)
+		}
+		if _, ok := d.items[itemType]; !ok {
+			return fmt.Errorf("%w: %s", items.ErrItemNotFound, itemType)
+		}
+
+		k := memoryUserItem{userId: userId, itemType: itemType}
+		total = d.userItems[k] + quantity
+		d.userItems[k] = total
+		return nil
+	})
+
+	return total, err
+}
+
+func (r *MemoryInventoryRepo) DecrementItem(
+	ctx context.Context, userId int, itemType string, quantity int,
+) (int, error) {
+	var total int
+	err := r.store.do(ctx, func(d *memoryData) error {
+		k := memoryUserItem{userId: userId, itemType: itemType}
+		owned, ok := d.userItems[k]
+		if !ok || owned < quantity {
+			return inventory.ErrNotEnoughItems
+		}
+
+		total = owned - quantity
+		if total == 0 {
+			delete(d.userItems, k)
+		} else {
+			d.userItems[k] = total
+		}
+		return nil
+	})
+
+	return total, err
+}
+
+// PasswordResetRepo implementation
+type MemoryPasswordResetRepo struct {
+	store  *MemoryStore
+	logger *slog.Logger
+}
+
+func NewMemoryPasswordResetRepo(store *MemoryStore, logger *slog.Logger) *MemoryPasswordResetRepo {
+	return &MemoryPasswordResetRepo{store: store, logger: logger}
+}
+
+func (r *MemoryPasswordResetRepo) CreatePasswordReset(ctx context.Context, reset user.PasswordReset) error {
+	return r.store.do(ctx, func(d *memoryData) error {
+		for _, saved := range d.resets {
+			if saved.Hash == reset.Hash {
+				return errors.New("cannot create password reset: token hash exists")
+			}
+		}
+
+		reset.Id = d.nextId("password_resets")
+		reset.CreatedAt = memoryNow()
+		reset.UsedAt = nil
+		d.resets[reset.Id] = reset
+		return nil
+	})
+}
+
+func (r *MemoryPasswordResetRepo) GetPasswordResetForUpdate(ctx context.Context, hash string) (user.PasswordReset, error) {
+	var reset user.PasswordReset
+	err := r.store.do(ctx, func(d *memoryData) error {
+		for _, saved := range d.resets {
+			if saved.Hash == hash {
+				reset = saved
+				return nil
+			}
+		}
+		return user.ErrResetTokenNotFound
+	})
+
+	return reset, err
+}
+
+func (r *MemoryPasswordResetRepo) MarkPasswordResetUsed(ctx context.Context, id int) error {
+	return r.store.do(ctx, func(d *memoryData) error {
+		if reset, ok := d.resets[id]; ok {
+			now := memoryNow()
+			reset.UsedAt = &now
+			d.resets[id] = reset
+		}
+		return nil
+	})
+}
+
+func (r *MemoryPasswordResetRepo) DeleteExpiredPasswordResets(ctx context.Context, before time.Time) (int64, error) {
+	var n int64
+	err := r.store.do(ctx, func(d *memoryData) error {
+		for id, reset := range d.resets {
+			if reset.ExpiresAt.Before(before) {
+				delete(d.resets, id)
+				n++
+			}
+		}
+		return nil
+	})
+
+	return n, err
+}
+
+// TransactionRepo implementation
+type MemoryTransRepo struct {
+	store  *MemoryStore
+	logger *slog.Logger
+}
+
+func NewMemoryTransRepo(store *MemoryStore, logger *slog.Logger) *MemoryTransRepo {
+	return &MemoryTransRepo{store: store, logger: logger}
+}
+
+func (r *MemoryTransRepo) CreateTransaction(ctx context.Context, t transactions.Transaction) error {
+	return r.store.do(ctx, func(d *memoryData) error {
+		if _, ok := d.users[t.FromUser]; !ok {
+			return fmt.Errorf("cannot create transaction: %w", user.ErrUserNotFound)
+		}
+		if _, ok := d.users[t.ToUser]; !ok {
+			return fmt.Errorf("cannot create transaction: %w", user.ErrUserNotFound)
+		}
+		if t.Amount <= 0 {
+			return errors.New("cannot create transaction: amount must be positive")
+		}
+
+		t.Id = d.nextId("transactions")
+		t.Timestamp = memoryNow()
+		t.FromUsername, t.ToUsername = "", ""
+		d.transactions = append(d.transactions, t)
+		return nil
+	})
+}
+
+func (r *MemoryTransRepo) ListTransactions(ctx context.Context, f transactions.Filter) ([]transactions.Transaction, error) {
+	var tList []transactions.Transaction
+	err := r.store.do(ctx, func(d *memoryData) error {
+		counterparty := -1
+		if f.Counterparty != "" {
+			for _, u := range d.users {
+				if u.Name == f.Counterparty {
+					counterparty = u.Id
+				}
+			}
+		}
+
+		for _, t := range d.transactions {
+			if !matchTransaction(t, f, counterparty) {
+				continue
+			}
+
+			t.FromUsername = d.users[t.FromUser].Name
+			t.ToUsername = d.users[t.ToUser].Name
+			tList = append(tList, t)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	slices.SortFunc(tList, func(a, b transactions.Transaction) int {
+		return cmp.Or(b.Timestamp.Compare(a.Timestamp), cmp.Compare(b.Id, a.Id))
+	})
+
+	return tList[:min(max(f.Limit, 0), len(tList))], nil
+}
+
+// matchTransaction applies the conditions PostgresTransRepo puts in its
+// WHERE clause. counterparty is the id of f.Counterparty, -1 if there is
+// no such user.
+func matchTransaction(t transactions.Transaction, f transactions.Filter, counterparty int) bool {
+	switch f.Direction {
+	case transactions.DirectionSent:
+		if t.FromUser != f.UserId {
+			return false
+		}
+	case transactions.DirectionReceived:
+		if t.ToUser != f.UserId {
+			return false
+		}
+	default:
+		if t.FromUser != f.UserId && t.ToUser != f.UserId {
+			return false
+		}
+	}
+
+	if f.Counterparty != "" {
+		other := t.FromUser
+		if t.FromUser == f.UserId {
+			other = t.ToUser
+		}
+		if other != counterparty {
+			return false
+		}
+	}
+
+	switch {
+	case f.MinAmount > 0 && t.Amount < f.MinAmount,
+		f.MaxAmount > 0 && t.Amount > f.MaxAmount,
+		!f.From.IsZero() && t.Timestamp.Before(f.From),
+		!f.To.IsZero() && !t.Timestamp.Before(f.To):
+		return false
+	}
+
+	if f.After != nil {
+		c := t.Timestamp.Compare(f.After.Timestamp)
+		if c > 0 || (c == 0 && t.Id >= f.After.Id) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// ItemRepo implementation
+type MemoryItemRepo struct {
+	store  *MemoryStore
+	logger *slog.Logger
+}
+
+func NewMemoryItemRepo(store *MemoryStore, logger *slog.Logger) *MemoryItemRepo {
+	return &MemoryItemRepo{store: store, logger: logger}
+}
+
+func (r *MemoryItemRepo) GetItemByName(ctx context.Context, name string) (items.ItemType, error) {
+	const op = "/internal/repository/memory/GetItemByName"
+
+	var item items.ItemType
+	err := r.store.do(ctx, func(d *memoryData) error {
+		var ok bool
+		if item, ok = d.items[name]; !ok {
+			r.logger.Warn("item not found", "op", op, "name", name)
+			return fmt.Errorf("%w: %s", items.ErrItemNotFound, name)
+		}
+		return nil
+	})
+
+	return item, err
+}
+
+// GetItemByNameForUpdate needs no lock of its own, the transaction in
+// ctx holds the whole store.
+func (r *MemoryItemRepo) GetItemByNameForUpdate(ctx context.Context, name string) (items.ItemType, error) {
+	return r.GetItemByName(ctx, name)
+}
+
+// GetItemByNameForShare needs no lock of its own either.
+func (r *MemoryItemRepo) GetItemByNameForShare(ctx context.Context, name string) (items.ItemType, error) {
+	return r.GetItemByName(ctx, name)
+}
+
+func (r *MemoryItemRepo) ListItems(ctx context.Context) ([]items.ItemType, error) {
+	var iList []items.ItemType
+	err := r.store.do(ctx, func(d *memoryData) error {
+		for _, item := range d.items {
+			iList = append(iList, item)
+		}
+		return nil
+	})
+
+	slices.SortFunc(iList, func(a, b items.ItemType) int {
+		return cmp.Compare(a.Name, b.Name)
+	})
+
+	return iList, err
+}
+
+func (r *MemoryItemRepo) CreateItem(ctx context.Context, item items.ItemType) error {
+	const op = "/internal/repository/memory/CreateItem"
+
+	return r.store.do(ctx, func(d *memoryData) error {
+		if _, ok := d.items[item.Name]; ok {
+			r.logger.Warn("item already exists", "op", op, "name", item.Name)
+			return fmt.Errorf("%w: %s", items.ErrItemExists, item.Name)
+		}
+
+		d.items[item.Name] = item
+		return nil
+	})
+}
+
+// UpdateItem overwrites the item called name, including its name.
+func (r *MemoryItemRepo) UpdateItem(ctx context.Context, name string, item items.ItemType) error {
+	const op = "/internal/repository/memory/UpdateItem"
+
+	return r.store.do(ctx, func(d *memoryData) error {
+		if _, ok := d.items[name]; !ok {
+			r.logger.Warn("item not found", "op", op, "name", name)
+			return fmt.Errorf("%w: %s", items.ErrItemNotFound, name)
+		}
+
+		if _, ok := d.items[item.Name]; ok && item.Name != name {
+			r.logger.Warn("item already exists", "op", op, "name", item.Name)
+			return fmt.Errorf("%w: %s", items.ErrItemExists, item.Name)
+		}
+
+		delete(d.items, name)
+		d.items[item.Name] = item
+
+		// holdings refer to the item, not to its name
+		if item.Name != name {
+			for k, quantity := range d.userItems {
+				if k.itemType == name {
+					delete(d.userItems, k)
+					d.userItems[memoryUserItem{userId: k.userId, itemType: item.Name}] = quantity
+				}
+			}
+		}
+		return nil
+	})
+}
+
+func (r *MemoryItemRepo) RecordChange(ctx context.Context, change items.Change) error {
+	return r.store.do(ctx, func(d *memoryData) error {
+		change.Id = d.nextId("catalog_audit")
+		change.CreatedAt = memoryNow()
+		// copies, so the caller cannot change the record later
+		change.Before = cloneItem(change.Before)
+		change.After = cloneItem(change.After)
+		d.changes = append(d.changes, change)
+		return nil
+	})
+}
+
+func (r *MemoryItemRepo) ListChanges(ctx context.Context, limit int) ([]items.Change, error) {
+	var cList []items.Change
+	err := r.store.do(ctx, func(d *memoryData) error {
+		for i := len(d.changes) - 1; i >= 0 && len(cList) < limit; i-- {
+			c := d.changes[i]
+			c.Before = cloneItem(c.Before)
+			c.After = cloneItem(c.After)
+			cList = append(cList, c)
+		}
+		return nil
+	})
+
+	return cList, err
+}
+
+func cloneItem(item *items.ItemType) *items.ItemType {
+	if item == nil {
+		return nil
+	}
+
+	c := *item
+	return &c
+}
+
+// IdempotencyRepo implementation
+type MemoryIdempotencyRepo struct {
+	store  *MemoryStore
+	logger *slog.Logger
+}
+
+func NewMemoryIdempotencyRepo(store *MemoryStore, logger *slog.Logger) *MemoryIdempotencyRepo {
+	return &MemoryIdempotencyRepo{store: store, logger: logger}
+}
+
+func (r *MemoryIdempotencyRepo) CreateRecord(ctx context.Context, record idempotency.Record) error {
+	return r.store.do(ctx, func(d *memoryData) error {
+		k := memoryRecordKey{userId: record.UserId, key: record.Key}
+		if _, ok := d.records[k]; ok {
+			return idempotency.ErrKeyExists
+		}
+
+		d.records[k] = idempotency.Record{
+			UserId:      record.UserId,
+			Key:         record.Key,
+			Fingerprint: record.Fingerprint,
+			CreatedAt:   memoryNow(),
+		}
+		return nil
+	})
+}
+
+func (r *MemoryIdempotencyRepo) GetRecord(ctx context.Context, userId int, key string) (idempotency.Record, error) {
+	var rec idempotency.Record
+	err := r.store.do(ctx, func(d *memoryData) error {
+		var ok bool
+		if rec, ok = d.records[memoryRecordKey{userId: userId, key: key}]; !ok {
+			return idempotency.ErrRecordNotFound
+		}
+		rec.Response = slices.Clone(rec.Response)
+		return nil
+	})
+
+	return rec, err
+}
+
+func (r *MemoryIdempotencyRepo) CompleteRecord(
+	ctx context.Context, userId int, key string, statusCode int, response []byte,
+) error {
+	return r.store.do(ctx, func(d *memoryData) error {
+		k := memoryRecordKey{userId: userId, key: key}
+		if rec, ok := d.records[k]; ok {
+			rec.StatusCode = statusCode
+			rec.Response = slices.Clone(response)
+			d.records[k] = rec
+		}
+		return nil
+	})
+}
+
+func (r *MemoryIdempotencyRepo) DeleteRecord(ctx context.Context, userId int, key string) error {
+	return r.store.do(ctx, func(d *memoryData) error {
+		delete(d.records, memoryRecordKey{userId: userId, key: key})
+		return nil
+	})
+}
+
+func (r *MemoryIdempotencyRepo) DeleteExpired(ctx context.Context, before time.Time) (int64, error) {
+	var n int64
+	err := r.store.do(ctx, func(d *memoryData) error {
+		for k, rec := range d.records {
+			if rec.CreatedAt.Before(before) {
+				delete(d.records, k)
+				n++
+			}
+		}
+		return nil
+	})
+
+	return n, err
+}
+
+// PurchaseRepo implementation
+type MemoryPurchaseRepo struct {
+	store  *MemoryStore
+	logger *slog.Logger
+}
+
+func NewMemoryPurchaseRepo(store *MemoryStore, logger *slog.Logger) *MemoryPurchaseRepo {
+	return &MemoryPurchaseRepo{store: store, logger: logger}
+}
+
+func (r *MemoryPurchaseRepo) CreatePurchase(ctx context.Context, p purchases.Purchase) error {
+	return r.store.do(ctx, func(d *memoryData) error {
+		if _, ok := d.users[p.UserId]; !ok {
+			return fmt.Errorf("cannot create purchase: %w", user.ErrUserNotFound)
+		}
+
+		p.Id = d.nextId("purchases")
+		p.CreatedAt = memoryNow()
+		d.purchases = append(d.purchases, p)
+		return nil
+	})
+}
+
+func (r *MemoryPurchaseRepo) GetPurchasesByUser(ctx context.Context, userId, limit int) ([]purchases.Purchase, error) {
+	var pList []purchases.Purchase
+	err := r.store.do(ctx, func(d *memoryData) error {
+		for _, p := range d.purchases {
+			if p.UserId == userId {
+				pList = append(pList, p)
+			}
+		}
+		return nil
+	})
+
+	slices.SortFunc(pList, func(a, b purchases.Purchase) int {
+		return cmp.Or(b.CreatedAt.Compare(a.CreatedAt), cmp.Compare(b.Id, a.Id))
+	})
+
+	return pList[:min(limit, len(pList))], err
+}
+
+// LedgerRepo implementation
+type MemoryLedgerRepo struct {
+	store  *MemoryStore
+	logger *slog.Logger
+}
+
+func NewMemoryLedgerRepo(store *MemoryStore, logger *slog.Logger) *MemoryLedgerRepo {
+	return &MemoryLedgerRepo{store: store, logger: logger}
+}
+
+func (r *MemoryLedgerRepo) CreateWallet(ctx context.Context, userId int) (ledger.Account, error) {
+	a := ledger.Account{Kind: ledger.AccountWallet, UserId: userId}
+
+	err := r.store.do(ctx, func(d *memoryData) error {
+		if _, ok := d.users[userId]; !ok {
+			return fmt.Errorf("cannot create wallet: %w", user.ErrUserNotFound)
+		}
+		if _, ok := d.wallet(userId); ok {
+			return fmt.Errorf("cannot create wallet: user %d has one", userId)
+		}
+
+		a.Id = d.nextId("accounts")
+		d.accounts[a.Id] = a
+		return nil
+	})
+	if err != nil {
+		return ledger.Account{}, err
+	}
+
+	return a, nil
+}
+
+func (r *MemoryLedgerRepo) GetWallet(ctx context.Context, userId int) (ledger.Account, error) {
+	var a ledger.Account
+	err := r.store.do(ctx, func(d *memoryData) error {
+		var ok bool
+		if a, ok = d.wallet(userId); !ok {
+			return ledger.ErrAccountNotFound
+		}
+		return nil
+	})
+
+	return a, err
+}
+
+func (r *MemoryLedgerRepo) GetSystemAccount(ctx context.Context, kind ledger.AccountKind) (ledger.Account, error) {
+	var a ledger.Account
+	err := r.store.do(ctx, func(d *memoryData) error {
+		for _, saved := range d.accounts {
+			if saved.Kind == kind && saved.UserId == 0 {
+				a = saved
+				return nil
+			}
+		}
+		return ledger.ErrAccountNotFound
+	})
+
+	return a, err
+}
+
+// PostEntry records the entry and applies its postings to the cached
+// balances. Wallets are debited only if they hold enough coins, otherwise
+// nothing is applied and ledger.ErrInsufficientFunds is returned.
+func (r *MemoryLedgerRepo) PostEntry(ctx context.Context, entry ledger.Entry) (int, error) {
+	if err := entry.Validate(); err != nil {
+		return 0, err
+	}
+
+	// same order as PostgresLedgerRepo, so the same posting fails first
+	postings := slices.Clone(entry.Postings)
+	slices.SortFunc(postings, func(a, b ledger.Posting) int {
+		return a.AccountId - b.AccountId
+	})
+
+	var entryId int
+	err := r.store.do(ctx, func(d *memoryData) error {
+		balances := make(map[int]ledger.Account, len(postings))
+		for _, p := range postings {
+			a, ok := balances[p.AccountId]
+			if !ok {
+				if a, ok = d.accounts[p.AccountId]; !ok {
+					return fmt.Errorf("cannot post entry: %w", ledger.ErrAccountNotFound)
+				}
+			}
+
+			a.Balance += p.Amount
+			if a.Kind == ledger.AccountWallet && a.Balance < 0 {
+				return ledger.ErrInsufficientFunds
+			}
+			balances[p.AccountId] = a
+		}
+
+		for id, a := range balances {
+			d.accounts[id] = a
+		}
+
+		entryId = d.nextId("ledger_entries")
+		d.entries = append(d.entries, ledger.Entry{
+			Id:        entryId,
+			Kind:      entry.Kind,
+			Postings:  postings,
+			CreatedAt: memoryNow(),
+		})
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return entryId, nil
+}
+
+func (r *MemoryLedgerRepo) Reconcile(ctx context.Context) (ledger.Report, error) {
+	var report ledger.Report
+	err := r.store.do(ctx, func(d *memoryData) error {
+		posted := make(map[int]int)
+		for _, e := range d.entries {
+			sum := 0
+			for _, p := range e.Postings {
+				sum += p.Amount
+				posted[p.AccountId] += p.Amount
+			}
+			if sum != 0 {
+				report.UnbalancedEntries = append(report.UnbalancedEntries, e.Id)
+			}
+		}
+
+		for _, a := range d.accounts {
+			if a.Balance != posted[a.Id] {
+				report.Mismatches = append(report.Mismatches, ledger.AccountMismatch{
+					AccountId: a.Id,
+					Cached:    a.Balance,
+					Posted:    posted[a.Id],
+				})
+			}
+			report.Total += a.Balance
+		}
+		return nil
+	})
+
+	slices.SortFunc(report.Mismatches, func(a, b ledger.AccountMismatch) int {
+		return cmp.Compare(a.AccountId, b.AccountId)
+	})
+
+	return report, err
+}
+
+// RefreshTokenRepo implementation
+type MemoryRefreshTokenRepo struct {
+	store  *MemoryStore
+	logger *slog.Logger
+}
+
+func NewMemoryRefreshTokenRepo(store *MemoryStore, logger *slog.Logger) *MemoryRefreshTokenRepo {
+	return &MemoryRefreshTokenRepo{store: store, logger: logger}
+}
+
+func (r *MemoryRefreshTokenRepo) CreateRefreshToken(ctx context.Context, t session.RefreshToken) error {
+	return r.store.do(ctx, func(d *memoryData) error {
+		for _, saved := range d.refreshTokens {
+			if saved.Hash == t.Hash {
+				return errors.New("cannot create refresh token: token hash exists")
+			}
+		}
+
+		t.Id = d.nextId("refresh_tokens")
+		t.CreatedAt = memoryNow()
+		t.UsedAt, t.RevokedAt = nil, nil
+		d.refreshTokens[t.Id] = t
+		return nil
+	})
+}
+
+// GetRefreshTokenForUpdate needs no lock of its own, the transaction in
+// ctx holds the whole store.
+func (r *MemoryRefreshTokenRepo) GetRefreshTokenForUpdate(ctx context.Context, hash string) (session.RefreshToken, error) {
+	var t session.RefreshToken
+	err := r.store.do(ctx, func(d *memoryData) error {
+		for _, saved := range d.refreshTokens {
+			if saved.Hash == hash {
+				t = saved
+				return nil
+			}
+		}
+		return session.ErrRefreshTokenNotFound
+	})
+
+	return t, err
+}
+
+func (r *MemoryRefreshTokenRepo) MarkRefreshTokenUsed(ctx context.Context, id int) error {
+	return r.store.do(ctx, func(d *memoryData) error {
+		if t, ok := d.refreshTokens[id]; ok {
+			now := memoryNow()
+			t.UsedAt = &now
+			d.refreshTokens[id] = t
+		}
+		return nil
+	})
+}
+
+func (r *MemoryRefreshTokenRepo) RevokeFamily(ctx context.Context, familyId string) error {
+	return r.revoke(ctx, func(t session.RefreshToken) bool { return t.FamilyId == familyId })
+}
+
+func (r *MemoryRefreshTokenRepo) RevokeUserRefreshTokens(ctx context.Context, userId int) error {
+	return r.revoke(ctx, func(t session.RefreshToken) bool { return t.UserId == userId })
+}
+
+func (r *MemoryRefreshTokenRepo) revoke(ctx context.Context, match func(session.RefreshToken) bool) error {
+	return r.store.do(ctx, func(d *memoryData) error {
+		now := memoryNow()
+		for id, t := range d.refreshTokens {
+			if t.RevokedAt == nil && match(t) {
+				t.RevokedAt = &now
+				d.refreshTokens[id] = t
+			}
+		}
+		return nil
+	})
+}
+
+func (r *MemoryRefreshTokenRepo) DeleteExpiredRefreshTokens(ctx context.Context, before time.Time) (int64, error) {
+	var n int64
+	err := r.store.do(ctx, func(d *memoryData) error {
+		for id, t := range d.refreshTokens {
+			if t.ExpiresAt.Before(before) {
+				delete(d.refreshTokens, id)
+				n++
+			}
+		}
+		return nil
+	})
+
+	return n, err
+}
+
+// RevocationRepo implementation
+type MemoryRevocationRepo struct {
+	store  *MemoryStore
+	logger *slog.Logger
+}
+
+func NewMemoryRevocationRepo(store *MemoryStore, logger *slog.Logger) *MemoryRevocationRepo {
+	return &MemoryRevocationRepo{store: store, logger: logger}
+}
+
+func (r *MemoryRevocationRepo) RevokeToken(ctx context.Context, jti string, userId int, expiresAt time.Time) error {
+	return r.store.do(ctx, func(d *memoryData) error {
+		if _, ok := d.revokedTokens[jti]; !ok {
+			d.revokedTokens[jti] = memoryRevokedToken{userId: userId, expiresAt: expiresAt}
+		}
+		return nil
+	})
+}
+
+func (r *MemoryRevocationRepo) RevokeUserTokens(ctx context.Context, userId int, before time.Time) error {
+	return r.store.do(ctx, func(d *memoryData) error {
+		if saved, ok := d.userRevocations[userId]; !ok || before.After(saved) {
+			d.userRevocations[userId] = before
+		}
+		return nil
+	})
+}
+
+func (r *MemoryRevocationRepo) IsRevoked(ctx context.Context, jti string, userId int, issuedAt time.Time) (bool, error) {
+	var revoked bool
+	err := r.store.do(ctx, func(d *memoryData) error {
+		_, revoked = d.revokedTokens[jti]
+		if before, ok := d.userRevocations[userId]; ok && before.After(issuedAt) {
+			revoked = true
+		}
+		return nil
+	})
+
+	return revoked, err
+}
+
+func (r *MemoryRevocationRepo) DeleteExpiredRevocations(ctx context.Context, tokensBefore, usersBefore time.Time) (int64, error) {
+	var n int64
+	err := r.store.do(ctx, func(d *memoryData) error {
+		for jti, t := range d.revokedTokens {
+			if t.expiresAt.Before(tokensBefore) {
+				delete(d.revokedTokens, jti)
+				n++
+			}
+		}
+		for userId, before := range d.userRevocations {
+			if before.Before(usersBefore) {
+				delete(d.userRevocations, userId)
+				n++
+			}
+		}
+		return nil
+	})
+
+	return n, err
+}
+
+// LockoutRepo implementation
+type MemoryLockoutRepo struct {
+	store  *MemoryStore
+	logger *slog.Logger
+}
+
+func NewMemoryLockoutRepo(store *MemoryStore, logger *slog.Logger) *MemoryLockoutRepo {
+	return &MemoryLockoutRepo{store: store, logger: logger}
+}
+
+func (r *MemoryLockoutRepo) GetLockout(ctx context.Context, scope lockout.Scope, key string) (lockout.Lockout, error) {
+	var l lockout.Lockout
+	err := r.store.do(ctx, func(d *memoryData) error {
+		var ok bool
+		if l, ok = d.lockouts[memoryLockoutKey{scope: scope, key: key}]; !ok {
+			return lockout.ErrLockoutNotFound
+		}
+		return nil
+	})
+
+	return l, err
+}
+
+func (r *MemoryLockoutRepo) RecordFailure(
+	ctx context.Context, scope lockout.Scope, key string, at, resetBefore time.Time,
+) (int, error) {
+	var failures int
+	err := r.store.do(ctx, func(d *memoryData) error {
+		k := memoryLockoutKey{scope: scope, key: key}
+		l, ok := d.lockouts[k]
+		switch {
+		case !ok:
+			l = lockout.Lockout{Scope: scope, Key: key, Failures: 1}
+		case l.LastFailure.Before(resetBefore):
+			l.Failures = 1
+		default:
+			l.Failures++
+		}
+
+		l.LastFailure = at
+		d.lockouts[k] = l
+		failures = l.Failures
+		return nil
+	})
+
+	return failures, err
+}
+
+func (r *MemoryLockoutRepo) ForgetFailure(ctx context.Context, scope lockout.Scope, key string) error {
+	return r.store.do(ctx, func(d *memoryData) error {
+		k := memoryLockoutKey{scope: scope, key: key}
+		if l, ok := d.lockouts[k]; ok && l.Failures > 0 {
+			l.Failures--
+			d.lockouts[k] = l
+		}
+		return nil
+	})
+}
+
+func (r *MemoryLockoutRepo) LockUntil(ctx context.Context, scope lockout.Scope, key string, until time.Time) error {
+	return r.store.do(ctx, func(d *memoryData) error {
+		k := memoryLockoutKey{scope: scope, key: key}
+		if l, ok := d.lockouts[k]; ok {
+			l.LockedUntil = &until
+			d.lockouts[k] = l
+		}
+		return nil
+	})
+}
+
+func (r *MemoryLockoutRepo) DeleteLockout(ctx context.Context, scope lockout.Scope, key string) error {
+	return r.store.do(ctx, func(d *memoryData) error {
+		k := memoryLockoutKey{scope: scope, key: key}
+		if _, ok := d.lockouts[k]; !ok {
+			return lockout.ErrLockoutNotFound
+		}
+
+		delete(d.lockouts, k)
+		return nil
+	})
+}
+
+func (r *MemoryLockoutRepo) ListLockouts(ctx context.Context, limit int) ([]lockout.Lockout, error) {
+	var lList []lockout.Lockout
+	err := r.store.do(ctx, func(d *memoryData) error {
+		for _, l := range d.lockouts {
+			lList = append(lList, l)
+		}
+		return nil
+	})
+
+	// locked_until DESC NULLS LAST, last_failure DESC
+	slices.SortFunc(lList, func(a, b lockout.Lockout) int {
+		switch {
+		case a.LockedUntil == nil && b.LockedUntil != nil:
+			return 1
+		case a.LockedUntil != nil && b.LockedUntil == nil:
+			return -1
+		case a.LockedUntil != nil:
+			if c := b.LockedUntil.Compare(*a.LockedUntil); c != 0 {
+				return c
+			}
+		}
+		return b.LastFailure.Compare(a.LastFailure)
+	})
+
+	return lList[:min(max(limit, 0), len(lList))], err
+}
+
+func (r *MemoryLockoutRepo) DeleteStaleLockouts(ctx context.Context, before time.Time) (int64, error) {
+	var n int64
+	err := r.store.do(ctx, func(d *memoryData) error {
+		for k, l := range d.lockouts {
+			if l.LastFailure.Before(before) && (l.LockedUntil == nil || l.LockedUntil.Before(before)) {
+				delete(d.lockouts, k)
+				n++
+			}
+		}
+		return nil
+	})
+
+	return n, err
+}
+
+// KeyRepo implementation
+type MemoryKeyRepo struct {
+	store  *MemoryStore
+	logger *slog.Logger
+}
+
+func NewMemoryKeyRepo(store *MemoryStore, logger *slog.Logger) *MemoryKeyRepo {
+	return &MemoryKeyRepo{store: store, logger: logger}
+}
+
+func (r *MemoryKeyRepo) CreateKey(ctx context.Context, key apikey.Key) (apikey.Key, error) {
+	err := r.store.do(ctx, func(d *memoryData) error {
+		if _, ok := d.users[key.UserId]; !ok {
+			return fmt.Errorf("cannot create api key: %w", user.ErrUserNotFound)
+		}
+		for _, saved := range d.keys {
+			if saved.key.Hash == key.Hash {
+				return errors.New("cannot create api key: key hash exists")
+			}
+		}
+
+		key.Id = d.nextId("api_keys")
+		key.CreatedAt = memoryNow()
+		key.LastUsedAt = nil
+		key.Scopes = slices.Clone(key.Scopes)
+		d.keys[key.Id] = memoryKey{key: key}
+		return nil
+	})
+	if err != nil {
+		return apikey.Key{}, err
+	}
+
+	return key, nil
+}
+
+func (r *MemoryKeyRepo) ListKeys(ctx context.Context, userId int) ([]apikey.Key, error) {
+	var kList []apikey.Key
+	err := r.store.do(ctx, func(d *memoryData) error {
+		for _, k := range d.keys {
+			if k.key.UserId == userId && k.revokedAt == nil {
+				kList = append(kList, k.key)
+			}
+		}
+		return nil
+	})
+
+	slices.SortFunc(kList, func(a, b apikey.Key) int {
+		return cmp.Or(a.CreatedAt.Compare(b.CreatedAt), cmp.Compare(a.Id, b.Id))
+	})
+
+	return kList, err
+}
+
+// GetKeyByHash returns the key only while it is not revoked.
+func (r *MemoryKeyRepo) GetKeyByHash(ctx context.Context, hash string) (apikey.Key, error) {
+	var key apikey.Key
+	err := r.store.do(ctx, func(d *memoryData) error {
+		for _, k := range d.keys {
+			if k.key.Hash == hash && k.revokedAt == nil {
+				key = k.key
+				return nil
+			}
+		}
+		return apikey.ErrKeyNotFound
+	})
+
+	return key, err
+}
+
+func (r *MemoryKeyRepo) RevokeKey(ctx context.Context, userId, id int) error {
+	return r.store.do(ctx, func(d *memoryData) error {
+		k, ok := d.keys[id]
+		if !ok || k.key.UserId != userId || k.revokedAt != nil {
+			return apikey.ErrKeyNotFound
+		}
+
+		now := memoryNow()
+		k.revokedAt = &now
+		d.keys[id] = k
+		return nil
+	})
+}
+
+func (r *MemoryKeyRepo) RevokeUserKeys(ctx context.Context, userId int) error {
+	return r.store.do(ctx, func(d *memoryData) error {
+		now := memoryNow()
+		for id, k := range d.keys {
+			if k.key.UserId == userId && k.revokedAt == nil {
+				k.revokedAt = &now
+				d.keys[id] = k
+			}
+		}
+		return nil
+	})
+}
+
+func (r *MemoryKeyRepo) TouchKey(ctx context.Context, id int, at time.Time) error {
+	return r.store.do(ctx, func(d *memoryData) error {
+		if k, ok := d.keys[id]; ok {
+			k.key.LastUsedAt = &at
+			d.keys[id] = k
+		}
+		return nil
+	})
+}
+
+// IdentityRepo implementation
+type MemoryIdentityRepo struct {
+	store  *MemoryStore
+	logger *slog.Logger
+}
+
+func NewMemoryIdentityRepo(store *MemoryStore, logger *slog.Logger) *MemoryIdentityRepo {
+	return &MemoryIdentityRepo{store: store, logger: logger}
+}
+
+func (r *MemoryIdentityRepo) GetIdentity(ctx context.Context, issuer, subject string) (identity.Identity, error) {
+	var id identity.Identity
+	err := r.store.do(ctx, func(d *memoryData) error {
+		var ok bool
+		if id, ok = d.identities[memoryIdentityKey{issuer: issuer, subject: subject}]; !ok {
+			return identity.ErrIdentityNotFound
+		}
+		return nil
+	})
+
+	return id, err
+}
+
+func (r *MemoryIdentityRepo) CreateIdentity(ctx context.Context, id identity.Identity) error {
+	return r.store.do(ctx, func(d *memoryData) error {
+		k := memoryIdentityKey{issuer: id.Issuer, subject: id.Subject}
+		if _, ok := d.identities[k]; ok {
+			return identity.ErrIdentityExists
+		}
+		if _, ok := d.users[id.UserId]; !ok {
+			return fmt.Errorf("cannot create identity: %w", user.ErrUserNotFound)
+		}
+
+		id.CreatedAt = memoryNow()
+		d.identities[k] = id
+		return nil
+	})
+}
+
+// LoginStateRepo implementation
+type MemoryLoginStateRepo struct {
+	store  *MemoryStore
+	logger *slog.Logger
+}
+
+func NewMemoryLoginStateRepo(store *MemoryStore, logger *slog.Logger) *MemoryLoginStateRepo {
+	return &MemoryLoginStateRepo{store: store, logger: logger}
+}
+
+func (r *MemoryLoginStateRepo) CreateLoginState(ctx context.Context, s identity.LoginState) error {
+	return r.store.do(ctx, func(d *memoryData) error {
+		if _, ok := d.loginStates[s.State]; ok {
+			return errors.New("cannot create login state: state exists")
+		}
+
+		d.loginStates[s.State] = s
+		return nil
+	})
+}
+
+func (r *MemoryLoginStateRepo) TakeLoginState(ctx context.Context, state string) (identity.LoginState, error) {
+	var s identity.LoginState
+	err := r.store.do(ctx, func(d *memoryData) error {
+		var ok bool
+		if s, ok = d.loginStates[state]; !ok {
+			return identity.ErrLoginStateNotFound
+		}
+
+		delete(d.loginStates, state)
+		return nil
+	})
+
+	return s, err
+}
+
+func (r *MemoryLoginStateRepo) DeleteExpiredLoginStates(ctx context.Context, before time.Time) (int64, error) {
+	var n int64
+	err := r.store.do(ctx, func(d *memoryData) error {
+		for state, s := range d.loginStates {
+			if s.ExpiresAt.Before(before) {
+				delete(d.loginStates, state)
+				n++
+			}
+		}
+		return nil
+	})
+
+	return n, err
+}
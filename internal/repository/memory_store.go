@@ -0,0 +1,225 @@
+package repository
+
+import (
+	"context"
+	"log/slog"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/437d5/merch-store/internal/apikey"
+	"github.com/437d5/merch-store/internal/idempotency"
+	"github.com/437d5/merch-store/internal/identity"
+	"github.com/437d5/merch-store/internal/items"
+	"github.com/437d5/merch-store/internal/ledger"
+	"github.com/437d5/merch-store/internal/lockout"
+	"github.com/437d5/merch-store/internal/purchases"
+	"github.com/437d5/merch-store/internal/session"
+	"github.com/437d5/merch-store/internal/transactions"
+	"github.com/437d5/merch-store/internal/user"
+)
+
+// memoryTxKey marks a ctx passed to fn by MemoryStore.WithinTx
+type memoryTxKey struct{}
+
+// memoryCatalog is the catalog seeded by migrations/0001_init.up.sql
+var memoryCatalog = []items.ItemType{
+	{Name: "t-shirt", Cost: 80, Available: true},
+	{Name: "cup", Cost: 20, Available: true},
+	{Name: "book", Cost: 50, Available: true},
+	{Name: "pen", Cost: 10, Available: true},
+	{Name: "powerbank", Cost: 200, Available: true},
+	{Name: "hoody", Cost: 300, Available: true},
+	{Name: "umbrella", Cost: 200, Available: true},
+	{Name: "socks", Cost: 10, Available: true},
+	{Name: "wallet", Cost: 50, Available: true},
+	{Name: "pink-hoody", Cost: 500, Available: true},
+}
+
+// MemoryStore keeps the data of the in-memory repositories for tests
+// and local development. It follows the Postgres schema: ids come from
+// sequences, unique columns are enforced and every repo call is atomic.
+//
+// MemoryStore is also the TxManager of its repos. WithinTx holds the
+// store for the whole transaction, so transactions run one at a time,
+// and restores the previous state when fn fails. Repo calls inside fn
+// must use the ctx fn is given, a call with another ctx waits for the
+// transaction to finish and so deadlocks.
+type MemoryStore struct {
+	mu     sync.Mutex
+	data   memoryData
+	logger *slog.Logger
+}
+
+type memoryUserItem struct {
+	userId   int
+	itemType string
+}
+
+type memoryRecordKey struct {
+	userId int
+	key    string
+}
+
+type memoryRevokedToken struct {
+	userId    int
+	expiresAt time.Time
+}
+
+type memoryKey struct {
+	key       apikey.Key
+	revokedAt *time.Time
+}
+
+type memoryLockoutKey struct {
+	scope lockout.Scope
+	key   string
+}
+
+type memoryIdentityKey struct {
+	issuer  string
+	subject string
+}
+
+// memoryData holds the rows by primary key. Rows are stored by value
+// and never changed through pointers, so a shallow copy of the maps and
+// slices is a snapshot.
+type memoryData struct {
+	users           map[int]user.User
+	userItems       map[memoryUserItem]int
+	items           map[string]items.ItemType
+	changes         []items.Change
+	transactions    []transactions.Transaction
+	accounts        map[int]ledger.Account
+	entries         []ledger.Entry
+	purchases       []purchases.Purchase
+	records         map[memoryRecordKey]idempotency.Record
+	refreshTokens   map[int]session.RefreshToken
+	revokedTokens   map[string]memoryRevokedToken
+	userRevocations map[int]time.Time
+	resets          map[int]user.PasswordReset
+	keys            map[int]memoryKey
+	lockouts        map[memoryLockoutKey]lockout.Lockout
+	identities      map[memoryIdentityKey]identity.Identity
+	loginStates     map[string]identity.LoginState
+	// lastId is the last id handed out per table
+	lastId map[string]int
+}
+
+// NewMemoryStore returns a store with the system accounts and the
+// catalog the migrations create.
+func NewMemoryStore(logger *slog.Logger) *MemoryStore {
+	s := &MemoryStore{
+		data: memoryData{
+			users:           make(map[int]user.User),
+			userItems:       make(map[memoryUserItem]int),
+			items:           make(map[string]items.ItemType),
+			accounts:        make(map[int]ledger.Account),
+			records:         make(map[memoryRecordKey]idempotency.Record),
+			refreshTokens:   make(map[int]session.RefreshToken),
+			revokedTokens:   make(map[string]memoryRevokedToken),
+			userRevocations: make(map[int]time.Time),
+			resets:          make(map[int]user.PasswordReset),
+			keys:            make(map[int]memoryKey),
+			lockouts:        make(map[memoryLockoutKey]lockout.Lockout),
+			identities:      make(map[memoryIdentityKey]identity.Identity),
+			loginStates:     make(map[string]identity.LoginState),
+			lastId:          make(map[string]int),
+		},
+		logger: logger,
+	}
+
+	for _, kind := range []ledger.AccountKind{ledger.AccountMint, ledger.AccountRevenue} {
+		id := s.data.nextId("accounts")
+		s.data.accounts[id] = ledger.Account{Id: id, Kind: kind}
+	}
+
+	for _, item := range memoryCatalog {
+		s.data.items[item.Name] = item
+	}
+
+	return s
+}
+
+func (s *MemoryStore) WithinTx(ctx context.Context, fn func(ctx context.Context) error) (err error) {
+	if ctx.Value(memoryTxKey{}) == s {
+		return fn(ctx)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	snapshot := s.data.clone()
+	defer func() {
+		if p := recover(); p != nil {
+			s.data = snapshot
+			panic(p)
+		}
+
+		if err != nil {
+			s.data = snapshot
+		}
+	}()
+
+	return fn(context.WithValue(ctx, memoryTxKey{}, s))
+}
+
+// do runs fn on the data, as part of the transaction in ctx if there is
+// one. fn must check everything before it changes the data, so a failed
+// call leaves nothing behind.
+func (s *MemoryStore) do(ctx context.Context, fn func(d *memoryData) error) error {
+	if ctx.Value(memoryTxKey{}) == s {
+		return fn(&s.data)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return fn(&s.data)
+}
+
+func (d *memoryData) nextId(table string) int {
+	d.lastId[table]++
+	return d.lastId[table]
+}
+
+func (d *memoryData) clone() memoryData {
+	return memoryData{
+		users:           maps.Clone(d.users),
+		userItems:       maps.Clone(d.userItems),
+		items:           maps.Clone(d.items),
+		changes:         slices.Clone(d.changes),
+		transactions:    slices.Clone(d.transactions),
+		accounts:        maps.Clone(d.accounts),
+		entries:         slices.Clone(d.entries),
+		purchases:       slices.Clone(d.purchases),
+		records:         maps.Clone(d.records),
+		refreshTokens:   maps.Clone(d.refreshTokens),
+		revokedTokens:   maps.Clone(d.revokedTokens),
+		userRevocations: maps.Clone(d.userRevocations),
+		resets:          maps.Clone(d.resets),
+		keys:            maps.Clone(d.keys),
+		lockouts:        maps.Clone(d.lockouts),
+		identities:      maps.Clone(d.identities),
+		loginStates:     maps.Clone(d.loginStates),
+		lastId:          maps.Clone(d.lastId),
+	}
+}
+
+// wallet returns the wallet of the user, if it has one.
+func (d *memoryData) wallet(userId int) (ledger.Account, bool) {
+	for _, a := range d.accounts {
+		if a.Kind == ledger.AccountWallet && a.UserId == userId {
+			return a, true
+		}
+	}
+
+	return ledger.Account{}, false
+}
+
+// memoryNow is the current time at the microsecond precision Postgres
+// stores timestamps with.
+func memoryNow() time.Time {
+	return time.Now().UTC().Truncate(time.Microsecond)
+}
@@ -2,13 +2,23 @@ package repository
 
 import (
 	"context"
-	"database/sql"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"log/slog"
+	"slices"
+	"strings"
+	"time"
 
+	"github.com/437d5/merch-store/internal/apikey"
+	"github.com/437d5/merch-store/internal/idempotency"
+	"github.com/437d5/merch-store/internal/identity"
+	"github.com/437d5/merch-store/internal/inventory"
 	"github.com/437d5/merch-store/internal/items"
+	"github.com/437d5/merch-store/internal/ledger"
+	"github.com/437d5/merch-store/internal/lockout"
+	"github.com/437d5/merch-store/internal/purchases"
+	"github.com/437d5/merch-store/internal/session"
 	"github.com/437d5/merch-store/internal/transactions"
 	"github.com/437d5/merch-store/internal/user"
 	"github.com/jackc/pgx/v5"
@@ -29,32 +39,54 @@ func (r *PostgresUserRepo) GetUserByID(ctx context.Context, id int) (user.User,
 	const op = "/internal/repository/postgres/GetUserByID"
 
 	var u user.User
-	var inventoryJSON string
 
 	query := `
-		SELECT id, name, password, coins, inventory
-		FROM users
-		WHERE id = $1;
+		SELECT u.id, u.name, u.password, u.role, COALESCE(a.balance, 0)
+		FROM users u
+		LEFT JOIN accounts a ON a.user_id = u.id
+		WHERE u.id = $1;
 	`
 
-	err := r.db.QueryRow(ctx, query, id).Scan(
-		&u.Id, &u.Name, &u.Password, &u.Coins, &inventoryJSON,
+	err := conn(ctx, r.db).QueryRow(ctx, query, id).Scan(
+		&u.Id, &u.Name, &u.Password, &u.Role, &u.Coins,
 	)
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
 			r.logger.Warn("user not found", "op", op, "userId", id)
-			return user.User{}, fmt.Errorf("user not found: %d", id)
+			return user.User{}, fmt.Errorf("%w: %d", user.ErrUserNotFound, id)
 		}
 		r.logger.Error("cannot get user", "op", op, "error", err)
 		return user.User{}, fmt.Errorf("cannot get user: %w", err)
 	}
 
-	err = json.Unmarshal([]byte(inventoryJSON), &u.Inventory.Items)
+	return u, nil
+}
+
+// GetUserByIDForUpdate locks the user row until the surrounding
+// transaction ends. It must be called inside TxManager.WithinTx.
+func (r *PostgresUserRepo) GetUserByIDForUpdate(ctx context.Context, id int) (user.User, error) {
+	const op = "/internal/repository/postgres/GetUserByIDForUpdate"
+
+	var u user.User
+
+	query := `
+		SELECT u.id, u.name, u.password, u.role, COALESCE(a.balance, 0)
+		FROM users u
+		LEFT JOIN accounts a ON a.user_id = u.id
+		WHERE u.id = $1
+		FOR UPDATE OF u;
+	`
+
+	err := conn(ctx, r.db).QueryRow(ctx, query, id).Scan(
+		&u.Id, &u.Name, &u.Password, &u.Role, &u.Coins,
+	)
 	if err != nil {
-		r.logger.Error(
-			"cannot unmarshal inventory", "op", op, "error", err,
-		)
-		return user.User{}, fmt.Errorf("cannot unmarshal inventory: %w", err)
+		if errors.Is(err, pgx.ErrNoRows) {
+			r.logger.Warn("user not found", "op", op, "userId", id)
+			return user.User{}, fmt.Errorf("%w: %d", user.ErrUserNotFound, id)
+		}
+		r.logger.Error("cannot lock user", "op", op, "error", err)
+		return user.User{}, fmt.Errorf("cannot lock user: %w", err)
 	}
 
 	return u, nil
@@ -64,58 +96,47 @@ func (r *PostgresUserRepo) GetUserByName(ctx context.Context, name string) (user
 	const op = "/internal/repository/postgres/GetUserByName"
 
 	var u user.User
-	var inventoryJSON string
 
 	query := `
-		SELECT id, name, password, coins, inventory
-		FROM users
-		WHERE name = $1;
+		SELECT u.id, u.name, u.password, u.role, COALESCE(a.balance, 0)
+		FROM users u
+		LEFT JOIN accounts a ON a.user_id = u.id
+		WHERE u.name = $1;
 	`
 
-	err := r.db.QueryRow(ctx, query, name).Scan(
-		&u.Id, &u.Name, &u.Password, &u.Coins, &inventoryJSON,
+	err := conn(ctx, r.db).QueryRow(ctx, query, name).Scan(
+		&u.Id, &u.Name, &u.Password, &u.Role, &u.Coins,
 	)
 	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
+		if errors.Is(err, pgx.ErrNoRows) {
 			r.logger.Warn("user not found", "op", op, "name", name)
-			return user.User{}, fmt.Errorf("user not found: %w", err)
+			return user.User{}, fmt.Errorf("%w: %s", user.ErrUserNotFound, name)
 		}
 		r.logger.Error("cannot get user", "op", op, "error", err)
 		return user.User{}, fmt.Errorf("cannot get user: %w", err)
 	}
 
-	err = json.Unmarshal([]byte(inventoryJSON), &u.Inventory.Items)
-	if err != nil {
-		r.logger.Error(
-			"cannot unmarshal inventory", "op", op, "error", err,
-		)
-		return user.User{}, fmt.Errorf("cannot unmarshal inventory: %w", err)
-	}
-
 	return u, nil
 }
 
-func (r *PostgresUserRepo) CreateUser(ctx context.Context, user user.User) (int, error) {
+func (r *PostgresUserRepo) CreateUser(ctx context.Context, u user.User) (int, error) {
 	const op = "/internal/repository/postgres/Create"
 
-	inventoryJSON, err := json.Marshal(user.Inventory.Items)
-	if err != nil {
-		r.logger.Error("cannot marshal inventory", "op", op, "error", err)
-		return 0, fmt.Errorf("cannot marshal inventory: %w", err)
-	}
-
 	var id int
 	query := `
-		INSERT INTO users (name, password, coins, inventory)
-		VALUES ($1, $2, $3, $4)
+		INSERT INTO users (name, password, role)
+		VALUES ($1, $2, COALESCE(NULLIF($3, ''), 'employee'))
 		RETURNING id;
 	`
 
-	err = r.db.QueryRow(
-		ctx, query, user.Name, user.Password,
-		user.Coins, inventoryJSON,
+	err := conn(ctx, r.db).QueryRow(
+		ctx, query, u.Name, u.Password, string(u.Role),
 	).Scan(&id)
 	if err != nil {
+		if isUniqueViolation(err) {
+			r.logger.Warn("user already exists", "op", op, "name", u.Name)
+			return 0, fmt.Errorf("%w: %s", user.ErrUserExists, u.Name)
+		}
 		r.logger.Error("cannot create user", "op", op, "error", err)
 		return 0, fmt.Errorf("cannot create user: %w", err)
 	}
@@ -123,30 +144,262 @@ func (r *PostgresUserRepo) CreateUser(ctx context.Context, user user.User) (int,
 	return id, nil
 }
 
-func (r *PostgresUserRepo) UpdateUser(ctx context.Context, user user.User) error {
-	const op = "/internal/repository/postgres/UpdateUser"
+func (r *PostgresUserRepo) UpdateRole(ctx context.Context, name string, role user.Role) error {
+	const op = "/internal/repository/postgres/UpdateRole"
+
+	query := `
+		UPDATE users
+		SET role = $1
+		WHERE name = $2
+	`
 
-	inventoryJSON, err := json.Marshal(user.Inventory.Items)
+	tag, err := conn(ctx, r.db).Exec(ctx, query, role, name)
 	if err != nil {
-		r.logger.Error("cannot marshal inventory", "op", op, "error", err)
-		return fmt.Errorf("cannot marshal inventory: %w", err)
+		r.logger.Error("cannot update role", "op", op, "error", err)
+		return fmt.Errorf("cannot update role: %w", err)
+	}
+
+	if tag.RowsAffected() == 0 {
+		r.logger.Warn("user not found", "op", op, "name", name)
+		return fmt.Errorf("%w: %s", user.ErrUserNotFound, name)
 	}
 
+	return nil
+}
+
+// UpdatePassword stores the password hash of the user.
+func (r *PostgresUserRepo) UpdatePassword(ctx context.Context, id int, password string) error {
+	const op = "/internal/repository/postgres/UpdatePassword"
+
 	query := `
 		UPDATE users
-		SET coins = $1, inventory = $2
-		WHERE id = $3
+		SET password = $1
+		WHERE id = $2
+	`
+
+	tag, err := conn(ctx, r.db).Exec(ctx, query, password, id)
+	if err != nil {
+		r.logger.Error("cannot update password", "op", op, "error", err)
+		return fmt.Errorf("cannot update password: %w", err)
+	}
+
+	if tag.RowsAffected() == 0 {
+		r.logger.Warn("user not found", "op", op, "userId", id)
+		return fmt.Errorf("%w: %d", user.ErrUserNotFound, id)
+	}
+
+	return nil
+}
+
+// InventoryRepo implementation
+type PostgresInventoryRepo struct {
+	db     *pgxpool.Pool
+	logger *slog.Logger
+}
+
+func NewInventoryRepo(db *pgxpool.Pool, logger *slog.Logger) *PostgresInventoryRepo {
+	return &PostgresInventoryRepo{db: db, logger: logger}
+}
+
+func (r *PostgresInventoryRepo) GetInventory(ctx context.Context, userId int) (inventory.Inventory, error) {
+	const op = "/internal/repository/postgres/GetInventory"
+
+	query := `
+		SELECT i.name, ui.quantity
+		FROM user_items ui
+		JOIN items i ON i.id = ui.item_id
+		WHERE ui.user_id = $1
+		ORDER BY i.name;
+	`
+
+	rows, err := conn(ctx, r.db).Query(ctx, query, userId)
+	if err != nil {
+		r.logger.Error("cannot get inventory", "op", op, "error", err)
+		return inventory.Inventory{}, fmt.Errorf("cannot get inventory: %w", err)
+	}
+	defer rows.Close()
+
+	var inv inventory.Inventory
+	for rows.Next() {
+		var item inventory.Item
+		if err = rows.Scan(&item.ItemType, &item.Quantity); err != nil {
+			r.logger.Error("cannot scan inventory", "op", op, "error", err)
+			return inventory.Inventory{}, fmt.Errorf("cannot scan inventory: %w", err)
+		}
+		inv.Items = append(inv.Items, item)
+	}
+
+	if err = rows.Err(); err != nil {
+		r.logger.Error("cannot read inventory", "op", op, "error", err)
+		return inventory.Inventory{}, fmt.Errorf("cannot read inventory: %w", err)
+	}
+
+	return inv, nil
+}
+
+func (r *PostgresInventoryRepo) IncrementItem(
+	ctx context.Context, userId int, itemType string, quantity int,
+) (int, error) {
+	const op = "/internal/repository/postgres/IncrementItem"
+
+	query := `
+		INSERT INTO user_items (user_id, item_id, quantity)
+		SELECT $1::INTEGER, id, $3::INTEGER
+		FROM items
+		WHERE name = $2
+		ON CONFLICT (user_id, item_id)
+		DO UPDATE SET quantity = user_items.quantity + EXCLUDED.quantity
+		RETURNING quantity;
+	`
+
+	var total int
+	err := conn(ctx, r.db).QueryRow(ctx, query, userId, itemType, quantity).Scan(&total)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			r.logger.Warn("item not found", "op", op, "name", itemType)
+			return 0, fmt.Errorf("%w: %s", items.ErrItemNotFound, itemType)
+		}
+		r.logger.Error("cannot increment item", "op", op, "error", err)
+		return 0, fmt.Errorf("cannot increment item: %w", err)
+	}
+
+	return total, nil
+}
+
+// DecrementItem removes the row once the quantity drops to zero, so
+// inventories only list items the user owns.
+func (r *PostgresInventoryRepo) DecrementItem(
+	ctx context.Context, userId int, itemType string, quantity int,
+) (int, error) {
+	const op = "/internal/repository/postgres/DecrementItem"
+
+	query := `
+		UPDATE user_items
+		SET quantity = quantity - $3
+		WHERE user_id = $1
+			AND item_id = (SELECT id FROM items WHERE name = $2)
+			AND quantity >= $3
+		RETURNING item_id, quantity;
+	`
+
+	var itemId, total int
+	err := conn(ctx, r.db).QueryRow(ctx, query, userId, itemType, quantity).Scan(&itemId, &total)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return 0, inventory.ErrNotEnoughItems
+		}
+		r.logger.Error("cannot decrement item", "op", op, "error", err)
+		return 0, fmt.Errorf("cannot decrement item: %w", err)
+	}
+
+	if total == 0 {
+		query = `
+			DELETE FROM user_items
+			WHERE user_id = $1 AND item_id = $2 AND quantity = 0;
+		`
+
+		if _, err = conn(ctx, r.db).Exec(ctx, query, userId, itemId); err != nil {
+			r.logger.Error("cannot delete item", "op", op, "error", err)
+			return 0, fmt.Errorf("cannot delete item: %w", err)
+		}
+	}
+
+	return total, nil
+}
+
+// PasswordResetRepo implementation
+type PostgresPasswordResetRepo struct {
+	db     *pgxpool.Pool
+	logger *slog.Logger
+}
+
+func NewPasswordResetRepo(db *pgxpool.Pool, logger *slog.Logger) *PostgresPasswordResetRepo {
+	return &PostgresPasswordResetRepo{db: db, logger: logger}
+}
+
+func (r *PostgresPasswordResetRepo) CreatePasswordReset(ctx context.Context, reset user.PasswordReset) error {
+	const op = "/internal/repository/postgres/CreatePasswordReset"
+
+	query := `
+		INSERT INTO password_resets (user_id, token_hash, created_by, expires_at)
+		VALUES ($1, $2, NULLIF($3, 0), $4);
+	`
+
+	_, err := conn(ctx, r.db).Exec(
+		ctx, query, reset.UserId, reset.Hash, reset.CreatedBy, reset.ExpiresAt,
+	)
+	if err != nil {
+		r.logger.Error("cannot create password reset", "op", op, "error", err)
+		return fmt.Errorf("cannot create password reset: %w", err)
+	}
+
+	return nil
+}
+
+// GetPasswordResetForUpdate locks the reset row until the surrounding
+// transaction ends. It must be called inside TxManager.WithinTx.
+func (r *PostgresPasswordResetRepo) GetPasswordResetForUpdate(ctx context.Context, hash string) (user.PasswordReset, error) {
+	const op = "/internal/repository/postgres/GetPasswordResetForUpdate"
+
+	var reset user.PasswordReset
+
+	query := `
+		SELECT id, user_id, token_hash, COALESCE(created_by, 0), expires_at, created_at, used_at
+		FROM password_resets
+		WHERE token_hash = $1
+		FOR UPDATE;
+	`
+
+	err := conn(ctx, r.db).QueryRow(ctx, query, hash).Scan(
+		&reset.Id, &reset.UserId, &reset.Hash, &reset.CreatedBy,
+		&reset.ExpiresAt, &reset.CreatedAt, &reset.UsedAt,
+	)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return user.PasswordReset{}, user.ErrResetTokenNotFound
+		}
+		r.logger.Error("cannot get password reset", "op", op, "error", err)
+		return user.PasswordReset{}, fmt.Errorf("cannot get password reset: %w", err)
+	}
+
+	return reset, nil
+}
+
+func (r *PostgresPasswordResetRepo) MarkPasswordResetUsed(ctx context.Context, id int) error {
+	const op = "/internal/repository/postgres/MarkPasswordResetUsed"
+
+	query := `
+		UPDATE password_resets
+		SET used_at = CURRENT_TIMESTAMP
+		WHERE id = $1;
 	`
 
-	_, err = r.db.Exec(ctx, query, user.Coins, inventoryJSON, user.Id)
+	_, err := conn(ctx, r.db).Exec(ctx, query, id)
 	if err != nil {
-		r.logger.Error("cannot update user", "op", op, "error", err)
-		return fmt.Errorf("cannot update user: %w", err)
+		r.logger.Error("cannot mark password reset used", "op", op, "error", err)
+		return fmt.Errorf("cannot mark password reset used: %w", err)
 	}
 
 	return nil
 }
 
+func (r *PostgresPasswordResetRepo) DeleteExpiredPasswordResets(ctx context.Context, before time.Time) (int64, error) {
+	const op = "/internal/repository/postgres/DeleteExpiredPasswordResets"
+
+	query := `
+		DELETE FROM password_resets
+		WHERE expires_at < $1;
+	`
+
+	tag, err := conn(ctx, r.db).Exec(ctx, query, before)
+	if err != nil {
+		r.logger.Error("cannot delete expired password resets", "op", op, "error", err)
+		return 0, fmt.Errorf("cannot delete expired password resets: %w", err)
+	}
+
+	return tag.RowsAffected(), nil
+}
+
 // TransactionRepo implementation
 type PostgresTransRepo struct {
 	db     *pgxpool.Pool
@@ -165,8 +418,12 @@ func (r *PostgresTransRepo) CreateTransaction(ctx context.Context, t transaction
 		VALUES ($1, $2, $3);
 	`
 
-	_, err := r.db.Exec(ctx, query, t.FromUser, t.ToUser, t.Amount)
+	_, err := conn(ctx, r.db).Exec(ctx, query, t.FromUser, t.ToUser, t.Amount)
 	if err != nil {
+		if isForeignKeyViolation(err) {
+			r.logger.Warn("user not found", "op", op, "from", t.FromUser, "to", t.ToUser)
+			return fmt.Errorf("cannot create transaction: %w", user.ErrUserNotFound)
+		}
 		r.logger.Error("cannot create transaction", "op", op, "error", err)
 		return fmt.Errorf("cannot create transaction: %w", err)
 	}
@@ -174,33 +431,73 @@ func (r *PostgresTransRepo) CreateTransaction(ctx context.Context, t transaction
 	return nil
 }
 
-func (r *PostgresTransRepo) GetTransactionByUser(ctx context.Context, userId int) ([]transactions.Transaction, error) {
-	const op = "/internal/repository/postgres/GetTransactionByUser"
+func (r *PostgresTransRepo) ListTransactions(ctx context.Context, f transactions.Filter) ([]transactions.Transaction, error) {
+	const op = "/internal/repository/postgres/ListTransactions"
 
-	query := `
-		SELECT from_user, to_user, amount
-		FROM transactions
-		WHERE from_user = $1 OR to_user = $1
-		ORDER BY timestamp DESC;
-	`
+	args := []any{f.UserId}
+	var conds []string
 
-	rows, err := r.db.Query(ctx, query, userId)
-	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			r.logger.Warn("no transactions found", "op", op, "userID", userId)
-			return []transactions.Transaction{}, nil
-		}
+	switch f.Direction {
+	case transactions.DirectionSent:
+		conds = append(conds, "t.from_user = $1")
+	case transactions.DirectionReceived:
+		conds = append(conds, "t.to_user = $1")
+	default:
+		conds = append(conds, "(t.from_user = $1 OR t.to_user = $1)")
+	}
+
+	// where adds a condition with a single placeholder for arg
+	where := func(cond string, arg any) {
+		args = append(args, arg)
+		conds = append(conds, fmt.Sprintf(cond, len(args)))
+	}
+
+	if f.Counterparty != "" {
+		where(`CASE WHEN t.from_user = $1 THEN t.to_user ELSE t.from_user END =
+			(SELECT id FROM users WHERE name = $%d)`, f.Counterparty)
+	}
+	if f.MinAmount > 0 {
+		where("t.amount >= $%d", f.MinAmount)
+	}
+	if f.MaxAmount > 0 {
+		where("t.amount <= $%d", f.MaxAmount)
+	}
+	if !f.From.IsZero() {
+		where("t.timestamp >= $%d", f.From.UTC())
+	}
+	if !f.To.IsZero() {
+		where("t.timestamp < $%d", f.To.UTC())
+	}
+	if f.After != nil {
+		args = append(args, f.After.Timestamp, f.After.Id)
+		conds = append(conds, fmt.Sprintf("(t.timestamp, t.id) < ($%d, $%d)", len(args)-1, len(args)))
+	}
 
+	args = append(args, f.Limit)
+	query := fmt.Sprintf(`
+		SELECT t.id, t.from_user, t.to_user, f.name, tu.name, t.amount, t.timestamp
+		FROM transactions t
+		JOIN users f ON f.id = t.from_user
+		JOIN users tu ON tu.id = t.to_user
+		WHERE %s
+		ORDER BY t.timestamp DESC, t.id DESC
+		LIMIT $%d;
+	`, strings.Join(conds, " AND "), len(args))
+
+	rows, err := conn(ctx, r.db).Query(ctx, query, args...)
+	if err != nil {
 		r.logger.Error("failed to get transactions", "op", op, "error", err)
 		return nil, fmt.Errorf("failed to get transactions: %w", err)
 	}
+	defer rows.Close()
 
 	var tList []transactions.Transaction
 
 	for rows.Next() {
 		var t transactions.Transaction
 		err := rows.Scan(
-			&t.FromUser, &t.ToUser, &t.Amount,
+			&t.Id, &t.FromUser, &t.ToUser, &t.FromUsername, &t.ToUsername,
+			&t.Amount, &t.Timestamp,
 		)
 		if err != nil {
 			r.logger.Error("failed to scan transaction", "op", op, "error", err)
@@ -234,15 +531,17 @@ func (r *PostgresItemRepo) GetItemByName(ctx context.Context, name string) (item
 	var item items.ItemType
 
 	query := `
-		SELECT name, cost FROM items
+		SELECT name, cost, available FROM items
 		WHERE name = $1;
 	`
 
-	err := r.db.QueryRow(ctx, query, name).Scan(&item.Name, &item.Cost)
+	err := conn(ctx, r.db).QueryRow(ctx, query, name).Scan(
+		&item.Name, &item.Cost, &item.Available,
+	)
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
 			r.logger.Warn("item not found", "op", op, "name", name)
-			return items.ItemType{}, fmt.Errorf("item not found: %w", err)
+			return items.ItemType{}, fmt.Errorf("%w: %s", items.ErrItemNotFound, name)
 		}
 
 		r.logger.Error("failed to get item", "op", op, "error", err)
@@ -251,3 +550,1315 @@ func (r *PostgresItemRepo) GetItemByName(ctx context.Context, name string) (item
 
 	return item, nil
 }
+
+// GetItemByNameForUpdate locks the item row until the surrounding
+// transaction ends. It must be called inside TxManager.WithinTx.
+func (r *PostgresItemRepo) GetItemByNameForUpdate(ctx context.Context, name string) (items.ItemType, error) {
+	const op = "/internal/repository/postgres/GetItemByNameForUpdate"
+
+	var item items.ItemType
+
+	query := `
+		SELECT name, cost, available FROM items
+		WHERE name = $1
+		FOR UPDATE;
+	`
+
+	err := conn(ctx, r.db).QueryRow(ctx, query, name).Scan(
+		&item.Name, &item.Cost, &item.Available,
+	)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			r.logger.Warn("item not found", "op", op, "name", name)
+			return items.ItemType{}, fmt.Errorf("%w: %s", items.ErrItemNotFound, name)
+		}
+
+		r.logger.Error("failed to lock item", "op", op, "error", err)
+		return items.ItemType{}, fmt.Errorf("failed to lock item: %w", err)
+	}
+
+	return item, nil
+}
+
+// GetItemByNameForShare locks the item row against updates until the
+// surrounding transaction ends. It must be called inside TxManager.WithinTx.
+func (r *PostgresItemRepo) GetItemByNameForShare(ctx context.Context, name string) (items.ItemType, error) {
+	const op = "/internal/repository/postgres/GetItemByNameForShare"
+
+	var item items.ItemType
+
+	query := `
+		SELECT name, cost, available FROM items
+		WHERE name = $1
+		FOR SHARE;
+	`
+
+	err := conn(ctx, r.db).QueryRow(ctx, query, name).Scan(
+		&item.Name, &item.Cost, &item.Available,
+	)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			r.logger.Warn("item not found", "op", op, "name", name)
+			return items.ItemType{}, fmt.Errorf("%w: %s", items.ErrItemNotFound, name)
+		}
+
+		r.logger.Error("failed to lock item", "op", op, "error", err)
+		return items.ItemType{}, fmt.Errorf("failed to lock item: %w", err)
+	}
+
+	return item, nil
+}
+
+func (r *PostgresItemRepo) ListItems(ctx context.Context) ([]items.ItemType, error) {
+	const op = "/internal/repository/postgres/ListItems"
+
+	query := `
+		SELECT name, cost, available FROM items
+		ORDER BY name;
+	`
+
+	rows, err := conn(ctx, r.db).Query(ctx, query)
+	if err != nil {
+		r.logger.Error("failed to list items", "op", op, "error", err)
+		return nil, fmt.Errorf("failed to list items: %w", err)
+	}
+	defer rows.Close()
+
+	var iList []items.ItemType
+
+	for rows.Next() {
+		var item items.ItemType
+		err := rows.Scan(&item.Name, &item.Cost, &item.Available)
+		if err != nil {
+			r.logger.Error("failed to scan item", "op", op, "error", err)
+			return nil, fmt.Errorf("failed to scan item: %w", err)
+		}
+
+		iList = append(iList, item)
+	}
+
+	if err = rows.Err(); err != nil {
+		r.logger.Error("rows iteration error", "op", op, "error", err)
+		return nil, fmt.Errorf("rows iteration error: %w", err)
+	}
+
+	return iList, nil
+}
+
+func (r *PostgresItemRepo) CreateItem(ctx context.Context, item items.ItemType) error {
+	const op = "/internal/repository/postgres/CreateItem"
+
+	query := `
+		INSERT INTO items (name, cost, available)
+		VALUES ($1, $2, $3);
+	`
+
+	_, err := conn(ctx, r.db).Exec(ctx, query, item.Name, item.Cost, item.Available)
+	if err != nil {
+		if isUniqueViolation(err) {
+			r.logger.Warn("item already exists", "op", op, "name", item.Name)
+			return fmt.Errorf("%w: %s", items.ErrItemExists, item.Name)
+		}
+		r.logger.Error("cannot create item", "op", op, "error", err)
+		return fmt.Errorf("cannot create item: %w", err)
+	}
+
+	return nil
+}
+
+// UpdateItem overwrites the item called name, including its name.
+func (r *PostgresItemRepo) UpdateItem(ctx context.Context, name string, item items.ItemType) error {
+	const op = "/internal/repository/postgres/UpdateItem"
+
+	query := `
+		UPDATE items
+		SET name = $1, cost = $2, available = $3
+		WHERE name = $4;
+	`
+
+	tag, err := conn(ctx, r.db).Exec(ctx, query, item.Name, item.Cost, item.Available, name)
+	if err != nil {
+		if isUniqueViolation(err) {
+			r.logger.Warn("item already exists", "op", op, "name", item.Name)
+			return fmt.Errorf("%w: %s", items.ErrItemExists, item.Name)
+		}
+		r.logger.Error("cannot update item", "op", op, "error", err)
+		return fmt.Errorf("cannot update item: %w", err)
+	}
+
+	if tag.RowsAffected() == 0 {
+		r.logger.Warn("item not found", "op", op, "name", name)
+		return fmt.Errorf("%w: %s", items.ErrItemNotFound, name)
+	}
+
+	return nil
+}
+
+func (r *PostgresItemRepo) RecordChange(ctx context.Context, change items.Change) error {
+	const op = "/internal/repository/postgres/RecordChange"
+
+	before, err := json.Marshal(change.Before)
+	if err != nil {
+		r.logger.Error("cannot marshal item", "op", op, "error", err)
+		return fmt.Errorf("cannot marshal item: %w", err)
+	}
+
+	after, err := json.Marshal(change.After)
+	if err != nil {
+		r.logger.Error("cannot marshal item", "op", op, "error", err)
+		return fmt.Errorf("cannot marshal item: %w", err)
+	}
+
+	query := `
+		INSERT INTO catalog_audit (actor_id, action, item_name, before, after)
+		VALUES ($1, $2, $3, $4, $5);
+	`
+
+	_, err = conn(ctx, r.db).Exec(
+		ctx, query, change.ActorId, change.Action, change.ItemName, before, after,
+	)
+	if err != nil {
+		r.logger.Error("cannot record catalog change", "op", op, "error", err)
+		return fmt.Errorf("cannot record catalog change: %w", err)
+	}
+
+	return nil
+}
+
+func (r *PostgresItemRepo) ListChanges(ctx context.Context, limit int) ([]items.Change, error) {
+	const op = "/internal/repository/postgres/ListChanges"
+
+	query := `
+		SELECT id, COALESCE(actor_id, 0), action, item_name, before, after, created_at
+		FROM catalog_audit
+		ORDER BY id DESC
+		LIMIT $1;
+	`
+
+	rows, err := conn(ctx, r.db).Query(ctx, query, limit)
+	if err != nil {
+		r.logger.Error("failed to list catalog changes", "op", op, "error", err)
+		return nil, fmt.Errorf("failed to list catalog changes: %w", err)
+	}
+	defer rows.Close()
+
+	var cList []items.Change
+
+	for rows.Next() {
+		var c items.Change
+		var action string
+		var before, after []byte
+		err := rows.Scan(
+			&c.Id, &c.ActorId, &action, &c.ItemName, &before, &after, &c.CreatedAt,
+		)
+		if err != nil {
+			r.logger.Error("failed to scan catalog change", "op", op, "error", err)
+			return nil, fmt.Errorf("failed to scan catalog change: %w", err)
+		}
+		c.Action = items.Action(action)
+
+		if err = json.Unmarshal(before, &c.Before); err != nil {
+			return nil, fmt.Errorf("cannot unmarshal item: %w", err)
+		}
+		if err = json.Unmarshal(after, &c.After); err != nil {
+			return nil, fmt.Errorf("cannot unmarshal item: %w", err)
+		}
+
+		cList = append(cList, c)
+	}
+
+	if err = rows.Err(); err != nil {
+		r.logger.Error("rows iteration error", "op", op, "error", err)
+		return nil, fmt.Errorf("rows iteration error: %w", err)
+	}
+
+	return cList, nil
+}
+
+// IdempotencyRepo implementation
+type PostgresIdempotencyRepo struct {
+	db     *pgxpool.Pool
+	logger *slog.Logger
+}
+
+func NewIdempotencyRepo(db *pgxpool.Pool, logger *slog.Logger) *PostgresIdempotencyRepo {
+	return &PostgresIdempotencyRepo{db: db, logger: logger}
+}
+
+func (r *PostgresIdempotencyRepo) CreateRecord(ctx context.Context, record idempotency.Record) error {
+	const op = "/internal/repository/postgres/CreateRecord"
+
+	query := `
+		INSERT INTO idempotency_keys (user_id, key, fingerprint)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (user_id, key) DO NOTHING;
+	`
+
+	tag, err := conn(ctx, r.db).Exec(ctx, query, record.UserId, record.Key, record.Fingerprint)
+	if err != nil {
+		r.logger.Error("cannot create idempotency record", "op", op, "error", err)
+		return fmt.Errorf("cannot create idempotency record: %w", err)
+	}
+
+	if tag.RowsAffected() == 0 {
+		return idempotency.ErrKeyExists
+	}
+
+	return nil
+}
+
+func (r *PostgresIdempotencyRepo) GetRecord(ctx context.Context, userId int, key string) (idempotency.Record, error) {
+	const op = "/internal/repository/postgres/GetRecord"
+
+	var rec idempotency.Record
+
+	query := `
+		SELECT user_id, key, fingerprint, status_code, response, created_at
+		FROM idempotency_keys
+		WHERE user_id = $1 AND key = $2;
+	`
+
+	err := conn(ctx, r.db).QueryRow(ctx, query, userId, key).Scan(
+		&rec.UserId, &rec.Key, &rec.Fingerprint,
+		&rec.StatusCode, &rec.Response, &rec.CreatedAt,
+	)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return idempotency.Record{}, idempotency.ErrRecordNotFound
+		}
+		r.logger.Error("cannot get idempotency record", "op", op, "error", err)
+		return idempotency.Record{}, fmt.Errorf("cannot get idempotency record: %w", err)
+	}
+
+	return rec, nil
+}
+
+func (r *PostgresIdempotencyRepo) CompleteRecord(
+	ctx context.Context, userId int, key string, statusCode int, response []byte,
+) error {
+	const op = "/internal/repository/postgres/CompleteRecord"
+
+	query := `
+		UPDATE idempotency_keys
+		SET status_code = $1, response = $2
+		WHERE user_id = $3 AND key = $4;
+	`
+
+	_, err := conn(ctx, r.db).Exec(ctx, query, statusCode, response, userId, key)
+	if err != nil {
+		r.logger.Error("cannot complete idempotency record", "op", op, "error", err)
+		return fmt.Errorf("cannot complete idempotency record: %w", err)
+	}
+
+	return nil
+}
+
+func (r *PostgresIdempotencyRepo) DeleteRecord(ctx context.Context, userId int, key string) error {
+	const op = "/internal/repository/postgres/DeleteRecord"
+
+	query := `
+		DELETE FROM idempotency_keys
+		WHERE user_id = $1 AND key = $2;
+	`
+
+	_, err := conn(ctx, r.db).Exec(ctx, query, userId, key)
+	if err != nil {
+		r.logger.Error("cannot delete idempotency record", "op", op, "error", err)
+		return fmt.Errorf("cannot delete idempotency record: %w", err)
+	}
+
+	return nil
+}
+
+func (r *PostgresIdempotencyRepo) DeleteExpired(ctx context.Context, before time.Time) (int64, error) {
+	const op = "/internal/repository/postgres/DeleteExpired"
+
+	query := `
+		DELETE FROM idempotency_keys
+		WHERE created_at < $1;
+	`
+
+	tag, err := conn(ctx, r.db).Exec(ctx, query, before)
+	if err != nil {
+		r.logger.Error("cannot delete expired idempotency records", "op", op, "error", err)
+		return 0, fmt.Errorf("cannot delete expired idempotency records: %w", err)
+	}
+
+	return tag.RowsAffected(), nil
+}
+
+// PurchaseRepo implementation
+type PostgresPurchaseRepo struct {
+	db     *pgxpool.Pool
+	logger *slog.Logger
+}
+
+func NewPurchaseRepo(db *pgxpool.Pool, logger *slog.Logger) *PostgresPurchaseRepo {
+	return &PostgresPurchaseRepo{db: db, logger: logger}
+}
+
+func (r *PostgresPurchaseRepo) CreatePurchase(ctx context.Context, p purchases.Purchase) error {
+	const op = "/internal/repository/postgres/CreatePurchase"
+
+	query := `
+		INSERT INTO purchases (user_id, item_name, cost)
+		VALUES ($1, $2, $3);
+	`
+
+	_, err := conn(ctx, r.db).Exec(ctx, query, p.UserId, p.ItemName, p.Cost)
+	if err != nil {
+		r.logger.Error("cannot create purchase", "op", op, "error", err)
+		return fmt.Errorf("cannot create purchase: %w", err)
+	}
+
+	return nil
+}
+
+func (r *PostgresPurchaseRepo) GetPurchasesByUser(ctx context.Context, userId, limit int) ([]purchases.Purchase, error) {
+	const op = "/internal/repository/postgres/GetPurchasesByUser"
+
+	query := `
+		SELECT id, user_id, item_name, cost, created_at
+		FROM purchases
+		WHERE user_id = $1
+		ORDER BY created_at DESC, id DESC
+		LIMIT $2;
+	`
+
+	rows, err := conn(ctx, r.db).Query(ctx, query, userId, limit)
+	if err != nil {
+		r.logger.Error("failed to get purchases", "op", op, "error", err)
+		return nil, fmt.Errorf("failed to get purchases: %w", err)
+	}
+	defer rows.Close()
+
+	var pList []purchases.Purchase
+
+	for rows.Next() {
+		var p purchases.Purchase
+		err := rows.Scan(
+			&p.Id, &p.UserId, &p.ItemName, &p.Cost, &p.CreatedAt,
+		)
+		if err != nil {
+			r.logger.Error("failed to scan purchase", "op", op, "error", err)
+			return nil, fmt.Errorf("failed to scan purchase: %w", err)
+		}
+
+		pList = append(pList, p)
+	}
+
+	if err = rows.Err(); err != nil {
+		r.logger.Error("rows iteration error", "op", op, "error", err)
+		return nil, fmt.Errorf("rows iteration error: %w", err)
+	}
+
+	return pList, nil
+}
+
+// LedgerRepo implementation
+type PostgresLedgerRepo struct {
+	db        *pgxpool.Pool
+	txManager *PostgresTxManager
+	logger    *slog.Logger
+}
+
+func NewLedgerRepo(db *pgxpool.Pool, logger *slog.Logger) *PostgresLedgerRepo {
+	return &PostgresLedgerRepo{
+		db:        db,
+		txManager: NewTxManager(db, logger),
+		logger:    logger,
+	}
+}
+
+func (r *PostgresLedgerRepo) CreateWallet(ctx context.Context, userId int) (ledger.Account, error) {
+	const op = "/internal/repository/postgres/CreateWallet"
+
+	a := ledger.Account{Kind: ledger.AccountWallet, UserId: userId}
+
+	query := `
+		INSERT INTO accounts (kind, user_id)
+		VALUES ($1, $2)
+		RETURNING id;
+	`
+
+	err := conn(ctx, r.db).QueryRow(ctx, query, a.Kind, userId).Scan(&a.Id)
+	if err != nil {
+		r.logger.Error("cannot create wallet", "op", op, "error", err)
+		return ledger.Account{}, fmt.Errorf("cannot create wallet: %w", err)
+	}
+
+	return a, nil
+}
+
+func (r *PostgresLedgerRepo) GetWallet(ctx context.Context, userId int) (ledger.Account, error) {
+	const op = "/internal/repository/postgres/GetWallet"
+
+	var a ledger.Account
+	var kind string
+
+	query := `
+		SELECT id, kind, user_id, balance
+		FROM accounts
+		WHERE user_id = $1;
+	`
+
+	err := conn(ctx, r.db).QueryRow(ctx, query, userId).Scan(
+		&a.Id, &kind, &a.UserId, &a.Balance,
+	)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			r.logger.Warn("wallet not found", "op", op, "userId", userId)
+			return ledger.Account{}, ledger.ErrAccountNotFound
+		}
+		r.logger.Error("cannot get wallet", "op", op, "error", err)
+		return ledger.Account{}, fmt.Errorf("cannot get wallet: %w", err)
+	}
+	a.Kind = ledger.AccountKind(kind)
+
+	return a, nil
+}
+
+func (r *PostgresLedgerRepo) GetSystemAccount(ctx context.Context, kind ledger.AccountKind) (ledger.Account, error) {
+	const op = "/internal/repository/postgres/GetSystemAccount"
+
+	a := ledger.Account{Kind: kind}
+
+	query := `
+		SELECT id, balance
+		FROM accounts
+		WHERE kind = $1 AND user_id IS NULL;
+	`
+
+	err := conn(ctx, r.db).QueryRow(ctx, query, kind).Scan(&a.Id, &a.Balance)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			r.logger.Error("system account not found", "op", op, "kind", kind)
+			return ledger.Account{}, ledger.ErrAccountNotFound
+		}
+		r.logger.Error("cannot get system account", "op", op, "error", err)
+		return ledger.Account{}, fmt.Errorf("cannot get system account: %w", err)
+	}
+
+	return a, nil
+}
+
+// PostEntry records the entry and applies its postings to the cached
+// balances. Wallets are debited only if they hold enough coins, otherwise
+// the whole entry is rolled back with ledger.ErrInsufficientFunds.
+func (r *PostgresLedgerRepo) PostEntry(ctx context.Context, entry ledger.Entry) (int, error) {
+	const op = "/internal/repository/postgres/PostEntry"
+
+	if err := entry.Validate(); err != nil {
+		r.logger.Error("invalid entry", "op", op, "kind", entry.Kind, "error", err)
+		return 0, err
+	}
+
+	// update accounts in id order so concurrent entries cannot deadlock
+	postings := slices.Clone(entry.Postings)
+	slices.SortFunc(postings, func(a, b ledger.Posting) int {
+		return a.AccountId - b.AccountId
+	})
+
+	var entryId int
+	err := r.txManager.WithinTx(ctx, func(ctx context.Context) error {
+		db := conn(ctx, r.db)
+
+		err := db.QueryRow(ctx, `
+			INSERT INTO ledger_entries (kind)
+			VALUES ($1)
+			RETURNING id;
+		`, entry.Kind).Scan(&entryId)
+		if err != nil {
+			return fmt.Errorf("cannot create entry: %w", err)
+		}
+
+		for _, p := range postings {
+			tag, err := db.Exec(ctx, `
+				UPDATE accounts
+				SET balance = balance + $1
+				WHERE id = $2 AND (kind <> 'wallet' OR balance + $1 >= 0);
+			`, p.Amount, p.AccountId)
+			if err != nil {
+				return fmt.Errorf("cannot update balance: %w", err)
+			}
+
+			if tag.RowsAffected() == 0 {
+				var exists bool
+				err = db.QueryRow(ctx, `
+					SELECT EXISTS (SELECT 1 FROM accounts WHERE id = $1);
+				`, p.AccountId).Scan(&exists)
+				if err != nil {
+					return fmt.Errorf("cannot check account: %w", err)
+				}
+				if !exists {
+					return ledger.ErrAccountNotFound
+				}
+				return ledger.ErrInsufficientFunds
+			}
+
+			_, err = db.Exec(ctx, `
+				INSERT INTO postings (entry_id, account_id, amount)
+				VALUES ($1, $2, $3);
+			`, entryId, p.AccountId, p.Amount)
+			if err != nil {
+				return fmt.Errorf("cannot create posting: %w", err)
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		if errors.Is(err, ledger.ErrInsufficientFunds) {
+			r.logger.Warn("insufficient funds", "op", op, "kind", entry.Kind)
+			return 0, err
+		}
+		r.logger.Error("cannot post entry", "op", op, "error", err)
+		return 0, fmt.Errorf("cannot post entry: %w", err)
+	}
+
+	return entryId, nil
+}
+
+func (r *PostgresLedgerRepo) Reconcile(ctx context.Context) (ledger.Report, error) {
+	const op = "/internal/repository/postgres/Reconcile"
+
+	// the checks read one snapshot, a posting committed between them
+	// would show up as a discrepancy
+	tx, err := r.db.BeginTx(ctx, pgx.TxOptions{IsoLevel: pgx.RepeatableRead, AccessMode: pgx.ReadOnly})
+	if err != nil {
+		r.logger.Error("cannot begin transaction", "op", op, "error", err)
+		return ledger.Report{}, fmt.Errorf("cannot begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	var report ledger.Report
+
+	rows, err := tx.Query(ctx, `
+		SELECT entry_id
+		FROM postings
+		GROUP BY entry_id
+		HAVING SUM(amount) <> 0
+		ORDER BY entry_id;
+	`)
+	if err != nil {
+		r.logger.Error("cannot check entries", "op", op, "error", err)
+		return ledger.Report{}, fmt.Errorf("cannot check entries: %w", err)
+	}
+	report.UnbalancedEntries, err = pgx.CollectRows(rows, pgx.RowTo[int])
+	if err != nil {
+		r.logger.Error("cannot scan entries", "op", op, "error", err)
+		return ledger.Report{}, fmt.Errorf("cannot scan entries: %w", err)
+	}
+
+	rows, err = tx.Query(ctx, `
+		SELECT a.id, a.balance, COALESCE(SUM(p.amount), 0)
+		FROM accounts a
+		LEFT JOIN postings p ON p.account_id = a.id
+		GROUP BY a.id, a.balance
+		HAVING a.balance <> COALESCE(SUM(p.amount), 0)
+		ORDER BY a.id;
+	`)
+	if err != nil {
+		r.logger.Error("cannot check balances", "op", op, "error", err)
+		return ledger.Report{}, fmt.Errorf("cannot check balances: %w", err)
+	}
+	report.Mismatches, err = pgx.CollectRows(rows, func(row pgx.CollectableRow) (ledger.AccountMismatch, error) {
+		var m ledger.AccountMismatch
+		err := row.Scan(&m.AccountId, &m.Cached, &m.Posted)
+		return m, err
+	})
+	if err != nil {
+		r.logger.Error("cannot scan balances", "op", op, "error", err)
+		return ledger.Report{}, fmt.Errorf("cannot scan balances: %w", err)
+	}
+
+	err = tx.QueryRow(ctx, `
+		SELECT COALESCE(SUM(balance), 0) FROM accounts;
+	`).Scan(&report.Total)
+	if err != nil {
+		r.logger.Error("cannot sum balances", "op", op, "error", err)
+		return ledger.Report{}, fmt.Errorf("cannot sum balances: %w", err)
+	}
+
+	return report, nil
+}
+
+// RefreshTokenRepo implementation
+type PostgresRefreshTokenRepo struct {
+	db     *pgxpool.Pool
+	logger *slog.Logger
+}
+
+func NewRefreshTokenRepo(db *pgxpool.Pool, logger *slog.Logger) *PostgresRefreshTokenRepo {
+	return &PostgresRefreshTokenRepo{db: db, logger: logger}
+}
+
+func (r *PostgresRefreshTokenRepo) CreateRefreshToken(ctx context.Context, t session.RefreshToken) error {
+	const op = "/internal/repository/postgres/CreateRefreshToken"
+
+	query := `
+		INSERT INTO refresh_tokens (user_id, family_id, token_hash, expires_at)
+		VALUES ($1, $2, $3, $4);
+	`
+
+	_, err := conn(ctx, r.db).Exec(ctx, query, t.UserId, t.FamilyId, t.Hash, t.ExpiresAt)
+	if err != nil {
+		r.logger.Error("cannot create refresh token", "op", op, "error", err)
+		return fmt.Errorf("cannot create refresh token: %w", err)
+	}
+
+	return nil
+}
+
+// GetRefreshTokenForUpdate locks the token row until the surrounding
+// transaction ends. It must be called inside TxManager.WithinTx.
+func (r *PostgresRefreshTokenRepo) GetRefreshTokenForUpdate(ctx context.Context, hash string) (session.RefreshToken, error) {
+	const op = "/internal/repository/postgres/GetRefreshTokenForUpdate"
+
+	var t session.RefreshToken
+
+	query := `
+		SELECT id, user_id, family_id, token_hash, expires_at, created_at, used_at, revoked_at
+		FROM refresh_tokens
+		WHERE token_hash = $1
+		FOR UPDATE;
+	`
+
+	err := conn(ctx, r.db).QueryRow(ctx, query, hash).Scan(
+		&t.Id, &t.UserId, &t.FamilyId, &t.Hash,
+		&t.ExpiresAt, &t.CreatedAt, &t.UsedAt, &t.RevokedAt,
+	)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return session.RefreshToken{}, session.ErrRefreshTokenNotFound
+		}
+		r.logger.Error("cannot get refresh token", "op", op, "error", err)
+		return session.RefreshToken{}, fmt.Errorf("cannot get refresh token: %w", err)
+	}
+
+	return t, nil
+}
+
+func (r *PostgresRefreshTokenRepo) MarkRefreshTokenUsed(ctx context.Context, id int) error {
+	const op = "/internal/repository/postgres/MarkRefreshTokenUsed"
+
+	query := `
+		UPDATE refresh_tokens
+		SET used_at = CURRENT_TIMESTAMP
+		WHERE id = $1;
+	`
+
+	_, err := conn(ctx, r.db).Exec(ctx, query, id)
+	if err != nil {
+		r.logger.Error("cannot mark refresh token used", "op", op, "error", err)
+		return fmt.Errorf("cannot mark refresh token used: %w", err)
+	}
+
+	return nil
+}
+
+func (r *PostgresRefreshTokenRepo) RevokeFamily(ctx context.Context, familyId string) error {
+	const op = "/internal/repository/postgres/RevokeFamily"
+
+	query := `
+		UPDATE refresh_tokens
+		SET revoked_at = CURRENT_TIMESTAMP
+		WHERE family_id = $1 AND revoked_at IS NULL;
+	`
+
+	_, err := conn(ctx, r.db).Exec(ctx, query, familyId)
+	if err != nil {
+		r.logger.Error("cannot revoke refresh tokens", "op", op, "error", err)
+		return fmt.Errorf("cannot revoke refresh tokens: %w", err)
+	}
+
+	return nil
+}
+
+func (r *PostgresRefreshTokenRepo) RevokeUserRefreshTokens(ctx context.Context, userId int) error {
+	const op = "/internal/repository/postgres/RevokeUserRefreshTokens"
+
+	query := `
+		UPDATE refresh_tokens
+		SET revoked_at = CURRENT_TIMESTAMP
+		WHERE user_id = $1 AND revoked_at IS NULL;
+	`
+
+	_, err := conn(ctx, r.db).Exec(ctx, query, userId)
+	if err != nil {
+		r.logger.Error("cannot revoke user refresh tokens", "op", op, "error", err)
+		return fmt.Errorf("cannot revoke user refresh tokens: %w", err)
+	}
+
+	return nil
+}
+
+func (r *PostgresRefreshTokenRepo) DeleteExpiredRefreshTokens(ctx context.Context, before time.Time) (int64, error) {
+	const op = "/internal/repository/postgres/DeleteExpiredRefreshTokens"
+
+	query := `
+		DELETE FROM refresh_tokens
+		WHERE expires_at < $1;
+	`
+
+	tag, err := conn(ctx, r.db).Exec(ctx, query, before)
+	if err != nil {
+		r.logger.Error("cannot delete expired refresh tokens", "op", op, "error", err)
+		return 0, fmt.Errorf("cannot delete expired refresh tokens: %w", err)
+	}
+
+	return tag.RowsAffected(), nil
+}
+
+// RevocationRepo implementation
+type PostgresRevocationRepo struct {
+	db     *pgxpool.Pool
+	logger *slog.Logger
+}
+
+func NewRevocationRepo(db *pgxpool.Pool, logger *slog.Logger) *PostgresRevocationRepo {
+	return &PostgresRevocationRepo{db: db, logger: logger}
+}
+
+func (r *PostgresRevocationRepo) RevokeToken(ctx context.Context, jti string, userId int, expiresAt time.Time) error {
+	const op = "/internal/repository/postgres/RevokeToken"
+
+	query := `
+		INSERT INTO revoked_tokens (jti, user_id, expires_at)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (jti) DO NOTHING;
+	`
+
+	_, err := conn(ctx, r.db).Exec(ctx, query, jti, userId, expiresAt)
+	if err != nil {
+		r.logger.Error("cannot revoke token", "op", op, "error", err)
+		return fmt.Errorf("cannot revoke token: %w", err)
+	}
+
+	return nil
+}
+
+func (r *PostgresRevocationRepo) RevokeUserTokens(ctx context.Context, userId int, before time.Time) error {
+	const op = "/internal/repository/postgres/RevokeUserTokens"
+
+	query := `
+		INSERT INTO user_revocations (user_id, revoked_before)
+		VALUES ($1, $2)
+		ON CONFLICT (user_id) DO UPDATE
+		SET revoked_before = GREATEST(user_revocations.revoked_before, EXCLUDED.revoked_before);
+	`
+
+	_, err := conn(ctx, r.db).Exec(ctx, query, userId, before)
+	if err != nil {
+		r.logger.Error("cannot revoke user tokens", "op", op, "error", err)
+		return fmt.Errorf("cannot revoke user tokens: %w", err)
+	}
+
+	return nil
+}
+
+func (r *PostgresRevocationRepo) IsRevoked(ctx context.Context, jti string, userId int, issuedAt time.Time) (bool, error) {
+	const op = "/internal/repository/postgres/IsRevoked"
+
+	var revoked bool
+
+	query := `
+		SELECT EXISTS (SELECT 1 FROM revoked_tokens WHERE jti = $1)
+			OR EXISTS (SELECT 1 FROM user_revocations WHERE user_id = $2 AND revoked_before > $3);
+	`
+
+	err := conn(ctx, r.db).QueryRow(ctx, query, jti, userId, issuedAt).Scan(&revoked)
+	if err != nil {
+		r.logger.Error("cannot check token revocation", "op", op, "error", err)
+		return false, fmt.Errorf("cannot check token revocation: %w", err)
+	}
+
+	return revoked, nil
+}
+
+func (r *PostgresRevocationRepo) DeleteExpiredRevocations(ctx context.Context, tokensBefore, usersBefore time.Time) (int64, error) {
+	const op = "/internal/repository/postgres/DeleteExpiredRevocations"
+
+	tokens, err := conn(ctx, r.db).Exec(ctx, `DELETE FROM revoked_tokens WHERE expires_at < $1;`, tokensBefore)
+	if err != nil {
+		r.logger.Error("cannot delete expired revocations", "op", op, "error", err)
+		return 0, fmt.Errorf("cannot delete expired revocations: %w", err)
+	}
+
+	users, err := conn(ctx, r.db).Exec(ctx, `DELETE FROM user_revocations WHERE revoked_before < $1;`, usersBefore)
+	if err != nil {
+		r.logger.Error("cannot delete expired revocations", "op", op, "error", err)
+		return 0, fmt.Errorf("cannot delete expired revocations: %w", err)
+	}
+
+	return tokens.RowsAffected() + users.RowsAffected(), nil
+}
+
+// LockoutRepo implementation
+type PostgresLockoutRepo struct {
+	db     *pgxpool.Pool
+	logger *slog.Logger
+}
+
+func NewLockoutRepo(db *pgxpool.Pool, logger *slog.Logger) *PostgresLockoutRepo {
+	return &PostgresLockoutRepo{db: db, logger: logger}
+}
+
+func (r *PostgresLockoutRepo) GetLockout(ctx context.Context, scope lockout.Scope, key string) (lockout.Lockout, error) {
+	const op = "/internal/repository/postgres/GetLockout"
+
+	var l lockout.Lockout
+
+	query := `
+		SELECT scope, key, failures, last_failure, locked_until
+		FROM login_lockouts
+		WHERE scope = $1 AND key = $2;
+	`
+
+	err := conn(ctx, r.db).QueryRow(ctx, query, string(scope), key).Scan(
+		&l.Scope, &l.Key, &l.Failures, &l.LastFailure, &l.LockedUntil,
+	)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return lockout.Lockout{}, lockout.ErrLockoutNotFound
+		}
+		r.logger.Error("cannot get lockout", "op", op, "error", err)
+		return lockout.Lockout{}, fmt.Errorf("cannot get lockout: %w", err)
+	}
+
+	return l, nil
+}
+
+func (r *PostgresLockoutRepo) RecordFailure(
+	ctx context.Context, scope lockout.Scope, key string, at, resetBefore time.Time,
+) (int, error) {
+	const op = "/internal/repository/postgres/RecordFailure"
+
+	var failures int
+
+	query := `
+		INSERT INTO login_lockouts (scope, key, failures, last_failure)
+		VALUES ($1, $2, 1, $3)
+		ON CONFLICT (scope, key) DO UPDATE
+		SET failures = CASE
+				WHEN login_lockouts.last_failure < $4 THEN 1
+				ELSE login_lockouts.failures + 1
+			END,
+			last_failure = EXCLUDED.last_failure
+		RETURNING failures;
+	`
+
+	err := conn(ctx, r.db).QueryRow(ctx, query, string(scope), key, at, resetBefore).Scan(&failures)
+	if err != nil {
+		r.logger.Error("cannot record login failure", "op", op, "error", err)
+		return 0, fmt.Errorf("cannot record login failure: %w", err)
+	}
+
+	return failures, nil
+}
+
+func (r *PostgresLockoutRepo) ForgetFailure(ctx context.Context, scope lockout.Scope, key string) error {
+	const op = "/internal/repository/postgres/ForgetFailure"
+
+	query := `
+		UPDATE login_lockouts
+		SET failures = failures - 1
+		WHERE scope = $1 AND key = $2 AND failures > 0;
+	`
+
+	_, err := conn(ctx, r.db).Exec(ctx, query, string(scope), key)
+	if err != nil {
+		r.logger.Error("cannot forget login failure", "op", op, "error", err)
+		return fmt.Errorf("cannot forget login failure: %w", err)
+	}
+
+	return nil
+}
+
+func (r *PostgresLockoutRepo) LockUntil(ctx context.Context, scope lockout.Scope, key string, until time.Time) error {
+	const op = "/internal/repository/postgres/LockUntil"
+
+	query := `
+		UPDATE login_lockouts
+		SET locked_until = $3
+		WHERE scope = $1 AND key = $2;
+	`
+
+	_, err := conn(ctx, r.db).Exec(ctx, query, string(scope), key, until)
+	if err != nil {
+		r.logger.Error("cannot lock login", "op", op, "error", err)
+		return fmt.Errorf("cannot lock login: %w", err)
+	}
+
+	return nil
+}
+
+func (r *PostgresLockoutRepo) DeleteLockout(ctx context.Context, scope lockout.Scope, key string) error {
+	const op = "/internal/repository/postgres/DeleteLockout"
+
+	query := `
+		DELETE FROM login_lockouts
+		WHERE scope = $1 AND key = $2;
+	`
+
+	tag, err := conn(ctx, r.db).Exec(ctx, query, string(scope), key)
+	if err != nil {
+		r.logger.Error("cannot delete lockout", "op", op, "error", err)
+		return fmt.Errorf("cannot delete lockout: %w", err)
+	}
+
+	if tag.RowsAffected() == 0 {
+		return lockout.ErrLockoutNotFound
+	}
+
+	return nil
+}
+
+func (r *PostgresLockoutRepo) ListLockouts(ctx context.Context, limit int) ([]lockout.Lockout, error) {
+	const op = "/internal/repository/postgres/ListLockouts"
+
+	query := `
+		SELECT scope, key, failures, last_failure, locked_until
+		FROM login_lockouts
+		ORDER BY locked_until DESC NULLS LAST, last_failure DESC
+		LIMIT $1;
+	`
+
+	rows, err := conn(ctx, r.db).Query(ctx, query, limit)
+	if err != nil {
+		r.logger.Error("cannot list lockouts", "op", op, "error", err)
+		return nil, fmt.Errorf("cannot list lockouts: %w", err)
+	}
+	defer rows.Close()
+
+	var lList []lockout.Lockout
+	for rows.Next() {
+		var l lockout.Lockout
+		err = rows.Scan(&l.Scope, &l.Key, &l.Failures, &l.LastFailure, &l.LockedUntil)
+		if err != nil {
+			r.logger.Error("cannot scan lockout", "op", op, "error", err)
+			return nil, fmt.Errorf("cannot scan lockout: %w", err)
+		}
+		lList = append(lList, l)
+	}
+
+	if err = rows.Err(); err != nil {
+		r.logger.Error("cannot list lockouts", "op", op, "error", err)
+		return nil, fmt.Errorf("cannot list lockouts: %w", err)
+	}
+
+	return lList, nil
+}
+
+func (r *PostgresLockoutRepo) DeleteStaleLockouts(ctx context.Context, before time.Time) (int64, error) {
+	const op = "/internal/repository/postgres/DeleteStaleLockouts"
+
+	query := `
+		DELETE FROM login_lockouts
+		WHERE last_failure < $1 AND (locked_until IS NULL OR locked_until < $1);
+	`
+
+	tag, err := conn(ctx, r.db).Exec(ctx, query, before)
+	if err != nil {
+		r.logger.Error("cannot delete stale lockouts", "op", op, "error", err)
+		return 0, fmt.Errorf("cannot delete stale lockouts: %w", err)
+	}
+
+	return tag.RowsAffected(), nil
+}
+
+// KeyRepo implementation
+type PostgresKeyRepo struct {
+	db     *pgxpool.Pool
+	logger *slog.Logger
+}
+
+func NewKeyRepo(db *pgxpool.Pool, logger *slog.Logger) *PostgresKeyRepo {
+	return &PostgresKeyRepo{db: db, logger: logger}
+}
+
+func (r *PostgresKeyRepo) CreateKey(ctx context.Context, key apikey.Key) (apikey.Key, error) {
+	const op = "/internal/repository/postgres/CreateKey"
+
+	query := `
+		INSERT INTO api_keys (user_id, name, prefix, key_hash, scopes)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, created_at;
+	`
+
+	err := conn(ctx, r.db).QueryRow(
+		ctx, query, key.UserId, key.Name, key.Prefix, key.Hash, scopeStrings(key.Scopes),
+	).Scan(&key.Id, &key.CreatedAt)
+	if err != nil {
+		r.logger.Error("cannot create api key", "op", op, "error", err)
+		return apikey.Key{}, fmt.Errorf("cannot create api key: %w", err)
+	}
+
+	return key, nil
+}
+
+func (r *PostgresKeyRepo) ListKeys(ctx context.Context, userId int) ([]apikey.Key, error) {
+	const op = "/internal/repository/postgres/ListKeys"
+
+	query := `
+		SELECT id, user_id, name, prefix, key_hash, scopes, created_at, last_used_at
+		FROM api_keys
+		WHERE user_id = $1 AND revoked_at IS NULL
+		ORDER BY created_at, id;
+	`
+
+	rows, err := conn(ctx, r.db).Query(ctx, query, userId)
+	if err != nil {
+		r.logger.Error("cannot list api keys", "op", op, "error", err)
+		return nil, fmt.Errorf("cannot list api keys: %w", err)
+	}
+	defer rows.Close()
+
+	var kList []apikey.Key
+	for rows.Next() {
+		k, err := scanKey(rows)
+		if err != nil {
+			r.logger.Error("cannot scan api key", "op", op, "error", err)
+			return nil, fmt.Errorf("cannot scan api key: %w", err)
+		}
+		kList = append(kList, k)
+	}
+
+	if err = rows.Err(); err != nil {
+		r.logger.Error("cannot list api keys", "op", op, "error", err)
+		return nil, fmt.Errorf("cannot list api keys: %w", err)
+	}
+
+	return kList, nil
+}
+
+// GetKeyByHash returns the key only while it is not revoked.
+func (r *PostgresKeyRepo) GetKeyByHash(ctx context.Context, hash string) (apikey.Key, error) {
+	const op = "/internal/repository/postgres/GetKeyByHash"
+
+	query := `
+		SELECT id, user_id, name, prefix, key_hash, scopes, created_at, last_used_at
+		FROM api_keys
+		WHERE key_hash = $1 AND revoked_at IS NULL;
+	`
+
+	k, err := scanKey(conn(ctx, r.db).QueryRow(ctx, query, hash))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return apikey.Key{}, apikey.ErrKeyNotFound
+		}
+		r.logger.Error("cannot get api key", "op", op, "error", err)
+		return apikey.Key{}, fmt.Errorf("cannot get api key: %w", err)
+	}
+
+	return k, nil
+}
+
+func (r *PostgresKeyRepo) RevokeKey(ctx context.Context, userId, id int) error {
+	const op = "/internal/repository/postgres/RevokeKey"
+
+	query := `
+		UPDATE api_keys
+		SET revoked_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL;
+	`
+
+	tag, err := conn(ctx, r.db).Exec(ctx, query, id, userId)
+	if err != nil {
+		r.logger.Error("cannot revoke api key", "op", op, "error", err)
+		return fmt.Errorf("cannot revoke api key: %w", err)
+	}
+
+	if tag.RowsAffected() == 0 {
+		return apikey.ErrKeyNotFound
+	}
+
+	return nil
+}
+
+func (r *PostgresKeyRepo) RevokeUserKeys(ctx context.Context, userId int) error {
+	const op = "/internal/repository/postgres/RevokeUserKeys"
+
+	query := `
+		UPDATE api_keys
+		SET revoked_at = CURRENT_TIMESTAMP
+		WHERE user_id = $1 AND revoked_at IS NULL;
+	`
+
+	_, err := conn(ctx, r.db).Exec(ctx, query, userId)
+	if err != nil {
+		r.logger.Error("cannot revoke api keys", "op", op, "error", err)
+		return fmt.Errorf("cannot revoke api keys: %w", err)
+	}
+
+	return nil
+}
+
+func (r *PostgresKeyRepo) TouchKey(ctx context.Context, id int, at time.Time) error {
+	const op = "/internal/repository/postgres/TouchKey"
+
+	query := `
+		UPDATE api_keys
+		SET last_used_at = $2
+		WHERE id = $1;
+	`
+
+	_, err := conn(ctx, r.db).Exec(ctx, query, id, at)
+	if err != nil {
+		r.logger.Error("cannot update api key usage", "op", op, "error", err)
+		return fmt.Errorf("cannot update api key usage: %w", err)
+	}
+
+	return nil
+}
+
+func scanKey(row pgx.Row) (apikey.Key, error) {
+	var k apikey.Key
+	var scopes []string
+
+	err := row.Scan(
+		&k.Id, &k.UserId, &k.Name, &k.Prefix, &k.Hash, &scopes, &k.CreatedAt, &k.LastUsedAt,
+	)
+	if err != nil {
+		return apikey.Key{}, err
+	}
+
+	for _, s := range scopes {
+		k.Scopes = append(k.Scopes, apikey.Scope(s))
+	}
+
+	return k, nil
+}
+
+func scopeStrings(scopes []apikey.Scope) []string {
+	res := make([]string, 0, len(scopes))
+	for _, s := range scopes {
+		res = append(res, string(s))
+	}
+
+	return res
+}
+
+// IdentityRepo implementation
+type PostgresIdentityRepo struct {
+	db     *pgxpool.Pool
+	logger *slog.Logger
+}
+
+func NewIdentityRepo(db *pgxpool.Pool, logger *slog.Logger) *PostgresIdentityRepo {
+	return &PostgresIdentityRepo{db: db, logger: logger}
+}
+
+func (r *PostgresIdentityRepo) GetIdentity(ctx context.Context, issuer, subject string) (identity.Identity, error) {
+	const op = "/internal/repository/postgres/GetIdentity"
+
+	var id identity.Identity
+
+	query := `
+		SELECT issuer, subject, user_id, created_at
+		FROM user_identities
+		WHERE issuer = $1 AND subject = $2;
+	`
+
+	err := conn(ctx, r.db).QueryRow(ctx, query, issuer, subject).Scan(
+		&id.Issuer, &id.Subject, &id.UserId, &id.CreatedAt,
+	)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return identity.Identity{}, identity.ErrIdentityNotFound
+		}
+		r.logger.Error("cannot get identity", "op", op, "error", err)
+		return identity.Identity{}, fmt.Errorf("cannot get identity: %w", err)
+	}
+
+	return id, nil
+}
+
+func (r *PostgresIdentityRepo) CreateIdentity(ctx context.Context, id identity.Identity) error {
+	const op = "/internal/repository/postgres/CreateIdentity"
+
+	query := `
+		INSERT INTO user_identities (issuer, subject, user_id)
+		VALUES ($1, $2, $3);
+	`
+
+	_, err := conn(ctx, r.db).Exec(ctx, query, id.Issuer, id.Subject, id.UserId)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return identity.ErrIdentityExists
+		}
+		r.logger.Error("cannot create identity", "op", op, "error", err)
+		return fmt.Errorf("cannot create identity: %w", err)
+	}
+
+	return nil
+}
+
+// LoginStateRepo implementation
+type PostgresLoginStateRepo struct {
+	db     *pgxpool.Pool
+	logger *slog.Logger
+}
+
+func NewLoginStateRepo(db *pgxpool.Pool, logger *slog.Logger) *PostgresLoginStateRepo {
+	return &PostgresLoginStateRepo{db: db, logger: logger}
+}
+
+func (r *PostgresLoginStateRepo) CreateLoginState(ctx context.Context, s identity.LoginState) error {
+	const op = "/internal/repository/postgres/CreateLoginState"
+
+	query := `
+		INSERT INTO oidc_login_states (state, verifier, nonce, binding_hash, expires_at)
+		VALUES ($1, $2, $3, $4, $5);
+	`
+
+	_, err := conn(ctx, r.db).Exec(ctx, query, s.State, s.Verifier, s.Nonce, s.BindingHash, s.ExpiresAt)
+	if err != nil {
+		r.logger.Error("cannot create login state", "op", op, "error", err)
+		return fmt.Errorf("cannot create login state: %w", err)
+	}
+
+	return nil
+}
+
+func (r *PostgresLoginStateRepo) TakeLoginState(ctx context.Context, state string) (identity.LoginState, error) {
+	const op = "/internal/repository/postgres/TakeLoginState"
+
+	var s identity.LoginState
+
+	query := `
+		DELETE FROM oidc_login_states
+		WHERE state = $1
+		RETURNING state, verifier, nonce, binding_hash, expires_at;
+	`
+
+	err := conn(ctx, r.db).QueryRow(ctx, query, state).Scan(
+		&s.State, &s.Verifier, &s.Nonce, &s.BindingHash, &s.ExpiresAt,
+	)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return identity.LoginState{}, identity.ErrLoginStateNotFound
+		}
+		r.logger.Error("cannot take login state", "op", op, "error", err)
+		return identity.LoginState{}, fmt.Errorf("cannot take login state: %w", err)
+	}
+
+	return s, nil
+}
+
+func (r *PostgresLoginStateRepo) DeleteExpiredLoginStates(ctx context.Context, before time.Time) (int64, error) {
+	const op = "/internal/repository/postgres/DeleteExpiredLoginStates"
+
+	query := `
+		DELETE FROM oidc_login_states
+		WHERE expires_at < $1;
+	`
+
+	tag, err := conn(ctx, r.db).Exec(ctx, query, before)
+	if err != nil {
+		r.logger.Error("cannot delete expired login states", "op", op, "error", err)
+		return 0, fmt.Errorf("cannot delete expired login states: %w", err)
+	}
+
+	return tag.RowsAffected(), nil
+}
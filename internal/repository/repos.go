@@ -0,0 +1,105 @@
+package repository
+
+import (
+	"database/sql"
+	"log/slog"
+
+	"github.com/437d5/merch-store/internal/apikey"
+	"github.com/437d5/merch-store/internal/idempotency"
+	"github.com/437d5/merch-store/internal/identity"
+	"github.com/437d5/merch-store/internal/inventory"
+	"github.com/437d5/merch-store/internal/items"
+	"github.com/437d5/merch-store/internal/ledger"
+	"github.com/437d5/merch-store/internal/lockout"
+	"github.com/437d5/merch-store/internal/purchases"
+	"github.com/437d5/merch-store/internal/session"
+	"github.com/437d5/merch-store/internal/transactions"
+	"github.com/437d5/merch-store/internal/txmanager"
+	"github.com/437d5/merch-store/internal/user"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Repos is the set of repositories of one storage backend together with
+// the TxManager their transactions go through.
+type Repos struct {
+	Users          user.UserRepo
+	PasswordResets user.PasswordResetRepo
+	Items          items.ItemRepo
+	Transactions   transactions.TransactionRepo
+	Purchases      purchases.PurchaseRepo
+	Inventory      inventory.InventoryRepo
+	Ledger         ledger.LedgerRepo
+	Idempotency    idempotency.IdempotencyRepo
+	RefreshTokens  session.RefreshTokenRepo
+	Revocations    session.RevocationRepo
+	Lockouts       lockout.LockoutRepo
+	Keys           apikey.KeyRepo
+	Identities     identity.IdentityRepo
+	LoginStates    identity.LoginStateRepo
+	TxManager      txmanager.TxManager
+}
+
+func NewPostgresRepos(db *pgxpool.Pool, logger *slog.Logger) Repos {
+	return Repos{
+		Users:          NewUserRepo(db, logger),
+		PasswordResets: NewPasswordResetRepo(db, logger),
+		Items:          NewItemRepo(db, logger),
+		Transactions:   NewTransRepo(db, logger),
+		Purchases:      NewPurchaseRepo(db, logger),
+		Inventory:      NewInventoryRepo(db, logger),
+		Ledger:         NewLedgerRepo(db, logger),
+		Idempotency:    NewIdempotencyRepo(db, logger),
+		RefreshTokens:  NewRefreshTokenRepo(db, logger),
+		Revocations:    NewRevocationRepo(db, logger),
+		Lockouts:       NewLockoutRepo(db, logger),
+		Keys:           NewKeyRepo(db, logger),
+		Identities:     NewIdentityRepo(db, logger),
+		LoginStates:    NewLoginStateRepo(db, logger),
+		TxManager:      NewTxManager(db, logger),
+	}
+}
+
+// NewSQLiteRepos returns repos on a database opened with OpenSQLite.
+func NewSQLiteRepos(db *sql.DB, logger *slog.Logger) Repos {
+	return Repos{
+		Users:          NewSQLiteUserRepo(db, logger),
+		PasswordResets: NewSQLitePasswordResetRepo(db, logger),
+		Items:          NewSQLiteItemRepo(db, logger),
+		Transactions:   NewSQLiteTransRepo(db, logger),
+		Purchases:      NewSQLitePurchaseRepo(db, logger),
+		Inventory:      NewSQLiteInventoryRepo(db, logger),
+		Ledger:         NewSQLiteLedgerRepo(db, logger),
+		Idempotency:    NewSQLiteIdempotencyRepo(db, logger),
+		RefreshTokens:  NewSQLiteRefreshTokenRepo(db, logger),
+		Revocations:    NewSQLiteRevocationRepo(db, logger),
+		Lockouts:       NewSQLiteLockoutRepo(db, logger),
+		Keys:           NewSQLiteKeyRepo(db, logger),
+		Identities:     NewSQLiteIdentityRepo(db, logger),
+		LoginStates:    NewSQLiteLoginStateRepo(db, logger),
+		TxManager:      NewSQLiteTxManager(db, logger),
+	}
+}
+
+// NewMemoryRepos returns repos sharing one empty MemoryStore. The data
+// is lost when the process exits.
+func NewMemoryRepos(logger *slog.Logger) Repos {
+	store := NewMemoryStore(logger)
+
+	return Repos{
+		Users:          NewMemoryUserRepo(store, logger),
+		PasswordResets: NewMemoryPasswordResetRepo(store, logger),
+		Items:          NewMemoryItemRepo(store, logger),
+		Transactions:   NewMemoryTransRepo(store, logger),
+		Purchases:      NewMemoryPurchaseRepo(store, logger),
+		Inventory:      NewMemoryInventoryRepo(store, logger),
+		Ledger:         NewMemoryLedgerRepo(store, logger),
+		Idempotency:    NewMemoryIdempotencyRepo(store, logger),
+		RefreshTokens:  NewMemoryRefreshTokenRepo(store, logger),
+		Revocations:    NewMemoryRevocationRepo(store, logger),
+		Lockouts:       NewMemoryLockoutRepo(store, logger),
+		Keys:           NewMemoryKeyRepo(store, logger),
+		Identities:     NewMemoryIdentityRepo(store, logger),
+		LoginStates:    NewMemoryLoginStateRepo(store, logger),
+		TxManager:      store,
+	}
+}
@@ -0,0 +1,100 @@
+package repotest
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/437d5/merch-store/internal/inventory"
+	"github.com/437d5/merch-store/internal/items"
+	"github.com/437d5/merch-store/internal/user"
+)
+
+// RunInventoryRepo checks the inventory.InventoryRepo of the repos
+// returned by open.
+func RunInventoryRepo(t *testing.T, open Opener) {
+	t.Run("increment and decrement", func(t *testing.T) {
+		repos := open(t)
+		ctx := context.Background()
+
+		owner, err := repos.Users.CreateUser(ctx, user.User{Name: unique("user"), Password: "hash"})
+		if err != nil {
+			t.Fatalf("create user: %v", err)
+		}
+		item := items.ItemType{Name: unique("it"), Cost: 1, Available: true}
+		if err = repos.Items.CreateItem(ctx, item); err != nil {
+			t.Fatalf("create item: %v", err)
+		}
+
+		if got, err := repos.Inventory.IncrementItem(ctx, owner, item.Name, 2); err != nil || got != 2 {
+			t.Fatalf("first increment = %d, %v, want 2", got, err)
+		}
+		if got, err := repos.Inventory.IncrementItem(ctx, owner, item.Name, 3); err != nil || got != 5 {
+			t.Fatalf("second increment = %d, %v, want 5", got, err)
+		}
+
+		if _, err = repos.Inventory.DecrementItem(ctx, owner, item.Name, 6); !errors.Is(err, inventory.ErrNotEnoughItems) {
+			t.Errorf("decrement past zero: got %v, want %v", err, inventory.ErrNotEnoughItems)
+		}
+		if got, err := repos.Inventory.DecrementItem(ctx, owner, item.Name, 5); err != nil || got != 0 {
+			t.Fatalf("decrement to zero = %d, %v, want 0", got, err)
+		}
+
+		// items the user no longer owns are not listed
+		inv, err := repos.Inventory.GetInventory(ctx, owner)
+		if err != nil {
+			t.Fatalf("get inventory: %v", err)
+		}
+		if len(inv.Items) != 0 {
+			t.Errorf("inventory = %+v, want empty", inv.Items)
+		}
+
+		missing := unique("none")
+		if _, err = repos.Inventory.IncrementItem(ctx, owner, missing, 1); !errors.Is(err, items.ErrItemNotFound) {
+			t.Errorf("increment unknown item: got %v, want %v", err, items.ErrItemNotFound)
+		}
+		if _, err = repos.Inventory.DecrementItem(ctx, owner, missing, 1); !errors.Is(err, inventory.ErrNotEnoughItems) {
+			t.Errorf("decrement unknown item: got %v, want %v", err, inventory.ErrNotEnoughItems)
+		}
+	})
+
+	t.Run("rename keeps holdings", func(t *testing.T) {
+		repos := open(t)
+		ctx := context.Background()
+
+		owner, err := repos.Users.CreateUser(ctx, user.User{Name: unique("user"), Password: "hash"})
+		if err != nil {
+			t.Fatalf("create user: %v", err)
+		}
+		item := items.ItemType{Name: unique("it"), Cost: 1, Available: true}
+		if err = repos.Items.CreateItem(ctx, item); err != nil {
+			t.Fatalf("create item: %v", err)
+		}
+		if _, err = repos.Inventory.IncrementItem(ctx, owner, item.Name, 3); err != nil {
+			t.Fatalf("increment: %v", err)
+		}
+
+		renamed := item
+		renamed.Name = unique("it")
+		if err = repos.Items.UpdateItem(ctx, item.Name, renamed); err != nil {
+			t.Fatalf("rename: %v", err)
+		}
+
+		inv, err := repos.Inventory.GetInventory(ctx, owner)
+		if err != nil {
+			t.Fatalf("get inventory: %v", err)
+		}
+		want := inventory.Item{ItemType: renamed.Name, Quantity: 3}
+		if len(inv.Items) != 1 || inv.Items[0] != want {
+			t.Errorf("inventory after rename = %+v, want [%+v]", inv.Items, want)
+		}
+
+		// the holdings are found under the new name only
+		if got, err := repos.Inventory.IncrementItem(ctx, owner, renamed.Name, 1); err != nil || got != 4 {
+			t.Errorf("increment renamed = %d, %v, want 4", got, err)
+		}
+		if _, err = repos.Inventory.DecrementItem(ctx, owner, item.Name, 1); !errors.Is(err, inventory.ErrNotEnoughItems) {
+			t.Errorf("decrement old name: got %v, want %v", err, inventory.ErrNotEnoughItems)
+		}
+	})
+}
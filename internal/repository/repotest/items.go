@@ -0,0 +1,207 @@
+package repotest
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/437d5/merch-store/internal/items"
+	"github.com/437d5/merch-store/internal/user"
+)
+
+// RunItemRepo checks the items.ItemRepo of the repos returned by open.
+func RunItemRepo(t *testing.T, open Opener) {
+	t.Run("create and get", func(t *testing.T) {
+		repos := open(t)
+		ctx := context.Background()
+
+		item := items.ItemType{Name: unique("it"), Cost: 42, Available: true}
+		if err := repos.Items.CreateItem(ctx, item); err != nil {
+			t.Fatalf("create: %v", err)
+		}
+
+		got, err := repos.Items.GetItemByName(ctx, item.Name)
+		if err != nil {
+			t.Fatalf("get: %v", err)
+		}
+		if got != item {
+			t.Errorf("get = %+v, want %+v", got, item)
+		}
+
+		renamed := items.ItemType{Name: unique("it"), Cost: 7, Available: false}
+		if err = repos.Items.UpdateItem(ctx, item.Name, renamed); err != nil {
+			t.Fatalf("update: %v", err)
+		}
+
+		got, err = repos.Items.GetItemByName(ctx, renamed.Name)
+		if err != nil {
+			t.Fatalf("get renamed: %v", err)
+		}
+		if got != renamed {
+			t.Errorf("get renamed = %+v, want %+v", got, renamed)
+		}
+
+		if _, err = repos.Items.GetItemByName(ctx, item.Name); !errors.Is(err, items.ErrItemNotFound) {
+			t.Errorf("get old name: got %v, want %v", err, items.ErrItemNotFound)
+		}
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		repos := open(t)
+		ctx := context.Background()
+		name := unique("none")
+
+		errs := map[string]error{
+			"GetItemByName": func() error {
+				_, err := repos.Items.GetItemByName(ctx, name)
+				return err
+			}(),
+			"GetItemByNameForUpdate": repos.TxManager.WithinTx(ctx, func(ctx context.Context) error {
+				_, err := repos.Items.GetItemByNameForUpdate(ctx, name)
+				return err
+			}),
+			"GetItemByNameForShare": repos.TxManager.WithinTx(ctx, func(ctx context.Context) error {
+				_, err := repos.Items.GetItemByNameForShare(ctx, name)
+				return err
+			}),
+			"UpdateItem": repos.Items.UpdateItem(ctx, name, items.ItemType{Name: name, Cost: 1}),
+		}
+
+		for op, err := range errs {
+			if !errors.Is(err, items.ErrItemNotFound) {
+				t.Errorf("%s: got %v, want %v", op, err, items.ErrItemNotFound)
+			}
+		}
+	})
+
+	t.Run("unique name", func(t *testing.T) {
+		repos := open(t)
+		ctx := context.Background()
+
+		first := items.ItemType{Name: unique("it"), Cost: 1}
+		second := items.ItemType{Name: unique("it"), Cost: 2}
+		for _, item := range []items.ItemType{first, second} {
+			if err := repos.Items.CreateItem(ctx, item); err != nil {
+				t.Fatalf("create %s: %v", item.Name, err)
+			}
+		}
+
+		if err := repos.Items.CreateItem(ctx, first); !errors.Is(err, items.ErrItemExists) {
+			t.Errorf("create duplicate: got %v, want %v", err, items.ErrItemExists)
+		}
+
+		err := repos.Items.UpdateItem(ctx, second.Name, items.ItemType{Name: first.Name, Cost: 2})
+		if !errors.Is(err, items.ErrItemExists) {
+			t.Errorf("rename onto an item: got %v, want %v", err, items.ErrItemExists)
+		}
+
+		// a failed rename leaves the item as it was
+		got, err := repos.Items.GetItemByName(ctx, second.Name)
+		if err != nil || got != second {
+			t.Errorf("after failed rename = %+v, %v, want %+v", got, err, second)
+		}
+	})
+
+	t.Run("ordering", func(t *testing.T) {
+		repos := open(t)
+		ctx := context.Background()
+
+		// names differing in one letter sort the same under any
+		// collation, other rows may be interleaved
+		suffix := unique("")
+		names := []string{"ia" + suffix, "ib" + suffix, "ic" + suffix}
+		for _, i := range []int{1, 2, 0} {
+			if err := repos.Items.CreateItem(ctx, items.ItemType{Name: names[i], Cost: 1}); err != nil {
+				t.Fatalf("create %s: %v", names[i], err)
+			}
+		}
+
+		list, err := repos.Items.ListItems(ctx)
+		if err != nil {
+			t.Fatalf("list: %v", err)
+		}
+
+		var got []string
+		for _, item := range list {
+			if slices.Contains(names, item.Name) {
+				got = append(got, item.Name)
+			}
+		}
+		if !slices.Equal(got, names) {
+			t.Errorf("list order = %v, want %v", got, names)
+		}
+
+		actor, err := repos.Users.CreateUser(ctx, user.User{Name: unique("actor"), Password: "hash"})
+		if err != nil {
+			t.Fatalf("create actor: %v", err)
+		}
+
+		for _, name := range names {
+			err := repos.Items.RecordChange(ctx, items.Change{
+				ActorId:  actor,
+				Action:   items.ActionCreate,
+				ItemName: name,
+				After:    &items.ItemType{Name: name, Cost: 1},
+			})
+			if err != nil {
+				t.Fatalf("record change: %v", err)
+			}
+		}
+
+		changes, err := repos.Items.ListChanges(ctx, len(names))
+		if err != nil {
+			t.Fatalf("list changes: %v", err)
+		}
+
+		got = got[:0]
+		for _, c := range changes {
+			got = append(got, c.ItemName)
+			if c.ActorId != actor || c.Before != nil || c.After == nil || c.After.Name != c.ItemName {
+				t.Errorf("change = %+v", c)
+			}
+		}
+		if want := []string{names[2], names[1], names[0]}; !slices.Equal(got, want) {
+			t.Errorf("changes = %v, want newest first %v", got, want)
+		}
+	})
+
+	t.Run("concurrent updates", func(t *testing.T) {
+		repos := open(t)
+		ctx := context.Background()
+
+		name := unique("it")
+		if err := repos.Items.CreateItem(ctx, items.ItemType{Name: name, Cost: 1}); err != nil {
+			t.Fatalf("create: %v", err)
+		}
+
+		// GetItemByNameForUpdate must keep the read-modify-write cycles
+		// apart, a lost update keeps the cost low
+		errs := concurrently(workers, func(int) error {
+			return repos.TxManager.WithinTx(ctx, func(ctx context.Context) error {
+				item, err := repos.Items.GetItemByNameForUpdate(ctx, name)
+				if err != nil {
+					return err
+				}
+				// let the other workers reach their read meanwhile
+				time.Sleep(raceWindow)
+				item.Cost++
+				return repos.Items.UpdateItem(ctx, name, item)
+			})
+		})
+		for _, err := range errs {
+			if err != nil {
+				t.Fatalf("update: %v", err)
+			}
+		}
+
+		got, err := repos.Items.GetItemByName(ctx, name)
+		if err != nil {
+			t.Fatalf("get: %v", err)
+		}
+		if got.Cost != 1+workers {
+			t.Errorf("cost = %d, want %d", got.Cost, 1+workers)
+		}
+	})
+}
@@ -0,0 +1,62 @@
+// Package repotest is the conformance suite of the repository
+// implementations. Every storage backend runs it from its own tests, so
+// the backends agree on sentinel errors, uniqueness, ordering and on how
+// concurrent updates inside a transaction are serialized.
+package repotest
+
+import (
+	"math/rand/v2"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/437d5/merch-store/internal/repository"
+)
+
+// missingId is an id no row has
+const missingId = 1 << 30
+
+// workers is the number of goroutines in the concurrency tests
+const workers = 8
+
+// raceWindow is how long the concurrency tests hold a row between
+// reading and writing it
+const raceWindow = 5 * time.Millisecond
+
+// Opener returns the repos of the backend under test. The repos may
+// share a database with earlier calls, the suite relies only on the rows
+// it creates itself.
+type Opener func(t *testing.T) repository.Repos
+
+// Run runs the whole suite against the repos returned by open.
+func Run(t *testing.T, open Opener) {
+	t.Run("UserRepo", func(t *testing.T) { RunUserRepo(t, open) })
+	t.Run("ItemRepo", func(t *testing.T) { RunItemRepo(t, open) })
+	t.Run("InventoryRepo", func(t *testing.T) { RunInventoryRepo(t, open) })
+	t.Run("TransactionRepo", func(t *testing.T) { RunTransactionRepo(t, open) })
+}
+
+// unique returns prefix with a random suffix of at most 6 characters.
+// Prefixes of item names must not be longer than 4 characters.
+func unique(prefix string) string {
+	return prefix + strconv.FormatInt(rand.Int64N(1<<31), 36)
+}
+
+// concurrently calls fn from n goroutines and returns their errors, the
+// i-th error is returned by the call with i.
+func concurrently(n int, fn func(i int) error) []error {
+	errs := make([]error, n)
+
+	var wg sync.WaitGroup
+	for i := range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = fn(i)
+		}()
+	}
+	wg.Wait()
+
+	return errs
+}
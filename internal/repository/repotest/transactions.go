@@ -0,0 +1,210 @@
+package repotest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+
+	"github.com/437d5/merch-store/internal/repository"
+	"github.com/437d5/merch-store/internal/transactions"
+	"github.com/437d5/merch-store/internal/user"
+)
+
+// RunTransactionRepo checks the transactions.TransactionRepo of the
+// repos returned by open.
+func RunTransactionRepo(t *testing.T, open Opener) {
+	t.Run("not found", func(t *testing.T) {
+		repos := open(t)
+		ctx := context.Background()
+		alice := createUsers(t, repos, 1)[0]
+
+		for _, tr := range []transactions.Transaction{
+			{FromUser: alice, ToUser: missingId, Amount: 1},
+			{FromUser: missingId, ToUser: alice, Amount: 1},
+		} {
+			if err := repos.Transactions.CreateTransaction(ctx, tr); !errors.Is(err, user.ErrUserNotFound) {
+				t.Errorf("create %d -> %d: got %v, want %v", tr.FromUser, tr.ToUser, err, user.ErrUserNotFound)
+			}
+		}
+
+		list, err := repos.Transactions.ListTransactions(ctx, transactions.Filter{
+			UserId: alice, Counterparty: unique("none"), Limit: 10,
+		})
+		if err != nil || len(list) != 0 {
+			t.Errorf("unknown counterparty = %v, %v, want no transactions", amounts(list), err)
+		}
+	})
+
+	t.Run("invalid amount", func(t *testing.T) {
+		repos := open(t)
+		ctx := context.Background()
+		ids := createUsers(t, repos, 2)
+
+		for _, amount := range []int{0, -1} {
+			tr := transactions.Transaction{FromUser: ids[0], ToUser: ids[1], Amount: amount}
+			if err := repos.Transactions.CreateTransaction(ctx, tr); err == nil {
+				t.Errorf("create with amount %d succeeded", amount)
+			}
+		}
+	})
+
+	t.Run("ordering", func(t *testing.T) {
+		repos := open(t)
+		ctx := context.Background()
+		ids := createUsers(t, repos, 3)
+		alice, bob, carol := ids[0], ids[1], ids[2]
+
+		for _, tr := range []transactions.Transaction{
+			{FromUser: alice, ToUser: bob, Amount: 10},
+			{FromUser: bob, ToUser: alice, Amount: 20},
+			{FromUser: alice, ToUser: carol, Amount: 30},
+			{FromUser: bob, ToUser: carol, Amount: 40},
+		} {
+			if err := repos.Transactions.CreateTransaction(ctx, tr); err != nil {
+				t.Fatalf("create: %v", err)
+			}
+		}
+
+		list, err := repos.Transactions.ListTransactions(ctx, transactions.Filter{UserId: alice, Limit: 10})
+		if err != nil {
+			t.Fatalf("list: %v", err)
+		}
+		if got := amounts(list); !slices.Equal(got, []int{30, 20, 10}) {
+			t.Errorf("amounts = %v, want newest first [30 20 10]", got)
+		}
+		if len(list) == 3 && (list[1].FromUser != bob || list[1].ToUser != alice ||
+			list[1].FromUsername == "" || list[1].ToUsername == "") {
+			t.Errorf("second transaction = %+v", list[1])
+		}
+
+		// pages follow each other without gaps or repeats
+		var paged []transactions.Transaction
+		f := transactions.Filter{UserId: alice, Limit: 2}
+		for range 3 {
+			page, err := repos.Transactions.ListTransactions(ctx, f)
+			if err != nil {
+				t.Fatalf("page: %v", err)
+			}
+			paged = append(paged, page...)
+			if len(page) < f.Limit {
+				break
+			}
+
+			after, err := transactions.DecodeCursor(transactions.EncodeCursor(page[len(page)-1]))
+			if err != nil {
+				t.Fatalf("decode cursor: %v", err)
+			}
+			f.After = &after
+		}
+		if got := amounts(paged); !slices.Equal(got, []int{30, 20, 10}) {
+			t.Errorf("paged amounts = %v, want [30 20 10]", got)
+		}
+
+		filters := []struct {
+			name string
+			f    transactions.Filter
+			want []int
+		}{
+			{"sent", transactions.Filter{Direction: transactions.DirectionSent}, []int{30, 10}},
+			{"received", transactions.Filter{Direction: transactions.DirectionReceived}, []int{20}},
+			{"min amount", transactions.Filter{MinAmount: 20}, []int{30, 20}},
+			{"max amount", transactions.Filter{MaxAmount: 20}, []int{20, 10}},
+		}
+		for _, tt := range filters {
+			tt.f.UserId, tt.f.Limit = alice, 10
+			list, err := repos.Transactions.ListTransactions(ctx, tt.f)
+			if err != nil {
+				t.Fatalf("%s: %v", tt.name, err)
+			}
+			if got := amounts(list); !slices.Equal(got, tt.want) {
+				t.Errorf("%s: amounts = %v, want %v", tt.name, got, tt.want)
+			}
+		}
+
+		bobUser, err := repos.Users.GetUserByID(ctx, bob)
+		if err != nil {
+			t.Fatalf("get bob: %v", err)
+		}
+		list, err = repos.Transactions.ListTransactions(ctx, transactions.Filter{
+			UserId: alice, Counterparty: bobUser.Name, Limit: 10,
+		})
+		if err != nil {
+			t.Fatalf("counterparty: %v", err)
+		}
+		if got := amounts(list); !slices.Equal(got, []int{20, 10}) {
+			t.Errorf("counterparty: amounts = %v, want [20 10]", got)
+		}
+	})
+
+	t.Run("concurrent creates", func(t *testing.T) {
+		repos := open(t)
+		ctx := context.Background()
+		ids := createUsers(t, repos, 2)
+
+		errs := concurrently(workers, func(i int) error {
+			return repos.Transactions.CreateTransaction(ctx, transactions.Transaction{
+				FromUser: ids[0], ToUser: ids[1], Amount: i + 1,
+			})
+		})
+		for _, err := range errs {
+			if err != nil {
+				t.Fatalf("create: %v", err)
+			}
+		}
+
+		list, err := repos.Transactions.ListTransactions(ctx, transactions.Filter{UserId: ids[0], Limit: 2 * workers})
+		if err != nil {
+			t.Fatalf("list: %v", err)
+		}
+		if len(list) != workers {
+			t.Fatalf("listed %d transactions, want %d", len(list), workers)
+		}
+
+		got := amounts(list)
+		slices.Sort(got)
+		for i, amount := range got {
+			if amount != i+1 {
+				t.Errorf("amounts = %v, want 1 to %d once each", got, workers)
+				break
+			}
+		}
+
+		newestFirst := slices.IsSortedFunc(list, func(a, b transactions.Transaction) int {
+			if c := b.Timestamp.Compare(a.Timestamp); c != 0 {
+				return c
+			}
+			return b.Id - a.Id
+		})
+		if !newestFirst {
+			t.Error("transactions are not ordered by timestamp and id, newest first")
+		}
+	})
+}
+
+// createUsers creates n users with unique names and returns their ids.
+func createUsers(t *testing.T, repos repository.Repos, n int) []int {
+	t.Helper()
+
+	ids := make([]int, n)
+	for i := range ids {
+		id, err := repos.Users.CreateUser(context.Background(), user.User{
+			Name: unique(fmt.Sprint("tx", i)), Password: "hash",
+		})
+		if err != nil {
+			t.Fatalf("create user: %v", err)
+		}
+		ids[i] = id
+	}
+
+	return ids
+}
+
+func amounts(list []transactions.Transaction) []int {
+	var res []int
+	for _, t := range list {
+		res = append(res, t.Amount)
+	}
+	return res
+}
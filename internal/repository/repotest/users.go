@@ -0,0 +1,158 @@
+package repotest
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/437d5/merch-store/internal/user"
+)
+
+// RunUserRepo checks the user.UserRepo of the repos returned by open.
+func RunUserRepo(t *testing.T, open Opener) {
+	t.Run("create and get", func(t *testing.T) {
+		repos := open(t)
+		ctx := context.Background()
+
+		name := unique("user")
+		id, err := repos.Users.CreateUser(ctx, user.User{Name: name, Password: "hash"})
+		if err != nil {
+			t.Fatalf("create: %v", err)
+		}
+
+		byId, err := repos.Users.GetUserByID(ctx, id)
+		if err != nil {
+			t.Fatalf("get by id: %v", err)
+		}
+		want := user.User{Id: id, Name: name, Password: "hash", Role: user.RoleEmployee}
+		if byId != want {
+			t.Errorf("get by id = %+v, want %+v", byId, want)
+		}
+
+		byName, err := repos.Users.GetUserByName(ctx, name)
+		if err != nil {
+			t.Fatalf("get by name: %v", err)
+		}
+		if byName != want {
+			t.Errorf("get by name = %+v, want %+v", byName, want)
+		}
+
+		if err = repos.Users.UpdatePassword(ctx, id, "new hash"); err != nil {
+			t.Fatalf("update password: %v", err)
+		}
+		if err = repos.Users.UpdateRole(ctx, name, user.RoleAdmin); err != nil {
+			t.Fatalf("update role: %v", err)
+		}
+
+		got, err := repos.Users.GetUserByID(ctx, id)
+		if err != nil {
+			t.Fatalf("get after updates: %v", err)
+		}
+		if got.Password != "new hash" || got.Role != user.RoleAdmin {
+			t.Errorf("after updates = %+v", got)
+		}
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		repos := open(t)
+		ctx := context.Background()
+		name := unique("none")
+
+		errs := map[string]error{
+			"GetUserByID": func() error {
+				_, err := repos.Users.GetUserByID(ctx, missingId)
+				return err
+			}(),
+			"GetUserByIDForUpdate": repos.TxManager.WithinTx(ctx, func(ctx context.Context) error {
+				_, err := repos.Users.GetUserByIDForUpdate(ctx, missingId)
+				return err
+			}),
+			"GetUserByName": func() error {
+				_, err := repos.Users.GetUserByName(ctx, name)
+				return err
+			}(),
+			"UpdateRole":     repos.Users.UpdateRole(ctx, name, user.RoleAdmin),
+			"UpdatePassword": repos.Users.UpdatePassword(ctx, missingId, "hash"),
+		}
+
+		for op, err := range errs {
+			if !errors.Is(err, user.ErrUserNotFound) {
+				t.Errorf("%s: got %v, want %v", op, err, user.ErrUserNotFound)
+			}
+		}
+	})
+
+	t.Run("unique name", func(t *testing.T) {
+		repos := open(t)
+		ctx := context.Background()
+
+		name := unique("user")
+		if _, err := repos.Users.CreateUser(ctx, user.User{Name: name, Password: "hash"}); err != nil {
+			t.Fatalf("create: %v", err)
+		}
+
+		_, err := repos.Users.CreateUser(ctx, user.User{Name: name, Password: "other"})
+		if !errors.Is(err, user.ErrUserExists) {
+			t.Errorf("create duplicate: got %v, want %v", err, user.ErrUserExists)
+		}
+
+		// racing registrations of one name, exactly one of them wins
+		name = unique("user")
+		errs := concurrently(workers, func(int) error {
+			_, err := repos.Users.CreateUser(ctx, user.User{Name: name, Password: "hash"})
+			return err
+		})
+
+		created := 0
+		for _, err := range errs {
+			switch {
+			case err == nil:
+				created++
+			case !errors.Is(err, user.ErrUserExists):
+				t.Errorf("concurrent create: got %v, want nil or %v", err, user.ErrUserExists)
+			}
+		}
+		if created != 1 {
+			t.Errorf("%d concurrent creates succeeded, want 1", created)
+		}
+	})
+
+	t.Run("concurrent updates", func(t *testing.T) {
+		repos := open(t)
+		ctx := context.Background()
+
+		id, err := repos.Users.CreateUser(ctx, user.User{Name: unique("user"), Password: "hash"})
+		if err != nil {
+			t.Fatalf("create: %v", err)
+		}
+
+		// GetUserByIDForUpdate must keep the read-modify-write cycles
+		// apart, a lost update drops a character
+		errs := concurrently(workers, func(int) error {
+			return repos.TxManager.WithinTx(ctx, func(ctx context.Context) error {
+				u, err := repos.Users.GetUserByIDForUpdate(ctx, id)
+				if err != nil {
+					return err
+				}
+				// let the other workers reach their read meanwhile
+				time.Sleep(raceWindow)
+				return repos.Users.UpdatePassword(ctx, id, u.Password+"+")
+			})
+		})
+		for _, err := range errs {
+			if err != nil {
+				t.Fatalf("update: %v", err)
+			}
+		}
+
+		got, err := repos.Users.GetUserByID(ctx, id)
+		if err != nil {
+			t.Fatalf("get: %v", err)
+		}
+		if want := "hash" + strings.Repeat("+", workers); got.Password != want {
+			t.Errorf("password = %q, want %q", got.Password, want)
+		}
+	})
+}
@@ -0,0 +1,1822 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/437d5/merch-store/internal/apikey"
+	"github.com/437d5/merch-store/internal/idempotency"
+	"github.com/437d5/merch-store/internal/identity"
+	"github.com/437d5/merch-store/internal/inventory"
+	"github.com/437d5/merch-store/internal/items"
+	"github.com/437d5/merch-store/internal/ledger"
+	"github.com/437d5/merch-store/internal/lockout"
+	"github.com/437d5/merch-store/internal/purchases"
+	"github.com/437d5/merch-store/internal/session"
+	"github.com/437d5/merch-store/internal/transactions"
+	"github.com/437d5/merch-store/internal/user"
+)
+
+// UserRepo implementation
+type SQLiteUserRepo struct {
+	db     *sql.DB
+	logger *slog.Logger
+}
+
+func NewSQLiteUserRepo(db *sql.DB, logger *slog.Logger) *SQLiteUserRepo {
+	return &SQLiteUserRepo{db: db, logger: logger}
+}
+
+func (r *SQLiteUserRepo) GetUserByID(ctx context.Context, id int) (user.User, error) {
+	const op = "/internal/repository/sqlite/GetUserByID"
+
+	var u user.User
+
+	query := `
+		SELECT u.id, u.name, u.password, u.role, COALESCE(a.balance, 0)
+		FROM users u
+		LEFT JOIN accounts a ON a.user_id = u.id
+		WHERE u.id = ?;
+	`
+
+	err := sqliteConn(ctx, r.db).QueryRowContext(ctx, query, id).Scan(
+		&u.Id, &u.Name, &u.Password, &u.Role, &u.Coins,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			r.logger.Warn("user not found", "op", op, "userId", id)
+			return user.User{}, fmt.Errorf("%w: %d", user.ErrUserNotFound, id)
+		}
+		r.logger.Error("cannot get user", "op", op, "error", err)
+		return user.User{}, fmt.Errorf("cannot get user: %w", err)
+	}
+
+	return u, nil
+}
+
+// GetUserByIDForUpdate needs no lock of its own, transactions hold the
+// write lock of the whole database from their start.
+func (r *SQLiteUserRepo) GetUserByIDForUpdate(ctx context.Context, id int) (user.User, error) {
+	return r.GetUserByID(ctx, id)
+}
+
+func (r *SQLiteUserRepo) GetUserByName(ctx context.Context, name string) (user.User, error) {
+	const op = "/internal/repository/sqlite/GetUserByName"
+
+	var u user.User
+
+	query := `
+		SELECT u.id, u.name, u.password, u.role, COALESCE(a.balance, 0)
+		FROM users u
+		LEFT JOIN accounts a ON a.user_id = u.id
+		WHERE u.name = ?;
+	`
+
+	err := sqliteConn(ctx, r.db).QueryRowContext(ctx, query, name).Scan(
+		&u.Id, &u.Name, &u.Password, &u.Role, &u.Coins,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			r.logger.Warn("user not found", "op", op, "name", name)
+			return user.User{}, fmt.Errorf("%w: %s", user.ErrUserNotFound, name)
+		}
+		r.logger.Error("cannot get user", "op", op, "error", err)
+		return user.User{}, fmt.Errorf("cannot get user: %w", err)
+	}
+
+	return u, nil
+}
+
+func (r *SQLiteUserRepo) CreateUser(ctx context.Context, u user.User) (int, error) {
+	const op = "/internal/repository/sqlite/CreateUser"
+
+	var id int
+	query := `
+		INSERT INTO users (name, password, role)
+		VALUES (?, ?, COALESCE(NULLIF(?, ''), 'employee'))
+		RETURNING id;
+	`
+
+	err := sqliteConn(ctx, r.db).QueryRowContext(
+		ctx, query, u.Name, u.Password, string(u.Role),
+	).Scan(&id)
+	if err != nil {
+		if isSQLiteUniqueViolation(err) {
+			r.logger.Warn("user already exists", "op", op, "name", u.Name)
+			return 0, fmt.Errorf("%w: %s", user.ErrUserExists, u.Name)
+		}
+		r.logger.Error("cannot create user", "op", op, "error", err)
+		return 0, fmt.Errorf("cannot create user: %w", err)
+	}
+
+	return id, nil
+}
+
+func (r *SQLiteUserRepo) UpdateRole(ctx context.Context, name string, role user.Role) error {
+	const op = "/internal/repository/sqlite/UpdateRole"
+
+	query := `
+		UPDATE users
+		SET role = ?
+		WHERE name = ?;
+	`
+
+	res, err := sqliteConn(ctx, r.db).ExecContext(ctx, query, string(role), name)
+	if err != nil {
+		r.logger.Error("cannot update role", "op", op, "error", err)
+		return fmt.Errorf("cannot update role: %w", err)
+	}
+
+	if n, _ := res.RowsAffected(); n == 0 {
+		r.logger.Warn("user not found", "op", op, "name", name)
+		return fmt.Errorf("%w: %s", user.ErrUserNotFound, name)
+	}
+
+	return nil
+}
+
+// UpdatePassword stores the password hash of the user.
+func (r *SQLiteUserRepo) UpdatePassword(ctx context.Context, id int, password string) error {
+	const op = "/internal/repository/sqlite/UpdatePassword"
+
+	query := `
+		UPDATE users
+		SET password = ?
+		WHERE id = ?;
+	`
+
+	res, err := sqliteConn(ctx, r.db).ExecContext(ctx, query, password, id)
+	if err != nil {
+		r.logger.Error("cannot update password", "op", op, "error", err)
+		return fmt.Errorf("cannot update password: %w", err)
+	}
+
+	if n, _ := res.RowsAffected(); n == 0 {
+		r.logger.Warn("user not found", "op", op, "userId", id)
+		return fmt.Errorf("%w: %d", user.ErrUserNotFound, id)
+	}
+
+	return nil
+}
+
+// InventoryRepo implementation
+type SQLiteInventoryRepo struct {
+	db     *sql.DB
+	logger *slog.Logger
+}
+
+func NewSQLiteInventoryRepo(db *sql.DB, logger *slog.Logger) *SQLiteInventoryRepo {
+	return &SQLiteInventoryRepo{db: db, logger: logger}
+}
+
+func (r *SQLiteInventoryRepo) GetInventory(ctx context.Context, userId int) (inventory.Inventory, error) {
+	const op = "/internal/repository/sqlite/GetInventory"
+
+	query := `
+		SELECT i.name, ui.quantity
+		FROM user_items ui
+		JOIN items i ON i.id = ui.item_id
+		WHERE ui.user_id = ?
+		ORDER BY i.name;
+	`
+
+	rows, err := sqliteConn(ctx, r.db).QueryContext(ctx, query, userId)
+	if err != nil {
+		r.logger.Error("cannot get inventory", "op", op, "error", err)
+		return inventory.Inventory{}, fmt.Errorf("cannot get inventory: %w", err)
+	}
+	defer rows.Close()
+
+	var inv inventory.Inventory
+	for rows.Next() {
+		var item inventory.Item
+		if err = rows.Scan(&item.ItemType, &item.Quantity); err != nil {
+			r.logger.Error("cannot scan inventory", "op", op, "error", err)
+			return inventory.Inventory{}, fmt.Errorf("cannot scan inventory: %w", err)
+		}
+		inv.Items = append(inv.Items, item)
+	}
+
+	if err = rows.Err(); err != nil {
+		r.logger.Error("cannot read inventory", "op", op, "error", err)
+		return inventory.Inventory{}, fmt.Errorf("cannot read inventory: %w", err)
+	}
+
+	return inv, nil
+}
+
+func (r *SQLiteInventoryRepo) IncrementItem(
+	ctx context.Context, userId int, itemType string, quantity int,
+) (int, error) {
+	const op = "/internal/repository/sqlite/IncrementItem"
+
+	query := `
+		INSERT INTO user_items (user_id, item_id, quantity)
+		SELECT ?1, id, ?3
+		FROM items
+		WHERE name = ?2
+		ON CONFLICT (user_id, item_id)
+		DO UPDATE SET quantity = user_items.quantity + excluded.quantity
+		RETURNING quantity;
+	`
+
+	var total int
+	err := sqliteConn(ctx, r.db).QueryRowContext(ctx, query, userId, itemType, quantity).Scan(&total)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			r.logger.Warn("item not found", "op", op, "name", itemType)
+			return 0, fmt.Errorf("%w: %s", items.ErrItemNotFound, itemType)
+		}
+		r.logger.Error("cannot increment item", "op", op, "error", err)
+		return 0, fmt.Errorf("cannot increment item: %w", err)
+	}
+
+	return total, nil
+}
+
+// DecrementItem removes the row once the quantity drops to zero, so
+// inventories only list items the user owns.
+func (r *SQLiteInventoryRepo) DecrementItem(
+	ctx context.Context, userId int, itemType string, quantity int,
+) (int, error) {
+	const op = "/internal/repository/sqlite/DecrementItem"
+
+	query := `
+		UPDATE user_items
+		SET quantity = quantity - ?3
+		WHERE user_id = ?1
+			AND item_id = (SELECT id FROM items WHERE name = ?2)
+			AND quantity >= ?3
+		RETURNING item_id, quantity;
+	`
+
+	var itemId, total int
+	err := sqliteConn(ctx, r.db).QueryRowContext(ctx, query, userId, itemType, quantity).Scan(&itemId, &total)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, inventory.ErrNotEnoughItems
+		}
+		r.logger.Error("cannot decrement item", "op", op, "error", err)
+		return 0, fmt.Errorf("cannot decrement item: %w", err)
+	}
+
+	if total == 0 {
+		query = `
+			DELETE FROM user_items
+			WHERE user_id = ? AND item_id = ? AND quantity = 0;
+		`
+
+		if _, err = sqliteConn(ctx, r.db).ExecContext(ctx, query, userId, itemId); err != nil {
+			r.logger.Error("cannot delete item", "op", op, "error", err)
+			return 0, fmt.Errorf("cannot delete item: %w", err)
+		}
+	}
+
+	return total, nil
+}
+
+// PasswordResetRepo implementation
+type SQLitePasswordResetRepo struct {
+	db     *sql.DB
+	logger *slog.Logger
+}
+
+func NewSQLitePasswordResetRepo(db *sql.DB, logger *slog.Logger) *SQLitePasswordResetRepo {
+	return &SQLitePasswordResetRepo{db: db, logger: logger}
+}
+
+func (r *SQLitePasswordResetRepo) CreatePasswordReset(ctx context.Context, reset user.PasswordReset) error {
+	const op = "/internal/repository/sqlite/CreatePasswordReset"
+
+	query := `
+		INSERT INTO password_resets (user_id, token_hash, created_by, expires_at, created_at)
+		VALUES (?, ?, NULLIF(?, 0), ?, ?);
+	`
+
+	_, err := sqliteConn(ctx, r.db).ExecContext(
+		ctx, query, reset.UserId, reset.Hash, reset.CreatedBy, reset.ExpiresAt.UTC(), sqliteNow(),
+	)
+	if err != nil {
+		r.logger.Error("cannot create password reset", "op", op, "error", err)
+		return fmt.Errorf("cannot create password reset: %w", err)
+	}
+
+	return nil
+}
+
+// GetPasswordResetForUpdate needs no lock of its own, transactions hold
+// the write lock of the whole database from their start.
+func (r *SQLitePasswordResetRepo) GetPasswordResetForUpdate(ctx context.Context, hash string) (user.PasswordReset, error) {
+	const op = "/internal/repository/sqlite/GetPasswordResetForUpdate"
+
+	var reset user.PasswordReset
+
+	query := `
+		SELECT id, user_id, token_hash, COALESCE(created_by, 0), expires_at, created_at, used_at
+		FROM password_resets
+		WHERE token_hash = ?;
+	`
+
+	err := sqliteConn(ctx, r.db).QueryRowContext(ctx, query, hash).Scan(
+		&reset.Id, &reset.UserId, &reset.Hash, &reset.CreatedBy,
+		&reset.ExpiresAt, &reset.CreatedAt, &reset.UsedAt,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return user.PasswordReset{}, user.ErrResetTokenNotFound
+		}
+		r.logger.Error("cannot get password reset", "op", op, "error", err)
+		return user.PasswordReset{}, fmt.Errorf("cannot get password reset: %w", err)
+	}
+
+	return reset, nil
+}
+
+func (r *SQLitePasswordResetRepo) MarkPasswordResetUsed(ctx context.Context, id int) error {
+	const op = "/internal/repository/sqlite/MarkPasswordResetUsed"
+
+	query := `
+		UPDATE password_resets
+		SET used_at = ?
+		WHERE id = ?;
+	`
+
+	_, err := sqliteConn(ctx, r.db).ExecContext(ctx, query, sqliteNow(), id)
+	if err != nil {
+		r.logger.Error("cannot mark password reset used", "op", op, "error", err)
+		return fmt.Errorf("cannot mark password reset used: %w", err)
+	}
+
+	return nil
+}
+
+func (r *SQLitePasswordResetRepo) DeleteExpiredPasswordResets(ctx context.Context, before time.Time) (int64, error) {
+	const op = "/internal/repository/sqlite/DeleteExpiredPasswordResets"
+
+	query := `
+		DELETE FROM password_resets
+		WHERE expires_at < ?;
+	`
+
+	res, err := sqliteConn(ctx, r.db).ExecContext(ctx, query, before.UTC())
+	if err != nil {
+		r.logger.Error("cannot delete expired password resets", "op", op, "error", err)
+		return 0, fmt.Errorf("cannot delete expired password resets: %w", err)
+	}
+
+	return res.RowsAffected()
+}
+
+// TransactionRepo implementation
+type SQLiteTransRepo struct {
+	db     *sql.DB
+	logger *slog.Logger
+}
+
+func NewSQLiteTransRepo(db *sql.DB, logger *slog.Logger) *SQLiteTransRepo {
+	return &SQLiteTransRepo{db: db, logger: logger}
+}
+
+func (r *SQLiteTransRepo) CreateTransaction(ctx context.Context, t transactions.Transaction) error {
+	const op = "/internal/repository/sqlite/CreateTransaction"
+
+	query := `
+		INSERT INTO transactions (from_user, to_user, amount, timestamp)
+		VALUES (?, ?, ?, ?);
+	`
+
+	_, err := sqliteConn(ctx, r.db).ExecContext(ctx, query, t.FromUser, t.ToUser, t.Amount, sqliteNow())
+	if err != nil {
+		if isSQLiteForeignKeyViolation(err) {
+			r.logger.Warn("user not found", "op", op, "from", t.FromUser, "to", t.ToUser)
+			return fmt.Errorf("cannot create transaction: %w", user.ErrUserNotFound)
+		}
+		r.logger.Error("cannot create transaction", "op", op, "error", err)
+		return fmt.Errorf("cannot create transaction: %w", err)
+	}
+
+	return nil
+}
+
+func (r *SQLiteTransRepo) ListTransactions(ctx context.Context, f transactions.Filter) ([]transactions.Transaction, error) {
+	const op = "/internal/repository/sqlite/ListTransactions"
+
+	args := []any{f.UserId}
+	var conds []string
+
+	switch f.Direction {
+	case transactions.DirectionSent:
+		conds = append(conds, "t.from_user = ?1")
+	case transactions.DirectionReceived:
+		conds = append(conds, "t.to_user = ?1")
+	default:
+		conds = append(conds, "(t.from_user = ?1 OR t.to_user = ?1)")
+	}
+
+	// where adds a condition with a single placeholder for arg
+	where := func(cond string, arg any) {
+		args = append(args, arg)
+		conds = append(conds, fmt.Sprintf(cond, len(args)))
+	}
+
+	if f.Counterparty != "" {
+		where(`CASE WHEN t.from_user = ?1 THEN t.to_user ELSE t.from_user END =
+			(SELECT id FROM users WHERE name = ?%d)`, f.Counterparty)
+	}
+	if f.MinAmount > 0 {
+		where("t.amount >= ?%d", f.MinAmount)
+	}
+	if f.MaxAmount > 0 {
+		where("t.amount <= ?%d", f.MaxAmount)
+	}
+	if !f.From.IsZero() {
+		where("t.timestamp >= ?%d", f.From.UTC())
+	}
+	if !f.To.IsZero() {
+		where("t.timestamp < ?%d", f.To.UTC())
+	}
+	if f.After != nil {
+		args = append(args, f.After.Timestamp.UTC(), f.After.Id)
+		conds = append(conds, fmt.Sprintf("(t.timestamp, t.id) < (?%d, ?%d)", len(args)-1, len(args)))
+	}
+
+	args = append(args, f.Limit)
+	query := fmt.Sprintf(`
+		SELECT t.id, t.from_user, t.to_user, f.name, tu.name, t.amount, t.timestamp
+		FROM transactions t
+		JOIN users f ON f.id = t.from_user
+		JOIN users tu ON tu.id = t.to_user
+		WHERE %s
+		ORDER BY t.timestamp DESC, t.id DESC
+		LIMIT ?%d;
+	`, strings.Join(conds, " AND "), len(args))
+
+	rows, err := sqliteConn(ctx, r.db).QueryContext(ctx, query, args...)
+	if err != nil {
+		r.logger.Error("failed to get transactions", "op", op, "error", err)
+		return nil, fmt.Errorf("failed to get transactions: %w", err)
+	}
+	defer rows.Close()
+
+	var tList []transactions.Transaction
+
+	for rows.Next() {
+		var t transactions.Transaction
+		err := rows.Scan(
+			&t.Id, &t.FromUser, &t.ToUser, &t.FromUsername, &t.ToUsername,
+			&t.Amount, &t.Timestamp,
+		)
+		if err != nil {
+			r.logger.Error("failed to scan transaction", "op", op, "error", err)
+			return nil, fmt.Errorf("failed to scan transaction: %w", err)
+		}
+
+		tList = append(tList, t)
+	}
+
+	if err = rows.Err(); err != nil {
+		r.logger.Error("rows iteration error", "op", op, "error", err)
+		return nil, fmt.Errorf("rows iteration error: %w", err)
+	}
+
+	return tList, nil
+}
+
+// ItemRepo implementation
+type SQLiteItemRepo struct {
+	db     *sql.DB
+	logger *slog.Logger
+}
+
+func NewSQLiteItemRepo(db *sql.DB, logger *slog.Logger) *SQLiteItemRepo {
+	return &SQLiteItemRepo{db: db, logger: logger}
+}
+
+func (r *SQLiteItemRepo) GetItemByName(ctx context.Context, name string) (items.ItemType, error) {
+	const op = "/internal/repository/sqlite/GetItemByName"
+
+	var item items.ItemType
+
+	query := `
+		SELECT name, cost, available FROM items
+		WHERE name = ?;
+	`
+
+	err := sqliteConn(ctx, r.db).QueryRowContext(ctx, query, name).Scan(
+		&item.Name, &item.Cost, &item.Available,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			r.logger.Warn("item not found", "op", op, "name", name)
+			return items.ItemType{}, fmt.Errorf("%w: %s", items.ErrItemNotFound, name)
+		}
+
+		r.logger.Error("failed to get item", "op", op, "error", err)
+		return items.ItemType{}, fmt.Errorf("failed to get item: %w", err)
+	}
+
+	return item, nil
+}
+
+// GetItemByNameForUpdate needs no lock of its own, transactions hold the
+// write lock of the whole database from their start.
+func (r *SQLiteItemRepo) GetItemByNameForUpdate(ctx context.Context, name string) (items.ItemType, error) {
+	return r.GetItemByName(ctx, name)
+}
+
+// GetItemByNameForShare needs no lock of its own either.
+func (r *SQLiteItemRepo) GetItemByNameForShare(ctx context.Context, name string) (items.ItemType, error) {
+	return r.GetItemByName(ctx, name)
+}
+
+func (r *SQLiteItemRepo) ListItems(ctx context.Context) ([]items.ItemType, error) {
+	const op = "/internal/repository/sqlite/ListItems"
+
+	query := `
+		SELECT name, cost, available FROM items
+		ORDER BY name;
+	`
+
+	rows, err := sqliteConn(ctx, r.db).QueryContext(ctx, query)
+	if err != nil {
+		r.logger.Error("failed to list items", "op", op, "error", err)
+		return nil, fmt.Errorf("failed to list items: %w", err)
+	}
+	defer rows.Close()
+
+	var iList []items.ItemType
+
+	for rows.Next() {
+		var item items.ItemType
+		err := rows.Scan(&item.Name, &item.Cost, &item.Available)
+		if err != nil {
+			r.logger.Error("failed to scan item", "op", op, "error", err)
+			return nil, fmt.Errorf("failed to scan item: %w", err)
+		}
+
+		iList = append(iList, item)
+	}
+
+	if err = rows.Err(); err != nil {
+		r.logger.Error("rows iteration error", "op", op, "error", err)
+		return nil, fmt.Errorf("rows iteration error: %w", err)
+	}
+
+	return iList, nil
+}
+
+func (r *SQLiteItemRepo) CreateItem(ctx context.Context, item items.ItemType) error {
+	const op = "/internal/repository/sqlite/CreateItem"
+
+	query := `
+		INSERT INTO items (name, cost, available)
+		VALUES (?, ?, ?);
+	`
+
+	_, err := sqliteConn(ctx, r.db).ExecContext(ctx, query, item.Name, item.Cost, item.Available)
+	if err != nil {
+		if isSQLiteUniqueViolation(err) {
+			r.logger.Warn("item already exists", "op", op, "name", item.Name)
+			return fmt.Errorf("%w: %s", items.ErrItemExists, item.Name)
+		}
+		r.logger.Error("cannot create item", "op", op, "error", err)
+		return fmt.Errorf("cannot create item: %w", err)
+	}
+
+	return nil
+}
+
+// UpdateItem overwrites the item called name, including its name.
+func (r *SQLiteItemRepo) UpdateItem(ctx context.Context, name string, item items.ItemType) error {
+	const op = "/internal/repository/sqlite/UpdateItem"
+
+	query := `
+		UPDATE items
+		SET name = ?, cost = ?, available = ?
+		WHERE name = ?;
+	`
+
+	res, err := sqliteConn(ctx, r.db).ExecContext(ctx, query, item.Name, item.Cost, item.Available, name)
+	if err != nil {
+		if isSQLiteUniqueViolation(err) {
+			r.logger.Warn("item already exists", "op", op, "name", item.Name)
+			return fmt.Errorf("%w: %s", items.ErrItemExists, item.Name)
+		}
+		r.logger.Error("cannot update item", "op", op, "error", err)
+		return fmt.Errorf("cannot update item: %w", err)
+	}
+
+	if n, _ := res.RowsAffected(); n == 0 {
+		r.logger.Warn("item not found", "op", op, "name", name)
+		return fmt.Errorf("%w: %s", items.ErrItemNotFound, name)
+	}
+
+	return nil
+}
+
+func (r *SQLiteItemRepo) RecordChange(ctx context.Context, change items.Change) error {
+	const op = "/internal/repository/sqlite/RecordChange"
+
+	before, err := json.Marshal(change.Before)
+	if err != nil {
+		r.logger.Error("cannot marshal item", "op", op, "error", err)
+		return fmt.Errorf("cannot marshal item: %w", err)
+	}
+
+	after, err := json.Marshal(change.After)
+	if err != nil {
+		r.logger.Error("cannot marshal item", "op", op, "error", err)
+		return fmt.Errorf("cannot marshal item: %w", err)
+	}
+
+	query := `
+		INSERT INTO catalog_audit (actor_id, action, item_name, before, after, created_at)
+		VALUES (?, ?, ?, ?, ?, ?);
+	`
+
+	_, err = sqliteConn(ctx, r.db).ExecContext(
+		ctx, query, change.ActorId, string(change.Action), change.ItemName,
+		string(before), string(after), sqliteNow(),
+	)
+	if err != nil {
+		r.logger.Error("cannot record catalog change", "op", op, "error", err)
+		return fmt.Errorf("cannot record catalog change: %w", err)
+	}
+
+	return nil
+}
+
+func (r *SQLiteItemRepo) ListChanges(ctx context.Context, limit int) ([]items.Change, error) {
+	const op = "/internal/repository/sqlite/ListChanges"
+
+	query := `
+		SELECT id, COALESCE(actor_id, 0), action, item_name, before, after, created_at
+		FROM catalog_audit
+		ORDER BY id DESC
+		LIMIT ?;
+	`
+
+	rows, err := sqliteConn(ctx, r.db).QueryContext(ctx, query, limit)
+	if err != nil {
+		r.logger.Error("failed to list catalog changes", "op", op, "error", err)
+		return nil, fmt.Errorf("failed to list catalog changes: %w", err)
+	}
+	defer rows.Close()
+
+	var cList []items.Change
+
+	for rows.Next() {
+		var c items.Change
+		var action string
+		var before, after []byte
+		err := rows.Scan(
+			&c.Id, &c.ActorId, &action, &c.ItemName, &before, &after, &c.CreatedAt,
+		)
+		if err != nil {
+			r.logger.Error("failed to scan catalog change", "op", op, "error", err)
+			return nil, fmt.Errorf("failed to scan catalog change: %w", err)
+		}
+		c.Action = items.Action(action)
+
+		if err = json.Unmarshal(before, &c.Before); err != nil {
+			return nil, fmt.Errorf("cannot unmarshal item: %w", err)
+		}
+		if err = json.Unmarshal(after, &c.After); err != nil {
+			return nil, fmt.Errorf("cannot unmarshal item: %w", err)
+		}
+
+		cList = append(cList, c)
+	}
+
+	if err = rows.Err(); err != nil {
+		r.logger.Error("rows iteration error", "op", op, "error", err)
+		return nil, fmt.Errorf("rows iteration error: %w", err)
+	}
+
+	return cList, nil
+}
+
+// IdempotencyRepo implementation
+type SQLiteIdempotencyRepo struct {
+	db     *sql.DB
+	logger *slog.Logger
+}
+
+func NewSQLiteIdempotencyRepo(db *sql.DB, logger *slog.Logger) *SQLiteIdempotencyRepo {
+	return &SQLiteIdempotencyRepo{db: db, logger: logger}
+}
+
+func (r *SQLiteIdempotencyRepo) CreateRecord(ctx context.Context, record idempotency.Record) error {
+	const op = "/internal/repository/sqlite/CreateRecord"
+
+	query := `
+		INSERT INTO idempotency_keys (user_id, key, fingerprint, created_at)
+		VALUES (?, ?, ?, ?)
+		ON CONFLICT (user_id, key) DO NOTHING;
+	`
+
+	res, err := sqliteConn(ctx, r.db).ExecContext(
+		ctx, query, record.UserId, record.Key, record.Fingerprint, sqliteNow(),
+	)
+	if err != nil {
+		r.logger.Error("cannot create idempotency record", "op", op, "error", err)
+		return fmt.Errorf("cannot create idempotency record: %w", err)
+	}
+
+	if n, _ := res.RowsAffected(); n == 0 {
+		return idempotency.ErrKeyExists
+	}
+
+	return nil
+}
+
+func (r *SQLiteIdempotencyRepo) GetRecord(ctx context.Context, userId int, key string) (idempotency.Record, error) {
+	const op = "/internal/repository/sqlite/GetRecord"
+
+	var rec idempotency.Record
+
+	query := `
+		SELECT user_id, key, fingerprint, status_code, response, created_at
+		FROM idempotency_keys
+		WHERE user_id = ? AND key = ?;
+	`
+
+	err := sqliteConn(ctx, r.db).QueryRowContext(ctx, query, userId, key).Scan(
+		&rec.UserId, &rec.Key, &rec.Fingerprint,
+		&rec.StatusCode, &rec.Response, &rec.CreatedAt,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return idempotency.Record{}, idempotency.ErrRecordNotFound
+		}
+		r.logger.Error("cannot get idempotency record", "op", op, "error", err)
+		return idempotency.Record{}, fmt.Errorf("cannot get idempotency record: %w", err)
+	}
+
+	return rec, nil
+}
+
+func (r *SQLiteIdempotencyRepo) CompleteRecord(
+	ctx context.Context, userId int, key string, statusCode int, response []byte,
+) error {
+	const op = "/internal/repository/sqlite/CompleteRecord"
+
+	query := `
+		UPDATE idempotency_keys
+		SET status_code = ?, response = ?
+		WHERE user_id = ? AND key = ?;
+	`
+
+	_, err := sqliteConn(ctx, r.db).ExecContext(ctx, query, statusCode, response, userId, key)
+	if err != nil {
+		r.logger.Error("cannot complete idempotency record", "op", op, "error", err)
+		return fmt.Errorf("cannot complete idempotency record: %w", err)
+	}
+
+	return nil
+}
+
+func (r *SQLiteIdempotencyRepo) DeleteRecord(ctx context.Context, userId int, key string) error {
+	const op = "/internal/repository/sqlite/DeleteRecord"
+
+	query := `
+		DELETE FROM idempotency_keys
+		WHERE user_id = ? AND key = ?;
+	`
+
+	_, err := sqliteConn(ctx, r.db).ExecContext(ctx, query, userId, key)
+	if err != nil {
+		r.logger.Error("cannot delete idempotency record", "op", op, "error", err)
+		return fmt.Errorf("cannot delete idempotency record: %w", err)
+	}
+
+	return nil
+}
+
+func (r *SQLiteIdempotencyRepo) DeleteExpired(ctx context.Context, before time.Time) (int64, error) {
+	const op = "/internal/repository/sqlite/DeleteExpired"
+
+	query := `
+		DELETE FROM idempotency_keys
+		WHERE created_at < ?;
+	`
+
+	res, err := sqliteConn(ctx, r.db).ExecContext(ctx, query, before.UTC())
+	if err != nil {
+		r.logger.Error("cannot delete expired idempotency records", "op", op, "error", err)
+		return 0, fmt.Errorf("cannot delete expired idempotency records: %w", err)
+	}
+
+	return res.RowsAffected()
+}
+
+// PurchaseRepo implementation
+type SQLitePurchaseRepo struct {
+	db     *sql.DB
+	logger *slog.Logger
+}
+
+func NewSQLitePurchaseRepo(db *sql.DB, logger *slog.Logger) *SQLitePurchaseRepo {
+	return &SQLitePurchaseRepo{db: db, logger: logger}
+}
+
+func (r *SQLitePurchaseRepo) CreatePurchase(ctx context.Context, p purchases.Purchase) error {
+	const op = "/internal/repository/sqlite/CreatePurchase"
+
+	query := `
+		INSERT INTO purchases (user_id, item_name, cost, created_at)
+		VALUES (?, ?, ?, ?);
+	`
+
+	_, err := sqliteConn(ctx, r.db).ExecContext(ctx, query, p.UserId, p.ItemName, p.Cost, sqliteNow())
+	if err != nil {
+		r.logger.Error("cannot create purchase", "op", op, "error", err)
+		return fmt.Errorf("cannot create purchase: %w", err)
+	}
+
+	return nil
+}
+
+func (r *SQLitePurchaseRepo) GetPurchasesByUser(ctx context.Context, userId, limit int) ([]purchases.Purchase, error) {
+	const op = "/internal/repository/sqlite/GetPurchasesByUser"
+
+	query := `
+		SELECT id, user_id, item_name, cost, created_at
+		FROM purchases
+		WHERE user_id = ?
+		ORDER BY created_at DESC, id DESC
+		LIMIT ?;
+	`
+
+	rows, err := sqliteConn(ctx, r.db).QueryContext(ctx, query, userId, limit)
+	if err != nil {
+		r.logger.Error("failed to get purchases", "op", op, "error", err)
+		return nil, fmt.Errorf("failed to get purchases: %w", err)
+	}
+	defer rows.Close()
+
+	var pList []purchases.Purchase
+
+	for rows.Next() {
+		var p purchases.Purchase
+		err := rows.Scan(
+			&p.Id, &p.UserId, &p.ItemName, &p.Cost, &p.CreatedAt,
+		)
+		if err != nil {
+			r.logger.Error("failed to scan purchase", "op", op, "error", err)
+			return nil, fmt.Errorf("failed to scan purchase: %w", err)
+		}
+
+		pList = append(pList, p)
+	}
+
+	if err = rows.Err(); err != nil {
+		r.logger.Error("rows iteration error", "op", op, "error", err)
+		return nil, fmt.Errorf("rows iteration error: %w", err)
+	}
+
+	return pList, nil
+}
+
+// LedgerRepo implementation
+type SQLiteLedgerRepo struct {
+	db        *sql.DB
+	txManager *SQLiteTxManager
+	logger    *slog.Logger
+}
+
+func NewSQLiteLedgerRepo(db *sql.DB, logger *slog.Logger) *SQLiteLedgerRepo {
+	return &SQLiteLedgerRepo{
+		db:        db,
+		txManager: NewSQLiteTxManager(db, logger),
+		logger:    logger,
+	}
+}
+
+func (r *SQLiteLedgerRepo) CreateWallet(ctx context.Context, userId int) (ledger.Account, error) {
+	const op = "/internal/repository/sqlite/CreateWallet"
+
+	a := ledger.Account{Kind: ledger.AccountWallet, UserId: userId}
+
+	query := `
+		INSERT INTO accounts (kind, user_id)
+		VALUES (?, ?)
+		RETURNING id;
+	`
+
+	err := sqliteConn(ctx, r.db).QueryRowContext(ctx, query, string(a.Kind), userId).Scan(&a.Id)
+	if err != nil {
+		r.logger.Error("cannot create wallet", "op", op, "error", err)
+		return ledger.Account{}, fmt.Errorf("cannot create wallet: %w", err)
+	}
+
+	return a, nil
+}
+
+func (r *SQLiteLedgerRepo) GetWallet(ctx context.Context, userId int) (ledger.Account, error) {
+	const op = "/internal/repository/sqlite/GetWallet"
+
+	var a ledger.Account
+	var kind string
+
+	query := `
+		SELECT id, kind, user_id, balance
+		FROM accounts
+		WHERE user_id = ?;
+	`
+
+	err := sqliteConn(ctx, r.db).QueryRowContext(ctx, query, userId).Scan(
+		&a.Id, &kind, &a.UserId, &a.Balance,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			r.logger.Warn("wallet not found", "op", op, "userId", userId)
+			return ledger.Account{}, ledger.ErrAccountNotFound
+		}
+		r.logger.Error("cannot get wallet", "op", op, "error", err)
+		return ledger.Account{}, fmt.Errorf("cannot get wallet: %w", err)
+	}
+	a.Kind = ledger.AccountKind(kind)
+
+	return a, nil
+}
+
+func (r *SQLiteLedgerRepo) GetSystemAccount(ctx context.Context, kind ledger.AccountKind) (ledger.Account, error) {
+	const op = "/internal/repository/sqlite/GetSystemAccount"
+
+	a := ledger.Account{Kind: kind}
+
+	query := `
+		SELECT id, balance
+		FROM accounts
+		WHERE kind = ? AND user_id IS NULL;
+	`
+
+	err := sqliteConn(ctx, r.db).QueryRowContext(ctx, query, string(kind)).Scan(&a.Id, &a.Balance)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			r.logger.Error("system account not found", "op", op, "kind", kind)
+			return ledger.Account{}, ledger.ErrAccountNotFound
+		}
+		r.logger.Error("cannot get system account", "op", op, "error", err)
+		return ledger.Account{}, fmt.Errorf("cannot get system account: %w", err)
+	}
+
+	return a, nil
+}
+
+// PostEntry records the entry and applies its postings to the cached
+// balances. Wallets are debited only if they hold enough coins, otherwise
+// the whole entry is rolled back with ledger.ErrInsufficientFunds.
+func (r *SQLiteLedgerRepo) PostEntry(ctx context.Context, entry ledger.Entry) (int, error) {
+	const op = "/internal/repository/sqlite/PostEntry"
+
+	if err := entry.Validate(); err != nil {
+		r.logger.Error("invalid entry", "op", op, "kind", entry.Kind, "error", err)
+		return 0, err
+	}
+
+	// same order as PostgresLedgerRepo, so the same posting fails first
+	postings := slices.Clone(entry.Postings)
+	slices.SortFunc(postings, func(a, b ledger.Posting) int {
+		return a.AccountId - b.AccountId
+	})
+
+	var entryId int
+	err := r.txManager.WithinTx(ctx, func(ctx context.Context) error {
+		db := sqliteConn(ctx, r.db)
+
+		err := db.QueryRowContext(ctx, `
+			INSERT INTO ledger_entries (kind, created_at)
+			VALUES (?, ?)
+			RETURNING id;
+		`, string(entry.Kind), sqliteNow()).Scan(&entryId)
+		if err != nil {
+			return fmt.Errorf("cannot create entry: %w", err)
+		}
+
+		for _, p := range postings {
+			res, err := db.ExecContext(ctx, `
+				UPDATE accounts
+				SET balance = balance + ?1
+				WHERE id = ?2 AND (kind <> 'wallet' OR balance + ?1 >= 0);
+			`, p.Amount, p.AccountId)
+			if err != nil {
+				return fmt.Errorf("cannot update balance: %w", err)
+			}
+
+			if n, _ := res.RowsAffected(); n == 0 {
+				var exists bool
+				err = db.QueryRowContext(ctx, `
+					SELECT EXISTS (SELECT 1 FROM accounts WHERE id = ?);
+				`, p.AccountId).Scan(&exists)
+				if err != nil {
+					return fmt.Errorf("cannot check account: %w", err)
+				}
+				if !exists {
+					return ledger.ErrAccountNotFound
+				}
+				return ledger.ErrInsufficientFunds
+			}
+
+			_, err = db.ExecContext(ctx, `
+				INSERT INTO postings (entry_id, account_id, amount)
+				VALUES (?, ?, ?);
+			`, entryId, p.AccountId, p.Amount)
+			if err != nil {
+				return fmt.Errorf("cannot create posting: %w", err)
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		if errors.Is(err, ledger.ErrInsufficientFunds) {
+			r.logger.Warn("insufficient funds", "op", op, "kind", entry.Kind)
+			return 0, err
+		}
+		r.logger.Error("cannot post entry", "op", op, "error", err)
+		return 0, fmt.Errorf("cannot post entry: %w", err)
+	}
+
+	return entryId, nil
+}
+
+func (r *SQLiteLedgerRepo) Reconcile(ctx context.Context) (ledger.Report, error) {
+	const op = "/internal/repository/sqlite/Reconcile"
+
+	// the checks read one snapshot, a posting committed between them
+	// would show up as a discrepancy
+	db := sqliteConn(ctx, r.db)
+	if _, ok := db.(*sql.Tx); !ok {
+		tx, err := r.db.BeginTx(ctx, nil)
+		if err != nil {
+			r.logger.Error("cannot begin transaction", "op", op, "error", err)
+			return ledger.Report{}, fmt.Errorf("cannot begin transaction: %w", err)
+		}
+		defer tx.Rollback()
+		db = tx
+	}
+
+	var report ledger.Report
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT entry_id
+		FROM postings
+		GROUP BY entry_id
+		HAVING SUM(amount) <> 0
+		ORDER BY entry_id;
+	`)
+	if err != nil {
+		r.logger.Error("cannot check entries", "op", op, "error", err)
+		return ledger.Report{}, fmt.Errorf("cannot check entries: %w", err)
+	}
+	for rows.Next() {
+		var id int
+		if err = rows.Scan(&id); err != nil {
+			break
+		}
+		report.UnbalancedEntries = append(report.UnbalancedEntries, id)
+	}
+	rows.Close()
+	if err = errors.Join(err, rows.Err()); err != nil {
+		r.logger.Error("cannot scan entries", "op", op, "error", err)
+		return ledger.Report{}, fmt.Errorf("cannot scan entries: %w", err)
+	}
+
+	rows, err = db.QueryContext(ctx, `
+		SELECT a.id, a.balance, COALESCE(SUM(p.amount), 0)
+		FROM accounts a
+		LEFT JOIN postings p ON p.account_id = a.id
+		GROUP BY a.id, a.balance
+		HAVING a.balance <> COALESCE(SUM(p.amount), 0)
+		ORDER BY a.id;
+	`)
+	if err != nil {
+		r.logger.Error("cannot check balances", "op", op, "error", err)
+		return ledger.Report{}, fmt.Errorf("cannot check balances: %w", err)
+	}
+	for rows.Next() {
+		var m ledger.AccountMismatch
+		if err = rows.Scan(&m.AccountId, &m.Cached, &m.Posted); err != nil {
+			break
+		}
+		report.Mismatches = append(report.Mismatches, m)
+	}
+	rows.Close()
+	if err = errors.Join(err, rows.Err()); err != nil {
+		r.logger.Error("cannot scan balances", "op", op, "error", err)
+		return ledger.Report{}, fmt.Errorf("cannot scan balances: %w", err)
+	}
+
+	err = db.QueryRowContext(ctx, `
+		SELECT COALESCE(SUM(balance), 0) FROM accounts;
+	`).Scan(&report.Total)
+	if err != nil {
+		r.logger.Error("cannot sum balances", "op", op, "error", err)
+		return ledger.Report{}, fmt.Errorf("cannot sum balances: %w", err)
+	}
+
+	return report, nil
+}
+
+// RefreshTokenRepo implementation
+type SQLiteRefreshTokenRepo struct {
+	db     *sql.DB
+	logger *slog.Logger
+}
+
+func NewSQLiteRefreshTokenRepo(db *sql.DB, logger *slog.Logger) *SQLiteRefreshTokenRepo {
+	return &SQLiteRefreshTokenRepo{db: db, logger: logger}
+}
+
+func (r *SQLiteRefreshTokenRepo) CreateRefreshToken(ctx context.Context, t session.RefreshToken) error {
+	const op = "/internal/repository/sqlite/CreateRefreshToken"
+
+	query := `
+		INSERT INTO refresh_tokens (user_id, family_id, token_hash, expires_at, created_at)
+		VALUES (?, ?, ?, ?, ?);
+	`
+
+	_, err := sqliteConn(ctx, r.db).ExecContext(
+		ctx, query, t.UserId, t.FamilyId, t.Hash, t.ExpiresAt.UTC(), sqliteNow(),
+	)
+	if err != nil {
+		r.logger.Error("cannot create refresh token", "op", op, "error", err)
+		return fmt.Errorf("cannot create refresh token: %w", err)
+	}
+
+	return nil
+}
+
+// GetRefreshTokenForUpdate needs no lock of its own, transactions hold
+// the write lock of the whole database from their start.
+func (r *SQLiteRefreshTokenRepo) GetRefreshTokenForUpdate(ctx context.Context, hash string) (session.RefreshToken, error) {
+	const op = "/internal/repository/sqlite/GetRefreshTokenForUpdate"
+
+	var t session.RefreshToken
+
+	query := `
+		SELECT id, user_id, family_id, token_hash, expires_at, created_at, used_at, revoked_at
+		FROM refresh_tokens
+		WHERE token_hash = ?;
+	`
+
+	err := sqliteConn(ctx, r.db).QueryRowContext(ctx, query, hash).Scan(
+		&t.Id, &t.UserId, &t.FamilyId, &t.Hash,
+		&t.ExpiresAt, &t.CreatedAt, &t.UsedAt, &t.RevokedAt,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return session.RefreshToken{}, session.ErrRefreshTokenNotFound
+		}
+		r.logger.Error("cannot get refresh token", "op", op, "error", err)
+		return session.RefreshToken{}, fmt.Errorf("cannot get refresh token: %w", err)
+	}
+
+	return t, nil
+}
+
+func (r *SQLiteRefreshTokenRepo) MarkRefreshTokenUsed(ctx context.Context, id int) error {
+	const op = "/internal/repository/sqlite/MarkRefreshTokenUsed"
+
+	query := `
+		UPDATE refresh_tokens
+		SET used_at = ?
+		WHERE id = ?;
+	`
+
+	_, err := sqliteConn(ctx, r.db).ExecContext(ctx, query, sqliteNow(), id)
+	if err != nil {
+		r.logger.Error("cannot mark refresh token used", "op", op, "error", err)
+		return fmt.Errorf("cannot mark refresh token used: %w", err)
+	}
+
+	return nil
+}
+
+func (r *SQLiteRefreshTokenRepo) RevokeFamily(ctx context.Context, familyId string) error {
+	const op = "/internal/repository/sqlite/RevokeFamily"
+
+	query := `
+		UPDATE refresh_tokens
+		SET revoked_at = ?
+		WHERE family_id = ? AND revoked_at IS NULL;
+	`
+
+	_, err := sqliteConn(ctx, r.db).ExecContext(ctx, query, sqliteNow(), familyId)
+	if err != nil {
+		r.logger.Error("cannot revoke refresh tokens", "op", op, "error", err)
+		return fmt.Errorf("cannot revoke refresh tokens: %w", err)
+	}
+
+	return nil
+}
+
+func (r *SQLiteRefreshTokenRepo) RevokeUserRefreshTokens(ctx context.Context, userId int) error {
+	const op = "/internal/repository/sqlite/RevokeUserRefreshTokens"
+
+	query := `
+		UPDATE refresh_tokens
+		SET revoked_at = ?
+		WHERE user_id = ? AND revoked_at IS NULL;
+	`
+
+	_, err := sqliteConn(ctx, r.db).ExecContext(ctx, query, sqliteNow(), userId)
+	if err != nil {
+		r.logger.Error("cannot revoke user refresh tokens", "op", op, "error", err)
+		return fmt.Errorf("cannot revoke user refresh tokens: %w", err)
+	}
+
+	return nil
+}
+
+func (r *SQLiteRefreshTokenRepo) DeleteExpiredRefreshTokens(ctx context.Context, before time.Time) (int64, error) {
+	const op = "/internal/repository/sqlite/DeleteExpiredRefreshTokens"
+
+	query := `
+		DELETE FROM refresh_tokens
+		WHERE expires_at < ?;
+	`
+
+	res, err := sqliteConn(ctx, r.db).ExecContext(ctx, query, before.UTC())
+	if err != nil {
+		r.logger.Error("cannot delete expired refresh tokens", "op", op, "error", err)
+		return 0, fmt.Errorf("cannot delete expired refresh tokens: %w", err)
+	}
+
+	return res.RowsAffected()
+}
+
+// RevocationRepo implementation
+type SQLiteRevocationRepo struct {
+	db     *sql.DB
+	logger *slog.Logger
+}
+
+func NewSQLiteRevocationRepo(db *sql.DB, logger *slog.Logger) *SQLiteRevocationRepo {
+	return &SQLiteRevocationRepo{db: db, logger: logger}
+}
+
+func (r *SQLiteRevocationRepo) RevokeToken(ctx context.Context, jti string, userId int, expiresAt time.Time) error {
+	const op = "/internal/repository/sqlite/RevokeToken"
+
+	query := `
+		INSERT INTO revoked_tokens (jti, user_id, expires_at, revoked_at)
+		VALUES (?, ?, ?, ?)
+		ON CONFLICT (jti) DO NOTHING;
+	`
+
+	_, err := sqliteConn(ctx, r.db).ExecContext(ctx, query, jti, userId, expiresAt.UTC(), sqliteNow())
+	if err != nil {
+		r.logger.Error("cannot revoke token", "op", op, "error", err)
+		return fmt.Errorf("cannot revoke token: %w", err)
+	}
+
+	return nil
+}
+
+func (r *SQLiteRevocationRepo) RevokeUserTokens(ctx context.Context, userId int, before time.Time) error {
+	const op = "/internal/repository/sqlite/RevokeUserTokens"
+
+	// MAX of two values is the scalar function, GREATEST in Postgres
+	query := `
+		INSERT INTO user_revocations (user_id, revoked_before)
+		VALUES (?, ?)
+		ON CONFLICT (user_id) DO UPDATE
+		SET revoked_before = MAX(user_revocations.revoked_before, excluded.revoked_before);
+	`
+
+	_, err := sqliteConn(ctx, r.db).ExecContext(ctx, query, userId, before.UTC())
+	if err != nil {
+		r.logger.Error("cannot revoke user tokens", "op", op, "error", err)
+		return fmt.Errorf("cannot revoke user tokens: %w", err)
+	}
+
+	return nil
+}
+
+func (r *SQLiteRevocationRepo) IsRevoked(ctx context.Context, jti string, userId int, issuedAt time.Time) (bool, error) {
+	const op = "/internal/repository/sqlite/IsRevoked"
+
+	var revoked bool
+
+	query := `
+		SELECT EXISTS (SELECT 1 FROM revoked_tokens WHERE jti = ?)
+			OR EXISTS (SELECT 1 FROM user_revocations WHERE user_id = ? AND revoked_before > ?);
+	`
+
+	err := sqliteConn(ctx, r.db).QueryRowContext(ctx, query, jti, userId, issuedAt.UTC()).Scan(&revoked)
+	if err != nil {
+		r.logger.Error("cannot check token revocation", "op", op, "error", err)
+		return false, fmt.Errorf("cannot check token revocation: %w", err)
+	}
+
+	return revoked, nil
+}
+
+func (r *SQLiteRevocationRepo) DeleteExpiredRevocations(ctx context.Context, tokensBefore, usersBefore time.Time) (int64, error) {
+	const op = "/internal/repository/sqlite/DeleteExpiredRevocations"
+
+	tokens, err := sqliteConn(ctx, r.db).ExecContext(ctx, `DELETE FROM revoked_tokens WHERE expires_at < ?;`, tokensBefore.UTC())
+	if err != nil {
+		r.logger.Error("cannot delete expired revocations", "op", op, "error", err)
+		return 0, fmt.Errorf("cannot delete expired revocations: %w", err)
+	}
+
+	users, err := sqliteConn(ctx, r.db).ExecContext(ctx, `DELETE FROM user_revocations WHERE revoked_before < ?;`, usersBefore.UTC())
+	if err != nil {
+		r.logger.Error("cannot delete expired revocations", "op", op, "error", err)
+		return 0, fmt.Errorf("cannot delete expired revocations: %w", err)
+	}
+
+	nTokens, _ := tokens.RowsAffected()
+	nUsers, _ := users.RowsAffected()
+
+	return nTokens + nUsers, nil
+}
+
+// LockoutRepo implementation
+type SQLiteLockoutRepo struct {
+	db     *sql.DB
+	logger *slog.Logger
+}
+
+func NewSQLiteLockoutRepo(db *sql.DB, logger *slog.Logger) *SQLiteLockoutRepo {
+	return &SQLiteLockoutRepo{db: db, logger: logger}
+}
+
+func (r *SQLiteLockoutRepo) GetLockout(ctx context.Context, scope lockout.Scope, key string) (lockout.Lockout, error) {
+	const op = "/internal/repository/sqlite/GetLockout"
+
+	var l lockout.Lockout
+
+	query := `
+		SELECT scope, key, failures, last_failure, locked_until
+		FROM login_lockouts
+		WHERE scope = ? AND key = ?;
+	`
+
+	err := sqliteConn(ctx, r.db).QueryRowContext(ctx, query, string(scope), key).Scan(
+		&l.Scope, &l.Key, &l.Failures, &l.LastFailure, &l.LockedUntil,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return lockout.Lockout{}, lockout.ErrLockoutNotFound
+		}
+		r.logger.Error("cannot get lockout", "op", op, "error", err)
+		return lockout.Lockout{}, fmt.Errorf("cannot get lockout: %w", err)
+	}
+
+	return l, nil
+}
+
+func (r *SQLiteLockoutRepo) RecordFailure(
+	ctx context.Context, scope lockout.Scope, key string, at, resetBefore time.Time,
+) (int, error) {
+	const op = "/internal/repository/sqlite/RecordFailure"
+
+	var failures int
+
+	query := `
+		INSERT INTO login_lockouts (scope, key, failures, last_failure)
+		VALUES (?1, ?2, 1, ?3)
+		ON CONFLICT (scope, key) DO UPDATE
+		SET failures = CASE
+				WHEN login_lockouts.last_failure < ?4 THEN 1
+				ELSE login_lockouts.failures + 1
+			END,
+			last_failure = excluded.last_failure
+		RETURNING failures;
+	`
+
+	err := sqliteConn(ctx, r.db).QueryRowContext(
+		ctx, query, string(scope), key, at.UTC(), resetBefore.UTC(),
+	).Scan(&failures)
+	if err != nil {
+		r.logger.Error("cannot record login failure", "op", op, "error", err)
+		return 0, fmt.Errorf("cannot record login failure: %w", err)
+	}
+
+	return failures, nil
+}
+
+func (r *SQLiteLockoutRepo) ForgetFailure(ctx context.Context, scope lockout.Scope, key string) error {
+	const op = "/internal/repository/sqlite/ForgetFailure"
+
+	query := `
+		UPDATE login_lockouts
+		SET failures = failures - 1
+		WHERE scope = ? AND key = ? AND failures > 0;
+	`
+
+	_, err := sqliteConn(ctx, r.db).ExecContext(ctx, query, string(scope), key)
+	if err != nil {
+		r.logger.Error("cannot forget login failure", "op", op, "error", err)
+		return fmt.Errorf("cannot forget login failure: %w", err)
+	}
+
+	return nil
+}
+
+func (r *SQLiteLockoutRepo) LockUntil(ctx context.Context, scope lockout.Scope, key string, until time.Time) error {
+	const op = "/internal/repository/sqlite/LockUntil"
+
+	query := `
+		UPDATE login_lockouts
+		SET locked_until = ?3
+		WHERE scope = ?1 AND key = ?2;
+	`
+
+	_, err := sqliteConn(ctx, r.db).ExecContext(ctx, query, string(scope), key, until.UTC())
+	if err != nil {
+		r.logger.Error("cannot lock login", "op", op, "error", err)
+		return fmt.Errorf("cannot lock login: %w", err)
+	}
+
+	return nil
+}
+
+func (r *SQLiteLockoutRepo) DeleteLockout(ctx context.Context, scope lockout.Scope, key string) error {
+	const op = "/internal/repository/sqlite/DeleteLockout"
+
+	query := `
+		DELETE FROM login_lockouts
+		WHERE scope = ? AND key = ?;
+	`
+
+	res, err := sqliteConn(ctx, r.db).ExecContext(ctx, query, string(scope), key)
+	if err != nil {
+		r.logger.Error("cannot delete lockout", "op", op, "error", err)
+		return fmt.Errorf("cannot delete lockout: %w", err)
+	}
+
+	if n, _ := res.RowsAffected(); n == 0 {
+		return lockout.ErrLockoutNotFound
+	}
+
+	return nil
+}
+
+func (r *SQLiteLockoutRepo) ListLockouts(ctx context.Context, limit int) ([]lockout.Lockout, error) {
+	const op = "/internal/repository/sqlite/ListLockouts"
+
+	query := `
+		SELECT scope, key, failures, last_failure, locked_until
+		FROM login_lockouts
+		ORDER BY locked_until DESC NULLS LAST, last_failure DESC
+		LIMIT ?;
+	`
+
+	rows, err := sqliteConn(ctx, r.db).QueryContext(ctx, query, limit)
+	if err != nil {
+		r.logger.Error("cannot list lockouts", "op", op, "error", err)
+		return nil, fmt.Errorf("cannot list lockouts: %w", err)
+	}
+	defer rows.Close()
+
+	var lList []lockout.Lockout
+	for rows.Next() {
+		var l lockout.Lockout
+		err = rows.Scan(&l.Scope, &l.Key, &l.Failures, &l.LastFailure, &l.LockedUntil)
+		if err != nil {
+			r.logger.Error("cannot scan lockout", "op", op, "error", err)
+			return nil, fmt.Errorf("cannot scan lockout: %w", err)
+		}
+		lList = append(lList, l)
+	}
+
+	if err = rows.Err(); err != nil {
+		r.logger.Error("cannot list lockouts", "op", op, "error", err)
+		return nil, fmt.Errorf("cannot list lockouts: %w", err)
+	}
+
+	return lList, nil
+}
+
+func (r *SQLiteLockoutRepo) DeleteStaleLockouts(ctx context.Context, before time.Time) (int64, error) {
+	const op = "/internal/repository/sqlite/DeleteStaleLockouts"
+
+	query := `
+		DELETE FROM login_lockouts
+		WHERE last_failure < ?1 AND (locked_until IS NULL OR locked_until < ?1);
+	`
+
+	res, err := sqliteConn(ctx, r.db).ExecContext(ctx, query, before.UTC())
+	if err != nil {
+		r.logger.Error("cannot delete stale lockouts", "op", op, "error", err)
+		return 0, fmt.Errorf("cannot delete stale lockouts: %w", err)
+	}
+
+	return res.RowsAffected()
+}
+
+// KeyRepo implementation
+type SQLiteKeyRepo struct {
+	db     *sql.DB
+	logger *slog.Logger
+}
+
+func NewSQLiteKeyRepo(db *sql.DB, logger *slog.Logger) *SQLiteKeyRepo {
+	return &SQLiteKeyRepo{db: db, logger: logger}
+}
+
+func (r *SQLiteKeyRepo) CreateKey(ctx context.Context, key apikey.Key) (apikey.Key, error) {
+	const op = "/internal/repository/sqlite/CreateKey"
+
+	scopes, err := json.Marshal(scopeStrings(key.Scopes))
+	if err != nil {
+		r.logger.Error("cannot marshal scopes", "op", op, "error", err)
+		return apikey.Key{}, fmt.Errorf("cannot marshal scopes: %w", err)
+	}
+
+	query := `
+		INSERT INTO api_keys (user_id, name, prefix, key_hash, scopes, created_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+		RETURNING id;
+	`
+
+	key.CreatedAt = sqliteNow()
+	err = sqliteConn(ctx, r.db).QueryRowContext(
+		ctx, query, key.UserId, key.Name, key.Prefix, key.Hash, string(scopes), key.CreatedAt,
+	).Scan(&key.Id)
+	if err != nil {
+		r.logger.Error("cannot create api key", "op", op, "error", err)
+		return apikey.Key{}, fmt.Errorf("cannot create api key: %w", err)
+	}
+
+	return key, nil
+}
+
+func (r *SQLiteKeyRepo) ListKeys(ctx context.Context, userId int) ([]apikey.Key, error) {
+	const op = "/internal/repository/sqlite/ListKeys"
+
+	query := `
+		SELECT id, user_id, name, prefix, key_hash, scopes, created_at, last_used_at
+		FROM api_keys
+		WHERE user_id = ? AND revoked_at IS NULL
+		ORDER BY created_at, id;
+	`
+
+	rows, err := sqliteConn(ctx, r.db).QueryContext(ctx, query, userId)
+	if err != nil {
+		r.logger.Error("cannot list api keys", "op", op, "error", err)
+		return nil, fmt.Errorf("cannot list api keys: %w", err)
+	}
+	defer rows.Close()
+
+	var kList []apikey.Key
+	for rows.Next() {
+		k, err := scanSQLiteKey(rows)
+		if err != nil {
+			r.logger.Error("cannot scan api key", "op", op, "error", err)
+			return nil, fmt.Errorf("cannot scan api key: %w", err)
+		}
+		kList = append(kList, k)
+	}
+
+	if err = rows.Err(); err != nil {
+		r.logger.Error("cannot list api keys", "op", op, "error", err)
+		return nil, fmt.Errorf("cannot list api keys: %w", err)
+	}
+
+	return kList, nil
+}
+
+// GetKeyByHash returns the key only while it is not revoked.
+func (r *SQLiteKeyRepo) GetKeyByHash(ctx context.Context, hash string) (apikey.Key, error) {
+	const op = "/internal/repository/sqlite/GetKeyByHash"
+
+	query := `
+		SELECT id, user_id, name, prefix, key_hash, scopes, created_at, last_used_at
+		FROM api_keys
+		WHERE key_hash = ? AND revoked_at IS NULL;
+	`
+
+	k, err := scanSQLiteKey(sqliteConn(ctx, r.db).QueryRowContext(ctx, query, hash))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return apikey.Key{}, apikey.ErrKeyNotFound
+		}
+		r.logger.Error("cannot get api key", "op", op, "error", err)
+		return apikey.Key{}, fmt.Errorf("cannot get api key: %w", err)
+	}
+
+	return k, nil
+}
+
+func (r *SQLiteKeyRepo) RevokeKey(ctx context.Context, userId, id int) error {
+	const op = "/internal/repository/sqlite/RevokeKey"
+
+	query := `
+		UPDATE api_keys
+		SET revoked_at = ?
+		WHERE id = ? AND user_id = ? AND revoked_at IS NULL;
+	`
+
+	res, err := sqliteConn(ctx, r.db).ExecContext(ctx, query, sqliteNow(), id, userId)
+	if err != nil {
+		r.logger.Error("cannot revoke api key", "op", op, "error", err)
+		return fmt.Errorf("cannot revoke api key: %w", err)
+	}
+
+	if n, _ := res.RowsAffected(); n == 0 {
+		return apikey.ErrKeyNotFound
+	}
+
+	return nil
+}
+
+func (r *SQLiteKeyRepo) RevokeUserKeys(ctx context.Context, userId int) error {
+	const op = "/internal/repository/sqlite/RevokeUserKeys"
+
+	query := `
+		UPDATE api_keys
+		SET revoked_at = ?
+		WHERE user_id = ? AND revoked_at IS NULL;
+	`
+
+	_, err := sqliteConn(ctx, r.db).ExecContext(ctx, query, sqliteNow(), userId)
+	if err != nil {
+		r.logger.Error("cannot revoke api keys", "op", op, "error", err)
+		return fmt.Errorf("cannot revoke api keys: %w", err)
+	}
+
+	return nil
+}
+
+func (r *SQLiteKeyRepo) TouchKey(ctx context.Context, id int, at time.Time) error {
+	const op = "/internal/repository/sqlite/TouchKey"
+
+	query := `
+		UPDATE api_keys
+		SET last_used_at = ?
+		WHERE id = ?;
+	`
+
+	_, err := sqliteConn(ctx, r.db).ExecContext(ctx, query, at.UTC(), id)
+	if err != nil {
+		r.logger.Error("cannot update api key usage", "op", op, "error", err)
+		return fmt.Errorf("cannot update api key usage: %w", err)
+	}
+
+	return nil
+}
+
+// scanSQLiteKey scans an api_keys row, row is a *sql.Row or *sql.Rows.
+func scanSQLiteKey(row interface{ Scan(dest ...any) error }) (apikey.Key, error) {
+	var k apikey.Key
+	var scopes string
+
+	err := row.Scan(
+		&k.Id, &k.UserId, &k.Name, &k.Prefix, &k.Hash, &scopes, &k.CreatedAt, &k.LastUsedAt,
+	)
+	if err != nil {
+		return apikey.Key{}, err
+	}
+
+	if err = json.Unmarshal([]byte(scopes), &k.Scopes); err != nil {
+		return apikey.Key{}, fmt.Errorf("cannot unmarshal scopes: %w", err)
+	}
+
+	return k, nil
+}
+
+// IdentityRepo implementation
+type SQLiteIdentityRepo struct {
+	db     *sql.DB
+	logger *slog.Logger
+}
+
+func NewSQLiteIdentityRepo(db *sql.DB, logger *slog.Logger) *SQLiteIdentityRepo {
+	return &SQLiteIdentityRepo{db: db, logger: logger}
+}
+
+func (r *SQLiteIdentityRepo) GetIdentity(ctx context.Context, issuer, subject string) (identity.Identity, error) {
+	const op = "/internal/repository/sqlite/GetIdentity"
+
+	var id identity.Identity
+
+	query := `
+		SELECT issuer, subject, user_id, created_at
+		FROM user_identities
+		WHERE issuer = ? AND subject = ?;
+	`
+
+	err := sqliteConn(ctx, r.db).QueryRowContext(ctx, query, issuer, subject).Scan(
+		&id.Issuer, &id.Subject, &id.UserId, &id.CreatedAt,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return identity.Identity{}, identity.ErrIdentityNotFound
+		}
+		r.logger.Error("cannot get identity", "op", op, "error", err)
+		return identity.Identity{}, fmt.Errorf("cannot get identity: %w", err)
+	}
+
+	return id, nil
+}
+
+func (r *SQLiteIdentityRepo) CreateIdentity(ctx context.Context, id identity.Identity) error {
+	const op = "/internal/repository/sqlite/CreateIdentity"
+
+	query := `
+		INSERT INTO user_identities (issuer, subject, user_id, created_at)
+		VALUES (?, ?, ?, ?);
+	`
+
+	_, err := sqliteConn(ctx, r.db).ExecContext(ctx, query, id.Issuer, id.Subject, id.UserId, sqliteNow())
+	if err != nil {
+		if isSQLiteUniqueViolation(err) {
+			return identity.ErrIdentityExists
+		}
+		r.logger.Error("cannot create identity", "op", op, "error", err)
+		return fmt.Errorf("cannot create identity: %w", err)
+	}
+
+	return nil
+}
+
+// LoginStateRepo implementation
+type SQLiteLoginStateRepo struct {
+	db     *sql.DB
+	logger *slog.Logger
+}
+
+func NewSQLiteLoginStateRepo(db *sql.DB, logger *slog.Logger) *SQLiteLoginStateRepo {
+	return &SQLiteLoginStateRepo{db: db, logger: logger}
+}
+
+func (r *SQLiteLoginStateRepo) CreateLoginState(ctx context.Context, s identity.LoginState) error {
+	const op = "/internal/repository/sqlite/CreateLoginState"
+
+	query := `
+		INSERT INTO oidc_login_states (state, verifier, nonce, binding_hash, expires_at)
+		VALUES (?, ?, ?, ?, ?);
+	`
+
+	_, err := sqliteConn(ctx, r.db).ExecContext(
+		ctx, query, s.State, s.Verifier, s.Nonce, s.BindingHash, s.ExpiresAt.UTC(),
+	)
+	if err != nil {
+		r.logger.Error("cannot create login state", "op", op, "error", err)
+		return fmt.Errorf("cannot create login state: %w", err)
+	}
+
+	return nil
+}
+
+func (r *SQLiteLoginStateRepo) TakeLoginState(ctx context.Context, state string) (identity.LoginState, error) {
+	const op = "/internal/repository/sqlite/TakeLoginState"
+
+	var s identity.LoginState
+
+	query := `
+		DELETE FROM oidc_login_states
+		WHERE state = ?
+		RETURNING state, verifier, nonce, binding_hash, expires_at;
+	`
+
+	err := sqliteConn(ctx, r.db).QueryRowContext(ctx, query, state).Scan(
+		&s.State, &s.Verifier, &s.Nonce, &s.BindingHash, &s.ExpiresAt,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return identity.LoginState{}, identity.ErrLoginStateNotFound
+		}
+		r.logger.Error("cannot take login state", "op", op, "error", err)
+		return identity.LoginState{}, fmt.Errorf("cannot take login state: %w", err)
+	}
+
+	return s, nil
+}
+
+func (r *SQLiteLoginStateRepo) DeleteExpiredLoginStates(ctx context.Context, before time.Time) (int64, error) {
+	const op = "/internal/repository/sqlite/DeleteExpiredLoginStates"
+
+	query := `
+		DELETE FROM oidc_login_states
+		WHERE expires_at < ?;
+	`
+
+	res, err := sqliteConn(ctx, r.db).ExecContext(ctx, query, before.UTC())
+	if err != nil {
+		r.logger.Error("cannot delete expired login states", "op", op, "error", err)
+		return 0, fmt.Errorf("cannot delete expired login states: %w", err)
+	}
+
+	return res.RowsAffected()
+}
+
+// sqliteNow is the current time in UTC, which the schema relies on to
+// compare timestamps as text, at the microsecond precision of Postgres.
+func sqliteNow() time.Time {
+	return time.Now().UTC().Truncate(time.Microsecond)
+}
@@ -0,0 +1,113 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+type sqliteTxKey struct{}
+
+// sqliteQuerier is the subset of sql.DB and sql.Tx used by the repos
+type sqliteQuerier interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// sqliteConn returns the transaction stored in ctx by WithinTx or the
+// database if the call is not part of a transaction.
+func sqliteConn(ctx context.Context, db *sql.DB) sqliteQuerier {
+	if tx, ok := ctx.Value(sqliteTxKey{}).(*sql.Tx); ok {
+		return tx
+	}
+
+	return db
+}
+
+// TxManager implementation
+type SQLiteTxManager struct {
+	db     *sql.DB
+	logger *slog.Logger
+}
+
+func NewSQLiteTxManager(db *sql.DB, logger *slog.Logger) *SQLiteTxManager {
+	return &SQLiteTxManager{db: db, logger: logger}
+}
+
+func (m *SQLiteTxManager) WithinTx(ctx context.Context, fn func(ctx context.Context) error) (err error) {
+	const op = "/internal/repository/sqlite_tx/WithinTx"
+
+	if _, ok := ctx.Value(sqliteTxKey{}).(*sql.Tx); ok {
+		return fn(ctx)
+	}
+
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		m.logger.Error("cannot begin transaction", "op", op, "error", err)
+		return fmt.Errorf("cannot begin transaction: %w", err)
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			_ = tx.Rollback()
+			panic(p)
+		}
+
+		if err != nil {
+			if rbErr := tx.Rollback(); rbErr != nil && !errors.Is(rbErr, sql.ErrTxDone) {
+				m.logger.Error("cannot rollback transaction", "op", op, "error", rbErr)
+			}
+		}
+	}()
+
+	if err = fn(context.WithValue(ctx, sqliteTxKey{}, tx)); err != nil {
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		m.logger.Error("cannot commit transaction", "op", op, "error", err)
+		return fmt.Errorf("cannot commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+// OpenSQLite opens the database file at path, creating it if needed.
+//
+// SQLite has a single writer. The pool keeps one connection, so
+// transactions queue in Go instead of failing with SQLITE_BUSY, and
+// every transaction takes the write lock when it begins, which stands in
+// for the row locks of the FOR UPDATE queries in the Postgres repos.
+// Repo calls inside TxManager.WithinTx must therefore use the ctx fn is
+// given, a call with another ctx waits for the connection and deadlocks.
+func OpenSQLite(path string) (*sql.DB, error) {
+	db, err := sql.Open("sqlite3", "file:"+path+"?_foreign_keys=on&_txlock=immediate&_journal_mode=WAL&_busy_timeout=5000")
+	if err != nil {
+		return nil, fmt.Errorf("cannot open sqlite database: %w", err)
+	}
+	db.SetMaxOpenConns(1)
+
+	if err = db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("cannot open sqlite database: %w", err)
+	}
+
+	return db, nil
+}
+
+func isSQLiteUniqueViolation(err error) bool {
+	var sqliteErr sqlite3.Error
+	return errors.As(err, &sqliteErr) &&
+		(sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique ||
+			sqliteErr.ExtendedCode == sqlite3.ErrConstraintPrimaryKey)
+}
+
+func isSQLiteForeignKeyViolation(err error) bool {
+	var sqliteErr sqlite3.Error
+	return errors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == sqlite3.ErrConstraintForeignKey
+}
@@ -0,0 +1,79 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type txKey struct{}
+
+// querier is the subset of pgxpool.Pool and pgx.Tx used by the repos
+type querier interface {
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+// conn returns the transaction stored in ctx by WithinTx or the pool
+// if the call is not part of a transaction.
+func conn(ctx context.Context, db *pgxpool.Pool) querier {
+	if tx, ok := ctx.Value(txKey{}).(pgx.Tx); ok {
+		return tx
+	}
+
+	return db
+}
+
+// TxManager implementation
+type PostgresTxManager struct {
+	db     *pgxpool.Pool
+	logger *slog.Logger
+}
+
+func NewTxManager(db *pgxpool.Pool, logger *slog.Logger) *PostgresTxManager {
+	return &PostgresTxManager{db: db, logger: logger}
+}
+
+func (m *PostgresTxManager) WithinTx(ctx context.Context, fn func(ctx context.Context) error) (err error) {
+	const op = "/internal/repository/tx/WithinTx"
+
+	if _, ok := ctx.Value(txKey{}).(pgx.Tx); ok {
+		return fn(ctx)
+	}
+
+	tx, err := m.db.Begin(ctx)
+	if err != nil {
+		m.logger.Error("cannot begin transaction", "op", op, "error", err)
+		return fmt.Errorf("cannot begin transaction: %w", err)
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			_ = tx.Rollback(ctx)
+			panic(p)
+		}
+
+		if err != nil {
+			if rbErr := tx.Rollback(ctx); rbErr != nil && !errors.Is(rbErr, pgx.ErrTxClosed) {
+				m.logger.Error("cannot rollback transaction", "op", op, "error", rbErr)
+			}
+		}
+	}()
+
+	if err = fn(context.WithValue(ctx, txKey{}, tx)); err != nil {
+		return err
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		m.logger.Error("cannot commit transaction", "op", op, "error", err)
+		return fmt.Errorf("cannot commit transaction: %w", err)
+	}
+
+	return nil
+}
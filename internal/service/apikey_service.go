@@ -0,0 +1,138 @@
+package service
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/437d5/merch-store/internal/apikey"
+)
+
+var ErrInvalidAPIKey = errors.New("invalid api key")
+
+const (
+	// APIKeyPrefix starts every API key, so keys are told apart from
+	// JWTs in the Authorization header and found by secret scanners
+	APIKeyPrefix = "mk_"
+
+	apiKeyLen = 32
+	// displayed part of a key, enough to recognize it in a list
+	apiKeyShownLen = len(APIKeyPrefix) + 8
+	// last use is written at most once per apiKeyTouchInterval
+	apiKeyTouchInterval = time.Minute
+)
+
+type APIKeyService struct {
+	keyRepo apikey.KeyRepo
+	logger  *slog.Logger
+}
+
+func NewAPIKeyService(keyRepo apikey.KeyRepo, logger *slog.Logger) *APIKeyService {
+	return &APIKeyService{
+		keyRepo: keyRepo,
+		logger:  logger,
+	}
+}
+
+// Create makes a new key for the user. The returned raw key is not
+// stored and cannot be shown again.
+func (s *APIKeyService) Create(
+	ctx context.Context, userId int, name string, scopes []string,
+) (apikey.Key, string, error) {
+	const op = "/internal/service/apikey_service/Create"
+
+	if err := apikey.ValidateName(name); err != nil {
+		return apikey.Key{}, "", err
+	}
+
+	parsed, err := apikey.ParseScopes(scopes)
+	if err != nil {
+		return apikey.Key{}, "", err
+	}
+
+	secret, err := randomString(apiKeyLen, base64.RawURLEncoding.EncodeToString)
+	if err != nil {
+		s.logger.Error("cannot create api key", "op", op, "error", err)
+		return apikey.Key{}, "", fmt.Errorf("cannot create api key: %w", err)
+	}
+	raw := APIKeyPrefix + secret
+
+	key, err := s.keyRepo.CreateKey(ctx, apikey.Key{
+		UserId: userId,
+		Name:   name,
+		Prefix: raw[:apiKeyShownLen],
+		Hash:   hashToken(raw),
+		Scopes: parsed,
+	})
+	if err != nil {
+		s.logger.Error("cannot create api key", "op", op, "error", err)
+		return apikey.Key{}, "", fmt.Errorf("cannot create api key: %w", err)
+	}
+
+	s.logger.Info("api key created", "op", op, "userId", userId, "keyId", key.Id)
+	return key, raw, nil
+}
+
+func (s *APIKeyService) List(ctx context.Context, userId int) ([]apikey.Key, error) {
+	const op = "/internal/service/apikey_service/List"
+
+	kList, err := s.keyRepo.ListKeys(ctx, userId)
+	if err != nil {
+		s.logger.Error("cannot list api keys", "op", op, "error", err)
+		return nil, fmt.Errorf("cannot list api keys: %w", err)
+	}
+
+	return kList, nil
+}
+
+func (s *APIKeyService) Revoke(ctx context.Context, userId, id int) error {
+	const op = "/internal/service/apikey_service/Revoke"
+
+	err := s.keyRepo.RevokeKey(ctx, userId, id)
+	if err != nil {
+		if errors.Is(err, apikey.ErrKeyNotFound) {
+			return err
+		}
+		s.logger.Error("cannot revoke api key", "op", op, "error", err)
+		return fmt.Errorf("cannot revoke api key: %w", err)
+	}
+
+	s.logger.Info("api key revoked", "op", op, "userId", userId, "keyId", id)
+	return nil
+}
+
+// Authenticate returns the key matching raw and records its use.
+func (s *APIKeyService) Authenticate(ctx context.Context, raw string) (apikey.Key, error) {
+	const op = "/internal/service/apikey_service/Authenticate"
+
+	if !IsAPIKey(raw) {
+		return apikey.Key{}, ErrInvalidAPIKey
+	}
+
+	key, err := s.keyRepo.GetKeyByHash(ctx, hashToken(raw))
+	if err != nil {
+		if errors.Is(err, apikey.ErrKeyNotFound) {
+			return apikey.Key{}, ErrInvalidAPIKey
+		}
+		s.logger.Error("cannot authenticate api key", "op", op, "error", err)
+		return apikey.Key{}, fmt.Errorf("cannot authenticate api key: %w", err)
+	}
+
+	now := time.Now()
+	if key.LastUsedAt == nil || now.Sub(*key.LastUsedAt) >= apiKeyTouchInterval {
+		// a lost usage update must not fail the request
+		if err = s.keyRepo.TouchKey(ctx, key.Id, now); err == nil {
+			key.LastUsedAt = &now
+		}
+	}
+
+	return key, nil
+}
+
+func IsAPIKey(t string) bool {
+	return strings.HasPrefix(t, APIKeyPrefix)
+}
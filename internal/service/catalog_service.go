@@ -0,0 +1,160 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"github.com/437d5/merch-store/internal/items"
+	"github.com/437d5/merch-store/internal/txmanager"
+)
+
+// AuditLogLimit caps the number of catalog changes returned at once
+const AuditLogLimit = 100
+
+// CatalogService manages the merch catalog. Every change is written to
+// the audit trail in the same transaction as the change itself.
+type CatalogService struct {
+	itemRepo  items.ItemRepo
+	txManager txmanager.TxManager
+	logger    *slog.Logger
+}
+
+func NewCatalogService(
+	itemRepo items.ItemRepo, txManager txmanager.TxManager, logger *slog.Logger,
+) *CatalogService {
+	return &CatalogService{
+		itemRepo:  itemRepo,
+		txManager: txManager,
+		logger:    logger,
+	}
+}
+
+func (s *CatalogService) CreateItem(ctx context.Context, actorId int, name string, cost int) (items.ItemType, error) {
+	const op = "/internal/service/catalog_service/CreateItem"
+
+	if err := items.ValidateName(name); err != nil {
+		return items.ItemType{}, err
+	}
+	if err := items.ValidateCost(cost); err != nil {
+		return items.ItemType{}, err
+	}
+
+	item := items.ItemType{Name: name, Cost: cost, Available: true}
+
+	err := s.txManager.WithinTx(ctx, func(ctx context.Context) error {
+		if err := s.itemRepo.CreateItem(ctx, item); err != nil {
+			return err
+		}
+
+		return s.itemRepo.RecordChange(ctx, items.Change{
+			ActorId:  actorId,
+			Action:   items.ActionCreate,
+			ItemName: name,
+			After:    &item,
+		})
+	})
+	if err != nil {
+		s.logger.Error("cannot create item", "op", op, "error", err)
+		return items.ItemType{}, fmt.Errorf("cannot create item: %w", err)
+	}
+
+	s.logger.Info("item created", "op", op, "item", name, "actorId", actorId)
+	return item, nil
+}
+
+func (s *CatalogService) UpdatePrice(ctx context.Context, actorId int, name string, cost int) (items.ItemType, error) {
+	if err := items.ValidateCost(cost); err != nil {
+		return items.ItemType{}, err
+	}
+
+	return s.modify(ctx, actorId, name, items.ActionReprice, func(item *items.ItemType) error {
+		item.Cost = cost
+		return nil
+	})
+}
+
+func (s *CatalogService) RenameItem(ctx context.Context, actorId int, name, newName string) (items.ItemType, error) {
+	if err := items.ValidateName(newName); err != nil {
+		return items.ItemType{}, err
+	}
+
+	return s.modify(ctx, actorId, name, items.ActionRename, func(item *items.ItemType) error {
+		item.Name = newName
+		return nil
+	})
+}
+
+// RetireItem hides the item from purchase without removing it from the catalog.
+func (s *CatalogService) RetireItem(ctx context.Context, actorId int, name string) (items.ItemType, error) {
+	return s.modify(ctx, actorId, name, items.ActionRetire, func(item *items.ItemType) error {
+		item.Available = false
+		return nil
+	})
+}
+
+func (s *CatalogService) RestoreItem(ctx context.Context, actorId int, name string) (items.ItemType, error) {
+	return s.modify(ctx, actorId, name, items.ActionRestore, func(item *items.ItemType) error {
+		// items kept by migrations without a known price have to be
+		// repriced before they go on sale
+		if err := items.ValidateCost(item.Cost); err != nil {
+			return err
+		}
+		item.Available = true
+		return nil
+	})
+}
+
+func (s *CatalogService) ListChanges(ctx context.Context) ([]items.Change, error) {
+	const op = "/internal/service/catalog_service/ListChanges"
+
+	cList, err := s.itemRepo.ListChanges(ctx, AuditLogLimit)
+	if err != nil {
+		s.logger.Error("failed list catalog changes", "op", op, "error", err)
+		return nil, fmt.Errorf("failed to list catalog changes: %w", err)
+	}
+
+	return cList, nil
+}
+
+// modify locks the item, applies fn to it and records the change. An
+// error of fn leaves the item as it was.
+func (s *CatalogService) modify(
+	ctx context.Context, actorId int, name string,
+	action items.Action, fn func(item *items.ItemType) error,
+) (items.ItemType, error) {
+	const op = "/internal/service/catalog_service/modify"
+
+	var after items.ItemType
+
+	err := s.txManager.WithinTx(ctx, func(ctx context.Context) error {
+		before, err := s.itemRepo.GetItemByNameForUpdate(ctx, name)
+		if err != nil {
+			return err
+		}
+
+		after = before
+		if err = fn(&after); err != nil {
+			return err
+		}
+
+		if err = s.itemRepo.UpdateItem(ctx, name, after); err != nil {
+			return err
+		}
+
+		return s.itemRepo.RecordChange(ctx, items.Change{
+			ActorId:  actorId,
+			Action:   action,
+			ItemName: after.Name,
+			Before:   &before,
+			After:    &after,
+		})
+	})
+	if err != nil {
+		s.logger.Error("cannot modify item", "op", op, "action", action, "error", err)
+		return items.ItemType{}, fmt.Errorf("cannot %s item: %w", action, err)
+	}
+
+	s.logger.Info("item modified", "op", op, "action", action, "item", name, "actorId", actorId)
+	return after, nil
+}
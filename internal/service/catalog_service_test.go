@@ -0,0 +1,35 @@
+package service_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/437d5/merch-store/internal/items"
+)
+
+func TestRestoreItem(t *testing.T) {
+	ctx := context.Background()
+	env := newTestEnv(t, nil, false)
+	admin := env.register(t, "admin")
+
+	// a holding kept by the user_items migration without a known price
+	if err := env.repos.Items.CreateItem(ctx, items.ItemType{Name: "old-pen", Cost: -1}); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+
+	if _, err := env.catalog.RestoreItem(ctx, admin.Id, "old-pen"); !errors.Is(err, items.ErrInvalidItemCost) {
+		t.Fatalf("restore without a price: got %v, want %v", err, items.ErrInvalidItemCost)
+	}
+	if item, err := env.repos.Items.GetItemByName(ctx, "old-pen"); err != nil || item.Available {
+		t.Errorf("after failed restore = %+v, %v, want a retired item", item, err)
+	}
+
+	if _, err := env.catalog.UpdatePrice(ctx, admin.Id, "old-pen", 10); err != nil {
+		t.Fatalf("update price: %v", err)
+	}
+	item, err := env.catalog.RestoreItem(ctx, admin.Id, "old-pen")
+	if err != nil || !item.Available || item.Cost != 10 {
+		t.Errorf("restore = %+v, %v, want an item on sale for 10", item, err)
+	}
+}
@@ -0,0 +1,125 @@
+package service_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/437d5/merch-store/internal/repository"
+	"github.com/437d5/merch-store/internal/service"
+	"github.com/437d5/merch-store/internal/user"
+	"github.com/437d5/merch-store/pkg/password"
+	"github.com/437d5/merch-store/pkg/token"
+)
+
+// testEnv wires the services under test to one in-memory store.
+type testEnv struct {
+	repos        repository.Repos
+	users        *service.UserService
+	keys         *token.KeySet
+	sessions     *service.SessionService
+	apiKeys      *service.APIKeyService
+	passwords    *service.PasswordService
+	market       *service.MarketService
+	catalog      *service.CatalogService
+	transactions *service.TransactionService
+}
+
+func newTestEnv(t *testing.T, admins []string, autoRegister bool) testEnv {
+	t.Helper()
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	repos := repository.NewMemoryRepos(logger)
+	// the lowest bcrypt cost keeps the tests fast
+	hasher := password.NewHasher(password.Bcrypt{Cost: 4})
+	keys := token.NewKeySet("test", []byte("test-secret"))
+	sessions := service.NewSessionService(
+		repos.RefreshTokens, repos.Revocations, repos.Keys, repos.Users, repos.TxManager, keys,
+		time.Minute, time.Hour, time.Minute, logger,
+	)
+
+	return testEnv{
+		repos:    repos,
+		keys:     keys,
+		sessions: sessions,
+		apiKeys:  service.NewAPIKeyService(repos.Keys, logger),
+		passwords: service.NewPasswordService(
+			repos.Users, repos.PasswordResets, repos.TxManager, hasher, sessions,
+			time.Hour, logger,
+		),
+		users: service.NewUserService(
+			repos.Users, repos.Ledger, repos.Identities, repos.TxManager, hasher,
+			sessions, admins, autoRegister, logger,
+		),
+		market: service.NewMarketService(
+			repos.Users, logger, repos.Items, repos.Purchases, repos.Inventory,
+			repos.Ledger, repos.TxManager,
+		),
+		catalog: service.NewCatalogService(repos.Items, repos.TxManager, logger),
+		transactions: service.NewTransactionService(
+			repos.Transactions, repos.Users, repos.Ledger, repos.TxManager, logger,
+		),
+	}
+}
+
+// register creates a user with the signup grant or fails the test.
+func (e testEnv) register(t *testing.T, name string) user.User {
+	t.Helper()
+
+	u, err := e.users.Register(context.Background(), name, "password")
+	if err != nil {
+		t.Fatalf("register %s: %v", name, err)
+	}
+
+	return u
+}
+
+// balance returns the coins of the user or fails the test.
+func (e testEnv) balance(t *testing.T, userId int) int {
+	t.Helper()
+
+	u, err := e.users.UserInfo(context.Background(), userId)
+	if err != nil {
+		t.Fatalf("get user %d: %v", userId, err)
+	}
+
+	return u.Coins
+}
+
+// checkLedger fails the test if the ledger does not reconcile.
+func (e testEnv) checkLedger(t *testing.T) {
+	t.Helper()
+
+	report, err := e.repos.Ledger.Reconcile(context.Background())
+	if err != nil {
+		t.Fatalf("reconcile: %v", err)
+	}
+	if !report.Balanced() {
+		t.Errorf("ledger out of balance: %+v", report)
+	}
+}
+
+// apiKey creates a key of the user and returns a func that reports
+// whether the key still authenticates.
+func (e testEnv) apiKey(t *testing.T, userId int) func() bool {
+	t.Helper()
+
+	_, raw, err := e.apiKeys.Create(context.Background(), userId, "bot", []string{"read:info"})
+	if err != nil {
+		t.Fatalf("create api key: %v", err)
+	}
+
+	return func() bool {
+		t.Helper()
+
+		_, err := e.apiKeys.Authenticate(context.Background(), raw)
+		if err != nil && !errors.Is(err, service.ErrInvalidAPIKey) {
+			t.Fatalf("authenticate: %v", err)
+		}
+
+		return err == nil
+	}
+}
@@ -0,0 +1,169 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/437d5/merch-store/internal/idempotency"
+	"github.com/437d5/merch-store/internal/txmanager"
+)
+
+var (
+	ErrIdempotencyConflict   = errors.New("idempotency key reused with different request")
+	ErrIdempotencyInProgress = errors.New("request with this idempotency key is in progress")
+)
+
+type IdempotencyService struct {
+	idempotencyRepo idempotency.IdempotencyRepo
+	txManager       txmanager.TxManager
+	retention       time.Duration
+	logger          *slog.Logger
+}
+
+func NewIdempotencyService(
+	idempotencyRepo idempotency.IdempotencyRepo, txManager txmanager.TxManager,
+	retention time.Duration, logger *slog.Logger,
+) *IdempotencyService {
+	return &IdempotencyService{
+		idempotencyRepo: idempotencyRepo,
+		txManager:       txManager,
+		retention:       retention,
+		logger:          logger,
+	}
+}
+
+// errRequestFailed rolls back a request that was not answered with success
+var errRequestFailed = errors.New("request failed")
+
+// Do runs a request sent with key at most once. If the key was already
+// used for the same request, the stored record is returned with replay
+// set to true and run is not called.
+//
+// Otherwise run is called in a transaction that also stores its
+// response, so the effects of the request are committed together with
+// the record and a retry either replays it or runs on a clean slate. A
+// response with a status of 400 or above rolls the transaction back.
+// Client errors are stored afterwards and replayed, server errors are
+// not stored, so the request can be retried with the same key. When the
+// response cannot be stored the request is rolled back and an error is
+// returned, the caller must not send the response of run then.
+func (s *IdempotencyService) Do(
+	ctx context.Context, userId int, key, fingerprint string,
+	run func(ctx context.Context) (statusCode int, response []byte),
+) (rec idempotency.Record, replay bool, err error) {
+	const op = "/internal/service/idempotency_service/Do"
+
+	// a second attempt is needed when a concurrent request with the key
+	// committed between the lookup and the insert
+	for attempt := 0; attempt < 2; attempt++ {
+		rec, replay, err = s.lookup(ctx, userId, key, fingerprint)
+		if err != nil || replay {
+			return rec, replay, err
+		}
+
+		rec = idempotency.Record{UserId: userId, Key: key, Fingerprint: fingerprint}
+		err = s.txManager.WithinTx(ctx, func(ctx context.Context) error {
+			// a concurrent request with the key waits here until the
+			// transaction holding it finishes
+			if err := s.idempotencyRepo.CreateRecord(ctx, rec); err != nil {
+				return err
+			}
+
+			rec.StatusCode, rec.Response = run(ctx)
+			if rec.StatusCode >= http.StatusBadRequest {
+				return errRequestFailed
+			}
+
+			return s.idempotencyRepo.CompleteRecord(ctx, userId, key, rec.StatusCode, rec.Response)
+		})
+
+		switch {
+		case err == nil:
+			return rec, false, nil
+		case errors.Is(err, idempotency.ErrKeyExists):
+			continue
+		case errors.Is(err, errRequestFailed):
+			if rec.StatusCode < http.StatusInternalServerError {
+				s.storeFailure(ctx, rec)
+			}
+			return rec, false, nil
+		default:
+			s.logger.Error("cannot save idempotent response", "op", op, "error", err)
+			return idempotency.Record{}, false, fmt.Errorf("cannot save idempotent response: %w", err)
+		}
+	}
+
+	s.logger.Error("cannot reserve idempotency key", "op", op, "userId", userId)
+	return idempotency.Record{}, false, ErrIdempotencyInProgress
+}
+
+// lookup returns the record stored for key, replay is false when the
+// request has to be run.
+func (s *IdempotencyService) lookup(
+	ctx context.Context, userId int, key, fingerprint string,
+) (rec idempotency.Record, replay bool, err error) {
+	const op = "/internal/service/idempotency_service/lookup"
+
+	rec, err = s.idempotencyRepo.GetRecord(ctx, userId, key)
+	if errors.Is(err, idempotency.ErrRecordNotFound) {
+		return idempotency.Record{}, false, nil
+	}
+	if err != nil {
+		s.logger.Error("cannot get idempotency record", "op", op, "error", err)
+		return idempotency.Record{}, false, fmt.Errorf("cannot get idempotency record: %w", err)
+	}
+
+	if rec.CreatedAt.Before(time.Now().Add(-s.retention)) {
+		if err = s.idempotencyRepo.DeleteRecord(ctx, userId, key); err != nil {
+			s.logger.Error("cannot drop expired idempotency key", "op", op, "error", err)
+			return idempotency.Record{}, false, fmt.Errorf("cannot drop expired idempotency key: %w", err)
+		}
+		return idempotency.Record{}, false, nil
+	}
+
+	if rec.Fingerprint != fingerprint {
+		s.logger.Warn("idempotency key conflict", "op", op, "userId", userId)
+		return idempotency.Record{}, false, ErrIdempotencyConflict
+	}
+
+	// the request may have committed without its response, it must not
+	// run again under this key
+	if rec.StatusCode == 0 {
+		return idempotency.Record{}, false, ErrIdempotencyInProgress
+	}
+
+	return rec, true, nil
+}
+
+// storeFailure stores a client error after its request was rolled back.
+// The request had no effect, so a failure here only costs the replay.
+func (s *IdempotencyService) storeFailure(ctx context.Context, rec idempotency.Record) {
+	const op = "/internal/service/idempotency_service/storeFailure"
+
+	err := s.txManager.WithinTx(ctx, func(ctx context.Context) error {
+		if err := s.idempotencyRepo.CreateRecord(ctx, rec); err != nil {
+			return err
+		}
+		return s.idempotencyRepo.CompleteRecord(ctx, rec.UserId, rec.Key, rec.StatusCode, rec.Response)
+	})
+	if err != nil && !errors.Is(err, idempotency.ErrKeyExists) {
+		s.logger.Warn("cannot save idempotent response", "op", op, "error", err)
+	}
+}
+
+// Cleanup removes records older than the retention window.
+func (s *IdempotencyService) Cleanup(ctx context.Context) (int64, error) {
+	const op = "/internal/service/idempotency_service/Cleanup"
+
+	n, err := s.idempotencyRepo.DeleteExpired(ctx, time.Now().Add(-s.retention))
+	if err != nil {
+		s.logger.Error("cannot cleanup idempotency keys", "op", op, "error", err)
+		return 0, fmt.Errorf("cannot cleanup idempotency keys: %w", err)
+	}
+
+	return n, nil
+}
@@ -0,0 +1,170 @@
+package service_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/437d5/merch-store/internal/idempotency"
+	"github.com/437d5/merch-store/internal/repository"
+	"github.com/437d5/merch-store/internal/service"
+	"github.com/437d5/merch-store/internal/user"
+)
+
+// failingCompletion loses every response stored with CompleteRecord
+type failingCompletion struct {
+	idempotency.IdempotencyRepo
+}
+
+func (failingCompletion) CompleteRecord(context.Context, int, string, int, []byte) error {
+	return errors.New("connection lost")
+}
+
+func newIdempotencyService(repo func(repository.Repos) idempotency.IdempotencyRepo) (*service.IdempotencyService, repository.Repos) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	repos := repository.NewMemoryRepos(logger)
+
+	return service.NewIdempotencyService(repo(repos), repos.TxManager, time.Hour, logger), repos
+}
+
+func defaultRepo(repos repository.Repos) idempotency.IdempotencyRepo {
+	return repos.Idempotency
+}
+
+// createUser returns a request that registers name and answers with status.
+func createUser(repos repository.Repos, name string, status int, runs *atomic.Int32) func(context.Context) (int, []byte) {
+	return func(ctx context.Context) (int, []byte) {
+		runs.Add(1)
+		if _, err := repos.Users.CreateUser(ctx, user.User{Name: name, Password: "hash"}); err != nil {
+			return 500, nil
+		}
+		return status, []byte(`{"name":"` + name + `"}`)
+	}
+}
+
+func userExists(t *testing.T, repos repository.Repos, name string) bool {
+	t.Helper()
+
+	_, err := repos.Users.GetUserByName(context.Background(), name)
+	if err != nil && !errors.Is(err, user.ErrUserNotFound) {
+		t.Fatalf("get user %s: %v", name, err)
+	}
+
+	return err == nil
+}
+
+func TestIdempotencyReplay(t *testing.T) {
+	ctx := context.Background()
+	idem, repos := newIdempotencyService(defaultRepo)
+	var runs atomic.Int32
+
+	rec, replay, err := idem.Do(ctx, 1, "key", "request", createUser(repos, "alice", 200, &runs))
+	if replay || err != nil || rec.StatusCode != 200 || string(rec.Response) != `{"name":"alice"}` {
+		t.Fatalf("do = %+v, %v, %v, want the response of the request", rec, replay, err)
+	}
+
+	rec, replay, err = idem.Do(ctx, 1, "key", "request", createUser(repos, "alice", 200, &runs))
+	if !replay || err != nil || rec.StatusCode != 200 || string(rec.Response) != `{"name":"alice"}` {
+		t.Errorf("retry = %+v, %v, %v, want a replay", rec, replay, err)
+	}
+	if n := runs.Load(); n != 1 {
+		t.Errorf("request ran %d times, want 1", n)
+	}
+
+	if _, _, err = idem.Do(ctx, 1, "key", "other", createUser(repos, "bob", 200, &runs)); !errors.Is(err, service.ErrIdempotencyConflict) {
+		t.Errorf("do other request: got %v, want %v", err, service.ErrIdempotencyConflict)
+	}
+
+	// keys are per user
+	if _, replay, err = idem.Do(ctx, 2, "key", "request", createUser(repos, "bob", 200, &runs)); replay || err != nil {
+		t.Errorf("do for another user = %v, %v, want a run", replay, err)
+	}
+}
+
+func TestIdempotencyFailures(t *testing.T) {
+	ctx := context.Background()
+	idem, repos := newIdempotencyService(defaultRepo)
+	var runs atomic.Int32
+
+	// a client error rolls the request back and is replayed
+	rec, replay, err := idem.Do(ctx, 1, "bad", "request", createUser(repos, "alice", 400, &runs))
+	if replay || err != nil || rec.StatusCode != 400 {
+		t.Fatalf("do = %+v, %v, %v, want the client error", rec, replay, err)
+	}
+	if userExists(t, repos, "alice") {
+		t.Error("a request answered with 400 was committed")
+	}
+	rec, replay, err = idem.Do(ctx, 1, "bad", "request", createUser(repos, "alice", 200, &runs))
+	if !replay || err != nil || rec.StatusCode != 400 {
+		t.Errorf("retry = %+v, %v, %v, want a replay of the client error", rec, replay, err)
+	}
+
+	// a server error is rolled back and not stored
+	if rec, _, err = idem.Do(ctx, 1, "down", "request", createUser(repos, "bob", 503, &runs)); err != nil || rec.StatusCode != 503 {
+		t.Fatalf("do = %+v, %v, want the server error", rec, err)
+	}
+	if userExists(t, repos, "bob") {
+		t.Error("a request answered with 503 was committed")
+	}
+	rec, replay, err = idem.Do(ctx, 1, "down", "request", createUser(repos, "bob", 200, &runs))
+	if replay || err != nil || rec.StatusCode != 200 || !userExists(t, repos, "bob") {
+		t.Errorf("retry = %+v, %v, %v, want a new run", rec, replay, err)
+	}
+}
+
+func TestIdempotencyLostResponse(t *testing.T) {
+	ctx := context.Background()
+	idem, repos := newIdempotencyService(func(repos repository.Repos) idempotency.IdempotencyRepo {
+		return failingCompletion{repos.Idempotency}
+	})
+	var runs atomic.Int32
+
+	_, _, err := idem.Do(ctx, 1, "key", "request", createUser(repos, "alice", 200, &runs))
+	if err == nil {
+		t.Fatal("do succeeded without storing the response")
+	}
+	// the request is undone with its response, a retry runs it anew
+	if userExists(t, repos, "alice") {
+		t.Error("the request was committed without its response")
+	}
+	if _, err = repos.Idempotency.GetRecord(ctx, 1, "key"); !errors.Is(err, idempotency.ErrRecordNotFound) {
+		t.Errorf("get record: got %v, want %v", err, idempotency.ErrRecordNotFound)
+	}
+}
+
+func TestIdempotencyConcurrent(t *testing.T) {
+	ctx := context.Background()
+	idem, repos := newIdempotencyService(defaultRepo)
+	var runs atomic.Int32
+
+	const workers = 8
+	var wg sync.WaitGroup
+	replays := make([]bool, workers)
+	errs := make([]error, workers)
+	for i := range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, replays[i], errs[i] = idem.Do(ctx, 1, "key", "request", createUser(repos, "alice", 200, &runs))
+		}()
+	}
+	wg.Wait()
+
+	replayed := 0
+	for i, err := range errs {
+		if err != nil {
+			t.Errorf("do: %v", err)
+		}
+		if replays[i] {
+			replayed++
+		}
+	}
+	if n := runs.Load(); n != 1 || replayed != workers-1 {
+		t.Errorf("request ran %d times and was replayed %d times, want 1 and %d", n, replayed, workers-1)
+	}
+}
@@ -0,0 +1,47 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"github.com/437d5/merch-store/internal/ledger"
+)
+
+type LedgerService struct {
+	ledgerRepo ledger.LedgerRepo
+	logger     *slog.Logger
+}
+
+func NewLedgerService(ledgerRepo ledger.LedgerRepo, logger *slog.Logger) *LedgerService {
+	return &LedgerService{
+		ledgerRepo: ledgerRepo,
+		logger:     logger,
+	}
+}
+
+// Reconcile checks that the books balance and logs every discrepancy.
+func (s *LedgerService) Reconcile(ctx context.Context) (ledger.Report, error) {
+	const op = "/internal/service/ledger_service/Reconcile"
+
+	report, err := s.ledgerRepo.Reconcile(ctx)
+	if err != nil {
+		s.logger.Error("failed to reconcile ledger", "op", op, "error", err)
+		return ledger.Report{}, fmt.Errorf("failed to reconcile ledger: %w", err)
+	}
+
+	for _, id := range report.UnbalancedEntries {
+		s.logger.Error("unbalanced ledger entry", "op", op, "entryId", id)
+	}
+	for _, m := range report.Mismatches {
+		s.logger.Error(
+			"account balance mismatch", "op", op, "accountId", m.AccountId,
+			"cached", m.Cached, "posted", m.Posted,
+		)
+	}
+	if report.Total != 0 {
+		s.logger.Error("ledger total is not zero", "op", op, "total", report.Total)
+	}
+
+	return report, nil
+}
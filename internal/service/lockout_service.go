@@ -0,0 +1,222 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/437d5/merch-store/internal/lockout"
+	"github.com/437d5/merch-store/internal/txmanager"
+)
+
+// LockoutListLimit caps the number of entries returned to admins
+const LockoutListLimit = 100
+
+// LockoutPolicy sets how many failed logins are allowed before a
+// lockout. Every further failure doubles the lockout, starting at
+// BaseDelay and capped at MaxLockout. Failures older than MaxLockout
+// are forgotten.
+type LockoutPolicy struct {
+	UserLimit  int
+	IPLimit    int
+	BaseDelay  time.Duration
+	MaxLockout time.Duration
+}
+
+func (p LockoutPolicy) limit(scope lockout.Scope) int {
+	if scope == lockout.ScopeIP {
+		return p.IPLimit
+	}
+	return p.UserLimit
+}
+
+func (p LockoutPolicy) delay(failures, limit int) time.Duration {
+	if failures <= limit {
+		return 0
+	}
+
+	d := p.BaseDelay
+	for i := limit + 1; i < failures && d < p.MaxLockout; i++ {
+		d *= 2
+	}
+
+	return min(d, p.MaxLockout)
+}
+
+type LockoutService struct {
+	lockoutRepo lockout.LockoutRepo
+	txManager   txmanager.TxManager
+	policy      LockoutPolicy
+	logger      *slog.Logger
+}
+
+func NewLockoutService(
+	lockoutRepo lockout.LockoutRepo, txManager txmanager.TxManager,
+	policy LockoutPolicy, logger *slog.Logger,
+) *LockoutService {
+	return &LockoutService{
+		lockoutRepo: lockoutRepo,
+		txManager:   txManager,
+		policy:      policy,
+		logger:      logger,
+	}
+}
+
+// errLoginLocked rolls back an attempt made during a lockout
+var errLoginLocked = errors.New("login locked")
+
+// Attempt counts a login for username from ip as failed before its
+// password is compared and returns how long the login must wait, zero
+// when it may proceed. Counting up front keeps concurrent guesses from
+// all passing before the first failure is recorded. A refused attempt is
+// not counted, an attempt with the right password is taken back by
+// Succeed.
+func (s *LockoutService) Attempt(ctx context.Context, username, ip string) (time.Duration, error) {
+	const op = "/internal/service/lockout_service/Attempt"
+
+	now := time.Now()
+	var wait time.Duration
+
+	err := s.txManager.WithinTx(ctx, func(ctx context.Context) error {
+		for _, k := range s.keys(username, ip) {
+			// the upsert holds the row until the transaction ends, so
+			// concurrent attempts are counted one after another
+			failures, err := s.lockoutRepo.RecordFailure(
+				ctx, k.scope, k.key, now, now.Add(-s.policy.MaxLockout),
+			)
+			if err != nil {
+				return err
+			}
+
+			l, err := s.lockoutRepo.GetLockout(ctx, k.scope, k.key)
+			if err != nil {
+				return err
+			}
+
+			if l.LockedUntil != nil && l.LockedUntil.After(now) {
+				wait = max(wait, l.LockedUntil.Sub(now))
+				continue
+			}
+
+			// the attempt over the limit may still proceed, the ones
+			// after it wait for the lock
+			d := s.policy.delay(failures, s.policy.limit(k.scope))
+			if d == 0 {
+				continue
+			}
+
+			if err = s.lockoutRepo.LockUntil(ctx, k.scope, k.key, now.Add(d)); err != nil {
+				return err
+			}
+			s.logger.Warn("login locked", "op", op, "scope", k.scope, "key", k.key,
+				"failures", failures, "for", d)
+		}
+
+		if wait > 0 {
+			return errLoginLocked
+		}
+		return nil
+	})
+	if errors.Is(err, errLoginLocked) {
+		return wait, nil
+	}
+	if err != nil {
+		s.logger.Error("cannot record login attempt", "op", op, "error", err)
+		return 0, fmt.Errorf("cannot record login attempt: %w", err)
+	}
+
+	return 0, nil
+}
+
+// Succeed ends an attempt that had the right password. The failures of
+// username are forgotten and the attempt is no longer counted for ip.
+// Other failures of the address are kept, otherwise a valid account
+// would let an attacker reset them.
+func (s *LockoutService) Succeed(ctx context.Context, username, ip string) error {
+	const op = "/internal/service/lockout_service/Succeed"
+
+	err := s.txManager.WithinTx(ctx, func(ctx context.Context) error {
+		err := s.lockoutRepo.DeleteLockout(ctx, lockout.ScopeUser, username)
+		if err != nil && !errors.Is(err, lockout.ErrLockoutNotFound) {
+			return err
+		}
+
+		return s.lockoutRepo.ForgetFailure(ctx, lockout.ScopeIP, ip)
+	})
+	if err != nil {
+		s.logger.Error("cannot reset failed logins", "op", op, "error", err)
+		return fmt.Errorf("cannot reset failed logins: %w", err)
+	}
+
+	return nil
+}
+
+// ResetUser forgets the failures of username, e.g. after the password
+// was reset by an admin.
+func (s *LockoutService) ResetUser(ctx context.Context, username string) error {
+	const op = "/internal/service/lockout_service/ResetUser"
+
+	err := s.lockoutRepo.DeleteLockout(ctx, lockout.ScopeUser, username)
+	if err != nil && !errors.Is(err, lockout.ErrLockoutNotFound) {
+		s.logger.Error("cannot reset failed logins", "op", op, "error", err)
+		return fmt.Errorf("cannot reset failed logins: %w", err)
+	}
+
+	return nil
+}
+
+func (s *LockoutService) ListLockouts(ctx context.Context) ([]lockout.Lockout, error) {
+	const op = "/internal/service/lockout_service/ListLockouts"
+
+	lList, err := s.lockoutRepo.ListLockouts(ctx, LockoutListLimit)
+	if err != nil {
+		s.logger.Error("cannot list lockouts", "op", op, "error", err)
+		return nil, fmt.Errorf("cannot list lockouts: %w", err)
+	}
+
+	return lList, nil
+}
+
+func (s *LockoutService) Unlock(ctx context.Context, scope lockout.Scope, key string) error {
+	const op = "/internal/service/lockout_service/Unlock"
+
+	err := s.lockoutRepo.DeleteLockout(ctx, scope, key)
+	if err != nil {
+		if errors.Is(err, lockout.ErrLockoutNotFound) {
+			return err
+		}
+		s.logger.Error("cannot unlock", "op", op, "error", err)
+		return fmt.Errorf("cannot unlock: %w", err)
+	}
+
+	return nil
+}
+
+// Cleanup removes lockouts that no longer affect logins.
+func (s *LockoutService) Cleanup(ctx context.Context) (int64, error) {
+	const op = "/internal/service/lockout_service/Cleanup"
+
+	n, err := s.lockoutRepo.DeleteStaleLockouts(ctx, time.Now().Add(-s.policy.MaxLockout))
+	if err != nil {
+		s.logger.Error("cannot cleanup lockouts", "op", op, "error", err)
+		return 0, fmt.Errorf("cannot cleanup lockouts: %w", err)
+	}
+
+	return n, nil
+}
+
+type lockoutKey struct {
+	scope lockout.Scope
+	key   string
+}
+
+// keys returns the counters of a login attempt, always in the same
+// order so concurrent attempts lock the rows without deadlocks.
+func (s *LockoutService) keys(username, ip string) []lockoutKey {
+	return []lockoutKey{
+		{scope: lockout.ScopeUser, key: username},
+		{scope: lockout.ScopeIP, key: ip},
+	}
+}
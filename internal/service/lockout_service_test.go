@@ -0,0 +1,104 @@
+package service_test
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/437d5/merch-store/internal/lockout"
+	"github.com/437d5/merch-store/internal/repository"
+	"github.com/437d5/merch-store/internal/service"
+)
+
+func newLockoutService(policy service.LockoutPolicy) (*service.LockoutService, repository.Repos) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	repos := repository.NewMemoryRepos(logger)
+
+	return service.NewLockoutService(repos.Lockouts, repos.TxManager, policy, logger), repos
+}
+
+var testPolicy = service.LockoutPolicy{
+	UserLimit:  3,
+	IPLimit:    10,
+	BaseDelay:  time.Minute,
+	MaxLockout: time.Hour,
+}
+
+func TestLockoutAttempts(t *testing.T) {
+	ctx := context.Background()
+	lockouts, _ := newLockoutService(testPolicy)
+
+	// the attempt over the limit proceeds and locks the ones after it
+	for i := range testPolicy.UserLimit + 1 {
+		if wait, err := lockouts.Attempt(ctx, "alice", "10.0.0.1"); wait != 0 || err != nil {
+			t.Fatalf("attempt %d = %v, %v, want it to proceed", i+1, wait, err)
+		}
+	}
+
+	wait, err := lockouts.Attempt(ctx, "alice", "10.0.0.2")
+	if err != nil || wait <= 0 || wait > testPolicy.BaseDelay {
+		t.Errorf("attempt after the limit = %v, %v, want a wait up to %v", wait, err, testPolicy.BaseDelay)
+	}
+
+	// other users from the address are not locked
+	if wait, err = lockouts.Attempt(ctx, "bob", "10.0.0.1"); wait != 0 || err != nil {
+		t.Errorf("attempt of another user = %v, %v, want it to proceed", wait, err)
+	}
+}
+
+func TestLockoutConcurrentAttempts(t *testing.T) {
+	ctx := context.Background()
+	lockouts, _ := newLockoutService(testPolicy)
+
+	const guesses = 20
+	var wg sync.WaitGroup
+	waits := make([]time.Duration, guesses)
+	for i := range guesses {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var err error
+			if waits[i], err = lockouts.Attempt(ctx, "alice", "10.0.0.1"); err != nil {
+				t.Errorf("attempt: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	// parallel guesses are counted before any password is compared
+	proceeded := 0
+	for _, wait := range waits {
+		if wait == 0 {
+			proceeded++
+		}
+	}
+	if proceeded != testPolicy.UserLimit+1 {
+		t.Errorf("%d of %d parallel guesses proceeded, want %d", proceeded, guesses, testPolicy.UserLimit+1)
+	}
+}
+
+func TestLockoutSucceed(t *testing.T) {
+	ctx := context.Background()
+	lockouts, repos := newLockoutService(testPolicy)
+
+	for range 2 {
+		if _, err := lockouts.Attempt(ctx, "alice", "10.0.0.1"); err != nil {
+			t.Fatalf("attempt: %v", err)
+		}
+	}
+	if err := lockouts.Succeed(ctx, "alice", "10.0.0.1"); err != nil {
+		t.Fatalf("succeed: %v", err)
+	}
+
+	if _, err := repos.Lockouts.GetLockout(ctx, lockout.ScopeUser, "alice"); err == nil {
+		t.Error("failures of the user survived a login")
+	}
+	// the right password is taken back, the earlier guess is kept
+	l, err := repos.Lockouts.GetLockout(ctx, lockout.ScopeIP, "10.0.0.1")
+	if err != nil || l.Failures != 1 {
+		t.Errorf("address = %+v, %v, want 1 failure", l, err)
+	}
+}
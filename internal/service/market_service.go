@@ -1,62 +1,183 @@
 package service
 
 import (
+	"cmp"
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
+	"slices"
 
 	"github.com/437d5/merch-store/internal/inventory"
 	"github.com/437d5/merch-store/internal/items"
+	"github.com/437d5/merch-store/internal/ledger"
+	"github.com/437d5/merch-store/internal/purchases"
+	"github.com/437d5/merch-store/internal/txmanager"
 	"github.com/437d5/merch-store/internal/user"
 )
 
+var (
+	ErrItemUnavailable = errors.New("item is not available")
+	ErrInvalidSort     = errors.New("invalid sort order")
+)
+
+// Catalog sort orders accepted by ListItems
+const (
+	SortByName      = ""
+	SortByPrice     = "price"
+	SortByPriceDesc = "-price"
+)
+
 type MarketService struct {
-	userRepo user.UserRepo
-	itemRepo items.ItemRepo
-	logger   *slog.Logger
+	userRepo      user.UserRepo
+	itemRepo      items.ItemRepo
+	purchaseRepo  purchases.PurchaseRepo
+	inventoryRepo inventory.InventoryRepo
+	ledgerRepo    ledger.LedgerRepo
+	txManager     txmanager.TxManager
+	logger        *slog.Logger
 }
 
 func NewMarketService(
 	userRepo user.UserRepo, logger *slog.Logger, itemRepo items.ItemRepo,
+	purchaseRepo purchases.PurchaseRepo, inventoryRepo inventory.InventoryRepo,
+	ledgerRepo ledger.LedgerRepo, txManager txmanager.TxManager,
 ) *MarketService {
 	return &MarketService{
-		userRepo: userRepo,
-		itemRepo: itemRepo,
-		logger:   logger,
+		userRepo:      userRepo,
+		itemRepo:      itemRepo,
+		purchaseRepo:  purchaseRepo,
+		inventoryRepo: inventoryRepo,
+		ledgerRepo:    ledgerRepo,
+		txManager:     txManager,
+		logger:        logger,
 	}
 }
 
 func (s *MarketService) BuyMerch(ctx context.Context, userId int, itemType string) error {
 	const op = "/internal/service/market_service/BuyMerch"
 
-	user, err := s.userRepo.GetUserByID(ctx, userId)
+	return s.txManager.WithinTx(ctx, func(ctx context.Context) error {
+		// a reprice or retirement waits for the purchase to commit,
+		// so the item is sold at the price it has while being bought
+		itemCard, err := s.itemRepo.GetItemByNameForShare(ctx, itemType)
+		if err != nil {
+			if errors.Is(err, items.ErrItemNotFound) {
+				s.logger.Warn("item not found", "op", op, "item", itemType)
+				return err
+			}
+			s.logger.Error("cannot find item", "op", op, "error", err)
+			return fmt.Errorf("cannot find item: %w", err)
+		}
+
+		if !itemCard.Available {
+			s.logger.Warn("item is not available", "op", op, "item", itemType)
+			return ErrItemUnavailable
+		}
+
+		// the row stays locked until commit, so parallel purchases
+		// see each other's debits instead of overwriting them
+		_, err = s.userRepo.GetUserByIDForUpdate(ctx, userId)
+		if err != nil {
+			s.logger.Error("cannot find user", "op", op, "error", err)
+			return fmt.Errorf("cannot find user: %w", err)
+		}
+
+		wallet, err := s.ledgerRepo.GetWallet(ctx, userId)
+		if err != nil {
+			s.logger.Error("cannot find wallet", "op", op, "error", err)
+			return fmt.Errorf("cannot find wallet: %w", err)
+		}
+
+		revenue, err := s.ledgerRepo.GetSystemAccount(ctx, ledger.AccountRevenue)
+		if err != nil {
+			s.logger.Error("cannot find revenue account", "op", op, "error", err)
+			return fmt.Errorf("cannot find revenue account: %w", err)
+		}
+
+		_, err = s.ledgerRepo.PostEntry(ctx, ledger.NewMovement(
+			ledger.EntryPurchase, wallet.Id, revenue.Id, itemCard.Cost,
+		))
+		if err != nil {
+			if errors.Is(err, ledger.ErrInsufficientFunds) {
+				s.logger.Error("cannot buy item", "op", op, "error", ErrNotEnoughCoins)
+				return fmt.Errorf("cannot buy item: %w", ErrNotEnoughCoins)
+			}
+			s.logger.Error("cannot post purchase", "op", op, "error", err)
+			return fmt.Errorf("cannot post purchase: %w", err)
+		}
+
+		_, err = s.inventoryRepo.IncrementItem(ctx, userId, itemType, 1)
+		if err != nil {
+			s.logger.Error("cannot add item to inventory", "op", op, "error", err)
+			return fmt.Errorf("cannot add item to inventory: %w", err)
+		}
+
+		err = s.purchaseRepo.CreatePurchase(ctx, purchases.Purchase{
+			UserId:   userId,
+			ItemName: itemCard.Name,
+			Cost:     itemCard.Cost,
+		})
+		if err != nil {
+			s.logger.Error("cannot record purchase", "op", op, "error", err)
+			return fmt.Errorf("cannot record purchase: %w", err)
+		}
+
+		return nil
+	})
+}
+
+// RecentPurchases returns the latest purchases of the user, at most
+// RecentPurchasesLimit of them.
+func (s *MarketService) RecentPurchases(
+	ctx context.Context, userId int,
+) ([]purchases.Purchase, error) {
+	const op = "/internal/service/market_service/RecentPurchases"
+
+	pList, err := s.purchaseRepo.GetPurchasesByUser(ctx, userId, RecentPurchasesLimit)
 	if err != nil {
-		s.logger.Error("cannot find user", "op", op, "error", err)
-		return fmt.Errorf("cannot find user: %w", err)
+		s.logger.Error("failed get purchases", "op", op, "error", err)
+		return nil, fmt.Errorf("failed to get purchases: %w", err)
 	}
 
-	itemCard, err := s.itemRepo.GetItemByName(ctx, itemType)
+	return pList, nil
+}
+
+func (s *MarketService) GetInventory(ctx context.Context, userId int) (inventory.Inventory, error) {
+	const op = "/internal/service/market_service/GetInventory"
+
+	inv, err := s.inventoryRepo.GetInventory(ctx, userId)
 	if err != nil {
-		s.logger.Error("cannot find item", "op", op, "error", err)
-		return fmt.Errorf("cannot find item: %w", err)
+		s.logger.Error("failed get inventory", "op", op, "error", err)
+		return inventory.Inventory{}, fmt.Errorf("failed to get inventory: %w", err)
 	}
 
-	if user.Coins < itemCard.Cost {
-		s.logger.Error("cannot buy item", "op", op, "error", ErrNotEnoughCoins)
-		return fmt.Errorf("cannot buy item: %w", ErrNotEnoughCoins)
-	}
+	return inv, nil
+}
 
-	user.Coins = user.Coins - itemCard.Cost
-	user.Inventory.AddItem(inventory.Item{
-		ItemType: itemType,
-		Quantity: 1,
-	})
+// ListItems returns the whole catalog sorted by name or by price.
+func (s *MarketService) ListItems(ctx context.Context, sortBy string) ([]items.ItemType, error) {
+	const op = "/internal/service/market_service/ListItems"
 
-	err = s.userRepo.UpdateUser(ctx, user)
+	iList, err := s.itemRepo.ListItems(ctx)
 	if err != nil {
-		s.logger.Error("cannot update user", "op", op, "error", err)
-		return fmt.Errorf("cannot update user: %w", err)
+		s.logger.Error("failed list items", "op", op, "error", err)
+		return nil, fmt.Errorf("failed to list items: %w", err)
+	}
+
+	switch sortBy {
+	case SortByName:
+	case SortByPrice:
+		slices.SortStableFunc(iList, func(a, b items.ItemType) int {
+			return cmp.Compare(a.Cost, b.Cost)
+		})
+	case SortByPriceDesc:
+		slices.SortStableFunc(iList, func(a, b items.ItemType) int {
+			return cmp.Compare(b.Cost, a.Cost)
+		})
+	default:
+		return nil, ErrInvalidSort
 	}
 
-	return nil
+	return iList, nil
 }
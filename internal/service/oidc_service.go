@@ -0,0 +1,127 @@
+package service
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/437d5/merch-store/internal/identity"
+	"github.com/437d5/merch-store/internal/sso"
+	"golang.org/x/oauth2"
+)
+
+var ErrInvalidLoginState = errors.New("invalid or expired login state")
+
+// OIDCService runs the authorization code flow with PKCE against the
+// configured OpenID Connect provider.
+type OIDCService struct {
+	provider  *sso.Provider
+	stateRepo identity.LoginStateRepo
+	stateTTL  time.Duration
+	logger    *slog.Logger
+}
+
+func NewOIDCService(
+	provider *sso.Provider, stateRepo identity.LoginStateRepo,
+	stateTTL time.Duration, logger *slog.Logger,
+) *OIDCService {
+	return &OIDCService{
+		provider:  provider,
+		stateRepo: stateRepo,
+		stateTTL:  stateTTL,
+		logger:    logger,
+	}
+}
+
+// Begin stores a new login state and returns the provider URL the user
+// is redirected to. The binding must be kept by the browser the user
+// signs in with and handed back to Complete.
+func (s *OIDCService) Begin(ctx context.Context, loginHint string) (url, binding string, err error) {
+	const op = "/internal/service/oidc_service/Begin"
+
+	state, err := randomString(16, hex.EncodeToString)
+	if err != nil {
+		s.logger.Error("cannot begin login", "op", op, "error", err)
+		return "", "", fmt.Errorf("cannot begin login: %w", err)
+	}
+
+	nonce, err := randomString(16, hex.EncodeToString)
+	if err != nil {
+		s.logger.Error("cannot begin login", "op", op, "error", err)
+		return "", "", fmt.Errorf("cannot begin login: %w", err)
+	}
+
+	binding, err = randomString(32, hex.EncodeToString)
+	if err != nil {
+		s.logger.Error("cannot begin login", "op", op, "error", err)
+		return "", "", fmt.Errorf("cannot begin login: %w", err)
+	}
+
+	ls := identity.LoginState{
+		State:       state,
+		Verifier:    oauth2.GenerateVerifier(),
+		Nonce:       nonce,
+		BindingHash: hashToken(binding),
+		ExpiresAt:   time.Now().Add(s.stateTTL),
+	}
+
+	if err = s.stateRepo.CreateLoginState(ctx, ls); err != nil {
+		s.logger.Error("cannot begin login", "op", op, "error", err)
+		return "", "", fmt.Errorf("cannot begin login: %w", err)
+	}
+
+	return s.provider.AuthCodeURL(ls.State, ls.Verifier, ls.Nonce, loginHint), binding, nil
+}
+
+// Complete redeems the code the provider redirected back with and
+// returns the verified identity of the user. binding is the value Begin
+// returned for the state, a login finished in another browser fails.
+func (s *OIDCService) Complete(ctx context.Context, state, binding, code string) (sso.Claims, error) {
+	const op = "/internal/service/oidc_service/Complete"
+
+	ls, err := s.stateRepo.TakeLoginState(ctx, state)
+	if err != nil {
+		if errors.Is(err, identity.ErrLoginStateNotFound) {
+			s.logger.Warn("unknown login state", "op", op)
+			return sso.Claims{}, ErrInvalidLoginState
+		}
+		s.logger.Error("cannot complete login", "op", op, "error", err)
+		return sso.Claims{}, fmt.Errorf("cannot complete login: %w", err)
+	}
+
+	if time.Now().After(ls.ExpiresAt) {
+		s.logger.Warn("expired login state", "op", op)
+		return sso.Claims{}, ErrInvalidLoginState
+	}
+
+	// the state is taken either way, a leaked callback URL cannot be
+	// tried again
+	if ls.BindingHash == "" || subtle.ConstantTimeCompare([]byte(hashToken(binding)), []byte(ls.BindingHash)) != 1 {
+		s.logger.Warn("login state used by another browser", "op", op)
+		return sso.Claims{}, ErrInvalidLoginState
+	}
+
+	claims, err := s.provider.Exchange(ctx, code, ls.Verifier, ls.Nonce)
+	if err != nil {
+		s.logger.Warn("cannot complete login", "op", op, "error", err)
+		return sso.Claims{}, fmt.Errorf("cannot complete login: %w", err)
+	}
+
+	return claims, nil
+}
+
+func (s *OIDCService) Cleanup(ctx context.Context) (int64, error) {
+	const op = "/internal/service/oidc_service/Cleanup"
+
+	n, err := s.stateRepo.DeleteExpiredLoginStates(ctx, time.Now())
+	if err != nil {
+		s.logger.Error("cannot clean up login states", "op", op, "error", err)
+		return 0, fmt.Errorf("cannot clean up login states: %w", err)
+	}
+
+	return n, nil
+}
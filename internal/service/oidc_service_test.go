@@ -0,0 +1,104 @@
+package service_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/437d5/merch-store/internal/repository"
+	"github.com/437d5/merch-store/internal/service"
+	"github.com/437d5/merch-store/internal/sso"
+	"github.com/437d5/merch-store/internal/sso/mockidp"
+)
+
+func newOIDCService(t *testing.T) *service.OIDCService {
+	t.Helper()
+
+	_, srv, err := mockidp.NewServer("merch-store", "secret")
+	if err != nil {
+		t.Fatalf("start mock idp: %v", err)
+	}
+	t.Cleanup(srv.Close)
+
+	provider, err := sso.NewProvider(context.Background(), sso.Config{
+		Issuer:       srv.URL,
+		ClientID:     "merch-store",
+		ClientSecret: "secret",
+		RedirectURL:  "http://localhost:8080/api/oidc/callback",
+	})
+	if err != nil {
+		t.Fatalf("new provider: %v", err)
+	}
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	repos := repository.NewMemoryRepos(logger)
+
+	return service.NewOIDCService(provider, repos.LoginStates, time.Minute, logger)
+}
+
+// signIn follows the provider URL and returns the state and code the
+// provider redirects back with.
+func signIn(t *testing.T, authURL string) (state, code string) {
+	t.Helper()
+
+	client := &http.Client{
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	resp, err := client.Get(authURL)
+	if err != nil {
+		t.Fatalf("authorize: %v", err)
+	}
+	resp.Body.Close()
+
+	loc, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatalf("parse redirect: %v", err)
+	}
+
+	return loc.Query().Get("state"), loc.Query().Get("code")
+}
+
+func TestOIDCLogin(t *testing.T) {
+	ctx := context.Background()
+	oidc := newOIDCService(t)
+
+	authURL, binding, err := oidc.Begin(ctx, "alice")
+	if err != nil {
+		t.Fatalf("begin: %v", err)
+	}
+
+	state, code := signIn(t, authURL)
+	claims, err := oidc.Complete(ctx, state, binding, code)
+	if err != nil {
+		t.Fatalf("complete: %v", err)
+	}
+	if claims.Username != "alice" {
+		t.Errorf("username = %q, want alice", claims.Username)
+	}
+}
+
+func TestOIDCLoginBinding(t *testing.T) {
+	ctx := context.Background()
+	oidc := newOIDCService(t)
+
+	for _, binding := range []string{"", "other"} {
+		authURL, _, err := oidc.Begin(ctx, "alice")
+		if err != nil {
+			t.Fatalf("begin: %v", err)
+		}
+
+		// a state and code leaked from the browser that began the login
+		state, code := signIn(t, authURL)
+		if _, err = oidc.Complete(ctx, state, binding, code); !errors.Is(err, service.ErrInvalidLoginState) {
+			t.Errorf("complete with binding %q: got %v, want %v", binding, err, service.ErrInvalidLoginState)
+		}
+	}
+}
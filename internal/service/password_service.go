@@ -0,0 +1,193 @@
+package service
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/437d5/merch-store/internal/txmanager"
+	"github.com/437d5/merch-store/internal/user"
+	"github.com/437d5/merch-store/pkg/password"
+)
+
+var ErrInvalidResetToken = errors.New("invalid password reset token")
+
+const resetTokenLen = 32
+
+type PasswordService struct {
+	userRepo  user.UserRepo
+	resetRepo user.PasswordResetRepo
+	txManager txmanager.TxManager
+	hasher    *password.Hasher
+	sessions  *SessionService
+	resetTTL  time.Duration
+	logger    *slog.Logger
+}
+
+func NewPasswordService(
+	userRepo user.UserRepo, resetRepo user.PasswordResetRepo,
+	txManager txmanager.TxManager, hasher *password.Hasher, sessions *SessionService,
+	resetTTL time.Duration, logger *slog.Logger,
+) *PasswordService {
+	return &PasswordService{
+		userRepo:  userRepo,
+		resetRepo: resetRepo,
+		txManager: txManager,
+		hasher:    hasher,
+		sessions:  sessions,
+		resetTTL:  resetTTL,
+		logger:    logger,
+	}
+}
+
+// ChangePassword sets a new password for the user after checking the
+// current one and ends all sessions of the user.
+func (s *PasswordService) ChangePassword(
+	ctx context.Context, userId int, current, password string,
+) (user.User, error) {
+	const op = "/internal/service/password_service/ChangePassword"
+
+	u, err := s.userRepo.GetUserByID(ctx, userId)
+	if err != nil {
+		s.logger.Error("failed get user", "op", op, "userId", userId, "error", err)
+		return user.User{}, fmt.Errorf("failed get user: %w", err)
+	}
+
+	if !u.CheckPassword(s.hasher, current) {
+		s.logger.Warn("invalid current password", "op", op, "userId", userId)
+		return user.User{}, ErrInvalidPassword
+	}
+
+	if err = user.ValidatePassword(password); err != nil {
+		return user.User{}, err
+	}
+
+	if err = s.setPassword(ctx, &u, password); err != nil {
+		s.logger.Error("cannot change password", "op", op, "error", err)
+		return user.User{}, fmt.Errorf("cannot change password: %w", err)
+	}
+
+	s.logger.Info("password changed", "op", op, "userId", userId)
+	return u, nil
+}
+
+// IssueReset creates a one-time token that lets the named user set a
+// new password without knowing the current one.
+func (s *PasswordService) IssueReset(
+	ctx context.Context, name string, actorId int,
+) (string, time.Time, error) {
+	const op = "/internal/service/password_service/IssueReset"
+
+	u, err := s.userRepo.GetUserByName(ctx, name)
+	if err != nil {
+		if errors.Is(err, user.ErrUserNotFound) {
+			return "", time.Time{}, err
+		}
+		s.logger.Error("failed get user", "op", op, "error", err)
+		return "", time.Time{}, fmt.Errorf("cannot issue password reset: %w", err)
+	}
+
+	raw, err := randomString(resetTokenLen, base64.RawURLEncoding.EncodeToString)
+	if err != nil {
+		s.logger.Error("cannot issue password reset", "op", op, "error", err)
+		return "", time.Time{}, fmt.Errorf("cannot issue password reset: %w", err)
+	}
+
+	expiresAt := time.Now().Add(s.resetTTL)
+
+	err = s.resetRepo.CreatePasswordReset(ctx, user.PasswordReset{
+		UserId:    u.Id,
+		Hash:      hashToken(raw),
+		CreatedBy: actorId,
+		ExpiresAt: expiresAt,
+	})
+	if err != nil {
+		s.logger.Error("cannot issue password reset", "op", op, "error", err)
+		return "", time.Time{}, fmt.Errorf("cannot issue password reset: %w", err)
+	}
+
+	s.logger.Info("password reset issued", "op", op, "name", name, "by", actorId)
+	return raw, expiresAt, nil
+}
+
+// ResetPassword redeems a reset token issued by IssueReset and ends all
+// sessions of the user.
+func (s *PasswordService) ResetPassword(
+	ctx context.Context, resetToken, password string,
+) (user.User, error) {
+	const op = "/internal/service/password_service/ResetPassword"
+
+	if err := user.ValidatePassword(password); err != nil {
+		return user.User{}, err
+	}
+
+	var u user.User
+
+	err := s.txManager.WithinTx(ctx, func(ctx context.Context) error {
+		reset, err := s.resetRepo.GetPasswordResetForUpdate(ctx, hashToken(resetToken))
+		if err != nil {
+			if errors.Is(err, user.ErrResetTokenNotFound) {
+				return ErrInvalidResetToken
+			}
+			return err
+		}
+
+		if reset.UsedAt != nil || time.Now().After(reset.ExpiresAt) {
+			return ErrInvalidResetToken
+		}
+
+		u, err = s.userRepo.GetUserByID(ctx, reset.UserId)
+		if err != nil {
+			return err
+		}
+
+		if err = s.setPassword(ctx, &u, password); err != nil {
+			return err
+		}
+
+		return s.resetRepo.MarkPasswordResetUsed(ctx, reset.Id)
+	})
+	if err != nil {
+		if errors.Is(err, ErrInvalidResetToken) {
+			s.logger.Warn("invalid password reset token", "op", op)
+			return user.User{}, err
+		}
+		s.logger.Error("cannot reset password", "op", op, "error", err)
+		return user.User{}, fmt.Errorf("cannot reset password: %w", err)
+	}
+
+	s.logger.Info("password reset", "op", op, "userId", u.Id)
+	return u, nil
+}
+
+// Cleanup removes reset tokens that expired.
+func (s *PasswordService) Cleanup(ctx context.Context) (int64, error) {
+	const op = "/internal/service/password_service/Cleanup"
+
+	n, err := s.resetRepo.DeleteExpiredPasswordResets(ctx, time.Now())
+	if err != nil {
+		s.logger.Error("cannot cleanup password resets", "op", op, "error", err)
+		return 0, fmt.Errorf("cannot cleanup password resets: %w", err)
+	}
+
+	return n, nil
+}
+
+// setPassword stores the new password and revokes the sessions of u in
+// one transaction, a session must not outlive the password it began with.
+func (s *PasswordService) setPassword(ctx context.Context, u *user.User, password string) error {
+	if err := u.SetPassword(s.hasher, password); err != nil {
+		return err
+	}
+
+	return s.txManager.WithinTx(ctx, func(ctx context.Context) error {
+		if err := s.userRepo.UpdatePassword(ctx, u.Id, u.Password); err != nil {
+			return err
+		}
+
+		return s.sessions.RevokeUserSessions(ctx, u.Id)
+	})
+}
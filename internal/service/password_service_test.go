@@ -0,0 +1,87 @@
+package service_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/437d5/merch-store/internal/service"
+	"github.com/437d5/merch-store/internal/user"
+)
+
+func TestChangePassword(t *testing.T) {
+	ctx := context.Background()
+	env := newTestEnv(t, nil, false)
+	u := env.register(t, "alice")
+	keyValid := env.apiKey(t, u.Id)
+
+	tokens, err := env.sessions.Login(ctx, u)
+	if err != nil {
+		t.Fatalf("login: %v", err)
+	}
+
+	_, err = env.passwords.ChangePassword(ctx, u.Id, "wrong-password", "new-password")
+	if !errors.Is(err, service.ErrInvalidPassword) {
+		t.Errorf("wrong current password: got %v, want %v", err, service.ErrInvalidPassword)
+	}
+	_, err = env.passwords.ChangePassword(ctx, u.Id, "password", "short")
+	if !errors.Is(err, user.ErrWeakPassword) {
+		t.Errorf("weak password: got %v, want %v", err, user.ErrWeakPassword)
+	}
+
+	// failed changes leave the sessions alone
+	if tokens, err = env.sessions.Refresh(ctx, tokens.Refresh); err != nil {
+		t.Fatalf("refresh after failed changes: %v", err)
+	}
+	if !keyValid() {
+		t.Fatal("api key revoked by failed changes")
+	}
+
+	if _, err = env.passwords.ChangePassword(ctx, u.Id, "password", "new-password"); err != nil {
+		t.Fatalf("change password: %v", err)
+	}
+
+	if _, err = env.sessions.Refresh(ctx, tokens.Refresh); !errors.Is(err, service.ErrInvalidRefreshToken) {
+		t.Errorf("refresh after change: got %v, want %v", err, service.ErrInvalidRefreshToken)
+	}
+	if keyValid() {
+		t.Error("api key survived the change")
+	}
+	if _, err = env.users.AuthUser(ctx, "alice", "new-password"); err != nil {
+		t.Errorf("auth with the new password: %v", err)
+	}
+}
+
+func TestResetPassword(t *testing.T) {
+	ctx := context.Background()
+	env := newTestEnv(t, nil, false)
+	u := env.register(t, "alice")
+	admin := env.register(t, "boss")
+	keyValid := env.apiKey(t, u.Id)
+
+	tokens, err := env.sessions.Login(ctx, u)
+	if err != nil {
+		t.Fatalf("login: %v", err)
+	}
+
+	reset, _, err := env.passwords.IssueReset(ctx, "alice", admin.Id)
+	if err != nil {
+		t.Fatalf("issue reset: %v", err)
+	}
+
+	if _, err = env.passwords.ResetPassword(ctx, reset, "new-password"); err != nil {
+		t.Fatalf("reset password: %v", err)
+	}
+
+	if _, err = env.sessions.Refresh(ctx, tokens.Refresh); !errors.Is(err, service.ErrInvalidRefreshToken) {
+		t.Errorf("refresh after reset: got %v, want %v", err, service.ErrInvalidRefreshToken)
+	}
+	if keyValid() {
+		t.Error("api key survived the reset")
+	}
+
+	// reset tokens are single use
+	if _, err = env.passwords.ResetPassword(ctx, reset, "other-password"); !errors.Is(err, service.ErrInvalidResetToken) {
+		t.Errorf("second reset: got %v, want %v", err, service.ErrInvalidResetToken)
+	}
+}
@@ -0,0 +1,76 @@
+package service
+
+import (
+	"sync"
+	"time"
+)
+
+type revocationEntry struct {
+	userId    int
+	issuedAt  time.Time
+	expiresAt time.Time
+	revoked   bool
+	checkedAt time.Time
+}
+
+// revocationCache saves a database lookup per authenticated request.
+// Revoked tokens are remembered until they expire, tokens found valid
+// are trusted for ttl, so revocations made by other instances take
+// effect within ttl.
+type revocationCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	entries map[string]revocationEntry
+}
+
+func newRevocationCache(ttl time.Duration) *revocationCache {
+	return &revocationCache{
+		ttl:     ttl,
+		entries: make(map[string]revocationEntry),
+	}
+}
+
+func (c *revocationCache) get(jti string, now time.Time) (revoked bool, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[jti]
+	if !ok {
+		return false, false
+	}
+	if e.revoked {
+		return true, true
+	}
+
+	return false, now.Sub(e.checkedAt) < c.ttl
+}
+
+func (c *revocationCache) set(jti string, e revocationEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries[jti] = e
+}
+
+func (c *revocationCache) revokeUser(userId int, before time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for jti, e := range c.entries {
+		if e.userId == userId && e.issuedAt.Before(before) {
+			e.revoked = true
+			c.entries[jti] = e
+		}
+	}
+}
+
+func (c *revocationCache) purge(now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for jti, e := range c.entries {
+		if now.After(e.expiresAt) {
+			delete(c.entries, jti)
+		}
+	}
+}
@@ -0,0 +1,329 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/437d5/merch-store/internal/apikey"
+	"github.com/437d5/merch-store/internal/session"
+	"github.com/437d5/merch-store/internal/txmanager"
+	"github.com/437d5/merch-store/internal/user"
+	"github.com/437d5/merch-store/pkg/token"
+)
+
+var (
+	ErrInvalidRefreshToken = errors.New("invalid refresh token")
+	ErrRefreshTokenReused  = errors.New("refresh token reused")
+)
+
+const refreshTokenLen = 32
+
+// Tokens is a pair of a short lived JWT access token and an opaque
+// refresh token used to get the next pair.
+type Tokens struct {
+	Access  string
+	Refresh string
+}
+
+type SessionService struct {
+	refreshRepo    session.RefreshTokenRepo
+	revocationRepo session.RevocationRepo
+	keyRepo        apikey.KeyRepo
+	userRepo       user.UserRepo
+	txManager      txmanager.TxManager
+	keys           *token.KeySet
+	accessTTL      time.Duration
+	refreshTTL     time.Duration
+	revoked        *revocationCache
+	logger         *slog.Logger
+}
+
+func NewSessionService(
+	refreshRepo session.RefreshTokenRepo, revocationRepo session.RevocationRepo,
+	keyRepo apikey.KeyRepo, userRepo user.UserRepo, txManager txmanager.TxManager, keys *token.KeySet,
+	accessTTL, refreshTTL, revocationCacheTTL time.Duration, logger *slog.Logger,
+) *SessionService {
+	return &SessionService{
+		refreshRepo:    refreshRepo,
+		revocationRepo: revocationRepo,
+		keyRepo:        keyRepo,
+		userRepo:       userRepo,
+		txManager:      txManager,
+		keys:           keys,
+		accessTTL:      accessTTL,
+		refreshTTL:     refreshTTL,
+		revoked:        newRevocationCache(revocationCacheTTL),
+		logger:         logger,
+	}
+}
+
+// Login starts a new session for an authenticated user.
+func (s *SessionService) Login(ctx context.Context, u user.User) (Tokens, error) {
+	const op = "/internal/service/session_service/Login"
+
+	familyId, err := randomString(16, hex.EncodeToString)
+	if err != nil {
+		s.logger.Error("cannot start session", "op", op, "error", err)
+		return Tokens{}, fmt.Errorf("cannot start session: %w", err)
+	}
+
+	return s.issue(ctx, u, familyId)
+}
+
+// Refresh exchanges a refresh token for a new pair. Every refresh token
+// can be used once; presenting a used one again revokes the whole
+// session since either the client or an attacker holds a stolen copy.
+func (s *SessionService) Refresh(ctx context.Context, refreshToken string) (Tokens, error) {
+	const op = "/internal/service/session_service/Refresh"
+
+	var tokens Tokens
+	var reused bool
+
+	err := s.txManager.WithinTx(ctx, func(ctx context.Context) error {
+		rt, err := s.refreshRepo.GetRefreshTokenForUpdate(ctx, hashToken(refreshToken))
+		if err != nil {
+			if errors.Is(err, session.ErrRefreshTokenNotFound) {
+				return ErrInvalidRefreshToken
+			}
+			return err
+		}
+
+		if rt.RevokedAt != nil || time.Now().After(rt.ExpiresAt) {
+			return ErrInvalidRefreshToken
+		}
+
+		if rt.UsedAt != nil {
+			// the revocation must be committed, so the tx is not failed here
+			reused = true
+			return s.refreshRepo.RevokeFamily(ctx, rt.FamilyId)
+		}
+
+		if err = s.refreshRepo.MarkRefreshTokenUsed(ctx, rt.Id); err != nil {
+			return err
+		}
+
+		u, err := s.userRepo.GetUserByID(ctx, rt.UserId)
+		if err != nil {
+			return err
+		}
+
+		tokens, err = s.issue(ctx, u, rt.FamilyId)
+		return err
+	})
+	if err != nil {
+		if errors.Is(err, ErrInvalidRefreshToken) {
+			s.logger.Warn("invalid refresh token", "op", op)
+			return Tokens{}, err
+		}
+		s.logger.Error("cannot refresh session", "op", op, "error", err)
+		return Tokens{}, fmt.Errorf("cannot refresh session: %w", err)
+	}
+
+	if reused {
+		s.logger.Warn("refresh token reuse detected, session revoked", "op", op)
+		return Tokens{}, ErrRefreshTokenReused
+	}
+
+	return tokens, nil
+}
+
+// Logout revokes the access token described by claims together with
+// the refresh tokens of its session.
+func (s *SessionService) Logout(ctx context.Context, claims *token.TokenClaims) error {
+	const op = "/internal/service/session_service/Logout"
+
+	expiresAt := time.Now().Add(s.accessTTL)
+	if claims.ExpiresAt != nil {
+		expiresAt = claims.ExpiresAt.Time
+	}
+
+	err := s.txManager.WithinTx(ctx, func(ctx context.Context) error {
+		if claims.ID != "" {
+			err := s.revocationRepo.RevokeToken(ctx, claims.ID, claims.Id, expiresAt)
+			if err != nil {
+				return err
+			}
+		}
+
+		if claims.SessionId != "" {
+			return s.refreshRepo.RevokeFamily(ctx, claims.SessionId)
+		}
+
+		return nil
+	})
+	if err != nil {
+		s.logger.Error("cannot logout", "op", op, "error", err)
+		return fmt.Errorf("cannot logout: %w", err)
+	}
+
+	if claims.ID != "" {
+		s.revoked.set(claims.ID, revocationEntry{
+			userId:    claims.Id,
+			expiresAt: expiresAt,
+			revoked:   true,
+		})
+	}
+
+	return nil
+}
+
+// RevokeUser ends every session of the user, access tokens issued so
+// far are rejected and refresh tokens can no longer be used. The API
+// keys of the user are revoked with them.
+func (s *SessionService) RevokeUser(ctx context.Context, name string) error {
+	const op = "/internal/service/session_service/RevokeUser"
+
+	u, err := s.userRepo.GetUserByName(ctx, name)
+	if err != nil {
+		if errors.Is(err, user.ErrUserNotFound) {
+			return err
+		}
+		s.logger.Error("cannot get user", "op", op, "error", err)
+		return fmt.Errorf("cannot revoke sessions: %w", err)
+	}
+
+	if err = s.RevokeUserSessions(ctx, u.Id); err != nil {
+		s.logger.Error("cannot revoke sessions", "op", op, "error", err)
+		return fmt.Errorf("cannot revoke sessions: %w", err)
+	}
+
+	return nil
+}
+
+// RevokeUserSessions is RevokeUser for the user with userId. Called
+// inside TxManager.WithinTx it commits or rolls back with the caller's
+// transaction; a rolled back revocation may still end the sessions this
+// process has cached.
+func (s *SessionService) RevokeUserSessions(ctx context.Context, userId int) error {
+	// iat and the stored cutoff have a precision of a microsecond, the
+	// cutoff is rounded up so tokens issued in the microsecond of the
+	// call are revoked as well
+	cutoff := time.Now().Truncate(time.Microsecond).Add(time.Microsecond)
+
+	err := s.txManager.WithinTx(ctx, func(ctx context.Context) error {
+		if err := s.revocationRepo.RevokeUserTokens(ctx, userId, cutoff); err != nil {
+			return err
+		}
+
+		if err := s.refreshRepo.RevokeUserRefreshTokens(ctx, userId); err != nil {
+			return err
+		}
+
+		return s.keyRepo.RevokeUserKeys(ctx, userId)
+	})
+	if err != nil {
+		return err
+	}
+
+	s.revoked.revokeUser(userId, cutoff)
+
+	return nil
+}
+
+// IsRevoked reports whether the access token described by claims was
+// revoked by Logout or RevokeUser.
+func (s *SessionService) IsRevoked(ctx context.Context, claims *token.TokenClaims) (bool, error) {
+	const op = "/internal/service/session_service/IsRevoked"
+
+	now := time.Now()
+
+	if claims.ID != "" {
+		if revoked, ok := s.revoked.get(claims.ID, now); ok {
+			return revoked, nil
+		}
+	}
+
+	var issuedAt, expiresAt time.Time
+	if claims.IssuedAt != nil {
+		issuedAt = claims.IssuedAt.Time
+	}
+	if claims.ExpiresAt != nil {
+		expiresAt = claims.ExpiresAt.Time
+	}
+
+	revoked, err := s.revocationRepo.IsRevoked(ctx, claims.ID, claims.Id, issuedAt)
+	if err != nil {
+		s.logger.Error("cannot check revocation", "op", op, "error", err)
+		return false, fmt.Errorf("cannot check revocation: %w", err)
+	}
+
+	if claims.ID != "" {
+		s.revoked.set(claims.ID, revocationEntry{
+			userId:    claims.Id,
+			issuedAt:  issuedAt,
+			expiresAt: expiresAt,
+			revoked:   revoked,
+			checkedAt: now,
+		})
+	}
+
+	return revoked, nil
+}
+
+// Cleanup removes refresh tokens and revocations that expired.
+func (s *SessionService) Cleanup(ctx context.Context) (int64, error) {
+	const op = "/internal/service/session_service/Cleanup"
+
+	now := time.Now()
+	s.revoked.purge(now)
+
+	n, err := s.refreshRepo.DeleteExpiredRefreshTokens(ctx, now)
+	if err != nil {
+		s.logger.Error("cannot cleanup refresh tokens", "op", op, "error", err)
+		return 0, fmt.Errorf("cannot cleanup refresh tokens: %w", err)
+	}
+
+	// every token issued before now-accessTTL has expired by now
+	m, err := s.revocationRepo.DeleteExpiredRevocations(ctx, now, now.Add(-s.accessTTL))
+	if err != nil {
+		s.logger.Error("cannot cleanup revocations", "op", op, "error", err)
+		return 0, fmt.Errorf("cannot cleanup revocations: %w", err)
+	}
+
+	return n + m, nil
+}
+
+func (s *SessionService) issue(ctx context.Context, u user.User, familyId string) (Tokens, error) {
+	access, err := token.CreateToken(u.Id, u.Name, string(u.Role), familyId, s.keys, s.accessTTL)
+	if err != nil {
+		return Tokens{}, fmt.Errorf("cannot create access token: %w", err)
+	}
+
+	refresh, err := randomString(refreshTokenLen, base64.RawURLEncoding.EncodeToString)
+	if err != nil {
+		return Tokens{}, fmt.Errorf("cannot create refresh token: %w", err)
+	}
+
+	err = s.refreshRepo.CreateRefreshToken(ctx, session.RefreshToken{
+		UserId:    u.Id,
+		FamilyId:  familyId,
+		Hash:      hashToken(refresh),
+		ExpiresAt: time.Now().Add(s.refreshTTL),
+	})
+	if err != nil {
+		return Tokens{}, err
+	}
+
+	return Tokens{Access: access, Refresh: refresh}, nil
+}
+
+func hashToken(t string) string {
+	sum := sha256.Sum256([]byte(t))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomString(n int, encode func([]byte) string) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return encode(b), nil
+}
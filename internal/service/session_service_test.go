@@ -0,0 +1,67 @@
+package service_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/437d5/merch-store/pkg/token"
+)
+
+func TestRevokeUser(t *testing.T) {
+	ctx := context.Background()
+	env := newTestEnv(t, nil, false)
+	u := env.register(t, "alice")
+	keyValid := env.apiKey(t, u.Id)
+
+	claims := func() *token.TokenClaims {
+		t.Helper()
+
+		tokens, err := env.sessions.Login(ctx, u)
+		if err != nil {
+			t.Fatalf("login: %v", err)
+		}
+		c, _, err := token.ValidateToken(tokens.Access, env.keys)
+		if err != nil {
+			t.Fatalf("validate: %v", err)
+		}
+
+		return c
+	}
+	revoked := func(c *token.TokenClaims) bool {
+		t.Helper()
+
+		r, err := env.sessions.IsRevoked(ctx, c)
+		if err != nil {
+			t.Fatalf("is revoked: %v", err)
+		}
+
+		return r
+	}
+
+	// one token is cached as valid, the other one was never checked
+	cached, unchecked := claims(), claims()
+	if revoked(cached) {
+		t.Fatal("fresh token revoked")
+	}
+
+	if err := env.sessions.RevokeUser(ctx, "alice"); err != nil {
+		t.Fatalf("revoke: %v", err)
+	}
+
+	// the tokens were most likely issued in the second of the revocation
+	if !revoked(cached) {
+		t.Error("cached token survived the revocation")
+	}
+	if !revoked(unchecked) {
+		t.Error("token survived the revocation")
+	}
+
+	if keyValid() {
+		t.Error("api key survived the revocation")
+	}
+
+	// the user can log in again right away
+	if revoked(claims()) {
+		t.Error("token issued after the revocation is revoked")
+	}
+}
@@ -6,28 +6,44 @@ import (
 	"fmt"
 	"log/slog"
 
+	"github.com/437d5/merch-store/internal/ledger"
 	"github.com/437d5/merch-store/internal/transactions"
+	"github.com/437d5/merch-store/internal/txmanager"
 	"github.com/437d5/merch-store/internal/user"
 )
 
 var (
 	ErrNotEnoughCoins = errors.New("not enough coins")
 	ErrInvalidAmount  = errors.New("invalid amount of coins")
+	ErrSelfTransfer   = errors.New("cannot transfer coins to yourself")
+	ErrInvalidFilter  = errors.New("invalid transaction filter")
+)
+
+const (
+	RecentTransactionsLimit = 20
+	RecentPurchasesLimit    = 20
+	DefaultPageSize         = 20
+	MaxPageSize             = 100
 )
 
 type TransactionService struct {
 	transactionRepo transactions.TransactionRepo
 	userRepo        user.UserRepo
+	ledgerRepo      ledger.LedgerRepo
+	txManager       txmanager.TxManager
 	logger          *slog.Logger
 }
 
 func NewTransactionService(
 	transactionRepo transactions.TransactionRepo, userRepo user.UserRepo,
+	ledgerRepo ledger.LedgerRepo, txManager txmanager.TxManager,
 	logger *slog.Logger,
 ) *TransactionService {
 	return &TransactionService{
 		transactionRepo: transactionRepo,
 		userRepo:        userRepo,
+		ledgerRepo:      ledgerRepo,
+		txManager:       txManager,
 		logger:          logger,
 	}
 }
@@ -38,65 +54,116 @@ func (s *TransactionService) TransferCoins(
 ) error {
 	const op = "/internal/service/transaction_service/TransferCoins"
 
-	fromUser, err := s.userRepo.GetUserByID(ctx, fromUserId)
-	if err != nil {
-		s.logger.Error("Error transfering coins", "op", op, "error", err)
-		return fmt.Errorf("cannot transfer coins: %w", err)
-	}
-
-	toUser, err := s.userRepo.GetUserByName(ctx, toUsername)
-	if err != nil {
-		s.logger.Error("Error transfering coins", "op", op, "error", err)
-		return fmt.Errorf("cannot transfer coins: %w", err)
-	}
-
-	if fromUser.Coins < amount {
-		s.logger.Error("Not enough coins to transfer", "op", op, "error", ErrNotEnoughCoins)
-		return ErrNotEnoughCoins
-	}
-
 	if amount <= 0 {
 		s.logger.Error("Error transfer coins", "op", op, "errors", ErrInvalidAmount)
 		return ErrInvalidAmount
 	}
 
-	fromUser.Coins -= amount
-	toUser.Coins += amount
+	return s.txManager.WithinTx(ctx, func(ctx context.Context) error {
+		toUser, err := s.userRepo.GetUserByName(ctx, toUsername)
+		if err != nil {
+			s.logger.Error("Error transfering coins", "op", op, "error", err)
+			return fmt.Errorf("cannot transfer coins: %w", err)
+		}
+
+		if toUser.Id == fromUserId {
+			s.logger.Error("Error transfer coins", "op", op, "error", ErrSelfTransfer)
+			return ErrSelfTransfer
+		}
+
+		fromWallet, err := s.ledgerRepo.GetWallet(ctx, fromUserId)
+		if err != nil {
+			s.logger.Error("Error transfering coins", "op", op, "error", err)
+			return fmt.Errorf("cannot transfer coins: %w", err)
+		}
+
+		toWallet, err := s.ledgerRepo.GetWallet(ctx, toUser.Id)
+		if err != nil {
+			s.logger.Error("Error transfering coins", "op", op, "error", err)
+			return fmt.Errorf("cannot transfer coins: %w", err)
+		}
+
+		_, err = s.ledgerRepo.PostEntry(ctx, ledger.NewMovement(
+			ledger.EntryTransfer, fromWallet.Id, toWallet.Id, amount,
+		))
+		if err != nil {
+			if errors.Is(err, ledger.ErrInsufficientFunds) {
+				s.logger.Error("Not enough coins to transfer", "op", op, "error", ErrNotEnoughCoins)
+				return ErrNotEnoughCoins
+			}
+			s.logger.Error("Cannot post transfer", "op", op, "error", err)
+			return err
+		}
+		s.logger.Debug("Transfer posted", "op", op)
+
+		transaction := transactions.Transaction{
+			FromUser: fromUserId,
+			ToUser:   toUser.Id,
+			Amount:   amount,
+		}
+
+		s.logger.Debug("Trying create transaction", "op", op)
+		return s.transactionRepo.CreateTransaction(ctx, transaction)
+	})
+}
 
-	err = s.userRepo.UpdateUser(ctx, fromUser)
+// RecentTransactions returns the latest transactions of the user,
+// at most RecentTransactionsLimit of them.
+func (s *TransactionService) RecentTransactions(
+	ctx context.Context, userId int,
+) ([]transactions.Transaction, error) {
+	const op = "/internal/service/transaction_service/RecentTransactions"
+
+	tList, err := s.transactionRepo.ListTransactions(ctx, transactions.Filter{
+		UserId: userId,
+		Limit:  RecentTransactionsLimit,
+	})
 	if err != nil {
-		s.logger.Error("Cannot update 'from' user", "op", op, "error", err)
-		return err
+		s.logger.Error("failed get transactions", "op", op, "error", err)
+		return nil, fmt.Errorf("failed to get transactions")
 	}
-	s.logger.Debug("FromUser updated", "op", op)
 
-	err = s.userRepo.UpdateUser(ctx, toUser)
-	if err != nil {
-		s.logger.Error("Cannot update 'to' user", "op", op, "error", err)
-		return err
+	return tList, nil
+}
+
+// ListTransactions returns one page of the user's transactions matching f.
+func (s *TransactionService) ListTransactions(
+	ctx context.Context, f transactions.Filter,
+) (transactions.Page, error) {
+	const op = "/internal/service/transaction_service/ListTransactions"
+
+	if f.MinAmount < 0 || f.MaxAmount < 0 ||
+		(f.MaxAmount > 0 && f.MinAmount > f.MaxAmount) ||
+		(!f.From.IsZero() && !f.To.IsZero() && !f.From.Before(f.To)) {
+		return transactions.Page{}, ErrInvalidFilter
 	}
-	s.logger.Debug("ToUser updated", "op", op)
 
-	transaction := transactions.Transaction{
-		FromUser: fromUserId,
-		ToUser:   toUser.Id,
-		Amount:   amount,
+	switch f.Direction {
+	case transactions.DirectionAll, transactions.DirectionSent, transactions.DirectionReceived:
+	default:
+		return transactions.Page{}, ErrInvalidFilter
 	}
 
-	s.logger.Debug("Trying create transaction", "op", op)
-	return s.transactionRepo.CreateTransaction(ctx, transaction)
-}
+	if f.Limit <= 0 {
+		f.Limit = DefaultPageSize
+	}
+	f.Limit = min(f.Limit, MaxPageSize)
 
-func (s *TransactionService) GetTransactionsByUser(
-	ctx context.Context, userId int,
-) ([]transactions.Transaction, error) {
-	const op = "/internal/service/transaction_service/GetTransactionsByUser"
+	limit := f.Limit
+	// one extra row tells whether there is a next page
+	f.Limit++
 
-	tList, err := s.transactionRepo.GetTransactionByUser(ctx, userId)
+	tList, err := s.transactionRepo.ListTransactions(ctx, f)
 	if err != nil {
-		s.logger.Error("failed get transactions", "op", op, "error", err)
-		return nil, fmt.Errorf("failed to get transactions")
+		s.logger.Error("failed list transactions", "op", op, "error", err)
+		return transactions.Page{}, fmt.Errorf("failed to list transactions: %w", err)
 	}
 
-	return tList, nil
+	page := transactions.Page{Transactions: tList}
+	if len(tList) > limit {
+		page.Transactions = tList[:limit]
+		page.NextCursor = transactions.EncodeCursor(tList[limit-1])
+	}
+
+	return page, nil
 }
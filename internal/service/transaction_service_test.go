@@ -0,0 +1,215 @@
+package service_test
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/437d5/merch-store/internal/service"
+	"github.com/437d5/merch-store/internal/transactions"
+	"github.com/437d5/merch-store/internal/user"
+)
+
+func TestTransferCoins(t *testing.T) {
+	ctx := context.Background()
+	env := newTestEnv(t, nil, false)
+	alice := env.register(t, "alice")
+	bob := env.register(t, "bob")
+
+	if err := env.transactions.TransferCoins(ctx, alice.Id, 30, bob.Name); err != nil {
+		t.Fatalf("transfer: %v", err)
+	}
+
+	if got, want := env.balance(t, alice.Id), alice.Coins-30; got != want {
+		t.Errorf("sender balance = %d, want %d", got, want)
+	}
+	if got, want := env.balance(t, bob.Id), bob.Coins+30; got != want {
+		t.Errorf("recipient balance = %d, want %d", got, want)
+	}
+
+	tList, err := env.transactions.RecentTransactions(ctx, bob.Id)
+	if err != nil {
+		t.Fatalf("recent transactions: %v", err)
+	}
+	if len(tList) != 1 || tList[0].FromUsername != alice.Name ||
+		tList[0].ToUsername != bob.Name || tList[0].Amount != 30 {
+		t.Errorf("transactions = %+v, want 30 coins from alice to bob", tList)
+	}
+
+	env.checkLedger(t)
+}
+
+func TestTransferCoinsRejects(t *testing.T) {
+	ctx := context.Background()
+	env := newTestEnv(t, nil, false)
+	alice := env.register(t, "alice")
+	bob := env.register(t, "bob")
+
+	tests := []struct {
+		name   string
+		amount int
+		to     string
+		want   error
+	}{
+		{"zero amount", 0, bob.Name, service.ErrInvalidAmount},
+		{"negative amount", -1, bob.Name, service.ErrInvalidAmount},
+		{"to self", 10, alice.Name, service.ErrSelfTransfer},
+		{"unknown recipient", 10, "nobody", user.ErrUserNotFound},
+		{"more than the balance", alice.Coins + 1, bob.Name, service.ErrNotEnoughCoins},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := env.transactions.TransferCoins(ctx, alice.Id, tt.amount, tt.to)
+			if !errors.Is(err, tt.want) {
+				t.Errorf("got %v, want %v", err, tt.want)
+			}
+		})
+	}
+
+	// failed transfers change nothing
+	if got := env.balance(t, alice.Id); got != alice.Coins {
+		t.Errorf("sender balance = %d, want %d", got, alice.Coins)
+	}
+	if got := env.balance(t, bob.Id); got != bob.Coins {
+		t.Errorf("recipient balance = %d, want %d", got, bob.Coins)
+	}
+	tList, err := env.transactions.RecentTransactions(ctx, alice.Id)
+	if err != nil {
+		t.Fatalf("recent transactions: %v", err)
+	}
+	if len(tList) != 0 {
+		t.Errorf("failed transfers recorded %+v", tList)
+	}
+
+	env.checkLedger(t)
+}
+
+func TestTransferCoinsRollsBack(t *testing.T) {
+	ctx := context.Background()
+	env := newTestEnv(t, nil, false)
+	alice := env.register(t, "alice")
+	bob := env.register(t, "bob")
+
+	errAbort := errors.New("abort")
+	err := env.repos.TxManager.WithinTx(ctx, func(ctx context.Context) error {
+		if err := env.transactions.TransferCoins(ctx, alice.Id, 30, bob.Name); err != nil {
+			return err
+		}
+		// reads must go through ctx, the transaction holds the store
+		u, err := env.users.UserInfo(ctx, bob.Id)
+		if err != nil {
+			return err
+		}
+		if u.Coins != bob.Coins+30 {
+			t.Errorf("balance inside the transaction = %d, want %d", u.Coins, bob.Coins+30)
+		}
+		return errAbort
+	})
+	if !errors.Is(err, errAbort) {
+		t.Fatalf("got %v, want %v", err, errAbort)
+	}
+
+	if got := env.balance(t, alice.Id); got != alice.Coins {
+		t.Errorf("sender balance = %d, want %d", got, alice.Coins)
+	}
+	if got := env.balance(t, bob.Id); got != bob.Coins {
+		t.Errorf("recipient balance = %d, want %d", got, bob.Coins)
+	}
+	tList, err := env.transactions.RecentTransactions(ctx, bob.Id)
+	if err != nil {
+		t.Fatalf("recent transactions: %v", err)
+	}
+	if len(tList) != 0 {
+		t.Errorf("rolled back transfer recorded %+v", tList)
+	}
+
+	env.checkLedger(t)
+}
+
+func TestListTransactions(t *testing.T) {
+	ctx := context.Background()
+	env := newTestEnv(t, nil, false)
+	alice := env.register(t, "alice")
+	bob := env.register(t, "bob")
+	carol := env.register(t, "carol")
+
+	// alice sends 1..5 to bob and receives 10 and 20 from carol
+	for amount := 1; amount <= 5; amount++ {
+		if err := env.transactions.TransferCoins(ctx, alice.Id, amount, bob.Name); err != nil {
+			t.Fatalf("transfer: %v", err)
+		}
+	}
+	for _, amount := range []int{10, 20} {
+		if err := env.transactions.TransferCoins(ctx, carol.Id, amount, alice.Name); err != nil {
+			t.Fatalf("transfer: %v", err)
+		}
+	}
+
+	var amounts []int
+	f := transactions.Filter{UserId: alice.Id, Limit: 3}
+	for pages := 0; ; pages++ {
+		if pages > 3 {
+			t.Fatal("too many pages")
+		}
+
+		page, err := env.transactions.ListTransactions(ctx, f)
+		if err != nil {
+			t.Fatalf("list: %v", err)
+		}
+		for _, tr := range page.Transactions {
+			amounts = append(amounts, tr.Amount)
+		}
+		if page.NextCursor == "" {
+			break
+		}
+
+		after, err := transactions.DecodeCursor(page.NextCursor)
+		if err != nil {
+			t.Fatalf("decode cursor: %v", err)
+		}
+		f.After = &after
+	}
+
+	want := []int{20, 10, 5, 4, 3, 2, 1}
+	if !slices.Equal(amounts, want) {
+		t.Errorf("paged amounts = %v, want %v", amounts, want)
+	}
+
+	filters := []struct {
+		name string
+		f    transactions.Filter
+		want []int
+	}{
+		{"sent", transactions.Filter{Direction: transactions.DirectionSent}, []int{5, 4, 3, 2, 1}},
+		{"received", transactions.Filter{Direction: transactions.DirectionReceived}, []int{20, 10}},
+		{"counterparty", transactions.Filter{Counterparty: carol.Name}, []int{20, 10}},
+		{"amount range", transactions.Filter{MinAmount: 3, MaxAmount: 10}, []int{10, 5, 4, 3}},
+	}
+
+	for _, tt := range filters {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.f.UserId = alice.Id
+			page, err := env.transactions.ListTransactions(ctx, tt.f)
+			if err != nil {
+				t.Fatalf("list: %v", err)
+			}
+
+			var got []int
+			for _, tr := range page.Transactions {
+				got = append(got, tr.Amount)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("amounts = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	_, err := env.transactions.ListTransactions(ctx, transactions.Filter{
+		UserId: alice.Id, MinAmount: 10, MaxAmount: 5,
+	})
+	if !errors.Is(err, service.ErrInvalidFilter) {
+		t.Errorf("inverted amount range: got %v, want %v", err, service.ErrInvalidFilter)
+	}
+}
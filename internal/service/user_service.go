@@ -2,64 +2,297 @@ package service
 
 import (
 	"context"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"log/slog"
+	"regexp"
+	"strings"
 
-	"github.com/437d5/merch-store/internal/inventory"
+	"github.com/437d5/merch-store/internal/identity"
+	"github.com/437d5/merch-store/internal/ledger"
+	"github.com/437d5/merch-store/internal/txmanager"
 	"github.com/437d5/merch-store/internal/user"
+	"github.com/437d5/merch-store/pkg/password"
 )
 
 var ErrInvalidPassword = errors.New("invalid password")
 
+// signupGrant is the amount of coins every new user starts with
+const signupGrant = 100000
+
+// provisionAttempts bounds the username suffixes tried when the name
+// proposed by an identity provider is taken
+const provisionAttempts = 5
+
 type UserService struct {
-	userRepo user.UserRepo
-	logger   *slog.Logger
+	userRepo     user.UserRepo
+	ledgerRepo   ledger.LedgerRepo
+	identityRepo identity.IdentityRepo
+	txManager    txmanager.TxManager
+	hasher       *password.Hasher
+	sessions     *SessionService
+	// admins are promoted by EnsureAdmins if they exist
+	admins []string
+	// autoRegister makes AuthUser register unknown users, as the
+	// service did before POST /api/register existed
+	autoRegister bool
+	logger       *slog.Logger
 }
 
-func NewUserService(userRepo user.UserRepo, logger *slog.Logger) *UserService {
+func NewUserService(
+	userRepo user.UserRepo, ledgerRepo ledger.LedgerRepo,
+	identityRepo identity.IdentityRepo, txManager txmanager.TxManager,
+	hasher *password.Hasher, sessions *SessionService, admins []string,
+	autoRegister bool, logger *slog.Logger,
+) *UserService {
 	return &UserService{
-		userRepo: userRepo,
-		logger:   logger,
+		userRepo:     userRepo,
+		ledgerRepo:   ledgerRepo,
+		identityRepo: identityRepo,
+		txManager:    txManager,
+		hasher:       hasher,
+		sessions:     sessions,
+		admins:       admins,
+		autoRegister: autoRegister,
+		logger:       logger,
 	}
 }
 
-func (s *UserService) AuthUser(ctx context.Context, name, password string) (user.User, error) {
+func (s *UserService) AuthUser(ctx context.Context, name, pass string) (user.User, error) {
 	const op = "/internal/service/user_service/AuthUser"
 
 	existingUser, err := s.userRepo.GetUserByName(ctx, name)
-	if err == nil {
-		if !existingUser.CheckPassword(password) {
-			s.logger.Error("Failed to authenticate", "op", op, "errror", ErrInvalidPassword)
-			return user.User{}, ErrInvalidPassword
+	if err != nil {
+		if !errors.Is(err, user.ErrUserNotFound) {
+			s.logger.Error("failed get user", "op", op, "error", err)
+			return user.User{}, fmt.Errorf("failed get user: %w", err)
+		}
+		if !s.autoRegister {
+			s.logger.Warn("Failed to authenticate", "op", op, "error", err)
+			return user.User{}, user.ErrUserNotFound
+		}
+
+		u, err := s.Register(ctx, name, pass)
+		if errors.Is(err, user.ErrUserExists) {
+			// registered by a concurrent request, the user exists now
+			return s.AuthUser(ctx, name, pass)
 		}
+		return u, err
+	}
+
+	if !existingUser.CheckPassword(s.hasher, pass) {
+		s.logger.Warn("Failed to authenticate", "op", op, "error", ErrInvalidPassword)
+		return user.User{}, ErrInvalidPassword
+	}
+
+	if s.hasher.NeedsRehash(existingUser.Password) {
+		s.rehash(ctx, &existingUser, pass)
+	}
 
-		s.logger.Info("User authenticated succesfully", "op", op, "username", existingUser.Name)
-		return existingUser, nil
+	s.logger.Info("User authenticated succesfully", "op", op, "username", existingUser.Name)
+	return existingUser, nil
+}
+
+// Register creates a new user with the signup grant after validating
+// the username and password.
+func (s *UserService) Register(ctx context.Context, name, password string) (user.User, error) {
+	if err := user.ValidateName(name); err != nil {
+		return user.User{}, err
+	}
+	if err := user.ValidatePassword(password); err != nil {
+		return user.User{}, err
 	}
 
+	return s.register(ctx, name, password)
+}
+
+func (s *UserService) register(ctx context.Context, name, password string) (user.User, error) {
+	const op = "/internal/service/user_service/register"
+
 	newUser := user.User{
-		Name:      name,
-		Coins:     100000,
-		Inventory: inventory.Inventory{},
+		Name: name,
+		Role: user.RoleEmployee,
 	}
 
-	err = newUser.SetPassword(password)
+	err := newUser.SetPassword(s.hasher, password)
 	if err != nil {
 		s.logger.Error("cannot register new user", "op", op, "error", err)
 		return user.User{}, fmt.Errorf("cannot set pass: %w", err)
 	}
 
-	id, err := s.userRepo.CreateUser(ctx, newUser)
+	err = s.txManager.WithinTx(ctx, func(ctx context.Context) error {
+		id, err := s.userRepo.CreateUser(ctx, newUser)
+		if err != nil {
+			return err
+		}
+		newUser.Id = id
+
+		return s.grantSignupCoins(ctx, id)
+	})
 	if err != nil {
+		if errors.Is(err, user.ErrUserExists) {
+			return user.User{}, user.ErrUserExists
+		}
 		s.logger.Error("Error creating new user", "op", op, "error", err)
+		return user.User{}, fmt.Errorf("cannot register user: %w", err)
 	}
 
-	newUser.Id = id
-	s.logger.Info("New user authenticated succesfully", "op", op, "username", newUser.Name)
+	newUser.Coins = signupGrant
+	s.logger.Info("New user registered succesfully", "op", op, "username", newUser.Name)
 	return newUser, nil
 }
 
+// AuthExternal returns the user linked to the subject of an identity
+// provider, provisioning one with the signup grant on the first login.
+// The username is derived from the name the provider proposes, a random
+// suffix is added when it is taken. Provisioned users have no password
+// and can sign in through the provider only until one is reset.
+func (s *UserService) AuthExternal(ctx context.Context, issuer, subject, username string) (user.User, error) {
+	const op = "/internal/service/user_service/AuthExternal"
+
+	id, err := s.identityRepo.GetIdentity(ctx, issuer, subject)
+	if err == nil {
+		return s.externalUser(ctx, id)
+	}
+	if !errors.Is(err, identity.ErrIdentityNotFound) {
+		s.logger.Error("failed get identity", "op", op, "error", err)
+		return user.User{}, fmt.Errorf("failed get identity: %w", err)
+	}
+
+	base := externalName(username)
+	name := base
+	for range provisionAttempts {
+		u, err := s.provision(ctx, identity.Identity{Issuer: issuer, Subject: subject}, name)
+		switch {
+		case err == nil:
+			s.logger.Info("external user provisioned", "op", op, "username", u.Name, "issuer", issuer)
+			return u, nil
+		case errors.Is(err, identity.ErrIdentityExists):
+			// provisioned by a concurrent login of the same subject
+			id, err = s.identityRepo.GetIdentity(ctx, issuer, subject)
+			if err != nil {
+				s.logger.Error("failed get identity", "op", op, "error", err)
+				return user.User{}, fmt.Errorf("failed get identity: %w", err)
+			}
+			return s.externalUser(ctx, id)
+		case !errors.Is(err, user.ErrUserExists):
+			s.logger.Error("cannot provision user", "op", op, "error", err)
+			return user.User{}, fmt.Errorf("cannot provision user: %w", err)
+		}
+
+		suffix, err := randomString(2, hex.EncodeToString)
+		if err != nil {
+			return user.User{}, fmt.Errorf("cannot provision user: %w", err)
+		}
+		name = base[:min(len(base), user.MaxNameLen-len(suffix)-1)] + "-" + suffix
+	}
+
+	s.logger.Error("no free username", "op", op, "username", base)
+	return user.User{}, fmt.Errorf("cannot provision user: %w", user.ErrUserExists)
+}
+
+func (s *UserService) externalUser(ctx context.Context, id identity.Identity) (user.User, error) {
+	u, err := s.userRepo.GetUserByID(ctx, id.UserId)
+	if err != nil {
+		return user.User{}, fmt.Errorf("failed get user: %w", err)
+	}
+
+	return u, nil
+}
+
+// provision creates the user, its wallet and the identity link in one
+// transaction.
+func (s *UserService) provision(ctx context.Context, id identity.Identity, name string) (user.User, error) {
+	newUser := user.User{
+		Name: name,
+		Role: user.RoleEmployee,
+	}
+
+	err := s.txManager.WithinTx(ctx, func(ctx context.Context) error {
+		userId, err := s.userRepo.CreateUser(ctx, newUser)
+		if err != nil {
+			return err
+		}
+		newUser.Id = userId
+
+		if err = s.grantSignupCoins(ctx, userId); err != nil {
+			return err
+		}
+
+		id.UserId = userId
+		return s.identityRepo.CreateIdentity(ctx, id)
+	})
+	if err != nil {
+		return user.User{}, err
+	}
+
+	newUser.Coins = signupGrant
+	return newUser, nil
+}
+
+var invalidNameChars = regexp.MustCompile(`[^a-zA-Z0-9_.-]+`)
+
+// externalName turns a name proposed by an identity provider into a
+// valid username, the local part is kept from email addresses.
+func externalName(proposed string) string {
+	if at := strings.IndexByte(proposed, '@'); at >= 0 {
+		proposed = proposed[:at]
+	}
+
+	name := invalidNameChars.ReplaceAllString(proposed, "")
+	if len(name) > user.MaxNameLen {
+		name = name[:user.MaxNameLen]
+	}
+	if len(name) < user.MinNameLen {
+		name = "user"
+	}
+
+	return name
+}
+
+// rehash replaces an outdated password hash with one made by the
+// current algorithm. Failures are only logged, the old hash still works.
+func (s *UserService) rehash(ctx context.Context, u *user.User, pass string) {
+	const op = "/internal/service/user_service/rehash"
+
+	old := u.Password
+	if err := u.SetPassword(s.hasher, pass); err != nil {
+		s.logger.Error("cannot rehash password", "op", op, "error", err)
+		u.Password = old
+		return
+	}
+
+	if err := s.userRepo.UpdatePassword(ctx, u.Id, u.Password); err != nil {
+		s.logger.Error("cannot rehash password", "op", op, "error", err)
+		return
+	}
+
+	s.logger.Info("password rehashed", "op", op, "userId", u.Id)
+}
+
+// grantSignupCoins opens a wallet for the user and funds it from the mint.
+func (s *UserService) grantSignupCoins(ctx context.Context, userId int) error {
+	wallet, err := s.ledgerRepo.CreateWallet(ctx, userId)
+	if err != nil {
+		return fmt.Errorf("cannot create wallet: %w", err)
+	}
+
+	mint, err := s.ledgerRepo.GetSystemAccount(ctx, ledger.AccountMint)
+	if err != nil {
+		return fmt.Errorf("cannot find mint account: %w", err)
+	}
+
+	_, err = s.ledgerRepo.PostEntry(ctx, ledger.NewMovement(
+		ledger.EntryGrant, mint.Id, wallet.Id, signupGrant,
+	))
+	if err != nil {
+		return fmt.Errorf("cannot grant signup coins: %w", err)
+	}
+
+	return nil
+}
+
 func (s *UserService) UserInfo(ctx context.Context, userId int) (user.User, error) {
 	const op = "/internal/service/user_service/UserInfo"
 
@@ -71,3 +304,46 @@ func (s *UserService) UserInfo(ctx context.Context, userId int) (user.User, erro
 
 	return u, nil
 }
+
+// SetRole changes the role of the named user and ends their sessions,
+// the role in tokens issued before would outlive the change otherwise.
+func (s *UserService) SetRole(ctx context.Context, name string, role user.Role) error {
+	const op = "/internal/service/user_service/SetRole"
+
+	err := s.txManager.WithinTx(ctx, func(ctx context.Context) error {
+		if err := s.userRepo.UpdateRole(ctx, name, role); err != nil {
+			return err
+		}
+
+		u, err := s.userRepo.GetUserByName(ctx, name)
+		if err != nil {
+			return err
+		}
+
+		return s.sessions.RevokeUserSessions(ctx, u.Id)
+	})
+	if err != nil {
+		s.logger.Error("failed set role", "op", op, "name", name, "error", err)
+		return fmt.Errorf("failed set role: %w", err)
+	}
+
+	return nil
+}
+
+// EnsureAdmins grants the admin role to the configured admins that
+// already exist. Names registered later are not promoted, whoever takes
+// such a name first would become an admin; run EnsureAdmins again after
+// the admin registered or use SetRole.
+func (s *UserService) EnsureAdmins(ctx context.Context) error {
+	const op = "/internal/service/user_service/EnsureAdmins"
+
+	for _, name := range s.admins {
+		err := s.userRepo.UpdateRole(ctx, name, user.RoleAdmin)
+		if err != nil && !errors.Is(err, user.ErrUserNotFound) {
+			s.logger.Error("failed to promote admin", "op", op, "name", name, "error", err)
+			return fmt.Errorf("failed to promote admin: %w", err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,210 @@
+package service_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/437d5/merch-store/internal/service"
+	"github.com/437d5/merch-store/internal/user"
+	"github.com/437d5/merch-store/pkg/password"
+)
+
+func TestRegister(t *testing.T) {
+	ctx := context.Background()
+	env := newTestEnv(t, []string{"boss"}, false)
+
+	u := env.register(t, "alice")
+	if u.Role != user.RoleEmployee {
+		t.Errorf("role = %s, want %s", u.Role, user.RoleEmployee)
+	}
+	if got := env.balance(t, u.Id); got != u.Coins || got == 0 {
+		t.Errorf("balance = %d, want the signup grant %d", got, u.Coins)
+	}
+
+	// promotion is left to EnsureAdmins, otherwise whoever registers a
+	// configured name first becomes an admin
+	if boss := env.register(t, "boss"); boss.Role != user.RoleEmployee {
+		t.Errorf("configured admin registered as %s, want %s", boss.Role, user.RoleEmployee)
+	}
+
+	if _, err := env.users.Register(ctx, "alice", "password"); !errors.Is(err, user.ErrUserExists) {
+		t.Errorf("duplicate register: got %v, want %v", err, user.ErrUserExists)
+	}
+	if _, err := env.users.Register(ctx, "bob", "short"); !errors.Is(err, user.ErrWeakPassword) {
+		t.Errorf("weak password: got %v, want %v", err, user.ErrWeakPassword)
+	}
+	// the limit of bcrypt, the hasher of the tests
+	long := strings.Repeat("a", password.BcryptMaxLen+1)
+	if _, err := env.users.Register(ctx, "bob", long); !errors.Is(err, user.ErrWeakPassword) {
+		t.Errorf("long password: got %v, want %v", err, user.ErrWeakPassword)
+	}
+	if _, err := env.users.Register(ctx, "no spaces", "password"); !errors.Is(err, user.ErrInvalidUsername) {
+		t.Errorf("invalid name: got %v, want %v", err, user.ErrInvalidUsername)
+	}
+
+	env.checkLedger(t)
+}
+
+func TestEnsureAdmins(t *testing.T) {
+	ctx := context.Background()
+	env := newTestEnv(t, []string{"boss", "ghost"}, false)
+	boss := env.register(t, "boss")
+
+	if err := env.users.EnsureAdmins(ctx); err != nil {
+		t.Fatalf("ensure admins: %v", err)
+	}
+
+	u, err := env.users.UserInfo(ctx, boss.Id)
+	if err != nil {
+		t.Fatalf("get user: %v", err)
+	}
+	if u.Role != user.RoleAdmin {
+		t.Errorf("role = %s, want %s", u.Role, user.RoleAdmin)
+	}
+
+	// missing admins are skipped, not created
+	if _, err := env.repos.Users.GetUserByName(ctx, "ghost"); !errors.Is(err, user.ErrUserNotFound) {
+		t.Errorf("get missing admin: got %v, want %v", err, user.ErrUserNotFound)
+	}
+}
+
+func TestSetRole(t *testing.T) {
+	ctx := context.Background()
+	env := newTestEnv(t, nil, false)
+	u := env.register(t, "alice")
+
+	tokens, err := env.sessions.Login(ctx, u)
+	if err != nil {
+		t.Fatalf("login: %v", err)
+	}
+
+	if err = env.users.SetRole(ctx, "alice", user.RoleAdmin); err != nil {
+		t.Fatalf("set role: %v", err)
+	}
+
+	// the old tokens carry the old role
+	if _, err = env.sessions.Refresh(ctx, tokens.Refresh); !errors.Is(err, service.ErrInvalidRefreshToken) {
+		t.Errorf("refresh after role change: got %v, want %v", err, service.ErrInvalidRefreshToken)
+	}
+
+	if err = env.users.SetRole(ctx, "nobody", user.RoleAdmin); !errors.Is(err, user.ErrUserNotFound) {
+		t.Errorf("unknown user: got %v, want %v", err, user.ErrUserNotFound)
+	}
+}
+
+func TestAuthUser(t *testing.T) {
+	ctx := context.Background()
+	env := newTestEnv(t, nil, false)
+	registered := env.register(t, "alice")
+
+	u, err := env.users.AuthUser(ctx, "alice", "password")
+	if err != nil {
+		t.Fatalf("auth: %v", err)
+	}
+	if u.Id != registered.Id {
+		t.Errorf("authenticated user %d, want %d", u.Id, registered.Id)
+	}
+
+	if _, err := env.users.AuthUser(ctx, "alice", "wrong-password"); !errors.Is(err, service.ErrInvalidPassword) {
+		t.Errorf("wrong password: got %v, want %v", err, service.ErrInvalidPassword)
+	}
+	if _, err := env.users.AuthUser(ctx, "nobody", "password"); !errors.Is(err, user.ErrUserNotFound) {
+		t.Errorf("unknown user: got %v, want %v", err, user.ErrUserNotFound)
+	}
+}
+
+func TestAuthUserAutoRegister(t *testing.T) {
+	ctx := context.Background()
+	env := newTestEnv(t, nil, true)
+
+	const logins = 20
+	ids := make([]int, logins)
+	var wg sync.WaitGroup
+	for i := range logins {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			u, err := env.users.AuthUser(ctx, "alice", "password")
+			if err != nil {
+				t.Errorf("auth: %v", err)
+				return
+			}
+			ids[i] = u.Id
+		}()
+	}
+	wg.Wait()
+
+	for _, id := range ids[1:] {
+		if id != ids[0] {
+			t.Fatalf("concurrent first logins created users %v", ids)
+		}
+	}
+
+	// the grant is posted once
+	u, err := env.users.UserInfo(ctx, ids[0])
+	if err != nil {
+		t.Fatalf("get user: %v", err)
+	}
+	registered := env.register(t, "bob")
+	if u.Coins != registered.Coins {
+		t.Errorf("balance = %d, want %d", u.Coins, registered.Coins)
+	}
+
+	env.checkLedger(t)
+
+	// first logins are validated like registrations
+	if _, err = env.users.AuthUser(ctx, "carol", "short"); !errors.Is(err, user.ErrWeakPassword) {
+		t.Errorf("auth with a weak password: got %v, want %v", err, user.ErrWeakPassword)
+	}
+	if _, err = env.users.AuthUser(ctx, "carol", strings.Repeat("x", user.MaxPasswordLen+1)); !errors.Is(err, user.ErrWeakPassword) {
+		t.Errorf("auth with a long password: got %v, want %v", err, user.ErrWeakPassword)
+	}
+	if _, err = env.users.AuthUser(ctx, "c a r o l", "password"); !errors.Is(err, user.ErrInvalidUsername) {
+		t.Errorf("auth with an invalid name: got %v, want %v", err, user.ErrInvalidUsername)
+	}
+}
+
+func TestAuthExternal(t *testing.T) {
+	ctx := context.Background()
+	env := newTestEnv(t, []string{"carol"}, false)
+	taken := env.register(t, "alice")
+
+	first, err := env.users.AuthExternal(ctx, "https://idp", "sub-1", "alice@example.com")
+	if err != nil {
+		t.Fatalf("first login: %v", err)
+	}
+	if first.Id == taken.Id || !strings.HasPrefix(first.Name, "alice-") {
+		t.Errorf("provisioned %+v, want a new alice-<suffix> user", first)
+	}
+
+	again, err := env.users.AuthExternal(ctx, "https://idp", "sub-1", "renamed@example.com")
+	if err != nil {
+		t.Fatalf("second login: %v", err)
+	}
+	if again.Id != first.Id {
+		t.Errorf("second login returned user %d, want %d", again.Id, first.Id)
+	}
+
+	other, err := env.users.AuthExternal(ctx, "https://other-idp", "sub-1", "carol")
+	if err != nil {
+		t.Fatalf("other issuer: %v", err)
+	}
+	if other.Id == first.Id || other.Name != "carol" {
+		t.Errorf("other issuer provisioned %+v, want a new carol", other)
+	}
+	// an identity provider may propose any name, admins are not
+	// promoted on provisioning either
+	if other.Role != user.RoleEmployee {
+		t.Errorf("configured admin provisioned as %s, want %s", other.Role, user.RoleEmployee)
+	}
+
+	// provisioned users have no password
+	if _, err := env.users.AuthUser(ctx, first.Name, ""); !errors.Is(err, service.ErrInvalidPassword) {
+		t.Errorf("password login of external user: got %v, want %v", err, service.ErrInvalidPassword)
+	}
+
+	env.checkLedger(t)
+}
@@ -0,0 +1,42 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+var ErrRefreshTokenNotFound = errors.New("refresh token not found")
+
+// RefreshToken is the server side record of an opaque refresh token.
+// Only the SHA-256 hash of the token is stored. Tokens issued by rotating
+// one another share a FamilyId, so a reused token revokes its whole family.
+type RefreshToken struct {
+	Id        int
+	UserId    int
+	FamilyId  string
+	Hash      string
+	ExpiresAt time.Time
+	CreatedAt time.Time
+	UsedAt    *time.Time
+	RevokedAt *time.Time
+}
+
+type RefreshTokenRepo interface {
+	CreateRefreshToken(ctx context.Context, token RefreshToken) error
+	GetRefreshTokenForUpdate(ctx context.Context, hash string) (RefreshToken, error)
+	MarkRefreshTokenUsed(ctx context.Context, id int) error
+	RevokeFamily(ctx context.Context, familyId string) error
+	RevokeUserRefreshTokens(ctx context.Context, userId int) error
+	DeleteExpiredRefreshTokens(ctx context.Context, before time.Time) (int64, error)
+}
+
+// RevocationRepo keeps access tokens that must not be accepted before
+// they expire. A single token is revoked by its jti, all tokens of a
+// user by the time they were issued before.
+type RevocationRepo interface {
+	RevokeToken(ctx context.Context, jti string, userId int, expiresAt time.Time) error
+	RevokeUserTokens(ctx context.Context, userId int, before time.Time) error
+	IsRevoked(ctx context.Context, jti string, userId int, issuedAt time.Time) (bool, error)
+	DeleteExpiredRevocations(ctx context.Context, tokensBefore, usersBefore time.Time) (int64, error)
+}
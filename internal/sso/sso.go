@@ -0,0 +1,124 @@
+// Package sso signs users in with an OpenID Connect provider using the
+// authorization code flow with PKCE.
+package sso
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	gooidc "github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+)
+
+var (
+	ErrNoIDToken     = errors.New("token response has no id_token")
+	ErrNonceMismatch = errors.New("id_token nonce mismatch")
+)
+
+type Config struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+	// UsernameClaim names the id_token claim proposed as the merch-store
+	// username of provisioned users
+	UsernameClaim string
+}
+
+// Claims identify the user signed in at the provider.
+type Claims struct {
+	Issuer   string
+	Subject  string
+	Username string
+	Email    string
+}
+
+type Provider struct {
+	issuer        string
+	oauth         oauth2.Config
+	verifier      *gooidc.IDTokenVerifier
+	usernameClaim string
+}
+
+// NewProvider fetches the discovery document of the issuer.
+func NewProvider(ctx context.Context, cfg Config) (*Provider, error) {
+	p, err := gooidc.NewProvider(ctx, cfg.Issuer)
+	if err != nil {
+		return nil, fmt.Errorf("cannot discover oidc provider: %w", err)
+	}
+
+	scopes := cfg.Scopes
+	if len(scopes) == 0 {
+		scopes = []string{gooidc.ScopeOpenID, "profile", "email"}
+	}
+
+	usernameClaim := cfg.UsernameClaim
+	if usernameClaim == "" {
+		usernameClaim = "preferred_username"
+	}
+
+	return &Provider{
+		issuer: cfg.Issuer,
+		oauth: oauth2.Config{
+			ClientID:     cfg.ClientID,
+			ClientSecret: cfg.ClientSecret,
+			RedirectURL:  cfg.RedirectURL,
+			Endpoint:     p.Endpoint(),
+			Scopes:       scopes,
+		},
+		verifier:      p.Verifier(&gooidc.Config{ClientID: cfg.ClientID}),
+		usernameClaim: usernameClaim,
+	}, nil
+}
+
+// AuthCodeURL returns the provider URL the user is sent to. The PKCE
+// challenge is derived from verifier, loginHint may be empty.
+func (p *Provider) AuthCodeURL(state, verifier, nonce, loginHint string) string {
+	opts := []oauth2.AuthCodeOption{
+		oauth2.S256ChallengeOption(verifier),
+		gooidc.Nonce(nonce),
+	}
+	if loginHint != "" {
+		opts = append(opts, oauth2.SetAuthURLParam("login_hint", loginHint))
+	}
+
+	return p.oauth.AuthCodeURL(state, opts...)
+}
+
+// Exchange redeems the authorization code and verifies the id_token.
+func (p *Provider) Exchange(ctx context.Context, code, verifier, nonce string) (Claims, error) {
+	tok, err := p.oauth.Exchange(ctx, code, oauth2.VerifierOption(verifier))
+	if err != nil {
+		return Claims{}, fmt.Errorf("cannot exchange code: %w", err)
+	}
+
+	raw, ok := tok.Extra("id_token").(string)
+	if !ok || raw == "" {
+		return Claims{}, ErrNoIDToken
+	}
+
+	idToken, err := p.verifier.Verify(ctx, raw)
+	if err != nil {
+		return Claims{}, fmt.Errorf("cannot verify id_token: %w", err)
+	}
+
+	if idToken.Nonce != nonce {
+		return Claims{}, ErrNonceMismatch
+	}
+
+	var extra map[string]any
+	if err = idToken.Claims(&extra); err != nil {
+		return Claims{}, fmt.Errorf("cannot parse id_token claims: %w", err)
+	}
+
+	claims := Claims{
+		Issuer:  idToken.Issuer,
+		Subject: idToken.Subject,
+	}
+	claims.Username, _ = extra[p.usernameClaim].(string)
+	claims.Email, _ = extra["email"].(string)
+
+	return claims, nil
+}
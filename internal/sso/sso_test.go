@@ -0,0 +1,139 @@
+package sso_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/437d5/merch-store/internal/sso"
+	"github.com/437d5/merch-store/internal/sso/mockidp"
+	"golang.org/x/oauth2"
+)
+
+const (
+	clientID     = "merch-store"
+	clientSecret = "secret"
+	redirectURL  = "http://localhost:8080/api/oidc/callback"
+)
+
+func newProvider(t *testing.T) *sso.Provider {
+	t.Helper()
+
+	_, srv, err := mockidp.NewServer(clientID, clientSecret)
+	if err != nil {
+		t.Fatalf("start mock idp: %v", err)
+	}
+	t.Cleanup(srv.Close)
+
+	p, err := sso.NewProvider(context.Background(), sso.Config{
+		Issuer:       srv.URL,
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		RedirectURL:  redirectURL,
+	})
+	if err != nil {
+		t.Fatalf("new provider: %v", err)
+	}
+
+	return p
+}
+
+// authorize follows the provider URL and returns the code and state
+// the IdP redirects back with.
+func authorize(t *testing.T, authURL string) (code, state string) {
+	t.Helper()
+
+	client := &http.Client{
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	resp, err := client.Get(authURL)
+	if err != nil {
+		t.Fatalf("authorize: %v", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusFound {
+		t.Fatalf("authorize status = %d, want %d", resp.StatusCode, http.StatusFound)
+	}
+
+	loc, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatalf("parse redirect: %v", err)
+	}
+
+	return loc.Query().Get("code"), loc.Query().Get("state")
+}
+
+func TestLoginFlow(t *testing.T) {
+	p := newProvider(t)
+	verifier := oauth2.GenerateVerifier()
+
+	code, state := authorize(t, p.AuthCodeURL("state1", verifier, "nonce1", "alice"))
+	if state != "state1" {
+		t.Fatalf("state = %q, want %q", state, "state1")
+	}
+
+	claims, err := p.Exchange(context.Background(), code, verifier, "nonce1")
+	if err != nil {
+		t.Fatalf("exchange: %v", err)
+	}
+
+	if claims.Subject != mockidp.Subject("alice") || claims.Username != "alice" {
+		t.Errorf("claims = %+v, want subject %s and username alice", claims, mockidp.Subject("alice"))
+	}
+	if claims.Email != "alice@example.com" {
+		t.Errorf("email = %q, want alice@example.com", claims.Email)
+	}
+}
+
+func TestLoginFlowRejects(t *testing.T) {
+	p := newProvider(t)
+
+	tests := []struct {
+		name     string
+		verifier string
+		nonce    string
+		wantErr  error
+	}{
+		{name: "wrong verifier", verifier: oauth2.GenerateVerifier(), nonce: "nonce1"},
+		{name: "wrong nonce", nonce: "other", wantErr: sso.ErrNonceMismatch},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			verifier := oauth2.GenerateVerifier()
+			code, _ := authorize(t, p.AuthCodeURL("state1", verifier, "nonce1", ""))
+
+			if tt.verifier != "" {
+				verifier = tt.verifier
+			}
+
+			_, err := p.Exchange(context.Background(), code, verifier, tt.nonce)
+			if err == nil {
+				t.Fatal("exchange succeeded, want error")
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("err = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCodeIsSingleUse(t *testing.T) {
+	p := newProvider(t)
+	verifier := oauth2.GenerateVerifier()
+
+	code, _ := authorize(t, p.AuthCodeURL("state1", verifier, "nonce1", ""))
+
+	if _, err := p.Exchange(context.Background(), code, verifier, "nonce1"); err != nil {
+		t.Fatalf("first exchange: %v", err)
+	}
+	if _, err := p.Exchange(context.Background(), code, verifier, "nonce1"); err == nil {
+		t.Fatal("second exchange succeeded, want error")
+	}
+}
@@ -2,15 +2,92 @@ package transactions
 
 import (
 	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
 )
 
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Transaction is a transfer of coins between two users. FromUsername and
+// ToUsername are filled in when transactions are read back.
 type Transaction struct {
-	FromUser int
-	ToUser   int
-	Amount   int
+	Id           int
+	FromUser     int
+	ToUser       int
+	FromUsername string
+	ToUsername   string
+	Amount       int
+	Timestamp    time.Time
+}
+
+type Direction string
+
+const (
+	DirectionAll      Direction = ""
+	DirectionSent     Direction = "sent"
+	DirectionReceived Direction = "received"
+)
+
+// Cursor points at the last transaction of a page. The next page starts
+// right after it in (Timestamp, Id) descending order.
+type Cursor struct {
+	Timestamp time.Time
+	Id        int
+}
+
+// Filter selects transactions of UserId. Zero values mean "no filter".
+type Filter struct {
+	UserId       int
+	Direction    Direction
+	Counterparty string
+	MinAmount    int
+	MaxAmount    int
+	From         time.Time
+	To           time.Time
+	After        *Cursor
+	Limit        int
+}
+
+type Page struct {
+	Transactions []Transaction
+	NextCursor   string
 }
 
 type TransactionRepo interface {
 	CreateTransaction(ctx context.Context, transaction Transaction) error
-	GetTransactionByUser(ctx context.Context, userId int) ([]Transaction, error)
+	ListTransactions(ctx context.Context, filter Filter) ([]Transaction, error)
+}
+
+func EncodeCursor(t Transaction) string {
+	raw := fmt.Sprintf("%d:%d", t.Timestamp.UnixNano(), t.Id)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func DecodeCursor(s string) (Cursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return Cursor{}, ErrInvalidCursor
+	}
+
+	ts, id, ok := strings.Cut(string(raw), ":")
+	if !ok {
+		return Cursor{}, ErrInvalidCursor
+	}
+
+	nanos, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return Cursor{}, ErrInvalidCursor
+	}
+
+	c := Cursor{Timestamp: time.Unix(0, nanos).UTC()}
+	c.Id, err = strconv.Atoi(id)
+	if err != nil {
+		return Cursor{}, ErrInvalidCursor
+	}
+
+	return c, nil
 }
@@ -0,0 +1,11 @@
+package txmanager
+
+import "context"
+
+// TxManager runs fn inside a single database transaction. Repositories
+// called with the ctx passed to fn take part in that transaction, so all
+// their writes commit or roll back together. Nested calls join the outer
+// transaction.
+type TxManager interface {
+	WithinTx(ctx context.Context, fn func(ctx context.Context) error) error
+}
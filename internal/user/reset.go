@@ -0,0 +1,28 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+var ErrResetTokenNotFound = errors.New("password reset token not found")
+
+// PasswordReset is a one-time token an admin issues to let a user set
+// a new password. Only the SHA-256 hash of the token is stored.
+type PasswordReset struct {
+	Id        int
+	UserId    int
+	Hash      string
+	CreatedBy int
+	ExpiresAt time.Time
+	CreatedAt time.Time
+	UsedAt    *time.Time
+}
+
+type PasswordResetRepo interface {
+	CreatePasswordReset(ctx context.Context, reset PasswordReset) error
+	GetPasswordResetForUpdate(ctx context.Context, hash string) (PasswordReset, error)
+	MarkPasswordResetUsed(ctx context.Context, id int) error
+	DeleteExpiredPasswordResets(ctx context.Context, before time.Time) (int64, error)
+}
@@ -2,38 +2,103 @@ package user
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"regexp"
+	"unicode/utf8"
 
-	"github.com/437d5/merch-store/internal/inventory"
 	hash "github.com/437d5/merch-store/pkg/password"
 )
 
+var (
+	ErrUserNotFound    = errors.New("user not found")
+	ErrUserExists      = errors.New("user already exists")
+	ErrInvalidRole     = errors.New("invalid role")
+	ErrInvalidUsername = errors.New("invalid username")
+	ErrWeakPassword    = errors.New("password does not meet requirements")
+)
+
+const (
+	MinNameLen = 3
+	// MaxNameLen matches the VARCHAR(16) users.name column
+	MaxNameLen = 16
+
+	MinPasswordLen = 8
+	// MaxPasswordLen bounds the hashing work in bytes. The bcrypt hasher
+	// accepts password.BcryptMaxLen bytes only, SetPassword reports longer
+	// ones as ErrWeakPassword.
+	MaxPasswordLen = 1024
+)
+
+var nameRe = regexp.MustCompile(`^[a-zA-Z0-9_.-]+$`)
+
+func ValidateName(name string) error {
+	n := utf8.RuneCountInString(name)
+	if n < MinNameLen || n > MaxNameLen || !nameRe.MatchString(name) {
+		return ErrInvalidUsername
+	}
+
+	return nil
+}
+
+func ValidatePassword(password string) error {
+	if utf8.RuneCountInString(password) < MinPasswordLen || len(password) > MaxPasswordLen {
+		return ErrWeakPassword
+	}
+
+	return nil
+}
+
+type Role string
+
+const (
+	RoleEmployee Role = "employee"
+	RoleAdmin    Role = "admin"
+	RoleAuditor  Role = "auditor"
+)
+
+func ParseRole(s string) (Role, error) {
+	switch r := Role(s); r {
+	case RoleEmployee, RoleAdmin, RoleAuditor:
+		return r, nil
+	default:
+		return "", ErrInvalidRole
+	}
+}
+
 type User struct {
-	Id        int
-	Name      string
-	Password  string
-	Coins     int
-	Inventory inventory.Inventory
+	Id       int
+	Name     string
+	Password string
+	Role     Role
+	// Coins is the balance of the user's ledger wallet. It is read-only,
+	// balances change only through ledger entries.
+	Coins int
 }
 
 type UserRepo interface {
 	GetUserByID(ctx context.Context, id int) (User, error)
+	GetUserByIDForUpdate(ctx context.Context, id int) (User, error)
 	GetUserByName(ctx context.Context, name string) (User, error)
 	CreateUser(ctx context.Context, user User) (int, error)
-	UpdateUser(ctx context.Context, user User) error
+	UpdateRole(ctx context.Context, name string, role Role) error
+	UpdatePassword(ctx context.Context, id int, password string) error
 }
 
-func (u *User) SetPassword(password string) error {
-	res, err := hash.HashPassword(password)
+func (u *User) SetPassword(hasher *hash.Hasher, password string) error {
+	res, err := hasher.Hash(password)
+	if errors.Is(err, hash.ErrPasswordTooLong) {
+		return fmt.Errorf("%w: %w", ErrWeakPassword, err)
+	}
 	if err != nil {
-		return fmt.Errorf("cannot generate password")
+		return fmt.Errorf("cannot generate password: %w", err)
 	}
 
 	u.Password = res
 	return nil
 }
 
-func (u *User) CheckPassword(password string) bool {
-	ok := hash.VerifyPassword(password, u.Password)
+func (u *User) CheckPassword(hasher *hash.Hasher, password string) bool {
+	ok := hasher.Verify(password, u.Password)
 	return ok
 }
@@ -0,0 +1,19 @@
+DROP TABLE IF EXISTS
+    oidc_login_states,
+    user_identities,
+    login_lockouts,
+    api_keys,
+    password_resets,
+    user_revocations,
+    revoked_tokens,
+    refresh_tokens,
+    idempotency_keys,
+    purchases,
+    postings,
+    ledger_entries,
+    accounts,
+    catalog_audit,
+    items,
+    transactions,
+    user_items,
+    "users";
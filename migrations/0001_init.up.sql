@@ -0,0 +1,265 @@
+-- baseline schema. Statements are idempotent, so databases created from
+-- init.sql before schema_migrations existed are adopted as they are.
+
+CREATE TABLE IF NOT EXISTS "users" (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(16) UNIQUE NOT NULL,
+    password VARCHAR(256) NOT NULL,
+    role VARCHAR(16) NOT NULL DEFAULT 'employee' CHECK (role IN ('employee', 'admin', 'auditor'))
+);
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS role VARCHAR(16) NOT NULL DEFAULT 'employee'
+    CHECK (role IN ('employee', 'admin', 'auditor'));
+
+-- items owned by users, item_type is the item name at purchase time
+CREATE TABLE IF NOT EXISTS user_items (
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    item_type VARCHAR(10) NOT NULL,
+    quantity INTEGER NOT NULL CHECK (quantity >= 0),
+    PRIMARY KEY (user_id, item_type)
+);
+
+CREATE INDEX IF NOT EXISTS user_items_item_type_idx ON user_items (item_type);
+
+-- move inventories of databases created before user_items out of the
+-- users.inventory JSON column
+DO $$
+BEGIN
+    IF NOT EXISTS (
+        SELECT 1 FROM information_schema.columns
+        WHERE table_name = 'users' AND column_name = 'inventory'
+    ) THEN
+        RETURN;
+    END IF;
+
+    INSERT INTO user_items (user_id, item_type, quantity)
+    SELECT u.id, i->>'ItemType', SUM((i->>'Quantity')::INTEGER)
+    FROM users u, json_array_elements(u.inventory) i
+    WHERE json_typeof(u.inventory) = 'array'
+    GROUP BY u.id, i->>'ItemType'
+    HAVING SUM((i->>'Quantity')::INTEGER) > 0
+    ON CONFLICT (user_id, item_type)
+    DO UPDATE SET quantity = user_items.quantity + EXCLUDED.quantity;
+
+    ALTER TABLE users DROP COLUMN inventory;
+END $$;
+
+CREATE TABLE IF NOT EXISTS transactions (
+    id SERIAL PRIMARY KEY,
+    from_user INTEGER REFERENCES users(id) ON DELETE CASCADE,
+    to_user INTEGER REFERENCES users(id) ON DELETE CASCADE,
+    amount INTEGER NOT NULL CHECK (amount > 0),
+    timestamp TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS transactions_from_user_idx ON transactions (from_user, timestamp DESC, id DESC);
+CREATE INDEX IF NOT EXISTS transactions_to_user_idx ON transactions (to_user, timestamp DESC, id DESC);
+
+CREATE TABLE IF NOT EXISTS items (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(10) NOT NULL UNIQUE,
+    cost INT NOT NULL,
+    available BOOLEAN NOT NULL DEFAULT TRUE
+);
+
+ALTER TABLE items ADD COLUMN IF NOT EXISTS available BOOLEAN NOT NULL DEFAULT TRUE;
+
+CREATE TABLE IF NOT EXISTS catalog_audit (
+    id SERIAL PRIMARY KEY,
+    actor_id INTEGER REFERENCES users(id) ON DELETE SET NULL,
+    action VARCHAR(16) NOT NULL,
+    item_name VARCHAR(10) NOT NULL,
+    before JSONB,
+    after JSONB,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- double-entry ledger: every movement of coins is an entry whose postings
+-- sum to zero, accounts.balance caches the sum of an account's postings
+CREATE TABLE IF NOT EXISTS accounts (
+    id SERIAL PRIMARY KEY,
+    kind VARCHAR(16) NOT NULL,
+    user_id INTEGER UNIQUE REFERENCES users(id),
+    balance INT NOT NULL DEFAULT 0,
+    CHECK (kind <> 'wallet' OR (user_id IS NOT NULL AND balance >= 0))
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS accounts_system_kind_idx ON accounts (kind) WHERE user_id IS NULL;
+
+CREATE TABLE IF NOT EXISTS ledger_entries (
+    id SERIAL PRIMARY KEY,
+    kind VARCHAR(16) NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS postings (
+    id SERIAL PRIMARY KEY,
+    entry_id INTEGER NOT NULL REFERENCES ledger_entries(id),
+    account_id INTEGER NOT NULL REFERENCES accounts(id),
+    amount INT NOT NULL CHECK (amount <> 0)
+);
+
+CREATE INDEX IF NOT EXISTS postings_entry_idx ON postings (entry_id);
+CREATE INDEX IF NOT EXISTS postings_account_idx ON postings (account_id);
+
+INSERT INTO accounts (kind) VALUES
+    ('mint'),
+    ('revenue')
+ON CONFLICT (kind) WHERE user_id IS NULL DO NOTHING;
+
+-- move balances of databases created before the ledger into wallets
+DO $$
+DECLARE
+    u RECORD;
+    v_mint INTEGER;
+    v_wallet INTEGER;
+    v_entry INTEGER;
+BEGIN
+    IF NOT EXISTS (
+        SELECT 1 FROM information_schema.columns
+        WHERE table_name = 'users' AND column_name = 'coins'
+    ) THEN
+        RETURN;
+    END IF;
+
+    SELECT id INTO v_mint FROM accounts WHERE kind = 'mint' AND user_id IS NULL;
+
+    FOR u IN SELECT id, COALESCE(coins, 0) AS coins FROM users LOOP
+        INSERT INTO accounts (kind, user_id) VALUES ('wallet', u.id)
+        ON CONFLICT (user_id) DO NOTHING;
+
+        IF u.coins > 0 THEN
+            SELECT id INTO v_wallet FROM accounts WHERE user_id = u.id;
+            INSERT INTO ledger_entries (kind) VALUES ('grant') RETURNING id INTO v_entry;
+            INSERT INTO postings (entry_id, account_id, amount) VALUES
+                (v_entry, v_mint, -u.coins),
+                (v_entry, v_wallet, u.coins);
+            UPDATE accounts SET balance = balance - u.coins WHERE id = v_mint;
+            UPDATE accounts SET balance = balance + u.coins WHERE id = v_wallet;
+        END IF;
+    END LOOP;
+
+    ALTER TABLE users DROP COLUMN coins;
+END $$;
+
+CREATE TABLE IF NOT EXISTS purchases (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    item_name VARCHAR(10) NOT NULL,
+    cost INT NOT NULL CHECK (cost >= 0),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS purchases_user_idx ON purchases (user_id, created_at);
+
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    key VARCHAR(255) NOT NULL,
+    fingerprint VARCHAR(64) NOT NULL,
+    status_code INT NOT NULL DEFAULT 0,
+    response BYTEA,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (user_id, key)
+);
+
+CREATE INDEX IF NOT EXISTS idempotency_keys_created_at_idx ON idempotency_keys (created_at);
+
+CREATE TABLE IF NOT EXISTS refresh_tokens (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    family_id VARCHAR(32) NOT NULL,
+    token_hash VARCHAR(64) NOT NULL UNIQUE,
+    expires_at TIMESTAMPTZ NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    used_at TIMESTAMPTZ,
+    revoked_at TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS refresh_tokens_family_idx ON refresh_tokens (family_id);
+CREATE INDEX IF NOT EXISTS refresh_tokens_user_idx ON refresh_tokens (user_id);
+
+-- access tokens revoked before they expire, kept until expires_at
+CREATE TABLE IF NOT EXISTS revoked_tokens (
+    jti VARCHAR(32) PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    expires_at TIMESTAMPTZ NOT NULL,
+    revoked_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS revoked_tokens_expires_at_idx ON revoked_tokens (expires_at);
+
+-- access tokens of user_id issued before revoked_before are rejected
+CREATE TABLE IF NOT EXISTS user_revocations (
+    user_id INTEGER PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    revoked_before TIMESTAMPTZ NOT NULL
+);
+
+-- one-time tokens issued by admins to reset a user password
+CREATE TABLE IF NOT EXISTS password_resets (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    token_hash VARCHAR(64) NOT NULL UNIQUE,
+    created_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+    expires_at TIMESTAMPTZ NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    used_at TIMESTAMPTZ
+);
+
+-- long-lived scoped keys for bots, revoked keys are kept for reference
+CREATE TABLE IF NOT EXISTS api_keys (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    name VARCHAR(64) NOT NULL,
+    prefix VARCHAR(16) NOT NULL,
+    key_hash VARCHAR(64) NOT NULL UNIQUE,
+    scopes TEXT[] NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    last_used_at TIMESTAMPTZ,
+    revoked_at TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS api_keys_user_idx ON api_keys (user_id);
+
+-- failed logins per username and per client address
+CREATE TABLE IF NOT EXISTS login_lockouts (
+    scope VARCHAR(8) NOT NULL CHECK (scope IN ('user', 'ip')),
+    key TEXT NOT NULL,
+    failures INTEGER NOT NULL,
+    last_failure TIMESTAMPTZ NOT NULL,
+    locked_until TIMESTAMPTZ,
+    PRIMARY KEY (scope, key)
+);
+
+-- accounts at OpenID Connect providers, subjects are unique per issuer
+CREATE TABLE IF NOT EXISTS user_identities (
+    issuer TEXT NOT NULL,
+    subject TEXT NOT NULL,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (issuer, subject)
+);
+
+CREATE INDEX IF NOT EXISTS user_identities_user_idx ON user_identities (user_id);
+
+-- pending OpenID Connect logins between the redirect and the callback
+CREATE TABLE IF NOT EXISTS oidc_login_states (
+    state VARCHAR(64) PRIMARY KEY,
+    verifier VARCHAR(128) NOT NULL,
+    nonce VARCHAR(64) NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL
+);
+
+INSERT INTO items (name, cost) VALUES
+    ('t-shirt', 80),
+    ('cup', 20),
+    ('book', 50),
+    ('pen', 10),
+    ('powerbank', 200),
+    ('hoody', 300),
+    ('umbrella', 200),
+    ('socks', 10),
+    ('wallet', 50),
+    ('pink-hoody', 500)
+ON CONFLICT (name) DO NOTHING;
+
+-- add fake data
\ No newline at end of file
@@ -0,0 +1,13 @@
+ALTER TABLE user_items ADD COLUMN item_type VARCHAR(10);
+
+UPDATE user_items ui
+SET item_type = i.name
+FROM items i
+WHERE i.id = ui.item_id;
+
+ALTER TABLE user_items ALTER COLUMN item_type SET NOT NULL;
+ALTER TABLE user_items DROP CONSTRAINT user_items_pkey;
+ALTER TABLE user_items DROP COLUMN item_id;
+ALTER TABLE user_items ADD PRIMARY KEY (user_id, item_type);
+
+CREATE INDEX user_items_item_type_idx ON user_items (item_type);
@@ -0,0 +1,28 @@
+-- user_items refer to catalog items by id, so holdings follow renames
+
+-- holdings of items renamed before this migration have no catalog entry,
+-- they are kept as retired items with their last purchase price, or -1
+-- when it is unknown, so they cannot be restored without a new price
+INSERT INTO items (name, cost, available)
+SELECT DISTINCT ui.item_type, COALESCE((
+    SELECT p.cost FROM purchases p
+    WHERE p.item_name = ui.item_type
+    ORDER BY p.created_at DESC
+    LIMIT 1
+), -1), FALSE
+FROM user_items ui
+WHERE NOT EXISTS (SELECT 1 FROM items i WHERE i.name = ui.item_type);
+
+ALTER TABLE user_items ADD COLUMN item_id INTEGER REFERENCES items(id);
+
+UPDATE user_items ui
+SET item_id = i.id
+FROM items i
+WHERE i.name = ui.item_type;
+
+ALTER TABLE user_items ALTER COLUMN item_id SET NOT NULL;
+ALTER TABLE user_items DROP CONSTRAINT user_items_pkey;
+ALTER TABLE user_items DROP COLUMN item_type;
+ALTER TABLE user_items ADD PRIMARY KEY (user_id, item_id);
+
+CREATE INDEX user_items_item_id_idx ON user_items (item_id);
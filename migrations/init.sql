@@ -1,36 +0,0 @@
-CREATE TABLE IF NOT EXISTS "users" (
-    id SERIAL PRIMARY KEY,
-    name VARCHAR(16) UNIQUE NOT NULL,
-    password VARCHAR(256) NOT NULL,
-    coins INT DEFAULT 0,
-    inventory JSON DEFAULT '[]'
-);
-
-CREATE TABLE IF NOT EXISTS transactions (
-    id SERIAL PRIMARY KEY,
-    from_user INTEGER REFERENCES users(id) ON DELETE CASCADE,
-    to_user INTEGER REFERENCES users(id) ON DELETE CASCADE,
-    amount INTEGER NOT NULL CHECK (amount > 0),
-    timestamp TIMESTAMP DEFAULT CURRENT_TIMESTAMP
-);
-
-CREATE TABLE IF NOT EXISTS items (
-    id SERIAL PRIMARY KEY,
-    name VARCHAR(10) NOT NULL UNIQUE,
-    cost INT NOT NULL
-);
-
-INSERT INTO items (name, cost) VALUES
-    ('t-shirt', 80),
-    ('cup', 20),
-    ('book', 50),
-    ('pen', 10),
-    ('powerbank', 200),
-    ('hoody', 300),
-    ('umbrella', 200),
-    ('socks', 10),
-    ('wallet', 50),
-    ('pink-hoody', 500)
-ON CONFLICT (name) DO NOTHING;
-
--- add fake data
\ No newline at end of file
@@ -0,0 +1,12 @@
+// Package migrations embeds the SQL migrations applied by
+// "merch-store migrate" and MIGRATE_ON_START.
+package migrations
+
+import "embed"
+
+// Files holds NNNN_name.up.sql and NNNN_name.down.sql pairs, see
+// internal/migrate for the naming rules. Applied migrations must not be
+// edited, schema changes go into a new pair with the next number.
+//
+//go:embed *.sql
+var Files embed.FS
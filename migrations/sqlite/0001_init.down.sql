@@ -0,0 +1,18 @@
+DROP TABLE IF EXISTS oidc_login_states;
+DROP TABLE IF EXISTS user_identities;
+DROP TABLE IF EXISTS login_lockouts;
+DROP TABLE IF EXISTS api_keys;
+DROP TABLE IF EXISTS password_resets;
+DROP TABLE IF EXISTS user_revocations;
+DROP TABLE IF EXISTS revoked_tokens;
+DROP TABLE IF EXISTS refresh_tokens;
+DROP TABLE IF EXISTS idempotency_keys;
+DROP TABLE IF EXISTS purchases;
+DROP TABLE IF EXISTS postings;
+DROP TABLE IF EXISTS ledger_entries;
+DROP TABLE IF EXISTS accounts;
+DROP TABLE IF EXISTS catalog_audit;
+DROP TABLE IF EXISTS items;
+DROP TABLE IF EXISTS transactions;
+DROP TABLE IF EXISTS user_items;
+DROP TABLE IF EXISTS users;
@@ -0,0 +1,200 @@
+-- SQLite schema, see ../0001_init.up.sql for the Postgres one. SQLite
+-- does not enforce VARCHAR lengths, CHECKs stand in for them. Timestamps
+-- are written by the repos in UTC, so they compare as text.
+
+CREATE TABLE users (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name VARCHAR(16) UNIQUE NOT NULL CHECK (length(name) <= 16),
+    password VARCHAR(256) NOT NULL,
+    role VARCHAR(16) NOT NULL DEFAULT 'employee' CHECK (role IN ('employee', 'admin', 'auditor'))
+);
+
+-- items owned by users, item_type is the item name at purchase time
+CREATE TABLE user_items (
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    item_type VARCHAR(10) NOT NULL CHECK (length(item_type) <= 10),
+    quantity INTEGER NOT NULL CHECK (quantity >= 0),
+    PRIMARY KEY (user_id, item_type)
+);
+
+CREATE INDEX user_items_item_type_idx ON user_items (item_type);
+
+CREATE TABLE transactions (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    from_user INTEGER REFERENCES users(id) ON DELETE CASCADE,
+    to_user INTEGER REFERENCES users(id) ON DELETE CASCADE,
+    amount INTEGER NOT NULL CHECK (amount > 0),
+    timestamp TIMESTAMP NOT NULL
+);
+
+CREATE INDEX transactions_from_user_idx ON transactions (from_user, timestamp DESC, id DESC);
+CREATE INDEX transactions_to_user_idx ON transactions (to_user, timestamp DESC, id DESC);
+
+CREATE TABLE items (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name VARCHAR(10) NOT NULL UNIQUE CHECK (length(name) <= 10),
+    cost INTEGER NOT NULL,
+    available BOOLEAN NOT NULL DEFAULT TRUE
+);
+
+CREATE TABLE catalog_audit (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    actor_id INTEGER REFERENCES users(id) ON DELETE SET NULL,
+    action VARCHAR(16) NOT NULL,
+    item_name VARCHAR(10) NOT NULL,
+    before TEXT,
+    after TEXT,
+    created_at TIMESTAMP NOT NULL
+);
+
+-- double-entry ledger: every movement of coins is an entry whose postings
+-- sum to zero, accounts.balance caches the sum of an account's postings
+CREATE TABLE accounts (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    kind VARCHAR(16) NOT NULL,
+    user_id INTEGER UNIQUE REFERENCES users(id),
+    balance INTEGER NOT NULL DEFAULT 0,
+    CHECK (kind <> 'wallet' OR (user_id IS NOT NULL AND balance >= 0))
+);
+
+CREATE UNIQUE INDEX accounts_system_kind_idx ON accounts (kind) WHERE user_id IS NULL;
+
+CREATE TABLE ledger_entries (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    kind VARCHAR(16) NOT NULL,
+    created_at TIMESTAMP NOT NULL
+);
+
+CREATE TABLE postings (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    entry_id INTEGER NOT NULL REFERENCES ledger_entries(id),
+    account_id INTEGER NOT NULL REFERENCES accounts(id),
+    amount INTEGER NOT NULL CHECK (amount <> 0)
+);
+
+CREATE INDEX postings_entry_idx ON postings (entry_id);
+CREATE INDEX postings_account_idx ON postings (account_id);
+
+INSERT INTO accounts (kind) VALUES
+    ('mint'),
+    ('revenue');
+
+CREATE TABLE purchases (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    item_name VARCHAR(10) NOT NULL,
+    cost INTEGER NOT NULL CHECK (cost >= 0),
+    created_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX purchases_user_idx ON purchases (user_id, created_at);
+
+CREATE TABLE idempotency_keys (
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    key VARCHAR(255) NOT NULL,
+    fingerprint VARCHAR(64) NOT NULL,
+    status_code INTEGER NOT NULL DEFAULT 0,
+    response BLOB,
+    created_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (user_id, key)
+);
+
+CREATE INDEX idempotency_keys_created_at_idx ON idempotency_keys (created_at);
+
+CREATE TABLE refresh_tokens (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    family_id VARCHAR(32) NOT NULL,
+    token_hash VARCHAR(64) NOT NULL UNIQUE,
+    expires_at TIMESTAMP NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    used_at TIMESTAMP,
+    revoked_at TIMESTAMP
+);
+
+CREATE INDEX refresh_tokens_family_idx ON refresh_tokens (family_id);
+CREATE INDEX refresh_tokens_user_idx ON refresh_tokens (user_id);
+
+-- access tokens revoked before they expire, kept until expires_at
+CREATE TABLE revoked_tokens (
+    jti VARCHAR(32) PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    expires_at TIMESTAMP NOT NULL,
+    revoked_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX revoked_tokens_expires_at_idx ON revoked_tokens (expires_at);
+
+-- access tokens of user_id issued before revoked_before are rejected
+CREATE TABLE user_revocations (
+    user_id INTEGER PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    revoked_before TIMESTAMP NOT NULL
+);
+
+-- one-time tokens issued by admins to reset a user password
+CREATE TABLE password_resets (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    token_hash VARCHAR(64) NOT NULL UNIQUE,
+    created_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+    expires_at TIMESTAMP NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    used_at TIMESTAMP
+);
+
+-- long-lived scoped keys for bots, revoked keys are kept for reference.
+-- scopes is a JSON array
+CREATE TABLE api_keys (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    name VARCHAR(64) NOT NULL,
+    prefix VARCHAR(16) NOT NULL,
+    key_hash VARCHAR(64) NOT NULL UNIQUE,
+    scopes TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    last_used_at TIMESTAMP,
+    revoked_at TIMESTAMP
+);
+
+CREATE INDEX api_keys_user_idx ON api_keys (user_id);
+
+-- failed logins per username and per client address
+CREATE TABLE login_lockouts (
+    scope VARCHAR(8) NOT NULL CHECK (scope IN ('user', 'ip')),
+    key TEXT NOT NULL,
+    failures INTEGER NOT NULL,
+    last_failure TIMESTAMP NOT NULL,
+    locked_until TIMESTAMP,
+    PRIMARY KEY (scope, key)
+);
+
+-- accounts at OpenID Connect providers, subjects are unique per issuer
+CREATE TABLE user_identities (
+    issuer TEXT NOT NULL,
+    subject TEXT NOT NULL,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    created_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (issuer, subject)
+);
+
+CREATE INDEX user_identities_user_idx ON user_identities (user_id);
+
+-- pending OpenID Connect logins between the redirect and the callback
+CREATE TABLE oidc_login_states (
+    state VARCHAR(64) PRIMARY KEY,
+    verifier VARCHAR(128) NOT NULL,
+    nonce VARCHAR(64) NOT NULL,
+    expires_at TIMESTAMP NOT NULL
+);
+
+INSERT INTO items (name, cost) VALUES
+    ('t-shirt', 80),
+    ('cup', 20),
+    ('book', 50),
+    ('pen', 10),
+    ('powerbank', 200),
+    ('hoody', 300),
+    ('umbrella', 200),
+    ('socks', 10),
+    ('wallet', 50),
+    ('pink-hoody', 500);
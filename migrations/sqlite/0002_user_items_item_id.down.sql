@@ -0,0 +1,16 @@
+CREATE TABLE user_items_by_type (
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    item_type VARCHAR(10) NOT NULL CHECK (length(item_type) <= 10),
+    quantity INTEGER NOT NULL CHECK (quantity >= 0),
+    PRIMARY KEY (user_id, item_type)
+);
+
+INSERT INTO user_items_by_type (user_id, item_type, quantity)
+SELECT ui.user_id, i.name, ui.quantity
+FROM user_items ui
+JOIN items i ON i.id = ui.item_id;
+
+DROP TABLE user_items;
+ALTER TABLE user_items_by_type RENAME TO user_items;
+
+CREATE INDEX user_items_item_type_idx ON user_items (item_type);
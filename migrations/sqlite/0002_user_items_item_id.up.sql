@@ -0,0 +1,32 @@
+-- user_items refer to catalog items by id, so holdings follow renames
+
+-- holdings of items renamed before this migration have no catalog entry,
+-- they are kept as retired items with their last purchase price, or -1
+-- when it is unknown, so they cannot be restored without a new price
+INSERT INTO items (name, cost, available)
+SELECT DISTINCT ui.item_type, COALESCE((
+    SELECT p.cost FROM purchases p
+    WHERE p.item_name = ui.item_type
+    ORDER BY p.created_at DESC
+    LIMIT 1
+), -1), FALSE
+FROM user_items ui
+WHERE NOT EXISTS (SELECT 1 FROM items i WHERE i.name = ui.item_type);
+
+-- SQLite cannot change a primary key, the table is rebuilt
+CREATE TABLE user_items_by_id (
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    item_id INTEGER NOT NULL REFERENCES items(id),
+    quantity INTEGER NOT NULL CHECK (quantity >= 0),
+    PRIMARY KEY (user_id, item_id)
+);
+
+INSERT INTO user_items_by_id (user_id, item_id, quantity)
+SELECT ui.user_id, i.id, ui.quantity
+FROM user_items ui
+JOIN items i ON i.name = ui.item_type;
+
+DROP TABLE user_items;
+ALTER TABLE user_items_by_id RENAME TO user_items;
+
+CREATE INDEX user_items_item_id_idx ON user_items (item_id);
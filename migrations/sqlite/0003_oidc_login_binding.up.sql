@@ -0,0 +1,3 @@
+-- logins are bound to the browser that started them, pending logins
+-- without a binding can no longer be completed
+ALTER TABLE oidc_login_states ADD COLUMN binding_hash VARCHAR(64) NOT NULL DEFAULT '';
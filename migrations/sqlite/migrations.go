@@ -0,0 +1,11 @@
+// Package sqlite embeds the SQL migrations of the SQLite storage driver.
+package sqlite
+
+import "embed"
+
+// Files holds NNNN_name.up.sql and NNNN_name.down.sql pairs. Schema
+// changes to the Postgres migrations in the parent directory need a
+// counterpart here.
+//
+//go:embed *.sql
+var Files embed.FS
@@ -0,0 +1,113 @@
+package password
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// defaults follow the OWASP recommendation for argon2id
+const (
+	defaultArgonMemory  = 19 * 1024
+	defaultArgonTime    = 2
+	defaultArgonThreads = 1
+	argonSaltLen        = 16
+	argonKeyLen         = 32
+)
+
+// Argon2id hashes into the PHC string format
+// $argon2id$v=19$m=<memory KiB>,t=<iterations>,p=<parallelism>$<salt>$<key>.
+// Zero fields take the defaults.
+type Argon2id struct {
+	Memory  uint32
+	Time    uint32
+	Threads uint8
+}
+
+func (a Argon2id) withDefaults() Argon2id {
+	if a.Memory == 0 {
+		a.Memory = defaultArgonMemory
+	}
+	if a.Time == 0 {
+		a.Time = defaultArgonTime
+	}
+	if a.Threads == 0 {
+		a.Threads = defaultArgonThreads
+	}
+	return a
+}
+
+func (a Argon2id) Hash(password string) (string, error) {
+	a = a.withDefaults()
+
+	salt := make([]byte, argonSaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+
+	key := argon2.IDKey([]byte(password), salt, a.Time, a.Memory, a.Threads, argonKeyLen)
+
+	return fmt.Sprintf(
+		"$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, a.Memory, a.Time, a.Threads,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+func (a Argon2id) Verify(password, hash string) (bool, error) {
+	params, salt, key, err := parseArgon2id(hash)
+	if err != nil {
+		return false, err
+	}
+
+	other := argon2.IDKey(
+		[]byte(password), salt, params.Time, params.Memory, params.Threads, uint32(len(key)),
+	)
+
+	return subtle.ConstantTimeCompare(key, other) == 1, nil
+}
+
+func (a Argon2id) Recognizes(hash string) bool {
+	return strings.HasPrefix(hash, "$argon2id$")
+}
+
+func (a Argon2id) Outdated(hash string) bool {
+	params, _, key, err := parseArgon2id(hash)
+	return err != nil || params != a.withDefaults() || len(key) != argonKeyLen
+}
+
+func parseArgon2id(hash string) (Argon2id, []byte, []byte, error) {
+	var params Argon2id
+
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return params, nil, nil, ErrUnknownHash
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return params, nil, nil, fmt.Errorf("unsupported argon2id version: %s", parts[2])
+	}
+
+	_, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Time, &params.Threads)
+	if err != nil || params.Time == 0 || params.Threads == 0 {
+		return params, nil, nil, fmt.Errorf("invalid argon2id parameters: %s", parts[3])
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return params, nil, nil, fmt.Errorf("invalid argon2id salt: %w", err)
+	}
+
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return params, nil, nil, fmt.Errorf("invalid argon2id key: %w", err)
+	}
+
+	return params, salt, key, nil
+}
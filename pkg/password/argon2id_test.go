@@ -0,0 +1,65 @@
+package password_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/437d5/merch-store/pkg/password"
+)
+
+func TestArgon2idFormat(t *testing.T) {
+	hash, err := fastArgon.Hash("password")
+	if err != nil {
+		t.Fatalf("hash: %v", err)
+	}
+
+	if !strings.HasPrefix(hash, "$argon2id$v=19$m=1024,t=1,p=1$") {
+		t.Errorf("hash = %s, want the PHC format with the parameters", hash)
+	}
+	if !fastArgon.Recognizes(hash) || (password.Bcrypt{}).Recognizes(hash) {
+		t.Errorf("hash %s recognized by the wrong algorithm", hash)
+	}
+
+	// salted, the same password hashes differently
+	other, err := fastArgon.Hash("password")
+	if err != nil {
+		t.Fatalf("hash: %v", err)
+	}
+	if other == hash {
+		t.Error("two hashes of a password are equal")
+	}
+
+	// zero parameters are the defaults
+	defaults, err := password.Argon2id{}.Hash("password")
+	if err != nil {
+		t.Fatalf("hash: %v", err)
+	}
+	if (password.Argon2id{}).Outdated(defaults) {
+		t.Errorf("hash %s with the defaults is outdated", defaults)
+	}
+}
+
+func TestArgon2idInvalidHash(t *testing.T) {
+	valid, err := fastArgon.Hash("password")
+	if err != nil {
+		t.Fatalf("hash: %v", err)
+	}
+	parts := strings.Split(valid, "$")
+
+	for name, hash := range map[string]string{
+		"bcrypt":       "$2a$04$abcdefghijklmnopqrstuu",
+		"fields":       "$argon2id$v=19$m=1024,t=1,p=1$salt",
+		"version":      strings.Replace(valid, "v=19", "v=16", 1),
+		"parameters":   strings.Replace(valid, "t=1", "t=0", 1),
+		"salt":         strings.Join([]string{"", parts[1], parts[2], parts[3], "!!", parts[5]}, "$"),
+		"key":          strings.Join([]string{"", parts[1], parts[2], parts[3], parts[4], ""}, "$"),
+		"key encoding": strings.Join([]string{"", parts[1], parts[2], parts[3], parts[4], "!!"}, "$"),
+	} {
+		if ok, err := fastArgon.Verify("password", hash); ok || err == nil {
+			t.Errorf("%s: verify = %v, %v, want an error", name, ok, err)
+		}
+		if !fastArgon.Outdated(hash) {
+			t.Errorf("%s: invalid hash is not outdated", name)
+		}
+	}
+}
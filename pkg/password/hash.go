@@ -1,13 +1,143 @@
+// Package password hashes user passwords. Hashes describe their own
+// algorithm and parameters, bcrypt in its modular crypt format and
+// argon2id in the PHC string format, so a Hasher verifies hashes made
+// with any supported settings and tells which ones are outdated.
 package password
 
-import "golang.org/x/crypto/bcrypt"
+import (
+	"errors"
+	"fmt"
+	"strings"
 
-func HashPassword(password string) (string, error) {
-	bytes, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	ErrPasswordTooLong = errors.New("password too long")
+	ErrUnknownHash     = errors.New("unknown password hash format")
+)
+
+const (
+	AlgBcrypt   = "bcrypt"
+	AlgArgon2id = "argon2id"
+)
+
+// Algorithm is a password hashing scheme with fixed parameters.
+type Algorithm interface {
+	Hash(password string) (string, error)
+	// Verify reports whether password matches hash. The hash may have
+	// been made with different parameters of the same scheme.
+	Verify(password, hash string) (bool, error)
+	// Recognizes reports whether hash was made by this scheme.
+	Recognizes(hash string) bool
+	// Outdated reports whether hash was made with other parameters.
+	Outdated(hash string) bool
+}
+
+// Hasher makes new hashes with its current algorithm and verifies
+// hashes of every supported algorithm.
+type Hasher struct {
+	current    Algorithm
+	algorithms []Algorithm
+}
+
+func NewHasher(current Algorithm) *Hasher {
+	return &Hasher{
+		current:    current,
+		algorithms: []Algorithm{current, Bcrypt{}, Argon2id{}},
+	}
+}
+
+// New returns a Hasher for the named algorithm, the zero parameters
+// of the algorithm are replaced with its defaults.
+func New(alg string, bcryptCost int, argon Argon2id) (*Hasher, error) {
+	switch alg {
+	case AlgBcrypt:
+		if bcryptCost != 0 && (bcryptCost < bcrypt.MinCost || bcryptCost > bcrypt.MaxCost) {
+			return nil, fmt.Errorf("bcrypt cost must be between %d and %d", bcrypt.MinCost, bcrypt.MaxCost)
+		}
+		return NewHasher(Bcrypt{Cost: bcryptCost}), nil
+	case AlgArgon2id:
+		return NewHasher(argon), nil
+	default:
+		return nil, fmt.Errorf("unsupported password hash algorithm %q", alg)
+	}
+}
+
+func (h *Hasher) Hash(password string) (string, error) {
+	return h.current.Hash(password)
+}
+
+func (h *Hasher) Verify(password, hash string) bool {
+	alg, err := h.algorithm(hash)
+	if err != nil {
+		return false
+	}
+
+	ok, err := alg.Verify(password, hash)
+	return err == nil && ok
+}
+
+// NeedsRehash reports whether hash should be replaced with one made by
+// the current algorithm and parameters.
+func (h *Hasher) NeedsRehash(hash string) bool {
+	return !h.current.Recognizes(hash) || h.current.Outdated(hash)
+}
+
+func (h *Hasher) algorithm(hash string) (Algorithm, error) {
+	for _, alg := range h.algorithms {
+		if alg.Recognizes(hash) {
+			return alg, nil
+		}
+	}
+
+	return nil, ErrUnknownHash
+}
+
+// BcryptMaxLen is the input limit of bcrypt in bytes, longer passwords
+// are rejected instead of being truncated. Argon2id has no such limit.
+const BcryptMaxLen = 72
+
+// Bcrypt hashes with the given cost, bcrypt.DefaultCost when zero.
+type Bcrypt struct {
+	Cost int
+}
+
+func (b Bcrypt) cost() int {
+	if b.Cost == 0 {
+		return bcrypt.DefaultCost
+	}
+	return b.Cost
+}
+
+func (b Bcrypt) Hash(password string) (string, error) {
+	if len(password) > BcryptMaxLen {
+		return "", ErrPasswordTooLong
+	}
+
+	bytes, err := bcrypt.GenerateFromPassword([]byte(password), b.cost())
 	return string(bytes), err
 }
 
-func VerifyPassword(password, hash string) bool {
+func (b Bcrypt) Verify(password, hash string) (bool, error) {
+	if len(password) > BcryptMaxLen {
+		return false, ErrPasswordTooLong
+	}
+
 	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
-	return err == nil
+	if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+		return false, nil
+	}
+
+	return err == nil, err
+}
+
+func (b Bcrypt) Recognizes(hash string) bool {
+	return strings.HasPrefix(hash, "$2a$") || strings.HasPrefix(hash, "$2b$") ||
+		strings.HasPrefix(hash, "$2y$")
+}
+
+func (b Bcrypt) Outdated(hash string) bool {
+	cost, err := bcrypt.Cost([]byte(hash))
+	return err != nil || cost != b.cost()
 }
@@ -0,0 +1,128 @@
+package password_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/437d5/merch-store/pkg/password"
+)
+
+// the lowest costs keep the tests fast
+var (
+	fastBcrypt = password.Bcrypt{Cost: 4}
+	fastArgon  = password.Argon2id{Memory: 1024, Time: 1, Threads: 1}
+)
+
+func TestVerifyAcrossAlgorithms(t *testing.T) {
+	bcryptHasher := password.NewHasher(fastBcrypt)
+	argonHasher := password.NewHasher(fastArgon)
+
+	hashes := map[string]string{}
+	for name, h := range map[string]*password.Hasher{"bcrypt": bcryptHasher, "argon2id": argonHasher} {
+		hash, err := h.Hash("password")
+		if err != nil {
+			t.Fatalf("%s hash: %v", name, err)
+		}
+		hashes[name] = hash
+	}
+
+	// either hasher verifies the hashes of both, so changing the
+	// algorithm keeps every password working
+	for hasherName, h := range map[string]*password.Hasher{"bcrypt": bcryptHasher, "argon2id": argonHasher} {
+		for hashName, hash := range hashes {
+			if !h.Verify("password", hash) {
+				t.Errorf("%s hasher rejects the %s hash", hasherName, hashName)
+			}
+			if h.Verify("wrong-password", hash) {
+				t.Errorf("%s hasher accepts a wrong password for the %s hash", hasherName, hashName)
+			}
+		}
+
+		if h.Verify("password", "plain") {
+			t.Errorf("%s hasher accepts an unknown hash", hasherName)
+		}
+	}
+}
+
+func TestNeedsRehash(t *testing.T) {
+	bcryptHash, err := password.NewHasher(fastBcrypt).Hash("password")
+	if err != nil {
+		t.Fatalf("bcrypt hash: %v", err)
+	}
+	argonHash, err := password.NewHasher(fastArgon).Hash("password")
+	if err != nil {
+		t.Fatalf("argon2id hash: %v", err)
+	}
+
+	for _, tc := range []struct {
+		name    string
+		current password.Algorithm
+		hash    string
+		want    bool
+	}{
+		{"same bcrypt cost", fastBcrypt, bcryptHash, false},
+		{"other bcrypt cost", password.Bcrypt{Cost: 5}, bcryptHash, true},
+		{"bcrypt to argon2id", fastArgon, bcryptHash, true},
+		{"same argon2id parameters", fastArgon, argonHash, false},
+		{"other argon2id memory", password.Argon2id{Memory: 2048, Time: 1, Threads: 1}, argonHash, true},
+		{"other argon2id time", password.Argon2id{Memory: 1024, Time: 2, Threads: 1}, argonHash, true},
+		{"argon2id to bcrypt", fastBcrypt, argonHash, true},
+		{"unknown hash", fastBcrypt, "plain", true},
+	} {
+		if got := password.NewHasher(tc.current).NeedsRehash(tc.hash); got != tc.want {
+			t.Errorf("%s: NeedsRehash = %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestPasswordTooLong(t *testing.T) {
+	limit := strings.Repeat("a", password.BcryptMaxLen)
+	long := limit + "a"
+
+	bcryptHasher := password.NewHasher(fastBcrypt)
+	if _, err := bcryptHasher.Hash(limit); err != nil {
+		t.Errorf("bcrypt hash of %d bytes: %v", len(limit), err)
+	}
+	if _, err := bcryptHasher.Hash(long); !errors.Is(err, password.ErrPasswordTooLong) {
+		t.Errorf("bcrypt hash of %d bytes: got %v, want %v", len(long), err, password.ErrPasswordTooLong)
+	}
+
+	// bcrypt would ignore the bytes past the limit, a hash of the
+	// limit must not match a longer password
+	hash, err := bcryptHasher.Hash(limit)
+	if err != nil {
+		t.Fatalf("bcrypt hash: %v", err)
+	}
+	if ok, err := fastBcrypt.Verify(long, hash); ok || !errors.Is(err, password.ErrPasswordTooLong) {
+		t.Errorf("bcrypt verify of %d bytes = %v, %v, want %v", len(long), ok, err, password.ErrPasswordTooLong)
+	}
+
+	argonHasher := password.NewHasher(fastArgon)
+	longer := strings.Repeat("a", 1000)
+	hash, err = argonHasher.Hash(longer)
+	if err != nil {
+		t.Fatalf("argon2id hash of %d bytes: %v", len(longer), err)
+	}
+	if !argonHasher.Verify(longer, hash) {
+		t.Errorf("argon2id rejects a password of %d bytes", len(longer))
+	}
+	if argonHasher.Verify(longer[:len(longer)-1], hash) {
+		t.Error("argon2id ignores the last byte of a long password")
+	}
+}
+
+func TestNew(t *testing.T) {
+	for _, alg := range []string{password.AlgBcrypt, password.AlgArgon2id} {
+		if _, err := password.New(alg, 0, password.Argon2id{}); err != nil {
+			t.Errorf("new %s: %v", alg, err)
+		}
+	}
+
+	if _, err := password.New(password.AlgBcrypt, 100, password.Argon2id{}); err == nil {
+		t.Error("bcrypt cost 100 accepted")
+	}
+	if _, err := password.New("md5", 0, password.Argon2id{}); err == nil {
+		t.Error("md5 accepted")
+	}
+}
@@ -11,26 +11,39 @@ var (
 	ErrInvalidToken = errors.New("invalid JWT")
 )
 
-func ValidateToken(token, secret string) (int, bool, error) {
+// ValidateToken checks the token against the key named by its kid header.
+// Tokens without a kid are checked against the active key. The token alg
+// must match the algorithm of the key.
+func ValidateToken(token string, keys *KeySet) (*TokenClaims, bool, error) {
 	parsedToken, err := jwt.ParseWithClaims(token, &TokenClaims{}, func(t *jwt.Token) (interface{}, error) {
-		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
+		kid, _ := t.Header["kid"].(string)
+		if kid == "" {
+			kid = keys.Active
+		}
+
+		key, err := keys.Key(kid)
+		if err != nil {
+			return nil, err
+		}
+
+		if t.Method.Alg() != key.signingMethod().Alg() {
 			return nil, fmt.Errorf("unexpected sign method: %v", t.Header["alg"])
 		}
-		return []byte(secret), nil
+		return key.verifyKey(), nil
 	})
 
 	if err != nil {
-		return 0, false, fmt.Errorf("invalid token: %s", err)
+		return nil, false, fmt.Errorf("invalid token: %s", err)
 	}
 
 	if !parsedToken.Valid {
-		return 0, false, ErrInvalidToken
+		return nil, false, ErrInvalidToken
 	}
 
 	claims, ok := parsedToken.Claims.(*TokenClaims)
 	if !ok {
-		return 0, false, ErrInvalidToken
+		return nil, false, ErrInvalidToken
 	}
 
-	return claims.Id, true, nil
+	return claims, true, nil
 }
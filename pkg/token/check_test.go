@@ -0,0 +1,283 @@
+package token_test
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/437d5/merch-store/pkg/token"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// kid returns the kid header of tok without verifying it.
+func kid(t *testing.T, tok string) string {
+	t.Helper()
+
+	parsed, _, err := jwt.NewParser().ParseUnverified(tok, &token.TokenClaims{})
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	kid, _ := parsed.Header["kid"].(string)
+
+	return kid
+}
+
+func TestCreateValidate(t *testing.T) {
+	keys := token.NewKeySet("k1", []byte("secret"))
+
+	before := time.Now()
+	tok, err := token.CreateToken(7, "alice", "admin", "session", keys, time.Hour)
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+
+	claims, ok, err := token.ValidateToken(tok, keys)
+	if !ok || err != nil {
+		t.Fatalf("validate: %v", err)
+	}
+	if claims.Id != 7 || claims.Username != "alice" || claims.Role != "admin" || claims.SessionId != "session" {
+		t.Errorf("claims = %+v", claims)
+	}
+	if claims.ID == "" {
+		t.Error("token has no jti")
+	}
+	if exp := claims.ExpiresAt.Sub(before); exp < time.Hour-time.Second || exp > time.Hour+time.Second {
+		t.Errorf("expires in %v, want %v", exp, time.Hour)
+	}
+	if got := kid(t, tok); got != "k1" {
+		t.Errorf("kid = %q, want k1", got)
+	}
+
+	other, err := token.CreateToken(7, "alice", "admin", "session", keys, time.Hour)
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	if otherClaims, _, _ := token.ValidateToken(other, keys); otherClaims.ID == claims.ID {
+		t.Error("two tokens share a jti")
+	}
+}
+
+func TestIssuedAtPrecision(t *testing.T) {
+	keys := token.NewKeySet("k1", []byte("secret"))
+
+	before := time.Now().Truncate(time.Microsecond)
+	tok, err := token.CreateToken(7, "alice", "admin", "session", keys, time.Hour)
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	after := time.Now()
+
+	claims, _, err := token.ValidateToken(tok, keys)
+	if err != nil {
+		t.Fatalf("validate: %v", err)
+	}
+	if iat := claims.IssuedAt.Time; iat.Before(before) || iat.After(after) {
+		t.Errorf("iat = %v, want between %v and %v", iat, before, after)
+	}
+	if jwt.TimePrecision != time.Second {
+		t.Errorf("jwt.TimePrecision = %v, want it left at %v", jwt.TimePrecision, time.Second)
+	}
+}
+
+func TestValidateRejects(t *testing.T) {
+	keys := token.NewKeySet("k1", []byte("secret"))
+
+	expired, err := token.CreateToken(1, "alice", "employee", "s", keys, -time.Minute)
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+
+	// same kid, different secret
+	forged, err := token.CreateToken(1, "alice", "admin", "s", token.NewKeySet("k1", []byte("guess")), time.Hour)
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+
+	unknown, err := token.CreateToken(1, "alice", "employee", "s", token.NewKeySet("k2", []byte("secret")), time.Hour)
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+
+	valid, err := token.CreateToken(1, "alice", "employee", "s", keys, time.Hour)
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	// change the first character of the signature, it carries 6 bits
+	sig := strings.LastIndexByte(valid, '.') + 1
+	swap := "A"
+	if valid[sig] == 'A' {
+		swap = "B"
+	}
+	tampered := valid[:sig] + swap + valid[sig+1:]
+
+	for name, tok := range map[string]string{
+		"expired":     expired,
+		"forged":      forged,
+		"unknown kid": unknown,
+		"tampered":    tampered,
+		"garbage":     "not.a.token",
+	} {
+		claims, ok, err := token.ValidateToken(tok, keys)
+		if ok || err == nil || claims != nil {
+			t.Errorf("%s token accepted", name)
+		}
+	}
+}
+
+func TestRotation(t *testing.T) {
+	keys := token.NewKeySet("old", []byte("old-secret"))
+
+	oldTok, err := token.CreateToken(1, "alice", "employee", "s", keys, time.Hour)
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+
+	newKid, err := keys.Generate(token.AlgHS256)
+	if err != nil {
+		t.Fatalf("generate: %v", err)
+	}
+	if err = keys.Activate(newKid); err != nil {
+		t.Fatalf("activate: %v", err)
+	}
+
+	newTok, err := token.CreateToken(1, "alice", "employee", "s", keys, time.Hour)
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	if got := kid(t, newTok); got != newKid {
+		t.Errorf("new token kid = %q, want %q", got, newKid)
+	}
+
+	// both keys verify during the rotation, each its own tokens
+	for name, tok := range map[string]string{"old": oldTok, "new": newTok} {
+		if _, ok, err := token.ValidateToken(tok, keys); !ok || err != nil {
+			t.Errorf("validate %s token: %v", name, err)
+		}
+	}
+
+	if err = keys.Remove("old"); err != nil {
+		t.Fatalf("remove: %v", err)
+	}
+	if _, ok, err := token.ValidateToken(oldTok, keys); ok || err == nil {
+		t.Error("token of a removed key accepted")
+	}
+	if _, ok, err := token.ValidateToken(newTok, keys); !ok || err != nil {
+		t.Errorf("validate new token after removal: %v", err)
+	}
+}
+
+func TestAsymmetricRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.json")
+
+	for _, alg := range []string{token.AlgRS256, token.AlgES256, token.AlgEdDSA} {
+		t.Run(alg, func(t *testing.T) {
+			keys := &token.KeySet{}
+			kid, err := keys.Generate(alg)
+			if err != nil {
+				t.Fatalf("generate: %v", err)
+			}
+			keys.Active = kid
+
+			tok, err := token.CreateToken(1, "alice", "employee", "s", keys, time.Hour)
+			if err != nil {
+				t.Fatalf("create: %v", err)
+			}
+
+			parsed, _, err := jwt.NewParser().ParseUnverified(tok, &token.TokenClaims{})
+			if err != nil {
+				t.Fatalf("parse: %v", err)
+			}
+			if got := parsed.Method.Alg(); got != alg {
+				t.Errorf("alg header = %s, want %s", got, alg)
+			}
+
+			// the private key survives the key file
+			if err = keys.Save(path); err != nil {
+				t.Fatalf("save: %v", err)
+			}
+			loaded, err := token.LoadKeySet(path)
+			if err != nil {
+				t.Fatalf("load: %v", err)
+			}
+
+			claims, ok, err := token.ValidateToken(tok, loaded)
+			if !ok || err != nil {
+				t.Fatalf("validate: %v", err)
+			}
+			if claims.Username != "alice" {
+				t.Errorf("claims = %+v", claims)
+			}
+		})
+	}
+}
+
+func TestAlgMismatch(t *testing.T) {
+	keys := &token.KeySet{}
+	for _, alg := range []string{token.AlgRS256, token.AlgES256, token.AlgEdDSA} {
+		kid, err := keys.Generate(alg)
+		if err != nil {
+			t.Fatalf("generate %s: %v", alg, err)
+		}
+		keys.Active = kid
+	}
+	rsaKey, ecKey, edKey := keys.Keys[0], keys.Keys[1], keys.Keys[2]
+
+	claims := token.TokenClaims{
+		Username: "mallory",
+		Role:     "admin",
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+		},
+	}
+	sign := func(method jwt.SigningMethod, kid string, key any) string {
+		t.Helper()
+
+		tok := jwt.NewWithClaims(method, claims)
+		tok.Header["kid"] = kid
+		s, err := tok.SignedString(key)
+		if err != nil {
+			t.Fatalf("sign: %v", err)
+		}
+
+		return s
+	}
+
+	for name, tok := range map[string]string{
+		// HMAC keyed with the public key, which anyone can fetch
+		"HS256 with an RSA kid": sign(
+			jwt.SigningMethodHS256, rsaKey.Kid, []byte(rsaKey.PrivateKey),
+		),
+		"EdDSA with an ES256 kid": sign(
+			jwt.SigningMethodEdDSA, ecKey.Kid, privateKey(t, edKey),
+		),
+		"ES256 with an EdDSA kid": sign(
+			jwt.SigningMethodES256, edKey.Kid, privateKey(t, ecKey),
+		),
+		"RS256 with an ES256 kid": sign(
+			jwt.SigningMethodRS256, ecKey.Kid, privateKey(t, rsaKey),
+		),
+	} {
+		if _, ok, err := token.ValidateToken(tok, keys); ok || err == nil {
+			t.Errorf("%s accepted", name)
+		}
+	}
+}
+
+// privateKey decodes the PEM private key of k.
+func privateKey(t *testing.T, k token.Key) any {
+	t.Helper()
+
+	block, _ := pem.Decode([]byte(k.PrivateKey))
+	if block == nil {
+		t.Fatalf("key %s is not PEM encoded", k.Kid)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		t.Fatalf("parse key %s: %v", k.Kid, err)
+	}
+
+	return key
+}
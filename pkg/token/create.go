@@ -1,37 +1,109 @@
 package token
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"fmt"
+	"math"
+	"strconv"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 )
 
 const (
-	JWTExpAt = 1
+	DefaultAccessTTL = time.Hour
 )
 
+// TokenClaims carry a unique jti so a single token can be revoked, and
+// the SessionId of the refresh token family the token was issued with.
 type TokenClaims struct {
-	Id       int
-	Username string
+	Id        int
+	Username  string
+	Role      string
+	SessionId string
+	// IssuedAt hides the iat of RegisteredClaims. iat is compared with
+	// revocation cutoffs, with whole seconds a token issued in the second
+	// of a revocation could not be told apart.
+	IssuedAt *NumericDate `json:"iat,omitempty"`
 	jwt.RegisteredClaims
 }
 
-func CreateToken(id int, username, secret string, expAt int) (string, error) {
+// GetIssuedAt implements the jwt.Claims interface.
+func (c TokenClaims) GetIssuedAt() (*jwt.NumericDate, error) {
+	if c.IssuedAt == nil {
+		return nil, nil
+	}
+
+	return &jwt.NumericDate{Time: c.IssuedAt.Time}, nil
+}
+
+// NumericDate is a JWT date with microseconds. jwt.NumericDate keeps the
+// precision of jwt.TimePrecision, which is whole seconds by default and
+// global to the process.
+type NumericDate struct {
+	time.Time
+}
+
+func NewNumericDate(t time.Time) *NumericDate {
+	return &NumericDate{t.Truncate(time.Microsecond)}
+}
+
+func (d NumericDate) MarshalJSON() ([]byte, error) {
+	us := d.UnixMicro()
+	sec, frac := us/1e6, us%1e6
+	if frac < 0 {
+		sec, frac = sec-1, frac+1e6
+	}
+
+	return fmt.Appendf(nil, "%d.%06d", sec, frac), nil
+}
+
+func (d *NumericDate) UnmarshalJSON(b []byte) error {
+	f, err := strconv.ParseFloat(string(b), 64)
+	if err != nil {
+		return fmt.Errorf("could not parse date: %w", err)
+	}
+
+	// a float64 holds the seconds of the next centuries to a fraction of
+	// a microsecond, rounding drops the error of the decimal fraction
+	d.Time = time.UnixMicro(int64(math.Round(f * 1e6)))
+
+	return nil
+}
+
+// CreateToken signs the claims with the active key of keys and puts
+// its id into the kid header.
+func CreateToken(id int, username, role, sessionId string, keys *KeySet, ttl time.Duration) (string, error) {
 	now := time.Now()
-	exp := now.Add(time.Duration(expAt) * time.Hour)
+	exp := now.Add(ttl)
+
+	jti := make([]byte, 16)
+	if _, err := rand.Read(jti); err != nil {
+		return "", fmt.Errorf("failed token signing: %s", err)
+	}
 
 	claims := TokenClaims{
-		Id:       id,
-		Username: username,
+		Id:        id,
+		Username:  username,
+		Role:      role,
+		SessionId: sessionId,
 		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        hex.EncodeToString(jti),
 			ExpiresAt: jwt.NewNumericDate(exp),
 		},
+		IssuedAt: NewNumericDate(now),
+	}
+
+	key, err := keys.ActiveKey()
+	if err != nil {
+		return "", fmt.Errorf("failed token signing: %s", err)
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	token := jwt.NewWithClaims(key.signingMethod(), claims)
+	token.Header["kid"] = key.Kid
 
-	tokenString, err := token.SignedString([]byte(secret))
+	tokenString, err := token.SignedString(key.signingKey())
 	if err != nil {
 		return "", fmt.Errorf("failed token signing: %s", err)
 	}
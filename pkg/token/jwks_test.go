@@ -0,0 +1,89 @@
+package token_test
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"testing"
+
+	"github.com/437d5/merch-store/pkg/token"
+)
+
+func TestJWKS(t *testing.T) {
+	keys := token.NewKeySet("hmac", []byte("secret"))
+	for _, alg := range []string{token.AlgRS256, token.AlgES256, token.AlgEdDSA} {
+		if _, err := keys.Generate(alg); err != nil {
+			t.Fatalf("generate %s: %v", alg, err)
+		}
+	}
+
+	data, err := json.Marshal(keys.JWKS())
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+
+	var set struct {
+		Keys []map[string]string `json:"keys"`
+	}
+	if err = json.Unmarshal(data, &set); err != nil {
+		t.Fatalf("unmarshal %s: %v", data, err)
+	}
+
+	// the HMAC secret is never published
+	if len(set.Keys) != 3 {
+		t.Fatalf("published %d keys, want 3: %s", len(set.Keys), data)
+	}
+
+	for i, jwk := range set.Keys {
+		k := keys.Keys[i+1]
+		if jwk["kid"] != k.Kid || jwk["alg"] != k.Alg || jwk["use"] != "sig" {
+			t.Errorf("key %s: kid, alg, use = %q, %q, %q", k.Kid, jwk["kid"], jwk["alg"], jwk["use"])
+		}
+
+		var want map[string]string
+		switch pub := privateKey(t, k).(crypto.Signer).Public().(type) {
+		case *rsa.PublicKey:
+			want = map[string]string{
+				"kty": "RSA",
+				"n":   b64(pub.N.Bytes()),
+				"e":   b64(big.NewInt(int64(pub.E)).Bytes()),
+			}
+		case *ecdsa.PublicKey:
+			want = map[string]string{
+				"kty": "EC",
+				"crv": "P-256",
+				"x":   b64(pub.X.FillBytes(make([]byte, 32))),
+				"y":   b64(pub.Y.FillBytes(make([]byte, 32))),
+			}
+		case ed25519.PublicKey:
+			want = map[string]string{"kty": "OKP", "crv": "Ed25519", "x": b64(pub)}
+		}
+
+		for _, field := range []string{"kty", "crv", "n", "e", "x", "y"} {
+			if jwk[field] != want[field] {
+				t.Errorf("key %s: %s = %q, want %q", k.Kid, field, jwk[field], want[field])
+			}
+		}
+	}
+
+	if e := set.Keys[0]["e"]; e != "AQAB" {
+		t.Errorf("RSA exponent = %q, want AQAB", e)
+	}
+
+	// an empty set is a list, clients reject null
+	data, err = json.Marshal(token.NewKeySet("hmac", []byte("secret")).JWKS())
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	if string(data) != `{"keys":[]}` {
+		t.Errorf("HMAC only set = %s, want no keys", data)
+	}
+}
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
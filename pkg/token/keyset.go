@@ -0,0 +1,289 @@
+package token
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	keySecretLen = 32
+	rsaKeyBits   = 2048
+)
+
+// Supported signing algorithms
+const (
+	AlgHS256 = "HS256"
+	AlgRS256 = "RS256"
+	AlgES256 = "ES256"
+	AlgEdDSA = "EdDSA"
+)
+
+var (
+	ErrUnknownKey      = errors.New("unknown signing key")
+	ErrNoActiveKey     = errors.New("no active signing key")
+	ErrRemoveActiveKey = errors.New("cannot remove the active signing key")
+	ErrUnsupportedAlg  = errors.New("unsupported signing algorithm")
+)
+
+// Key is a signing key identified by the kid token header. HMAC keys
+// keep their Secret, asymmetric keys keep a PKCS#8 PEM PrivateKey whose
+// public part is published in the JWKS.
+type Key struct {
+	Kid        string    `json:"kid"`
+	Alg        string    `json:"alg,omitempty"`
+	Secret     []byte    `json:"secret,omitempty"`
+	PrivateKey string    `json:"privateKey,omitempty"`
+	CreatedAt  time.Time `json:"createdAt"`
+
+	signer crypto.Signer
+}
+
+// KeySet holds every key tokens may be signed with. New tokens are signed
+// with the Active key, tokens signed with any other key of the set stay
+// valid, which allows rotating keys without logging everyone out.
+type KeySet struct {
+	Active string `json:"active"`
+	Keys   []Key  `json:"keys"`
+}
+
+// NewKeySet returns a set with a single active HMAC key.
+func NewKeySet(kid string, secret []byte) *KeySet {
+	return &KeySet{
+		Active: kid,
+		Keys: []Key{{
+			Kid: kid, Alg: AlgHS256, Secret: secret, CreatedAt: time.Now().UTC(),
+		}},
+	}
+}
+
+func LoadKeySet(path string) (*KeySet, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read key file: %w", err)
+	}
+
+	var ks KeySet
+	if err = json.Unmarshal(data, &ks); err != nil {
+		return nil, fmt.Errorf("cannot parse key file: %w", err)
+	}
+
+	for i := range ks.Keys {
+		if err = ks.Keys[i].parse(); err != nil {
+			return nil, fmt.Errorf("cannot parse key %s: %w", ks.Keys[i].Kid, err)
+		}
+	}
+
+	if _, err = ks.ActiveKey(); err != nil {
+		return nil, err
+	}
+
+	return &ks, nil
+}
+
+// Save writes the set to path atomically, readable by the owner only.
+func (ks *KeySet) Save(path string) error {
+	data, err := json.MarshalIndent(ks, "", "  ")
+	if err != nil {
+		return fmt.Errorf("cannot encode key file: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".keys-*")
+	if err != nil {
+		return fmt.Errorf("cannot write key file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if err = tmp.Chmod(0o600); err == nil {
+		_, err = tmp.Write(append(data, '\n'))
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("cannot write key file: %w", err)
+	}
+
+	if err = os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("cannot write key file: %w", err)
+	}
+
+	return nil
+}
+
+func (ks *KeySet) Key(kid string) (Key, error) {
+	for _, k := range ks.Keys {
+		if k.Kid == kid {
+			return k, nil
+		}
+	}
+
+	return Key{}, ErrUnknownKey
+}
+
+func (ks *KeySet) ActiveKey() (Key, error) {
+	k, err := ks.Key(ks.Active)
+	if err != nil {
+		return Key{}, ErrNoActiveKey
+	}
+
+	return k, nil
+}
+
+// Generate adds a new random key for alg to the set and returns its kid.
+// The key is not activated.
+func (ks *KeySet) Generate(alg string) (string, error) {
+	now := time.Now().UTC()
+
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return "", fmt.Errorf("cannot generate key id: %w", err)
+	}
+
+	k := Key{
+		Kid:       now.Format("20060102") + "-" + hex.EncodeToString(suffix),
+		Alg:       alg,
+		CreatedAt: now,
+	}
+
+	var err error
+	switch alg {
+	case AlgHS256:
+		k.Secret = make([]byte, keySecretLen)
+		_, err = rand.Read(k.Secret)
+	case AlgRS256:
+		k.signer, err = rsa.GenerateKey(rand.Reader, rsaKeyBits)
+	case AlgES256:
+		k.signer, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case AlgEdDSA:
+		_, k.signer, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		return "", ErrUnsupportedAlg
+	}
+	if err != nil {
+		return "", fmt.Errorf("cannot generate key: %w", err)
+	}
+
+	if k.signer != nil {
+		der, err := x509.MarshalPKCS8PrivateKey(k.signer)
+		if err != nil {
+			return "", fmt.Errorf("cannot encode key: %w", err)
+		}
+		k.PrivateKey = string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+	}
+
+	ks.Keys = append(ks.Keys, k)
+	return k.Kid, nil
+}
+
+func (ks *KeySet) Activate(kid string) error {
+	if _, err := ks.Key(kid); err != nil {
+		return err
+	}
+
+	ks.Active = kid
+	return nil
+}
+
+func (ks *KeySet) Remove(kid string) error {
+	if kid == ks.Active {
+		return ErrRemoveActiveKey
+	}
+
+	idx := slices.IndexFunc(ks.Keys, func(k Key) bool { return k.Kid == kid })
+	if idx < 0 {
+		return ErrUnknownKey
+	}
+
+	ks.Keys = slices.Delete(ks.Keys, idx, idx+1)
+	return nil
+}
+
+// parse decodes PrivateKey and checks that it matches Alg. Keys saved
+// before algorithms were configurable have no Alg and are HMAC keys.
+func (k *Key) parse() error {
+	if k.Alg == "" {
+		k.Alg = AlgHS256
+	}
+
+	if k.Alg == AlgHS256 {
+		if len(k.Secret) == 0 {
+			return errors.New("empty secret")
+		}
+		return nil
+	}
+
+	block, _ := pem.Decode([]byte(k.PrivateKey))
+	if block == nil {
+		return errors.New("private key is not PEM encoded")
+	}
+
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return err
+	}
+
+	var ok bool
+	switch k.Alg {
+	case AlgRS256:
+		_, ok = parsed.(*rsa.PrivateKey)
+	case AlgES256:
+		var ec *ecdsa.PrivateKey
+		ec, ok = parsed.(*ecdsa.PrivateKey)
+		ok = ok && ec.Curve == elliptic.P256()
+	case AlgEdDSA:
+		_, ok = parsed.(ed25519.PrivateKey)
+	default:
+		return ErrUnsupportedAlg
+	}
+	if !ok {
+		return fmt.Errorf("private key does not match %s", k.Alg)
+	}
+
+	k.signer = parsed.(crypto.Signer)
+	return nil
+}
+
+func (k Key) signingMethod() jwt.SigningMethod {
+	switch k.Alg {
+	case AlgRS256:
+		return jwt.SigningMethodRS256
+	case AlgES256:
+		return jwt.SigningMethodES256
+	case AlgEdDSA:
+		return jwt.SigningMethodEdDSA
+	default:
+		return jwt.SigningMethodHS256
+	}
+}
+
+func (k Key) signingKey() any {
+	if k.signer != nil {
+		return k.signer
+	}
+
+	return k.Secret
+}
+
+func (k Key) verifyKey() any {
+	if k.signer != nil {
+		return k.signer.Public()
+	}
+
+	return k.Secret
+}
@@ -0,0 +1,96 @@
+package token_test
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/437d5/merch-store/pkg/token"
+)
+
+func TestSaveLoadKeySet(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.json")
+
+	ks := token.NewKeySet("old", []byte("old-secret"))
+	kid, err := ks.Generate(token.AlgHS256)
+	if err != nil {
+		t.Fatalf("generate: %v", err)
+	}
+	if err = ks.Activate(kid); err != nil {
+		t.Fatalf("activate: %v", err)
+	}
+
+	if err = ks.Save(path); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("stat: %v", err)
+	}
+	if mode := info.Mode().Perm(); mode != 0o600 {
+		t.Errorf("key file mode = %v, want 0600", mode)
+	}
+
+	loaded, err := token.LoadKeySet(path)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if loaded.Active != kid || len(loaded.Keys) != 2 {
+		t.Fatalf("loaded active %s with %d keys, want %s with 2", loaded.Active, len(loaded.Keys), kid)
+	}
+
+	// tokens signed before the restart stay valid after it
+	tok, err := token.CreateToken(1, "alice", "employee", "s", ks, time.Hour)
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	if _, ok, err := token.ValidateToken(tok, loaded); !ok || err != nil {
+		t.Errorf("validate with loaded keys: %v", err)
+	}
+}
+
+func TestLoadKeySetErrors(t *testing.T) {
+	dir := t.TempDir()
+
+	if _, err := token.LoadKeySet(filepath.Join(dir, "missing.json")); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("missing file: got %v, want %v", err, fs.ErrNotExist)
+	}
+
+	path := filepath.Join(dir, "keys.json")
+	ks := token.NewKeySet("old", []byte("old-secret"))
+	ks.Active = "gone"
+	if err := ks.Save(path); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	if _, err := token.LoadKeySet(path); !errors.Is(err, token.ErrNoActiveKey) {
+		t.Errorf("unknown active key: got %v, want %v", err, token.ErrNoActiveKey)
+	}
+
+	if err := os.WriteFile(path, []byte(`{"active":"a","keys":[{"kid":"a"}]}`), 0o600); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if _, err := token.LoadKeySet(path); err == nil {
+		t.Error("key without a secret loaded")
+	}
+}
+
+func TestActivateRemove(t *testing.T) {
+	ks := token.NewKeySet("old", []byte("old-secret"))
+
+	if err := ks.Activate("missing"); !errors.Is(err, token.ErrUnknownKey) {
+		t.Errorf("activate unknown: got %v, want %v", err, token.ErrUnknownKey)
+	}
+	if err := ks.Remove("missing"); !errors.Is(err, token.ErrUnknownKey) {
+		t.Errorf("remove unknown: got %v, want %v", err, token.ErrUnknownKey)
+	}
+	if err := ks.Remove("old"); !errors.Is(err, token.ErrRemoveActiveKey) {
+		t.Errorf("remove active: got %v, want %v", err, token.ErrRemoveActiveKey)
+	}
+	if _, err := ks.Generate("HS512"); !errors.Is(err, token.ErrUnsupportedAlg) {
+		t.Errorf("generate HS512: got %v, want %v", err, token.ErrUnsupportedAlg)
+	}
+}